@@ -15,9 +15,9 @@
 package client
 
 import (
-	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/fatedier/frp/assets"
@@ -42,8 +42,11 @@ func (svr *Service) RunAdminServer(addr string, port int) (err error) {
 	// api, see dashboard_api.go
 	router.HandleFunc("/api/reload", svr.apiReload).Methods("GET")
 	router.HandleFunc("/api/status", svr.apiStatus).Methods("GET")
+	router.HandleFunc("/api/heartbeat", svr.apiHeartbeat).Methods("GET")
 	router.HandleFunc("/api/config", svr.apiGetConfig).Methods("GET")
 	router.HandleFunc("/api/config", svr.apiPutConfig).Methods("PUT")
+	router.HandleFunc("/api/proxy", svr.apiAddProxy).Methods("POST")
+	router.HandleFunc("/api/proxy/open", svr.apiOpenProxy).Methods("POST")
 
 	// view
 	router.Handle("/favicon.ico", http.FileServer(assets.FileSystem)).Methods("GET")
@@ -52,7 +55,7 @@ func (svr *Service) RunAdminServer(addr string, port int) (err error) {
 		http.Redirect(w, r, "/static/", http.StatusMovedPermanently)
 	})
 
-	address := fmt.Sprintf("%s:%d", addr, port)
+	address := net.JoinHostPort(addr, strconv.Itoa(port))
 	server := &http.Server{
 		Addr:         address,
 		Handler:      router,
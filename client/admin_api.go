@@ -18,9 +18,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatedier/frp/client/proxy"
 	"github.com/fatedier/frp/g"
@@ -63,7 +66,8 @@ func (svr *Service) apiReload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pxyCfgs, visitorCfgs, err := config.LoadAllConfFromIni(g.GlbClientCfg.User, content, newCommonCfg.Start)
+	pxyCfgs, visitorCfgs, err := config.LoadAllConfFromFiles(g.GlbClientCfg.User, g.GlbClientCfg.CfgFile, content,
+		newCommonCfg.Includes, newCommonCfg.Start)
 	if err != nil {
 		res.Code = 400
 		res.Msg = err.Error()
@@ -83,12 +87,13 @@ func (svr *Service) apiReload(w http.ResponseWriter, r *http.Request) {
 }
 
 type StatusResp struct {
-	Tcp   []ProxyStatusResp `json:"tcp"`
-	Udp   []ProxyStatusResp `json:"udp"`
-	Http  []ProxyStatusResp `json:"http"`
-	Https []ProxyStatusResp `json:"https"`
-	Stcp  []ProxyStatusResp `json:"stcp"`
-	Xtcp  []ProxyStatusResp `json:"xtcp"`
+	Tcp    []ProxyStatusResp `json:"tcp"`
+	Udp    []ProxyStatusResp `json:"udp"`
+	Http   []ProxyStatusResp `json:"http"`
+	Https  []ProxyStatusResp `json:"https"`
+	TcpMux []ProxyStatusResp `json:"tcpmux"`
+	Stcp   []ProxyStatusResp `json:"stcp"`
+	Xtcp   []ProxyStatusResp `json:"xtcp"`
 }
 
 type ProxyStatusResp struct {
@@ -99,6 +104,10 @@ type ProxyStatusResp struct {
 	LocalAddr  string `json:"local_addr"`
 	Plugin     string `json:"plugin"`
 	RemoteAddr string `json:"remote_addr"`
+
+	// for proxies generated by range section
+	RangeName  string `json:"range_name,omitempty"`
+	RemotePort int    `json:"remote_port,omitempty"`
 }
 
 type ByProxyStatusResp []ProxyStatusResp
@@ -114,6 +123,7 @@ func NewProxyStatusResp(status *proxy.ProxyStatus) ProxyStatusResp {
 		Status: status.Status,
 		Err:    status.Err,
 	}
+	psr.RangeName = status.Cfg.GetBaseInfo().RangeName
 	switch cfg := status.Cfg.(type) {
 	case *config.TcpProxyConf:
 		if cfg.LocalPort != 0 {
@@ -123,8 +133,14 @@ func NewProxyStatusResp(status *proxy.ProxyStatus) ProxyStatusResp {
 		if status.Err != "" {
 			psr.RemoteAddr = fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, cfg.RemotePort)
 		} else {
-			psr.RemoteAddr = g.GlbClientCfg.ServerAddr + status.RemoteAddr
+			// ":6000,:6001" if the proxy listens on several ports
+			addrs := strings.Split(status.RemoteAddr, ",")
+			for i := range addrs {
+				addrs[i] = g.GlbClientCfg.ServerAddr + addrs[i]
+			}
+			psr.RemoteAddr = strings.Join(addrs, ",")
 		}
+		psr.RemotePort = getRemotePort(status.RemoteAddr, cfg.RemotePort)
 	case *config.UdpProxyConf:
 		if cfg.LocalPort != 0 {
 			psr.LocalAddr = fmt.Sprintf("%s:%d", cfg.LocalIp, cfg.LocalPort)
@@ -134,6 +150,7 @@ func NewProxyStatusResp(status *proxy.ProxyStatus) ProxyStatusResp {
 		} else {
 			psr.RemoteAddr = g.GlbClientCfg.ServerAddr + status.RemoteAddr
 		}
+		psr.RemotePort = getRemotePort(status.RemoteAddr, cfg.RemotePort)
 	case *config.HttpProxyConf:
 		if cfg.LocalPort != 0 {
 			psr.LocalAddr = fmt.Sprintf("%s:%d", cfg.LocalIp, cfg.LocalPort)
@@ -146,6 +163,12 @@ func NewProxyStatusResp(status *proxy.ProxyStatus) ProxyStatusResp {
 		}
 		psr.Plugin = cfg.Plugin
 		psr.RemoteAddr = status.RemoteAddr
+	case *config.TcpMuxProxyConf:
+		if cfg.LocalPort != 0 {
+			psr.LocalAddr = fmt.Sprintf("%s:%d", cfg.LocalIp, cfg.LocalPort)
+		}
+		psr.Plugin = cfg.Plugin
+		psr.RemoteAddr = status.RemoteAddr
 	case *config.StcpProxyConf:
 		if cfg.LocalPort != 0 {
 			psr.LocalAddr = fmt.Sprintf("%s:%d", cfg.LocalIp, cfg.LocalPort)
@@ -160,6 +183,17 @@ func NewProxyStatusResp(status *proxy.ProxyStatus) ProxyStatusResp {
 	return psr
 }
 
+// getRemotePort returns the port frps listens on for tcp and udp proxies,
+// remoteAddr is like ":6000" if the proxy is running.
+func getRemotePort(remoteAddr string, defaultPort int) int {
+	if _, portStr, err := net.SplitHostPort(remoteAddr); err == nil {
+		if port, err := strconv.Atoi(portStr); err == nil {
+			return port
+		}
+	}
+	return defaultPort
+}
+
 // GET api/status
 func (svr *Service) apiStatus(w http.ResponseWriter, r *http.Request) {
 	var (
@@ -170,6 +204,7 @@ func (svr *Service) apiStatus(w http.ResponseWriter, r *http.Request) {
 	res.Udp = make([]ProxyStatusResp, 0)
 	res.Http = make([]ProxyStatusResp, 0)
 	res.Https = make([]ProxyStatusResp, 0)
+	res.TcpMux = make([]ProxyStatusResp, 0)
 	res.Stcp = make([]ProxyStatusResp, 0)
 	res.Xtcp = make([]ProxyStatusResp, 0)
 
@@ -191,6 +226,8 @@ func (svr *Service) apiStatus(w http.ResponseWriter, r *http.Request) {
 			res.Http = append(res.Http, NewProxyStatusResp(status))
 		case "https":
 			res.Https = append(res.Https, NewProxyStatusResp(status))
+		case "tcpmux":
+			res.TcpMux = append(res.TcpMux, NewProxyStatusResp(status))
 		case "stcp":
 			res.Stcp = append(res.Stcp, NewProxyStatusResp(status))
 		case "xtcp":
@@ -201,11 +238,38 @@ func (svr *Service) apiStatus(w http.ResponseWriter, r *http.Request) {
 	sort.Sort(ByProxyStatusResp(res.Udp))
 	sort.Sort(ByProxyStatusResp(res.Http))
 	sort.Sort(ByProxyStatusResp(res.Https))
+	sort.Sort(ByProxyStatusResp(res.TcpMux))
 	sort.Sort(ByProxyStatusResp(res.Stcp))
 	sort.Sort(ByProxyStatusResp(res.Xtcp))
 	return
 }
 
+type HeartbeatResp struct {
+	// round-trip time in milliseconds of the latest heartbeat
+	LastRttMs float64 `json:"last_rtt_ms"`
+	// average round-trip time in milliseconds of recent heartbeats
+	AvgRttMs float64 `json:"avg_rtt_ms"`
+	// number of heartbeats averaged, 0 if frps doesn't support it
+	Samples int `json:"samples"`
+}
+
+// GET api/heartbeat
+func (svr *Service) apiHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var res HeartbeatResp
+
+	log.Info("Http request [/api/heartbeat]")
+	defer func() {
+		log.Info("Http response [/api/heartbeat]")
+		buf, _ := json.Marshal(&res)
+		w.Write(buf)
+	}()
+
+	last, avg, count := svr.GetController().HeartbeatRtt()
+	res.LastRttMs = float64(last) / float64(time.Millisecond)
+	res.AvgRttMs = float64(avg) / float64(time.Millisecond)
+	res.Samples = count
+}
+
 // GET api/config
 func (svr *Service) apiGetConfig(w http.ResponseWriter, r *http.Request) {
 	res := GeneralResponse{Code: 200}
@@ -324,3 +388,77 @@ func (svr *Service) apiPutConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// POST api/proxy
+// body is ini content of a single proxy section, the proxy is started without reloading others
+func (svr *Service) apiAddProxy(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	log.Info("Http post request [/api/proxy]")
+	defer func() {
+		log.Info("Http post response [/api/proxy], code [%d]", res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		res.Code = 400
+		res.Msg = fmt.Sprintf("read request body error: %v", err)
+		log.Warn("%s", res.Msg)
+		return
+	}
+
+	pxyCfgs, visitorCfgs, err := config.LoadAllConfFromIni(g.GlbClientCfg.User, string(body), nil)
+	if err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		log.Warn("parse proxy config error: %s", res.Msg)
+		return
+	}
+	if len(pxyCfgs) != 1 || len(visitorCfgs) != 0 {
+		res.Code = 400
+		res.Msg = "body should contain exactly one proxy section"
+		log.Warn("%s", res.Msg)
+		return
+	}
+
+	for _, cfg := range pxyCfgs {
+		if err = svr.AddProxy(cfg); err != nil {
+			res.Code = 400
+			res.Msg = err.Error()
+			log.Warn("add proxy error: %s", res.Msg)
+			return
+		}
+	}
+}
+
+// POST api/proxy/open?name=xxx
+// frps accepts user connections of the lazy tcp proxy again if it's idle
+func (svr *Service) apiOpenProxy(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	log.Info("Http post request [/api/proxy/open]")
+	defer func() {
+		log.Info("Http post response [/api/proxy/open], code [%d]", res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		res.Code = 400
+		res.Msg = "proxy name is required"
+		return
+	}
+	if err := svr.GetController().OpenProxy(name); err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		log.Warn("open proxy error: %s", res.Msg)
+		return
+	}
+}
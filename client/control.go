@@ -18,8 +18,10 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/client/proxy"
@@ -65,6 +67,16 @@ type Control struct {
 	// last time got the Pong message
 	lastPong time.Time
 
+	// sequence and send time of the last Ping message, only used in msgHandler
+	pingSeq      uint64
+	lastPingTime time.Time
+
+	// round-trip times of recent heartbeats
+	rtt *RttStats
+
+	// 1 means frps told us it's shutting down
+	serverShutdown uint32
+
 	readerShutdown     *shutdown.Shutdown
 	writerShutdown     *shutdown.Shutdown
 	msgHandlerShutdown *shutdown.Shutdown
@@ -74,7 +86,10 @@ type Control struct {
 	log.Logger
 }
 
-func NewControl(runId string, conn frpNet.Conn, session *fmux.Session, pxyCfgs map[string]config.ProxyConf, visitorCfgs map[string]config.VisitorConf) *Control {
+// NewControl creates a control of proxies in pxyCfgs and visitors in
+// visitorCfgs, proxies in closedProxies are not registered.
+func NewControl(runId string, conn frpNet.Conn, session *fmux.Session, pxyCfgs map[string]config.ProxyConf,
+	visitorCfgs map[string]config.VisitorConf, closedProxies *proxy.ClosedProxies) *Control {
 	ctl := &Control{
 		runId:              runId,
 		conn:               conn,
@@ -87,9 +102,14 @@ func NewControl(runId string, conn frpNet.Conn, session *fmux.Session, pxyCfgs m
 		readerShutdown:     shutdown.New(),
 		writerShutdown:     shutdown.New(),
 		msgHandlerShutdown: shutdown.New(),
+		rtt:                NewRttStats(HeartbeatRttWindow),
 		Logger:             log.NewPrefixLogger(""),
 	}
 	ctl.pm = proxy.NewProxyManager(ctl.sendCh, runId)
+	ctl.pm.SetClosedProxies(closedProxies)
+	ctl.pm.SetWarmupFunc(func() {
+		ctl.handleReqWorkConn(&msg.ReqWorkConn{}, true)
+	})
 
 	ctl.vm = NewVisitorManager(ctl)
 	ctl.vm.Reload(visitorCfgs)
@@ -108,13 +128,28 @@ func (ctl *Control) Run() {
 }
 
 func (ctl *Control) HandleReqWorkConn(inMsg *msg.ReqWorkConn) {
-	workConn, err := ctl.connectServer()
+	ctl.handleReqWorkConn(inMsg, false)
+}
+
+// handleReqWorkConn returns after the work connection is used or closed.
+func (ctl *Control) handleReqWorkConn(inMsg *msg.ReqWorkConn, warmup bool) {
+	var (
+		workConn frpNet.Conn
+		err      error
+	)
+	if inMsg.ProxyName != "" {
+		workConn, err = ctl.connectServerBy(inMsg.Protocol, inMsg.Port)
+	} else {
+		workConn, err = ctl.connectServer()
+	}
 	if err != nil {
 		return
 	}
 
 	m := &msg.NewWorkConn{
-		RunId: ctl.runId,
+		RunId:     ctl.runId,
+		ProxyName: inMsg.ProxyName,
+		Warmup:    warmup,
 	}
 	if err = msg.WriteMsg(workConn, m); err != nil {
 		ctl.Warn("work connection write to server error: %v", err)
@@ -128,7 +163,8 @@ func (ctl *Control) HandleReqWorkConn(inMsg *msg.ReqWorkConn) {
 		workConn.Close()
 		return
 	}
-	workConn.AddLogPrefix(startMsg.ProxyName)
+	workConn.AddKeyLogPrefix(log.ProxyKey, startMsg.ProxyName)
+	workConn.AddKeyLogPrefix(log.TraceKey, startMsg.TraceId)
 
 	// dispatch this work connection to related proxy
 	ctl.pm.HandleWorkConn(startMsg.ProxyName, workConn, &startMsg)
@@ -137,14 +173,26 @@ func (ctl *Control) HandleReqWorkConn(inMsg *msg.ReqWorkConn) {
 func (ctl *Control) HandleNewProxyResp(inMsg *msg.NewProxyResp) {
 	// Server will return NewProxyResp message to each NewProxy message.
 	// Start a new proxy handler if no error got
-	err := ctl.pm.StartProxy(inMsg.ProxyName, inMsg.RemoteAddr, inMsg.Error)
+	err := ctl.pm.StartProxy(inMsg.ProxyName, inMsg.RemoteAddr, inMsg.Error, inMsg.ClientCertVerified)
 	if err != nil {
 		ctl.Warn("[%s] start error: %v", inMsg.ProxyName, err)
+	} else if inMsg.RemoteAddr != "" {
+		ctl.Info("[%s] start proxy success, remote address [%s]", inMsg.ProxyName, inMsg.RemoteAddr)
 	} else {
 		ctl.Info("[%s] start proxy success", inMsg.ProxyName)
 	}
 }
 
+func (ctl *Control) HandleCloseProxy(inMsg *msg.CloseProxy) {
+	// Server closed this proxy, don't register it again until reload.
+	err := ctl.pm.CloseProxyByServer(inMsg.ProxyName)
+	if err != nil {
+		ctl.Warn("[%s] close error: %v", inMsg.ProxyName, err)
+	} else {
+		ctl.Info("[%s] proxy closed by server, it won't be registered again until reload", inMsg.ProxyName)
+	}
+}
+
 func (ctl *Control) Close() error {
 	ctl.pm.Close()
 	ctl.conn.Close()
@@ -177,7 +225,7 @@ func (ctl *Control) connectServer() (conn frpNet.Conn, err error) {
 			}
 		}
 		conn, err = frpNet.ConnectServerByProxyWithTLS(g.GlbClientCfg.HttpProxy, g.GlbClientCfg.Protocol,
-			fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, g.GlbClientCfg.ServerPort), tlsConfig)
+			fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, g.GlbClientCfg.ServerPort), g.GlbClientCfg.WebsocketPath, tlsConfig)
 		if err != nil {
 			ctl.Warn("start new connection to server error: %v", err)
 			return
@@ -186,6 +234,47 @@ func (ctl *Control) connectServer() (conn frpNet.Conn, err error) {
 	return
 }
 
+// connectServerBy returns a new connection to port of frps by protocol, it's
+// used for dedicated work connections. If tcp_mux is true, the connection has
+// its own session which is closed with the returned stream.
+func (ctl *Control) connectServerBy(protocol string, port int) (conn frpNet.Conn, err error) {
+	var tlsConfig *tls.Config
+	if g.GlbClientCfg.TLSEnable {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+	conn, err = frpNet.ConnectServerByProxyWithTLS(g.GlbClientCfg.HttpProxy, protocol,
+		fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, port), g.GlbClientCfg.WebsocketPath, tlsConfig)
+	if err != nil {
+		ctl.Warn("start new %s connection to server error: %v", protocol, err)
+		return
+	}
+
+	if g.GlbClientCfg.TcpMux {
+		fmuxCfg := fmux.DefaultConfig()
+		fmuxCfg.KeepAliveInterval = 20 * time.Second
+		fmuxCfg.LogOutput = ioutil.Discard
+		session, errRet := fmux.Client(conn, fmuxCfg)
+		if errRet != nil {
+			conn.Close()
+			err = errRet
+			return
+		}
+		stream, errRet := session.OpenStream()
+		if errRet != nil {
+			session.Close()
+			err = errRet
+			ctl.Warn("start new %s connection to server error: %v", protocol, err)
+			return
+		}
+		conn = frpNet.WrapCloseNotifyConn(stream, func() {
+			session.Close()
+		})
+	}
+	return
+}
+
 // reader read all messages from frps and send to readCh
 func (ctl *Control) reader() {
 	defer func() {
@@ -204,7 +293,11 @@ func (ctl *Control) reader() {
 				ctl.Debug("read from control connection EOF")
 				return
 			} else {
-				ctl.Warn("read error: %v", err)
+				if atomic.LoadUint32(&ctl.serverShutdown) != 0 {
+					ctl.Debug("read error after server shutdown: %v", err)
+				} else {
+					ctl.Warn("read error: %v", err)
+				}
 				ctl.conn.Close()
 				return
 			}
@@ -258,7 +351,9 @@ func (ctl *Control) msgHandler() {
 		case <-hbSend.C:
 			// send heartbeat to server
 			ctl.Debug("send heartbeat to server")
-			ctl.sendCh <- &msg.Ping{}
+			ctl.pingSeq++
+			ctl.lastPingTime = time.Now()
+			ctl.sendCh <- &msg.Ping{Seq: ctl.pingSeq}
 		case <-hbCheck.C:
 			if time.Since(ctl.lastPong) > time.Duration(g.GlbClientCfg.HeartBeatTimeout)*time.Second {
 				ctl.Warn("heartbeat timeout")
@@ -276,9 +371,18 @@ func (ctl *Control) msgHandler() {
 				go ctl.HandleReqWorkConn(m)
 			case *msg.NewProxyResp:
 				ctl.HandleNewProxyResp(m)
+			case *msg.CloseProxy:
+				ctl.HandleCloseProxy(m)
 			case *msg.Pong:
 				ctl.lastPong = time.Now()
+				// old frps replies Pong without seq, late ones are also ignored
+				if m.Seq != 0 && m.Seq == ctl.pingSeq {
+					ctl.rtt.Add(ctl.lastPong.Sub(ctl.lastPingTime))
+				}
 				ctl.Debug("receive heartbeat from server")
+			case *msg.ServerShutdown:
+				atomic.StoreUint32(&ctl.serverShutdown, 1)
+				ctl.Info("server is shutting down")
 			}
 		}
 	}
@@ -311,6 +415,27 @@ func (ctl *Control) worker() {
 	}
 }
 
+// ServerShutdown returns true if frps closed the control connection because it
+// was shutting down.
+func (ctl *Control) ServerShutdown() bool {
+	return atomic.LoadUint32(&ctl.serverShutdown) != 0
+}
+
+// OpenProxy asks frps to accept user connections of the lazy tcp proxy name.
+func (ctl *Control) OpenProxy(name string) error {
+	return ctl.pm.OpenProxy(name)
+}
+
+// HeartbeatRtt returns round-trip time statistics of heartbeats on this control
+// connection.
+func (ctl *Control) HeartbeatRtt() (last time.Duration, avg time.Duration, count int) {
+	return ctl.rtt.Get()
+}
+
+func (ctl *Control) AddProxy(cfg config.ProxyConf) error {
+	return ctl.pm.AddProxy(cfg)
+}
+
 func (ctl *Control) ReloadConf(pxyCfgs map[string]config.ProxyConf, visitorCfgs map[string]config.VisitorConf) error {
 	ctl.vm.Reload(visitorCfgs)
 	ctl.pm.Reload(pxyCfgs)
@@ -15,6 +15,7 @@
 package health
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -22,6 +23,8 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/fatedier/frp/utils/log"
@@ -43,6 +46,17 @@ type HealthCheckMonitor struct {
 	// For http
 	url string
 
+	// For udp, addr is also used
+	udpSend   []byte
+	udpExpect []byte
+
+	// For command
+	command string
+
+	// failures in window are counted instead of consecutive ones if window is set
+	failureWindow time.Duration
+	failedAt      []time.Time
+
 	failedTimes    uint64
 	statusOK       bool
 	statusNormalFn func()
@@ -86,6 +100,23 @@ func (monitor *HealthCheckMonitor) SetLogger(l log.Logger) {
 	monitor.l = l
 }
 
+// SetFailureWindow makes monitor fail when maxFailedTimes failures happen within d.
+func (monitor *HealthCheckMonitor) SetFailureWindow(d time.Duration) {
+	monitor.failureWindow = d
+}
+
+// SetUdpProbe sets the payload sent by udp check and the substring expected in
+// its response, any response is ok if expect is empty.
+func (monitor *HealthCheckMonitor) SetUdpProbe(send string, expect string) {
+	monitor.udpSend = []byte(send)
+	monitor.udpExpect = []byte(expect)
+}
+
+// SetCommand sets the command run by command check, arguments are split by spaces.
+func (monitor *HealthCheckMonitor) SetCommand(command string) {
+	monitor.command = command
+}
+
 func (monitor *HealthCheckMonitor) Start() {
 	go monitor.checkWorker()
 }
@@ -124,7 +155,11 @@ func (monitor *HealthCheckMonitor) checkWorker() {
 				monitor.l.Warn("do one health check failed: %v", err)
 			}
 			monitor.failedTimes++
-			if monitor.statusOK && int(monitor.failedTimes) >= monitor.maxFailedTimes && monitor.statusFailedFn != nil {
+			failedTimes := int(monitor.failedTimes)
+			if monitor.failureWindow > 0 {
+				failedTimes = monitor.countFailuresInWindow(time.Now())
+			}
+			if monitor.statusOK && failedTimes >= monitor.maxFailedTimes && monitor.statusFailedFn != nil {
 				if monitor.l != nil {
 					monitor.l.Warn("health check status change to failed")
 				}
@@ -137,12 +172,27 @@ func (monitor *HealthCheckMonitor) checkWorker() {
 	}
 }
 
+// countFailuresInWindow records a failure at now and returns the number of failures in window.
+func (monitor *HealthCheckMonitor) countFailuresInWindow(now time.Time) int {
+	monitor.failedAt = append(monitor.failedAt, now)
+	start := 0
+	for start < len(monitor.failedAt) && now.Sub(monitor.failedAt[start]) > monitor.failureWindow {
+		start++
+	}
+	monitor.failedAt = monitor.failedAt[start:]
+	return len(monitor.failedAt)
+}
+
 func (monitor *HealthCheckMonitor) doCheck(ctx context.Context) error {
 	switch monitor.checkType {
 	case "tcp":
 		return monitor.doTcpCheck(ctx)
 	case "http":
 		return monitor.doHttpCheck(ctx)
+	case "udp":
+		return monitor.doUdpCheck(ctx)
+	case "command":
+		return monitor.doCommandCheck(ctx)
 	default:
 		return ErrHealthCheckType
 	}
@@ -163,6 +213,53 @@ func (monitor *HealthCheckMonitor) doTcpCheck(ctx context.Context) error {
 	return nil
 }
 
+func (monitor *HealthCheckMonitor) doUdpCheck(ctx context.Context) error {
+	// if udp address is not specified, always return nil
+	if monitor.addr == "" {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", monitor.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write(monitor.udpSend); err != nil {
+		return err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(buf[:n], monitor.udpExpect) {
+		return fmt.Errorf("udp response doesn't contain expected content")
+	}
+	return nil
+}
+
+func (monitor *HealthCheckMonitor) doCommandCheck(ctx context.Context) error {
+	args := strings.Fields(monitor.command)
+	if len(args) == 0 {
+		return nil
+	}
+
+	// the process is killed if it's still running when ctx is done
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("command timeout")
+		}
+		return err
+	}
+	return nil
+}
+
 func (monitor *HealthCheckMonitor) doHttpCheck(ctx context.Context) error {
 	req, err := http.NewRequest("GET", monitor.url, nil)
 	if err != nil {
@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("check commands need sh")
+	}
+	assert := assert.New(t)
+	monitor := NewHealthCheckMonitor("command", 10, 1, 1, "", "", func() {}, func() {})
+
+	tests := []struct {
+		command string
+		ok      bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"not_exist_command_of_frp", false},
+	}
+	for _, test := range tests {
+		monitor.SetCommand(test.command)
+		err := monitor.doCheck(context.Background())
+		if test.ok {
+			assert.NoError(err, test.command)
+		} else {
+			assert.Error(err, test.command)
+		}
+	}
+
+	// the command is killed after timeout
+	monitor.SetCommand("sleep 5")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := monitor.doCheck(ctx)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "timeout")
+	}
+	assert.True(time.Since(start) < 2*time.Second)
+}
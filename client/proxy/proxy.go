@@ -38,6 +38,7 @@ import (
 	"github.com/fatedier/golib/pool"
 	fmux "github.com/hashicorp/yamux"
 	pp "github.com/pires/go-proxyproto"
+	"golang.org/x/net/ipv4"
 )
 
 // Proxy defines how to handle work connections for different proxy type.
@@ -52,8 +53,10 @@ type Proxy interface {
 }
 
 func NewProxy(pxyConf config.ProxyConf) (pxy Proxy) {
+	logger := log.NewKeyPrefixLogger(log.ProxyKey, pxyConf.GetBaseInfo().ProxyName)
+	logger.SetLogLevel(pxyConf.GetBaseInfo().LogLevel)
 	baseProxy := BaseProxy{
-		Logger: log.NewPrefixLogger(pxyConf.GetBaseInfo().ProxyName),
+		Logger: logger,
 	}
 	switch cfg := pxyConf.(type) {
 	case *config.TcpProxyConf:
@@ -86,6 +89,11 @@ func NewProxy(pxyConf config.ProxyConf) (pxy Proxy) {
 			BaseProxy: &baseProxy,
 			cfg:       cfg,
 		}
+	case *config.TcpMuxProxyConf:
+		pxy = &TcpMuxProxy{
+			BaseProxy: &baseProxy,
+			cfg:       cfg,
+		}
 	}
 	return
 }
@@ -183,6 +191,35 @@ func (pxy *HttpsProxy) InWorkConn(conn frpNet.Conn, m *msg.StartWorkConn) {
 		[]byte(g.GlbClientCfg.Token), m)
 }
 
+// TCPMUX
+type TcpMuxProxy struct {
+	*BaseProxy
+
+	cfg         *config.TcpMuxProxyConf
+	proxyPlugin plugin.Plugin
+}
+
+func (pxy *TcpMuxProxy) Run() (err error) {
+	if pxy.cfg.Plugin != "" {
+		pxy.proxyPlugin, err = plugin.Create(pxy.cfg.Plugin, pxy.cfg.PluginParams)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (pxy *TcpMuxProxy) Close() {
+	if pxy.proxyPlugin != nil {
+		pxy.proxyPlugin.Close()
+	}
+}
+
+func (pxy *TcpMuxProxy) InWorkConn(conn frpNet.Conn, m *msg.StartWorkConn) {
+	HandleTcpWorkConnection(&pxy.cfg.LocalSvrConf, pxy.proxyPlugin, &pxy.cfg.BaseProxyConf, conn,
+		[]byte(g.GlbClientCfg.Token), m)
+}
+
 // STCP
 type StcpProxy struct {
 	*BaseProxy
@@ -237,6 +274,13 @@ func (pxy *XtcpProxy) Close() {
 }
 
 func (pxy *XtcpProxy) InWorkConn(conn frpNet.Conn, m *msg.StartWorkConn) {
+	if m.Relayed {
+		conn.Info("handle xtcp work connection relayed by server in stcp mode")
+		HandleTcpWorkConnection(&pxy.cfg.LocalSvrConf, pxy.proxyPlugin, &pxy.cfg.BaseProxyConf, conn,
+			[]byte(g.GlbClientCfg.Token), m)
+		return
+	}
+
 	defer conn.Close()
 	var natHoleSidMsg msg.NatHoleSid
 	err := msg.ReadMsgInto(conn, &natHoleSidMsg)
@@ -289,20 +333,31 @@ func (pxy *XtcpProxy) InWorkConn(conn frpNet.Conn, m *msg.StartWorkConn) {
 	array := strings.Split(natHoleRespMsg.VisitorAddr, ":")
 	if len(array) <= 1 {
 		pxy.Error("get NatHoleResp visitor address error: %v", natHoleRespMsg.VisitorAddr)
+		return
 	}
 	laddr, _ := net.ResolveUDPAddr("udp", clientConn.LocalAddr().String())
-	/*
-		for i := 1000; i < 65000; i++ {
-			pxy.sendDetectMsg(array[0], int64(i), laddr, "a")
-		}
-	*/
 	port, err := strconv.ParseInt(array[1], 10, 64)
 	if err != nil {
 		pxy.Error("get natHoleResp visitor address error: %v", natHoleRespMsg.VisitorAddr)
 		return
 	}
-	pxy.sendDetectMsg(array[0], int(port), laddr, []byte(natHoleRespMsg.Sid))
-	pxy.Trace("send all detect msg done")
+
+	// the port got by frps first, then ports in detect_port_range
+	detectPorts := []int{int(port)}
+	for _, p := range pxy.cfg.DetectPorts {
+		if p != port {
+			detectPorts = append(detectPorts, int(p))
+		}
+	}
+	detectCount := 0
+	for _, p := range detectPorts {
+		if err = pxy.sendDetectMsg(array[0], p, laddr, []byte(natHoleRespMsg.Sid)); err != nil {
+			pxy.Trace("send detect msg to port [%d] error: %v", p, err)
+			continue
+		}
+		detectCount++
+	}
+	pxy.Trace("send all detect msg done, count [%d]", detectCount)
 
 	msg.WriteMsg(conn, &msg.NatHoleClientDetectOK{})
 
@@ -328,7 +383,8 @@ func (pxy *XtcpProxy) InWorkConn(conn frpNet.Conn, m *msg.StartWorkConn) {
 		return
 	}
 	pool.PutBuf(sidBuf)
-	pxy.Info("nat hole connection make success, sid [%s]", natHoleRespMsg.Sid)
+	pxy.Info("nat hole connection make success after [%d] detect messages, visitor port [%d], sid [%s]",
+		detectCount, uAddr.Port, natHoleRespMsg.Sid)
 
 	lConn.WriteToUDP(sidBuf[:n], uAddr)
 
@@ -368,8 +424,15 @@ func (pxy *XtcpProxy) sendDetectMsg(addr string, port int, laddr *net.UDPAddr, c
 		return err
 	}
 
-	//uConn := ipv4.NewConn(tConn)
-	//uConn.SetTTL(3)
+	// with a low ttl, the message opens the hole in our nat but is dropped
+	// before reaching the nat of visitor
+	if pxy.cfg.DetectTtl > 0 {
+		uConn := ipv4.NewConn(tConn)
+		if err = uConn.SetTTL(pxy.cfg.DetectTtl); err != nil {
+			tConn.Close()
+			return err
+		}
+	}
 
 	tConn.Write(content)
 	tConn.Close()
@@ -391,7 +454,7 @@ type UdpProxy struct {
 }
 
 func (pxy *UdpProxy) Run() (err error) {
-	pxy.localAddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", pxy.cfg.LocalIp, pxy.cfg.LocalPort))
+	pxy.localAddr, err = net.ResolveUDPAddr("udp", net.JoinHostPort(pxy.cfg.LocalIp, strconv.Itoa(pxy.cfg.LocalPort)))
 	if err != nil {
 		return
 	}
@@ -491,6 +554,11 @@ func HandleTcpWorkConnection(localInfo *config.LocalSvrConf, proxyPlugin plugin.
 	)
 	remote = workConn
 
+	keepAlive := time.Duration(baseInfo.TcpKeepAlive) * time.Second
+	if err = frpNet.SetTcpKeepAlive(workConn, keepAlive); err != nil {
+		workConn.Debug("set tcp keepalive error: %v", err)
+	}
+
 	if baseInfo.UseEncryption {
 		remote, err = frpIo.WithEncryption(remote, encKey)
 		if err != nil {
@@ -500,40 +568,14 @@ func HandleTcpWorkConnection(localInfo *config.LocalSvrConf, proxyPlugin plugin.
 		}
 	}
 	if baseInfo.UseCompression {
-		remote = frpIo.WithCompression(remote)
+		// use the algorithm chosen by frps, old frps only supports snappy
+		remote = frpNet.WithCompression(remote, m.CompressionAlgorithm)
 	}
 
 	// check if we need to send proxy protocol info
 	var extraInfo []byte
 	if baseInfo.ProxyProtocolVersion != "" {
-		if m.SrcAddr != "" && m.SrcPort != 0 {
-			if m.DstAddr == "" {
-				m.DstAddr = "127.0.0.1"
-			}
-			h := &pp.Header{
-				Command:            pp.PROXY,
-				SourceAddress:      net.ParseIP(m.SrcAddr),
-				SourcePort:         m.SrcPort,
-				DestinationAddress: net.ParseIP(m.DstAddr),
-				DestinationPort:    m.DstPort,
-			}
-
-			if h.SourceAddress.To16() == nil {
-				h.TransportProtocol = pp.TCPv4
-			} else {
-				h.TransportProtocol = pp.TCPv6
-			}
-
-			if baseInfo.ProxyProtocolVersion == "v1" {
-				h.Version = 1
-			} else if baseInfo.ProxyProtocolVersion == "v2" {
-				h.Version = 2
-			}
-
-			buf := bytes.NewBuffer(nil)
-			h.WriteTo(buf)
-			extraInfo = buf.Bytes()
-		}
+		extraInfo = newProxyProtocolHeader(baseInfo, m)
 	}
 
 	if proxyPlugin != nil {
@@ -543,12 +585,20 @@ func HandleTcpWorkConnection(localInfo *config.LocalSvrConf, proxyPlugin plugin.
 		workConn.Debug("handle by plugin finished")
 		return
 	} else {
-		localConn, err := frpNet.ConnectServer("tcp", fmt.Sprintf("%s:%d", localInfo.LocalIp, localInfo.LocalPort))
+		localConn, err := frpNet.ConnectTcpServerFrom(localInfo.LocalBindIp, fmt.Sprintf("%s:%d", localInfo.LocalIp, localInfo.LocalPort),
+			time.Duration(localInfo.DialTimeoutS)*time.Second)
 		if err != nil {
 			workConn.Close()
-			workConn.Error("connect to local service [%s:%d] error: %v", localInfo.LocalIp, localInfo.LocalPort, err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				workConn.Error("connect to local service [%s:%d] timeout after %ds", localInfo.LocalIp, localInfo.LocalPort, localInfo.DialTimeoutS)
+			} else {
+				workConn.Error("connect to local service [%s:%d] error: %v", localInfo.LocalIp, localInfo.LocalPort, err)
+			}
 			return
 		}
+		if err = frpNet.SetTcpKeepAlive(localConn, keepAlive); err != nil {
+			workConn.Debug("set tcp keepalive of local connection error: %v", err)
+		}
 
 		workConn.Debug("join connections, localConn(l[%s] r[%s]) workConn(l[%s] r[%s])", localConn.LocalAddr().String(),
 			localConn.RemoteAddr().String(), workConn.LocalAddr().String(), workConn.RemoteAddr().String())
@@ -557,7 +607,57 @@ func HandleTcpWorkConnection(localInfo *config.LocalSvrConf, proxyPlugin plugin.
 			localConn.Write(extraInfo)
 		}
 
-		frpIo.Join(localConn, remote)
+		frpNet.JoinWithIdleTimeout(localConn, remote, time.Duration(baseInfo.ProxyIdleTimeoutS)*time.Second,
+			g.GlbClientCfg.TransportBufferSize)
 		workConn.Debug("join connections closed")
 	}
 }
+
+// newProxyProtocolHeader returns the proxy protocol header of the user
+// connection described by m, nil if its source address is unknown.
+func newProxyProtocolHeader(baseInfo *config.BaseProxyConf, m *msg.StartWorkConn) []byte {
+	srcIp := net.ParseIP(m.SrcAddr)
+	if srcIp == nil || m.SrcPort == 0 {
+		return nil
+	}
+	dstIp := net.ParseIP(m.DstAddr)
+	if baseInfo.ProxyProtocolDstAddr != "" {
+		dstIp = net.ParseIP(baseInfo.ProxyProtocolDstAddr)
+	}
+	dstPort := m.DstPort
+	if baseInfo.ProxyProtocolDstPort != 0 {
+		dstPort = uint16(baseInfo.ProxyProtocolDstPort)
+	}
+
+	// both addresses in the header should be of the same family,
+	// the destination falls back to loopback address if not
+	h := &pp.Header{
+		Command:         pp.PROXY,
+		SourcePort:      m.SrcPort,
+		DestinationPort: dstPort,
+	}
+	if srcIp.To4() != nil {
+		h.TransportProtocol = pp.TCPv4
+		if dstIp.To4() == nil {
+			dstIp = net.IPv4(127, 0, 0, 1)
+		}
+		srcIp, dstIp = srcIp.To4(), dstIp.To4()
+	} else {
+		h.TransportProtocol = pp.TCPv6
+		if dstIp == nil || dstIp.To4() != nil {
+			dstIp = net.IPv6loopback
+		}
+	}
+	h.SourceAddress = srcIp
+	h.DestinationAddress = dstIp
+
+	if baseInfo.ProxyProtocolVersion == "v1" {
+		h.Version = 1
+	} else if baseInfo.ProxyProtocolVersion == "v2" {
+		h.Version = 2
+	}
+
+	buf := bytes.NewBuffer(nil)
+	h.WriteTo(buf)
+	return buf.Bytes()
+}
@@ -3,8 +3,10 @@ package proxy
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fatedier/frp/client/event"
+	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
 	"github.com/fatedier/frp/utils/log"
@@ -13,10 +15,53 @@ import (
 	"github.com/fatedier/golib/errors"
 )
 
+// ClosedProxies records names of proxies closed by server, it's shared by
+// proxy managers of all controls so they are not registered again after
+// reconnecting. It's reset when the configure file is reloaded.
+type ClosedProxies struct {
+	names map[string]struct{}
+	mu    sync.RWMutex
+}
+
+func NewClosedProxies() *ClosedProxies {
+	return &ClosedProxies{
+		names: make(map[string]struct{}),
+	}
+}
+
+func (cp *ClosedProxies) Add(name string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.names[name] = struct{}{}
+}
+
+func (cp *ClosedProxies) Has(name string) bool {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	_, ok := cp.names[name]
+	return ok
+}
+
+func (cp *ClosedProxies) Reset() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.names = make(map[string]struct{})
+}
+
 type ProxyManager struct {
 	sendCh  chan (msg.Message)
 	proxies map[string]*ProxyWrapper
 
+	// proxies closed by server before, nil means only the ones of this
+	// manager are remembered
+	closedProxies *ClosedProxies
+
+	// opens one work connection and returns after it's used or closed, used to
+	// warm up the pool of frps for proxies with pool_warmup
+	warmupFn func()
+	// number of warmed up work connections not used yet
+	warmIdle int32
+
 	closed bool
 	mu     sync.RWMutex
 
@@ -30,11 +75,22 @@ func NewProxyManager(msgSendCh chan (msg.Message), logPrefix string) *ProxyManag
 		sendCh:    msgSendCh,
 		closed:    false,
 		logPrefix: logPrefix,
-		Logger:    log.NewPrefixLogger(logPrefix),
+		Logger:    log.NewKeyPrefixLogger(log.RunIdKey, logPrefix),
 	}
 }
 
-func (pm *ProxyManager) StartProxy(name string, remoteAddr string, serverRespErr string) error {
+// SetWarmupFunc sets the function which opens one work connection to frps.
+func (pm *ProxyManager) SetWarmupFunc(fn func()) {
+	pm.warmupFn = fn
+}
+
+// SetClosedProxies sets the names of proxies closed by server, they are not
+// registered by Reload.
+func (pm *ProxyManager) SetClosedProxies(cp *ClosedProxies) {
+	pm.closedProxies = cp
+}
+
+func (pm *ProxyManager) StartProxy(name string, remoteAddr string, serverRespErr string, clientCertVerified bool) error {
 	pm.mu.RLock()
 	pxy, ok := pm.proxies[name]
 	pm.mu.RUnlock()
@@ -42,10 +98,102 @@ func (pm *ProxyManager) StartProxy(name string, remoteAddr string, serverRespErr
 		return fmt.Errorf("proxy [%s] not found", name)
 	}
 
+	// old frps ignores verify_client_cert and lets everyone in, so the proxy
+	// is closed at once if frps doesn't confirm it
+	if cfg, ok := pxy.Cfg.(*config.HttpsProxyConf); ok && cfg.VerifyClientCert && serverRespErr == "" && !clientCertVerified {
+		serverRespErr = "frps doesn't support verify_client_cert, please upgrade it"
+		errors.PanicToError(func() {
+			pm.sendCh <- &msg.CloseProxy{ProxyName: name}
+		})
+	}
+
 	err := pxy.SetRunningStatus(remoteAddr, serverRespErr)
 	if err != nil {
 		return err
 	}
+	pm.warmup(pxy.Cfg.GetBaseInfo())
+	if cfg, ok := pxy.Cfg.(*config.TcpProxyConf); ok && cfg.Lazy {
+		return pm.OpenProxy(name)
+	}
+	return nil
+}
+
+// OpenProxy asks frps to accept user connections of the lazy tcp proxy name,
+// frps refuses them again after it's idle for lazy_idle_timeout_s.
+func (pm *ProxyManager) OpenProxy(name string) error {
+	pm.mu.RLock()
+	pxy, ok := pm.proxies[name]
+	pm.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("proxy [%s] not found", name)
+	}
+
+	status := pxy.GetStatus()
+	if cfg, ok := status.Cfg.(*config.TcpProxyConf); !ok || !cfg.Lazy {
+		return fmt.Errorf("proxy [%s] is not a lazy tcp proxy", name)
+	}
+	if status.Status != ProxyStatusRunning {
+		return fmt.Errorf("proxy [%s] is not running", name)
+	}
+	return errors.PanicToError(func() {
+		pm.sendCh <- &msg.OpenProxy{ProxyName: name}
+	})
+}
+
+// warmup opens work connections in advance, so the first user connections
+// don't wait for new ones. They are put into the pool of frps shared by all
+// proxies of the client, so only the ones needed to have pool_count unused
+// warmed up connections are opened. frps closes unused ones after a while.
+func (pm *ProxyManager) warmup(cfg *config.BaseProxyConf) {
+	if !cfg.PoolWarmup || pm.warmupFn == nil {
+		return
+	}
+	poolCount := cfg.PoolCount
+	if poolCount <= 0 {
+		poolCount = g.GlbClientCfg.PoolCount
+	}
+	n := poolCount - int(atomic.LoadInt32(&pm.warmIdle))
+	if n <= 0 {
+		return
+	}
+	pm.Debug("[%s] warm up %d work connections", cfg.ProxyName, n)
+	for i := 0; i < n; i++ {
+		atomic.AddInt32(&pm.warmIdle, 1)
+		go func() {
+			defer atomic.AddInt32(&pm.warmIdle, -1)
+			pm.warmupFn()
+		}()
+	}
+}
+
+func (pm *ProxyManager) CloseProxyByServer(name string) error {
+	pm.mu.RLock()
+	pxy, ok := pm.proxies[name]
+	pm.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("proxy [%s] not found", name)
+	}
+
+	pxy.SetClosedByServer()
+	if pm.closedProxies != nil {
+		pm.closedProxies.Add(name)
+	}
+	return nil
+}
+
+// AddProxy starts a new proxy without affecting the others.
+func (pm *ProxyManager) AddProxy(cfg config.ProxyConf) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	name := cfg.GetBaseInfo().ProxyName
+	if _, ok := pm.proxies[name]; ok {
+		return fmt.Errorf("proxy [%s] already exists", name)
+	}
+	pxy := NewProxyWrapper(cfg, pm.HandleEvent, pm.logPrefix)
+	pm.proxies[name] = pxy
+	pxy.Start()
+	pm.Info("proxy added: [%s]", name)
 	return nil
 }
 
@@ -107,7 +255,7 @@ func (pm *ProxyManager) Reload(pxyCfgs map[string]config.ProxyConf) {
 		if !ok {
 			del = true
 		} else {
-			if !pxy.Cfg.Compare(cfg) {
+			if !pxy.Cfg.Compare(cfg) || pxy.GetStatus().Status == ProxyStatusClosedByServer {
 				del = true
 			}
 		}
@@ -130,6 +278,9 @@ func (pm *ProxyManager) Reload(pxyCfgs map[string]config.ProxyConf) {
 			pm.proxies[name] = pxy
 			addPxyNames = append(addPxyNames, name)
 
+			if pm.closedProxies != nil && pm.closedProxies.Has(name) && !cfg.GetBaseInfo().Disabled {
+				pxy.SetClosedByServer()
+			}
 			pxy.Start()
 		}
 	}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/msg"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitNewProxy returns the first NewProxy message sent to sendCh in timeout,
+// it's nil if there is none.
+func waitNewProxy(sendCh chan msg.Message, timeout time.Duration) *msg.NewProxy {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case m := <-sendCh:
+			if newProxy, ok := m.(*msg.NewProxy); ok {
+				return newProxy
+			}
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+func TestClosedProxiesAfterReconnect(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	cfg.LocalIp = "127.0.0.1"
+	cfg.LocalPort = 80
+	pxyCfgs := map[string]config.ProxyConf{"tcp": cfg}
+	closedProxies := NewClosedProxies()
+
+	newManager := func() (*ProxyManager, chan msg.Message) {
+		sendCh := make(chan msg.Message, 10)
+		pm := NewProxyManager(sendCh, "test")
+		pm.SetClosedProxies(closedProxies)
+		pm.Reload(pxyCfgs)
+		return pm, sendCh
+	}
+
+	pm, sendCh := newManager()
+	assert.NotNil(waitNewProxy(sendCh, time.Second))
+	assert.NoError(pm.StartProxy("tcp", ":6000", "", false))
+	assert.NoError(pm.CloseProxyByServer("tcp"))
+	pm.Close()
+
+	// the proxy manager of the control after reconnecting
+	pm, sendCh = newManager()
+	assert.Nil(waitNewProxy(sendCh, 500*time.Millisecond))
+	if ps := pm.GetAllProxyStatus(); assert.Len(ps, 1) {
+		assert.Equal(ProxyStatusClosedByServer, ps[0].Status)
+	}
+	pm.Close()
+
+	// registered again after reloading
+	closedProxies.Reset()
+	pm, sendCh = newManager()
+	assert.NotNil(waitNewProxy(sendCh, time.Second))
+	pm.Close()
+}
+
+func TestDisabledProxy(t *testing.T) {
+	assert := assert.New(t)
+	newCfg := func(disabled bool) config.ProxyConf {
+		cfg := &config.TcpProxyConf{}
+		cfg.ProxyName = "tcp"
+		cfg.ProxyType = "tcp"
+		cfg.LocalIp = "127.0.0.1"
+		cfg.LocalPort = 80
+		cfg.Disabled = disabled
+		return cfg
+	}
+	sendCh := make(chan msg.Message, 10)
+	pm := NewProxyManager(sendCh, "test")
+	defer pm.Close()
+
+	// disabled proxies are listed but never registered
+	pm.Reload(map[string]config.ProxyConf{"tcp": newCfg(true)})
+	assert.Nil(waitNewProxy(sendCh, 500*time.Millisecond))
+	if ps := pm.GetAllProxyStatus(); assert.Len(ps, 1) {
+		assert.Equal(ProxyStatusDisabled, ps[0].Status)
+	}
+
+	// registered after it's enabled by reloading
+	pm.Reload(map[string]config.ProxyConf{"tcp": newCfg(false)})
+	assert.NotNil(waitNewProxy(sendCh, time.Second))
+}
+
+func TestStartProxyClientCertNotVerified(t *testing.T) {
+	assert := assert.New(t)
+	pxyCfgs := make(map[string]config.ProxyConf)
+	for _, name := range []string{"old", "new"} {
+		cfg := &config.HttpsProxyConf{}
+		cfg.ProxyName = name
+		cfg.ProxyType = "https"
+		cfg.CustomDomains = []string{name + ".example.com"}
+		cfg.VerifyClientCert = true
+		pxyCfgs[name] = cfg
+	}
+	sendCh := make(chan msg.Message, 10)
+	pm := NewProxyManager(sendCh, "test")
+	defer pm.Close()
+	pm.Reload(pxyCfgs)
+	assert.NotNil(waitNewProxy(sendCh, time.Second))
+	assert.NotNil(waitNewProxy(sendCh, time.Second))
+
+	// frps which doesn't verify client certificates is told to close the proxy
+	assert.Error(pm.StartProxy("old", "", "", false))
+	select {
+	case m := <-sendCh:
+		if closeMsg, ok := m.(*msg.CloseProxy); assert.True(ok) {
+			assert.Equal("old", closeMsg.ProxyName)
+		}
+	case <-time.After(time.Second):
+		assert.Fail("proxy is not closed on frps")
+	}
+
+	assert.NoError(pm.StartProxy("new", "", "", true))
+	for _, ps := range pm.GetAllProxyStatus() {
+		if ps.Name == "old" {
+			assert.Equal(ProxyStatusStartErr, ps.Status)
+		} else {
+			assert.Equal(ProxyStatusRunning, ps.Status)
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	assert := assert.New(t)
+	pm := NewProxyManager(make(chan msg.Message, 10), "test")
+	defer pm.Close()
+	// warmed up work connections stay unused until release is closed
+	var opened int32
+	release := make(chan struct{})
+	pm.SetWarmupFunc(func() {
+		atomic.AddInt32(&opened, 1)
+		<-release
+	})
+	waitOpened := func(expected int32) {
+		for i := 0; i < 50 && atomic.LoadInt32(&opened) < expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(expected, atomic.LoadInt32(&opened))
+	}
+
+	cfg := &config.BaseProxyConf{PoolWarmup: true, PoolCount: 2}
+	pm.warmup(cfg)
+	waitOpened(2)
+	// the pool of frps is shared, so other proxies only top it up
+	pm.warmup(cfg)
+	pm.warmup(&config.BaseProxyConf{PoolWarmup: true, PoolCount: 3})
+	waitOpened(3)
+	pm.warmup(&config.BaseProxyConf{PoolCount: 5})
+	waitOpened(3)
+
+	close(release)
+	for i := 0; i < 50 && atomic.LoadInt32(&pm.warmIdle) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pm.warmup(cfg)
+	waitOpened(5)
+}
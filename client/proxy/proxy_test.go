@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/msg"
+
+	pp "github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProxyProtocolHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	parse := func(version string, dstAddr string, m *msg.StartWorkConn) *pp.Header {
+		baseInfo := &config.BaseProxyConf{
+			ProxyProtocolVersion: version,
+			ProxyProtocolDstAddr: dstAddr,
+		}
+		buf := newProxyProtocolHeader(baseInfo, m)
+		if !assert.NotNil(buf) {
+			return nil
+		}
+		h, err := pp.Read(bufio.NewReader(bytes.NewReader(buf)))
+		if !assert.NoError(err) {
+			return nil
+		}
+		return h
+	}
+
+	for _, version := range []string{"v1", "v2"} {
+		h := parse(version, "", &msg.StartWorkConn{
+			SrcAddr: "1.2.3.4", SrcPort: 1000, DstAddr: "5.6.7.8", DstPort: 80,
+		})
+		if assert.NotNil(h) {
+			assert.True(h.TransportProtocol.IsIPv4())
+			assert.Equal("1.2.3.4", h.SourceAddress.String())
+			assert.Equal("5.6.7.8", h.DestinationAddress.String())
+		}
+
+		h = parse(version, "", &msg.StartWorkConn{
+			SrcAddr: "2001:db8::1", SrcPort: 1000, DstAddr: "2001:db8::2", DstPort: 80,
+		})
+		if assert.NotNil(h) {
+			assert.True(h.TransportProtocol.IsIPv6())
+			assert.Equal("2001:db8::1", h.SourceAddress.String())
+			assert.Equal("2001:db8::2", h.DestinationAddress.String())
+			assert.Equal(uint16(80), h.DestinationPort)
+		}
+
+		// ipv4-mapped ipv6 source from a dual-stack listener is ipv4
+		h = parse(version, "", &msg.StartWorkConn{
+			SrcAddr: "::ffff:1.2.3.4", SrcPort: 1000, DstAddr: "::ffff:5.6.7.8", DstPort: 80,
+		})
+		if assert.NotNil(h) {
+			assert.True(h.TransportProtocol.IsIPv4())
+			assert.Equal("1.2.3.4", h.SourceAddress.String())
+			assert.Equal("5.6.7.8", h.DestinationAddress.String())
+		}
+
+		// destination of another family is replaced by loopback address
+		h = parse(version, "127.0.0.1", &msg.StartWorkConn{
+			SrcAddr: "2001:db8::1", SrcPort: 1000, DstAddr: "2001:db8::2", DstPort: 80,
+		})
+		if assert.NotNil(h) {
+			assert.True(h.TransportProtocol.IsIPv6())
+			assert.True(h.DestinationAddress.Equal(net.IPv6loopback))
+		}
+	}
+
+	assert.Nil(newProxyProtocolHeader(&config.BaseProxyConf{ProxyProtocolVersion: "v2"}, &msg.StartWorkConn{}))
+}
@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/fatedier/frp/models/msg"
 	"github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/util"
 
 	"github.com/fatedier/golib/errors"
 )
@@ -23,6 +26,10 @@ const (
 	ProxyStatusRunning     = "running"
 	ProxyStatusCheckFailed = "check failed"
 	ProxyStatusClosed      = "closed"
+	// closed by server, won't register again until reload
+	ProxyStatusClosedByServer = "closed by server"
+	// enabled = false in config, never registered to server
+	ProxyStatusDisabled = "disabled"
 )
 
 var (
@@ -67,6 +74,8 @@ type ProxyWrapper struct {
 
 func NewProxyWrapper(cfg config.ProxyConf, eventHandler event.EventHandler, logPrefix string) *ProxyWrapper {
 	baseInfo := cfg.GetBaseInfo()
+	logger := log.NewKeyPrefixLogger(log.RunIdKey, logPrefix)
+	logger.SetLogLevel(baseInfo.LogLevel)
 	pw := &ProxyWrapper{
 		ProxyStatus: ProxyStatus{
 			Name:   baseInfo.ProxyName,
@@ -77,9 +86,12 @@ func NewProxyWrapper(cfg config.ProxyConf, eventHandler event.EventHandler, logP
 		closeCh:        make(chan struct{}),
 		healthNotifyCh: make(chan struct{}),
 		handler:        eventHandler,
-		Logger:         log.NewPrefixLogger(logPrefix),
+		Logger:         logger,
+	}
+	pw.AddKeyLogPrefix(log.ProxyKey, pw.Name)
+	if baseInfo.Disabled {
+		pw.Status = ProxyStatusDisabled
 	}
-	pw.AddLogPrefix(pw.Name)
 
 	if baseInfo.HealthCheckType != "" {
 		pw.health = 1 // means failed
@@ -87,6 +99,9 @@ func NewProxyWrapper(cfg config.ProxyConf, eventHandler event.EventHandler, logP
 			baseInfo.HealthCheckTimeoutS, baseInfo.HealthCheckMaxFailed, baseInfo.HealthCheckAddr,
 			baseInfo.HealthCheckUrl, pw.statusNormalCallback, pw.statusFailedCallback)
 		pw.monitor.SetLogger(pw.Logger)
+		pw.monitor.SetFailureWindow(time.Duration(baseInfo.HealthCheckFailureWindowS) * time.Second)
+		pw.monitor.SetUdpProbe(baseInfo.HealthCheckUdpSend, baseInfo.HealthCheckUdpExpect)
+		pw.monitor.SetCommand(baseInfo.HealthCheckCommand)
 		pw.Trace("enable health check monitor")
 	}
 
@@ -118,10 +133,14 @@ func (pw *ProxyWrapper) SetRunningStatus(remoteAddr string, respErr string) erro
 
 	pw.Status = ProxyStatusRunning
 	pw.Err = ""
+	pw.runHook(pw.Cfg.GetBaseInfo().OnStartCmd, "")
 	return nil
 }
 
 func (pw *ProxyWrapper) Start() {
+	if pw.Status == ProxyStatusDisabled {
+		return
+	}
 	go pw.checkWorker()
 	if pw.monitor != nil {
 		go pw.monitor.Start()
@@ -137,15 +156,68 @@ func (pw *ProxyWrapper) Stop() {
 	if pw.monitor != nil {
 		pw.monitor.Stop()
 	}
+	wasRunning := pw.Status == ProxyStatusRunning
+	wasDisabled := pw.Status == ProxyStatusDisabled
 	pw.Status = ProxyStatusClosed
+	if wasRunning {
+		pw.runHook(pw.Cfg.GetBaseInfo().OnStopCmd, "")
+	}
+	// disabled proxies are never registered to server
+	if wasDisabled {
+		return
+	}
 
 	pw.handler(event.EvCloseProxy, &event.CloseProxyPayload{
 		CloseProxyMsg: &msg.CloseProxy{
-			ProxyName: pw.Name,
+			ProxyName:     pw.Name,
+			DrainTimeoutS: pw.Cfg.GetBaseInfo().DrainTimeoutS,
 		},
 	})
 }
 
+// SetClosedByServer stops registering this proxy to server.
+func (pw *ProxyWrapper) SetClosedByServer() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.Trace("change status from [%s] to [%s]", pw.Status, ProxyStatusClosedByServer)
+	wasRunning := pw.Status == ProxyStatusRunning
+	pw.Status = ProxyStatusClosedByServer
+	if wasRunning {
+		pw.pxy.Close()
+		pw.runHook(pw.Cfg.GetBaseInfo().OnStopCmd, "")
+	}
+	pw.RemoteAddr = ""
+}
+
+// runHook runs command in a new goroutine with the current status of proxy and
+// errMsg in environment variables, the caller should hold pw.mu.
+func (pw *ProxyWrapper) runHook(command string, errMsg string) {
+	args, err := util.SplitCommand(command)
+	if err != nil {
+		pw.Warn("run command error: %v", err)
+		return
+	}
+	if len(args) == 0 {
+		return
+	}
+	env := append(os.Environ(),
+		"FRP_PROXY_NAME="+pw.Name,
+		"FRP_PROXY_TYPE="+pw.Type,
+		"FRP_PROXY_STATUS="+pw.Status,
+		"FRP_PROXY_ERR="+errMsg,
+		"FRP_REMOTE_ADDR="+pw.RemoteAddr,
+	)
+	go func() {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		if err := cmd.Run(); err != nil {
+			pw.Warn("run command [%s] error: %v", command, err)
+			return
+		}
+		pw.Info("run command [%s] success, exit status 0", command)
+	}()
+}
+
 func (pw *ProxyWrapper) checkWorker() {
 	if pw.monitor != nil {
 		// let monitor do check request first
@@ -181,7 +253,11 @@ func (pw *ProxyWrapper) checkWorker() {
 					},
 				})
 				pw.Trace("change status from [%s] to [%s]", pw.Status, ProxyStatusCheckFailed)
+				wasRunning := pw.Status == ProxyStatusRunning
 				pw.Status = ProxyStatusCheckFailed
+				if wasRunning {
+					pw.runHook(pw.Cfg.GetBaseInfo().OnStopCmd, "health check failed")
+				}
 			}
 			pw.mu.Unlock()
 		}
@@ -220,8 +296,10 @@ func (pw *ProxyWrapper) statusFailedCallback() {
 func (pw *ProxyWrapper) InWorkConn(workConn frpNet.Conn, m *msg.StartWorkConn) {
 	pw.mu.RLock()
 	pxy := pw.pxy
+	status := pw.Status
 	pw.mu.RUnlock()
-	if pxy != nil {
+	// frps may still send work connections of a proxy which failed to start
+	if pxy != nil && status != ProxyStatusStartErr {
 		workConn.Debug("start a new work connection, localAddr: %s remoteAddr: %s", workConn.LocalAddr().String(), workConn.RemoteAddr().String())
 		go pxy.InWorkConn(workConn, m)
 	} else {
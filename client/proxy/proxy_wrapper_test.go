@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/client/event"
+	"github.com/fatedier/frp/models/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitHookOutput returns lines written by the hook script to out in timeout.
+func waitHookOutput(out string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		buf, err := ioutil.ReadFile(out)
+		if err == nil && strings.HasSuffix(string(buf), "end\n") {
+			return strings.Split(string(buf), "\n")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script needs sh")
+	}
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frpc_hook")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// the script writes its arguments and env to the file of the first argument
+	script := filepath.Join(dir, "hook.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\n{ for a in \"$@\"; do echo \"arg: $a\"; done; env; echo end; } > \"$1\"\n"), 0755)
+	if !assert.NoError(err) {
+		return
+	}
+	startOut := filepath.Join(dir, "start")
+	stopOut := filepath.Join(dir, "stop")
+
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	cfg.LocalIp = "127.0.0.1"
+	cfg.LocalPort = 80
+	cfg.OnStartCmd = script + " " + startOut + ` "proxy started"`
+	cfg.OnStopCmd = script + " " + stopOut + ` 'proxy stopped'`
+	pw := NewProxyWrapper(cfg, func(evType event.EventType, payload interface{}) error { return nil }, "test")
+
+	// the proxy never started, so on_stop_cmd isn't run for start errors
+	pw.Status = ProxyStatusWaitStart
+	assert.Error(pw.SetRunningStatus("", "port already used"))
+	assert.Nil(waitHookOutput(stopOut, 500*time.Millisecond))
+
+	pw.Status = ProxyStatusWaitStart
+	assert.NoError(pw.SetRunningStatus(":6000", ""))
+	lines := waitHookOutput(startOut, 2*time.Second)
+	assert.Contains(lines, "arg: proxy started")
+	assert.Contains(lines, "FRP_PROXY_NAME=tcp")
+	assert.Contains(lines, "FRP_PROXY_TYPE=tcp")
+	assert.Contains(lines, "FRP_PROXY_STATUS="+ProxyStatusRunning)
+	assert.Contains(lines, "FRP_PROXY_ERR=")
+	assert.Contains(lines, "FRP_REMOTE_ADDR=:6000")
+
+	pw.Stop()
+	lines = waitHookOutput(stopOut, 2*time.Second)
+	assert.Contains(lines, "arg: proxy stopped")
+	assert.Contains(lines, "FRP_PROXY_STATUS="+ProxyStatusClosed)
+}
+
+func TestSetClosedByServer(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &config.UdpProxyConf{}
+	cfg.ProxyName = "udp"
+	cfg.ProxyType = "udp"
+	cfg.LocalIp = "127.0.0.1"
+	cfg.LocalPort = 53
+	pw := NewProxyWrapper(cfg, func(evType event.EventType, payload interface{}) error { return nil }, "test")
+
+	pw.Status = ProxyStatusWaitStart
+	if !assert.NoError(pw.SetRunningStatus(":6000", "")) {
+		return
+	}
+	pw.SetClosedByServer()
+	assert.Equal(ProxyStatusClosedByServer, pw.GetStatus().Status)
+	// the running proxy is closed too
+	assert.True(pw.pxy.(*UdpProxy).closed)
+}
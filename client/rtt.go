@@ -0,0 +1,66 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// HeartbeatRttWindow is the number of recent heartbeats whose round-trip times
+// are averaged.
+const HeartbeatRttWindow = 10
+
+// RttStats keeps the latest size round-trip times.
+type RttStats struct {
+	samples []time.Duration
+	next    int
+	count   int
+	last    time.Duration
+
+	mu sync.RWMutex
+}
+
+func NewRttStats(size int) *RttStats {
+	return &RttStats{
+		samples: make([]time.Duration, size),
+	}
+}
+
+func (s *RttStats) Add(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples[s.next] = rtt
+	s.next = (s.next + 1) % len(s.samples)
+	if s.count < len(s.samples) {
+		s.count++
+	}
+	s.last = rtt
+}
+
+// Get returns the latest round-trip time, the average of recent ones and how
+// many of them are averaged.
+func (s *RttStats) Get() (last time.Duration, avg time.Duration, count int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.count == 0 {
+		return
+	}
+	var sum time.Duration
+	for i := 0; i < s.count; i++ {
+		sum += s.samples[i]
+	}
+	return s.last, sum / time.Duration(s.count), s.count
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRttStats(t *testing.T) {
+	assert := assert.New(t)
+	s := NewRttStats(3)
+
+	last, avg, count := s.Get()
+	assert.EqualValues(0, last)
+	assert.EqualValues(0, avg)
+	assert.Equal(0, count)
+
+	s.Add(10 * time.Millisecond)
+	s.Add(20 * time.Millisecond)
+	last, avg, count = s.Get()
+	assert.Equal(20*time.Millisecond, last)
+	assert.Equal(15*time.Millisecond, avg)
+	assert.Equal(2, count)
+
+	// only the latest 3 are averaged
+	s.Add(30 * time.Millisecond)
+	s.Add(40 * time.Millisecond)
+	last, avg, count = s.Get()
+	assert.Equal(40*time.Millisecond, last)
+	assert.Equal(30*time.Millisecond, avg)
+	assert.Equal(3, count)
+}
@@ -18,12 +18,14 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/assets"
+	"github.com/fatedier/frp/client/proxy"
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
@@ -47,6 +49,10 @@ type Service struct {
 	visitorCfgs map[string]config.VisitorConf
 	cfgMu       sync.RWMutex
 
+	// proxies closed by server, they are not registered after reconnecting
+	// until the configure file is reloaded
+	closedProxies *proxy.ClosedProxies
+
 	exit     uint32 // 0 means not exit
 	closedCh chan int
 }
@@ -59,11 +65,15 @@ func NewService(pxyCfgs map[string]config.ProxyConf, visitorCfgs map[string]conf
 		return
 	}
 
+	msg.SetMaxMsgLength(g.GlbClientCfg.MaxMsgLength)
+
 	svr = &Service{
-		pxyCfgs:     pxyCfgs,
-		visitorCfgs: visitorCfgs,
-		exit:        0,
-		closedCh:    make(chan int),
+		runId:         g.GlbClientCfg.RunId,
+		pxyCfgs:       pxyCfgs,
+		visitorCfgs:   visitorCfgs,
+		closedProxies: proxy.NewClosedProxies(),
+		exit:          0,
+		closedCh:      make(chan int),
 	}
 	return
 }
@@ -90,7 +100,7 @@ func (svr *Service) Run() error {
 			}
 		} else {
 			// login success
-			ctl := NewControl(svr.runId, conn, session, svr.pxyCfgs, svr.visitorCfgs)
+			ctl := NewControl(svr.runId, conn, session, svr.pxyCfgs, svr.visitorCfgs, svr.closedProxies)
 			ctl.Run()
 			svr.ctlMu.Lock()
 			svr.ctl = ctl
@@ -114,8 +124,9 @@ func (svr *Service) Run() error {
 }
 
 func (svr *Service) keepControllerWorking() {
-	maxDelayTime := 20 * time.Second
-	delayTime := time.Second
+	minDelayTime := time.Duration(g.GlbClientCfg.ReconnectIntervalMin) * time.Second
+	maxDelayTime := time.Duration(g.GlbClientCfg.ReconnectIntervalMax) * time.Second
+	delayTime := minDelayTime
 
 	for {
 		<-svr.ctl.ClosedDoneCh()
@@ -123,12 +134,20 @@ func (svr *Service) keepControllerWorking() {
 			return
 		}
 
+		// all clients of frps are disconnected at the same time, so wait longer
+		// before reconnecting to avoid flooding the restarted frps
+		if svr.ctl.ServerShutdown() {
+			wait := jitter(maxDelayTime)
+			log.Info("server is shutting down, try to reconnect after %v", wait)
+			time.Sleep(wait)
+		}
+
 		for {
 			log.Info("try to reconnect to server...")
 			conn, session, err := svr.login()
 			if err != nil {
 				log.Warn("reconnect to server error: %v", err)
-				time.Sleep(delayTime)
+				time.Sleep(jitter(delayTime))
 				delayTime = delayTime * 2
 				if delayTime > maxDelayTime {
 					delayTime = maxDelayTime
@@ -136,9 +155,9 @@ func (svr *Service) keepControllerWorking() {
 				continue
 			}
 			// reconnect success, init delayTime
-			delayTime = time.Second
+			delayTime = minDelayTime
 
-			ctl := NewControl(svr.runId, conn, session, svr.pxyCfgs, svr.visitorCfgs)
+			ctl := NewControl(svr.runId, conn, session, svr.pxyCfgs, svr.visitorCfgs, svr.closedProxies)
 			ctl.Run()
 			svr.ctlMu.Lock()
 			svr.ctl = ctl
@@ -148,6 +167,25 @@ func (svr *Service) keepControllerWorking() {
 	}
 }
 
+var (
+	// the global source of math/rand isn't seeded, so it gives every client
+	// the same jitter
+	jitterRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandMu sync.Mutex
+)
+
+// jitter returns a random duration in [d/2, d], so that lots of clients
+// disconnected at the same time won't reconnect to frps at the same time.
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	jitterRandMu.Lock()
+	defer jitterRandMu.Unlock()
+	return time.Duration(half + jitterRand.Int63n(half+1))
+}
+
 // login creates a connection to frps and registers it self as a client
 // conn: control connection
 // session: if it's not nil, using tcp mux
@@ -159,7 +197,7 @@ func (svr *Service) login() (conn frpNet.Conn, session *fmux.Session, err error)
 		}
 	}
 	conn, err = frpNet.ConnectServerByProxyWithTLS(g.GlbClientCfg.HttpProxy, g.GlbClientCfg.Protocol,
-		fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, g.GlbClientCfg.ServerPort), tlsConfig)
+		fmt.Sprintf("%s:%d", g.GlbClientCfg.ServerAddr, g.GlbClientCfg.ServerPort), g.GlbClientCfg.WebsocketPath, tlsConfig)
 	if err != nil {
 		return
 	}
@@ -200,6 +238,7 @@ func (svr *Service) login() (conn frpNet.Conn, session *fmux.Session, err error)
 		PrivilegeKey: util.GetAuthKey(g.GlbClientCfg.Token, now),
 		Timestamp:    now,
 		RunId:        svr.runId,
+		Metas:        g.GlbClientCfg.Metas,
 	}
 
 	if err = msg.WriteMsg(conn, loginMsg); err != nil {
@@ -231,9 +270,38 @@ func (svr *Service) ReloadConf(pxyCfgs map[string]config.ProxyConf, visitorCfgs
 	svr.visitorCfgs = visitorCfgs
 	svr.cfgMu.Unlock()
 
+	// all proxies closed by server are registered again after reloading
+	svr.closedProxies.Reset()
 	return svr.ctl.ReloadConf(pxyCfgs, visitorCfgs)
 }
 
+// AddProxy validates cfg and starts it at runtime, other proxies are not touched.
+func (svr *Service) AddProxy(cfg config.ProxyConf) error {
+	if err := cfg.CheckForCli(); err != nil {
+		return err
+	}
+
+	name := cfg.GetBaseInfo().ProxyName
+	svr.cfgMu.Lock()
+	defer svr.cfgMu.Unlock()
+	if _, ok := svr.pxyCfgs[name]; ok {
+		return fmt.Errorf("proxy [%s] already exists", name)
+	}
+
+	if err := svr.GetController().AddProxy(cfg); err != nil {
+		return err
+	}
+
+	// keep it after reconnecting to frps
+	pxyCfgs := make(map[string]config.ProxyConf, len(svr.pxyCfgs)+1)
+	for k, v := range svr.pxyCfgs {
+		pxyCfgs[k] = v
+	}
+	pxyCfgs[name] = cfg
+	svr.pxyCfgs = pxyCfgs
+	return nil
+}
+
 func (svr *Service) Close() {
 	atomic.StoreUint32(&svr.exit, 1)
 	svr.ctl.Close()
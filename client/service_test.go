@@ -0,0 +1,23 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitter(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(time.Duration(1), jitter(1))
+	assert.Equal(time.Duration(0), jitter(0))
+
+	d := 20 * time.Second
+	values := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		wait := jitter(d)
+		assert.True(wait >= d/2 && wait <= d, "%v is out of range", wait)
+		values[wait] = struct{}{}
+	}
+	assert.True(len(values) > 1)
+}
@@ -16,6 +16,7 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -45,7 +46,7 @@ type Visitor interface {
 func NewVisitor(ctl *Control, cfg config.VisitorConf) (visitor Visitor) {
 	baseVisitor := BaseVisitor{
 		ctl:    ctl,
-		Logger: log.NewPrefixLogger(cfg.GetBaseInfo().ProxyName),
+		Logger: log.NewKeyPrefixLogger(log.ProxyKey, cfg.GetBaseInfo().ProxyName),
 	}
 	switch cfg := cfg.(type) {
 	case *config.StcpVisitorConf:
@@ -70,17 +71,44 @@ type BaseVisitor struct {
 	log.Logger
 }
 
+// listen on bind_port, or the first free port in bind_port_range if bind_port is not set
+func listenVisitor(cfg *config.BaseVisitorConf) (l frpNet.Listener, port int, err error) {
+	if cfg.BindPort > 0 {
+		l, err = frpNet.ListenTcp(cfg.BindAddr, cfg.BindPort)
+		return l, cfg.BindPort, err
+	}
+	for _, port = range cfg.BindPortRange {
+		if l, err = frpNet.ListenTcp(cfg.BindAddr, port); err == nil {
+			return
+		}
+	}
+	err = fmt.Errorf("no available port in bind_port_range of visitor [%s]", cfg.ProxyName)
+	return
+}
+
 type StcpVisitor struct {
 	*BaseVisitor
 
 	cfg *config.StcpVisitorConf
+	// presented to frps if proxy verifies certificates of visitors
+	cert *tls.Certificate
 }
 
 func (sv *StcpVisitor) Run() (err error) {
-	sv.l, err = frpNet.ListenTcp(sv.cfg.BindAddr, sv.cfg.BindPort)
+	if sv.cfg.TlsCertFile != "" {
+		cert, errRet := tls.LoadX509KeyPair(sv.cfg.TlsCertFile, sv.cfg.TlsKeyFile)
+		if errRet != nil {
+			return fmt.Errorf("load visitor certificate error: %v", errRet)
+		}
+		sv.cert = &cert
+	}
+
+	var port int
+	sv.l, port, err = listenVisitor(&sv.cfg.BaseVisitorConf)
 	if err != nil {
 		return
 	}
+	sv.Info("visitor listen on %s:%d", sv.cfg.BindAddr, port)
 
 	go sv.worker()
 	return
@@ -106,7 +134,14 @@ func (sv *StcpVisitor) handleConn(userConn frpNet.Conn) {
 	defer userConn.Close()
 
 	sv.Debug("get a new stcp user connection")
-	visitorConn, err := sv.ctl.connectServer()
+	handleConnByServer(sv.ctl, sv.Logger, &sv.cfg.BaseVisitorConf, sv.cert, userConn)
+}
+
+// handleConnByServer joins userConn with a visitor connection relayed by frps,
+// cert is sent to frps to authenticate the visitor if it's not nil.
+func handleConnByServer(ctl *Control, logger log.Logger, cfg *config.BaseVisitorConf, cert *tls.Certificate,
+	userConn frpNet.Conn) {
+	visitorConn, err := ctl.connectServer()
 	if err != nil {
 		return
 	}
@@ -114,15 +149,28 @@ func (sv *StcpVisitor) handleConn(userConn frpNet.Conn) {
 
 	now := time.Now().Unix()
 	newVisitorConnMsg := &msg.NewVisitorConn{
-		ProxyName:      sv.cfg.ServerName,
-		SignKey:        util.GetAuthKey(sv.cfg.Sk, now),
+		ProxyName:      cfg.ServerName,
+		SignKey:        util.GetAuthKey(cfg.Sk, now),
 		Timestamp:      now,
-		UseEncryption:  sv.cfg.UseEncryption,
-		UseCompression: sv.cfg.UseCompression,
+		UseEncryption:  cfg.UseEncryption,
+		UseCompression: cfg.UseCompression,
+	}
+	if cert != nil {
+		if newVisitorConnMsg.Nonce, err = util.RandIdWithLen(16); err != nil {
+			logger.Warn("generate nonce error: %v", err)
+			return
+		}
+		newVisitorConnMsg.Certs = cert.Certificate
+		signData := util.GetVisitorCertSignData(cfg.ServerName, now, newVisitorConnMsg.Nonce)
+		newVisitorConnMsg.CertSign, err = util.SignByCert(*cert, []byte(signData))
+		if err != nil {
+			logger.Warn("sign by visitor certificate error: %v", err)
+			return
+		}
 	}
 	err = msg.WriteMsg(visitorConn, newVisitorConnMsg)
 	if err != nil {
-		sv.Warn("send newVisitorConnMsg to server error: %v", err)
+		logger.Warn("send newVisitorConnMsg to server error: %v", err)
 		return
 	}
 
@@ -130,31 +178,31 @@ func (sv *StcpVisitor) handleConn(userConn frpNet.Conn) {
 	visitorConn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	err = msg.ReadMsgInto(visitorConn, &newVisitorConnRespMsg)
 	if err != nil {
-		sv.Warn("get newVisitorConnRespMsg error: %v", err)
+		logger.Warn("get newVisitorConnRespMsg error: %v", err)
 		return
 	}
 	visitorConn.SetReadDeadline(time.Time{})
 
 	if newVisitorConnRespMsg.Error != "" {
-		sv.Warn("start new visitor connection error: %s", newVisitorConnRespMsg.Error)
+		logger.Warn("start new visitor connection error: %s", newVisitorConnRespMsg.Error)
 		return
 	}
 
 	var remote io.ReadWriteCloser
 	remote = visitorConn
-	if sv.cfg.UseEncryption {
-		remote, err = frpIo.WithEncryption(remote, []byte(sv.cfg.Sk))
+	if cfg.UseEncryption {
+		remote, err = frpIo.WithEncryption(remote, []byte(cfg.Sk))
 		if err != nil {
-			sv.Error("create encryption stream error: %v", err)
+			logger.Error("create encryption stream error: %v", err)
 			return
 		}
 	}
 
-	if sv.cfg.UseCompression {
+	if cfg.UseCompression {
 		remote = frpIo.WithCompression(remote)
 	}
 
-	frpIo.Join(userConn, remote)
+	frpNet.Join(userConn, remote, g.GlbClientCfg.TransportBufferSize)
 }
 
 type XtcpVisitor struct {
@@ -164,10 +212,12 @@ type XtcpVisitor struct {
 }
 
 func (sv *XtcpVisitor) Run() (err error) {
-	sv.l, err = frpNet.ListenTcp(sv.cfg.BindAddr, sv.cfg.BindPort)
+	var port int
+	sv.l, port, err = listenVisitor(&sv.cfg.BaseVisitorConf)
 	if err != nil {
 		return
 	}
+	sv.Info("visitor listen on %s:%d", sv.cfg.BindAddr, port)
 
 	go sv.worker()
 	return
@@ -193,8 +243,63 @@ func (sv *XtcpVisitor) handleConn(userConn frpNet.Conn) {
 	defer userConn.Close()
 
 	sv.Debug("get a new xtcp user connection")
+	lConn, clientAddr, err := sv.makeNatHole()
+	if err != nil {
+		if sv.cfg.FallbackToStcp {
+			sv.Info("make nat hole error: %v, fall back to stcp mode", err)
+			handleConnByServer(sv.ctl, sv.Logger, &sv.cfg.BaseVisitorConf, nil, userConn)
+			sv.Debug("join connections in stcp mode closed")
+		}
+		return
+	}
+	defer lConn.Close()
+	sv.Info("xtcp connection works in p2p mode")
+
+	// wrap kcp connection
+	var remote io.ReadWriteCloser
+	remote, err = frpNet.NewKcpConnFromUdp(lConn, true, clientAddr)
+	if err != nil {
+		sv.Error("create kcp connection from udp connection error: %v", err)
+		return
+	}
+
+	if sv.cfg.UseEncryption {
+		remote, err = frpIo.WithEncryption(remote, []byte(sv.cfg.Sk))
+		if err != nil {
+			sv.Error("create encryption stream error: %v", err)
+			return
+		}
+	}
+
+	if sv.cfg.UseCompression {
+		remote = frpIo.WithCompression(remote)
+	}
+
+	fmuxCfg := fmux.DefaultConfig()
+	fmuxCfg.KeepAliveInterval = 5 * time.Second
+	fmuxCfg.LogOutput = ioutil.Discard
+	sess, err := fmux.Client(remote, fmuxCfg)
+	if err != nil {
+		sv.Error("create yamux session error: %v", err)
+		return
+	}
+	defer sess.Close()
+	muxConn, err := sess.Open()
+	if err != nil {
+		sv.Error("open yamux stream error: %v", err)
+		return
+	}
+
+	frpNet.Join(userConn, muxConn, g.GlbClientCfg.TransportBufferSize)
+	sv.Debug("join connections closed")
+}
+
+// makeNatHole returns the udp connection which can reach the client directly
+// and the client's address if nat hole punching succeeds.
+func (sv *XtcpVisitor) makeNatHole() (lConn *net.UDPConn, clientAddr string, err error) {
 	if g.GlbClientCfg.ServerUdpPort == 0 {
-		sv.Error("xtcp is not supported by server")
+		err = fmt.Errorf("xtcp is not supported by server")
+		sv.Error("%v", err)
 		return
 	}
 
@@ -243,6 +348,7 @@ func (sv *XtcpVisitor) handleConn(userConn frpNet.Conn) {
 	pool.PutBuf(buf)
 
 	if natHoleRespMsg.Error != "" {
+		err = fmt.Errorf("%s", natHoleRespMsg.Error)
 		sv.Error("natHoleRespMsg get error info: %s", natHoleRespMsg.Error)
 		return
 	}
@@ -259,67 +365,32 @@ func (sv *XtcpVisitor) handleConn(userConn frpNet.Conn) {
 		sv.Error("resolve client udp address error: %v", err)
 		return
 	}
-	lConn, err := net.DialUDP("udp", laddr, daddr)
+	conn, err := net.DialUDP("udp", laddr, daddr)
 	if err != nil {
 		sv.Error("dial client udp address error: %v", err)
 		return
 	}
-	defer lConn.Close()
 
-	lConn.Write([]byte(natHoleRespMsg.Sid))
+	conn.Write([]byte(natHoleRespMsg.Sid))
 
 	// read ack sid from client
 	sidBuf := pool.GetBuf(1024)
-	lConn.SetReadDeadline(time.Now().Add(8 * time.Second))
-	n, err = lConn.Read(sidBuf)
+	conn.SetReadDeadline(time.Now().Add(8 * time.Second))
+	n, err = conn.Read(sidBuf)
 	if err != nil {
 		sv.Warn("get sid from client error: %v", err)
+		conn.Close()
 		return
 	}
-	lConn.SetReadDeadline(time.Time{})
+	conn.SetReadDeadline(time.Time{})
 	if string(sidBuf[:n]) != natHoleRespMsg.Sid {
-		sv.Warn("incorrect sid from client")
+		err = fmt.Errorf("incorrect sid from client")
+		sv.Warn("%v", err)
+		conn.Close()
 		return
 	}
 	pool.PutBuf(sidBuf)
 
 	sv.Info("nat hole connection make success, sid [%s]", natHoleRespMsg.Sid)
-
-	// wrap kcp connection
-	var remote io.ReadWriteCloser
-	remote, err = frpNet.NewKcpConnFromUdp(lConn, true, natHoleRespMsg.ClientAddr)
-	if err != nil {
-		sv.Error("create kcp connection from udp connection error: %v", err)
-		return
-	}
-
-	if sv.cfg.UseEncryption {
-		remote, err = frpIo.WithEncryption(remote, []byte(sv.cfg.Sk))
-		if err != nil {
-			sv.Error("create encryption stream error: %v", err)
-			return
-		}
-	}
-
-	if sv.cfg.UseCompression {
-		remote = frpIo.WithCompression(remote)
-	}
-
-	fmuxCfg := fmux.DefaultConfig()
-	fmuxCfg.KeepAliveInterval = 5 * time.Second
-	fmuxCfg.LogOutput = ioutil.Discard
-	sess, err := fmux.Client(remote, fmuxCfg)
-	if err != nil {
-		sv.Error("create yamux session error: %v", err)
-		return
-	}
-	defer sess.Close()
-	muxConn, err := sess.Open()
-	if err != nil {
-		sv.Error("open yamux stream error: %v", err)
-		return
-	}
-
-	frpIo.Join(userConn, muxConn)
-	sv.Debug("join connections closed")
+	return conn, natHoleRespMsg.ClientAddr, nil
 }
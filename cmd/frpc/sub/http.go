@@ -37,6 +37,7 @@ func init() {
 	httpCmd.PersistentFlags().StringVarP(&proxyName, "proxy_name", "n", "", "proxy name")
 	httpCmd.PersistentFlags().StringVarP(&localIp, "local_ip", "i", "127.0.0.1", "local ip")
 	httpCmd.PersistentFlags().IntVarP(&localPort, "local_port", "l", 0, "local port")
+	httpCmd.PersistentFlags().IntVarP(&dialTimeoutS, "dial_timeout_s", "", config.DefaultDialTimeoutS, "timeout in seconds of connecting local service, 0 means no timeout")
 	httpCmd.PersistentFlags().StringVarP(&customDomains, "custom_domain", "d", "", "custom domain")
 	httpCmd.PersistentFlags().StringVarP(&subDomain, "sd", "", "", "sub domain")
 	httpCmd.PersistentFlags().StringVarP(&locations, "locations", "", "", "locations")
@@ -68,6 +69,7 @@ var httpCmd = &cobra.Command{
 		cfg.ProxyType = consts.HttpProxy
 		cfg.LocalIp = localIp
 		cfg.LocalPort = localPort
+		cfg.DialTimeoutS = dialTimeoutS
 		cfg.CustomDomains = strings.Split(customDomains, ",")
 		cfg.SubDomain = subDomain
 		cfg.Locations = strings.Split(locations, ",")
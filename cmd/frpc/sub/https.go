@@ -37,6 +37,7 @@ func init() {
 	httpsCmd.PersistentFlags().StringVarP(&proxyName, "proxy_name", "n", "", "proxy name")
 	httpsCmd.PersistentFlags().StringVarP(&localIp, "local_ip", "i", "127.0.0.1", "local ip")
 	httpsCmd.PersistentFlags().IntVarP(&localPort, "local_port", "l", 0, "local port")
+	httpsCmd.PersistentFlags().IntVarP(&dialTimeoutS, "dial_timeout_s", "", config.DefaultDialTimeoutS, "timeout in seconds of connecting local service, 0 means no timeout")
 	httpsCmd.PersistentFlags().StringVarP(&customDomains, "custom_domain", "d", "", "custom domain")
 	httpsCmd.PersistentFlags().StringVarP(&subDomain, "sd", "", "", "sub domain")
 	httpsCmd.PersistentFlags().BoolVarP(&useEncryption, "ue", "", false, "use encryption")
@@ -64,6 +65,7 @@ var httpsCmd = &cobra.Command{
 		cfg.ProxyType = consts.HttpsProxy
 		cfg.LocalIp = localIp
 		cfg.LocalPort = localPort
+		cfg.DialTimeoutS = dialTimeoutS
 		cfg.CustomDomains = strings.Split(customDomains, ",")
 		cfg.SubDomain = subDomain
 		cfg.UseEncryption = useEncryption
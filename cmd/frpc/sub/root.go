@@ -31,6 +31,7 @@ import (
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/version"
 )
 
@@ -54,6 +55,7 @@ var (
 	proxyName         string
 	localIp           string
 	localPort         int
+	dialTimeoutS      int
 	remotePort        int
 	useEncryption     bool
 	useCompression    bool
@@ -135,6 +137,11 @@ func parseClientCommonCfgFromIni(content string) (err error) {
 	if err != nil {
 		return err
 	}
+
+	// environment variables have higher priority than ini file
+	if err = config.UnmarshalClientConfFromEnv(cfg); err != nil {
+		return err
+	}
 	g.GlbClientCfg.ClientCommonConf = *cfg
 	return
 }
@@ -156,7 +163,9 @@ func parseClientCommonCfgFromCmd() (err error) {
 
 	g.GlbClientCfg.User = user
 	g.GlbClientCfg.Protocol = protocol
-	g.GlbClientCfg.Token = token
+	if g.GlbClientCfg.Token, err = config.ResolveToken(token); err != nil {
+		return
+	}
 	g.GlbClientCfg.LogLevel = logLevel
 	g.GlbClientCfg.LogFile = logFile
 	g.GlbClientCfg.LogMaxDays = int64(logMaxDays)
@@ -181,7 +190,8 @@ func runClient(cfgFilePath string) (err error) {
 		return
 	}
 
-	pxyCfgs, visitorCfgs, err := config.LoadAllConfFromIni(g.GlbClientCfg.User, content, g.GlbClientCfg.Start)
+	pxyCfgs, visitorCfgs, err := config.LoadAllConfFromFiles(g.GlbClientCfg.User, cfgFilePath, content,
+		g.GlbClientCfg.Includes, g.GlbClientCfg.Start)
 	if err != nil {
 		return err
 	}
@@ -191,20 +201,25 @@ func runClient(cfgFilePath string) (err error) {
 }
 
 func startService(pxyCfgs map[string]config.ProxyConf, visitorCfgs map[string]config.VisitorConf) (err error) {
-	log.InitLog(g.GlbClientCfg.LogWay, g.GlbClientCfg.LogFile, g.GlbClientCfg.LogLevel, g.GlbClientCfg.LogMaxDays)
+	log.InitLog(g.GlbClientCfg.LogWay, g.GlbClientCfg.LogFile, g.GlbClientCfg.LogLevel, g.GlbClientCfg.LogMaxDays, g.GlbClientCfg.LogFormat)
+	var dial frpNet.DialFunc
 	if g.GlbClientCfg.DnsServer != "" {
 		s := g.GlbClientCfg.DnsServer
 		if !strings.Contains(s, ":") {
 			s += ":53"
 		}
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", s)
+		}
 		// Change default dns server for frpc
 		net.DefaultResolver = &net.Resolver{
 			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return net.Dial("udp", s)
-			},
+			Dial:     dial,
 		}
 	}
+	if g.GlbClientCfg.DnsCacheTTLS > 0 {
+		net.DefaultResolver = frpNet.NewDnsCache(time.Duration(g.GlbClientCfg.DnsCacheTTLS)*time.Second, dial).Resolver()
+	}
 	svr, errRet := client.NewService(pxyCfgs, visitorCfgs)
 	if errRet != nil {
 		err = errRet
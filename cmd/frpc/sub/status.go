@@ -62,7 +62,7 @@ var statusCmd = &cobra.Command{
 
 func status() error {
 	if g.GlbClientCfg.AdminPort == 0 {
-		return fmt.Errorf("admin_port shoud be set if you want to get proxy status")
+		return fmt.Errorf("admin_port should be set if you want to get proxy status")
 	}
 
 	req, err := http.NewRequest("GET", "http://"+
@@ -132,6 +132,15 @@ func status() error {
 		tbl.Print()
 		fmt.Println("")
 	}
+	if len(res.TcpMux) > 0 {
+		fmt.Printf("TCPMUX")
+		tbl := table.New("Name", "Status", "LocalAddr", "Plugin", "RemoteAddr", "Error")
+		for _, ps := range res.TcpMux {
+			tbl.AddRow(ps.Name, ps.Status, ps.LocalAddr, ps.Plugin, ps.RemoteAddr, ps.Err)
+		}
+		tbl.Print()
+		fmt.Println("")
+	}
 	if len(res.Stcp) > 0 {
 		fmt.Printf("STCP")
 		tbl := table.New("Name", "Status", "LocalAddr", "Plugin", "RemoteAddr", "Error")
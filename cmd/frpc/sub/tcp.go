@@ -36,6 +36,7 @@ func init() {
 	tcpCmd.PersistentFlags().StringVarP(&proxyName, "proxy_name", "n", "", "proxy name")
 	tcpCmd.PersistentFlags().StringVarP(&localIp, "local_ip", "i", "127.0.0.1", "local ip")
 	tcpCmd.PersistentFlags().IntVarP(&localPort, "local_port", "l", 0, "local port")
+	tcpCmd.PersistentFlags().IntVarP(&dialTimeoutS, "dial_timeout_s", "", config.DefaultDialTimeoutS, "timeout in seconds of connecting local service, 0 means no timeout")
 	tcpCmd.PersistentFlags().IntVarP(&remotePort, "remote_port", "r", 0, "remote port")
 	tcpCmd.PersistentFlags().BoolVarP(&useEncryption, "ue", "", false, "use encryption")
 	tcpCmd.PersistentFlags().BoolVarP(&useCompression, "uc", "", false, "use compression")
@@ -62,6 +63,7 @@ var tcpCmd = &cobra.Command{
 		cfg.ProxyType = consts.TcpProxy
 		cfg.LocalIp = localIp
 		cfg.LocalPort = localPort
+		cfg.DialTimeoutS = dialTimeoutS
 		cfg.RemotePort = remotePort
 		cfg.UseEncryption = useEncryption
 		cfg.UseCompression = useCompression
@@ -39,6 +39,7 @@ func init() {
 	xtcpCmd.PersistentFlags().StringVarP(&serverName, "server_name", "", "", "server name")
 	xtcpCmd.PersistentFlags().StringVarP(&localIp, "local_ip", "i", "127.0.0.1", "local ip")
 	xtcpCmd.PersistentFlags().IntVarP(&localPort, "local_port", "l", 0, "local port")
+	xtcpCmd.PersistentFlags().IntVarP(&dialTimeoutS, "dial_timeout_s", "", config.DefaultDialTimeoutS, "timeout in seconds of connecting local service, 0 means no timeout")
 	xtcpCmd.PersistentFlags().StringVarP(&bindAddr, "bind_addr", "", "", "bind addr")
 	xtcpCmd.PersistentFlags().IntVarP(&bindPort, "bind_port", "", 0, "bind port")
 	xtcpCmd.PersistentFlags().BoolVarP(&useEncryption, "ue", "", false, "use encryption")
@@ -75,6 +76,7 @@ var xtcpCmd = &cobra.Command{
 			cfg.Sk = sk
 			cfg.LocalIp = localIp
 			cfg.LocalPort = localPort
+			cfg.DialTimeoutS = dialTimeoutS
 			err = cfg.CheckForCli()
 			if err != nil {
 				fmt.Println(err)
@@ -15,8 +15,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -160,6 +164,7 @@ func parseServerCommonCfgFromCmd() (err error) {
 	g.GlbServerCfg.BindUdpPort = bindUdpPort
 	g.GlbServerCfg.KcpBindPort = kcpBindPort
 	g.GlbServerCfg.ProxyBindAddr = proxyBindAddr
+	g.GlbServerCfg.NatHoleBindAddr = bindAddr
 	g.GlbServerCfg.VhostHttpPort = vhostHttpPort
 	g.GlbServerCfg.VhostHttpsPort = vhostHttpsPort
 	g.GlbServerCfg.VhostHttpTimeout = vhostHttpTimeout
@@ -170,7 +175,9 @@ func parseServerCommonCfgFromCmd() (err error) {
 	g.GlbServerCfg.LogFile = logFile
 	g.GlbServerCfg.LogLevel = logLevel
 	g.GlbServerCfg.LogMaxDays = logMaxDays
-	g.GlbServerCfg.Token = token
+	if g.GlbServerCfg.Token, err = config.ResolveToken(token); err != nil {
+		return
+	}
 	g.GlbServerCfg.SubDomainHost = subDomainHost
 	if len(allowPorts) > 0 {
 		// e.g. 1000-2000,2001,2002,3000-4000
@@ -196,13 +203,23 @@ func parseServerCommonCfgFromCmd() (err error) {
 
 func runServer() (err error) {
 	log.InitLog(g.GlbServerCfg.LogWay, g.GlbServerCfg.LogFile, g.GlbServerCfg.LogLevel,
-		g.GlbServerCfg.LogMaxDays)
+		g.GlbServerCfg.LogMaxDays, g.GlbServerCfg.LogFormat)
 	svr, err := server.NewService()
 	if err != nil {
 		return err
 	}
 	log.Info("Start frps success")
 	server.ServerService = svr
+	go handleSignal(svr)
 	svr.Run()
 	return
 }
+
+func handleSignal(svr *server.Service) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	<-ch
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(g.GlbServerCfg.ShutdownGracePeriodS)*time.Second)
+	defer cancel()
+	svr.Shutdown(ctx)
+}
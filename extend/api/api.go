@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatedier/frp/models/msg"
 )
@@ -14,6 +16,9 @@ import (
 // Service sakurafrp api servie
 type Service struct {
 	Host url.URL
+
+	// nil means no cache
+	cache *userCache
 }
 
 // NewService crate sakurafrp api servie
@@ -22,11 +27,38 @@ func NewService(host string) (s *Service, err error) {
 	if err != nil {
 		return
 	}
-	return &Service{*u}, nil
+	return &Service{Host: *u}, nil
+}
+
+// SetCacheTTL enables caching successful results of CheckToken and GetProxyLimit
+// for ttl, the cache of a user is dropped once the API call of it fails.
+func (s *Service) SetCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		s.cache = nil
+		return
+	}
+	s.cache = newUserCache(ttl)
 }
 
 // CheckToken 校验客户端 token
 func (s Service) CheckToken(user string, token string, timestamp int64, stk string) (ok bool, err error) {
+	if s.cache == nil {
+		return s.checkToken(user, token, timestamp, stk)
+	}
+	if s.cache.checkToken(user, token) {
+		return true, nil
+	}
+
+	ok, err = s.checkToken(user, token, timestamp, stk)
+	if err != nil || !ok {
+		s.cache.invalidate(user)
+		return
+	}
+	s.cache.setToken(user, token)
+	return
+}
+
+func (s Service) checkToken(user string, token string, timestamp int64, stk string) (ok bool, err error) {
 	values := url.Values{}
 	values.Set("action", "checktoken")
 	values.Set("user", user)
@@ -107,6 +139,13 @@ func (s Service) CheckProxy(user string, pMsg *msg.NewProxy, timestamp int64, st
 
 	// Tcp & Udp & Stcp
 	values.Set("remote_port", strconv.Itoa(pMsg.RemotePort))
+	if len(pMsg.RemotePorts) > 0 {
+		ports := make([]string, 0, len(pMsg.RemotePorts))
+		for _, port := range pMsg.RemotePorts {
+			ports = append(ports, strconv.Itoa(port))
+		}
+		values.Set("remote_ports", strings.Join(ports, ","))
+	}
 
 	// Stcp & Xtcp
 	values.Set("sk", pMsg.Sk)
@@ -141,11 +180,46 @@ func (s Service) CheckProxy(user string, pMsg *msg.NewProxy, timestamp int64, st
 	if !response.Success {
 		return false, ErrCheckProxyFail{response.Message}
 	}
+	// APIs which don't know remote_ports only check remote_port, the first one
+	if len(pMsg.RemotePorts) > 0 && !samePorts(pMsg.RemotePorts, response.RemotePorts) {
+		return false, ErrCheckProxyFail{"remote ports are not confirmed by the API"}
+	}
 	return true, nil
 }
 
+func samePorts(ports1, ports2 []int) bool {
+	if len(ports1) != len(ports2) {
+		return false
+	}
+	for i, port := range ports1 {
+		if port != ports2[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetProxyLimit 获取隧道限速信息
-func (s Service) GetProxyLimit(user string, timestamp int64, stk string) (inLimit, outLimit uint64, err error) {
+// maxProxies is the max number of proxies of the user, 0 means it's not limited by the API.
+func (s Service) GetProxyLimit(user string, timestamp int64, stk string) (inLimit, outLimit uint64, maxProxies int64, err error) {
+	if s.cache == nil {
+		return s.getProxyLimit(user, timestamp, stk)
+	}
+	var ok bool
+	if inLimit, outLimit, maxProxies, ok = s.cache.getLimit(user); ok {
+		return
+	}
+
+	inLimit, outLimit, maxProxies, err = s.getProxyLimit(user, timestamp, stk)
+	if err != nil {
+		s.cache.invalidate(user)
+		return
+	}
+	s.cache.setLimit(user, inLimit, outLimit, maxProxies)
+	return
+}
+
+func (s Service) getProxyLimit(user string, timestamp int64, stk string) (inLimit, outLimit uint64, maxProxies int64, err error) {
 	// 这部分就照之前的搬过去了，能跑就行x
 	values := url.Values{}
 	values.Set("action", "getlimit")
@@ -158,29 +232,29 @@ func (s Service) GetProxyLimit(user string, timestamp int64, stk string) (inLimi
 	}(&s.Host)
 	resp, err := http.Get(s.Host.String())
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 
 	er := &ErrHTTPStatus{}
 	if err = json.Unmarshal(body, er); err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 	if er.Status != 200 {
-		return 0, 0, er
+		return 0, 0, 0, er
 	}
 
 	response := &ResponseGetLimit{}
 	if err = json.Unmarshal(body, response); err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 
 	// 这里直接返回 uint64 应该问题不大
-	return response.MaxIn, response.MaxOut, nil
+	return response.MaxIn, response.MaxOut, response.MaxProxies, nil
 }
 
 func BoolToString(val bool) (str string) {
@@ -203,6 +277,9 @@ func (e ErrHTTPStatus) Error() string {
 type ResponseGetLimit struct {
 	MaxIn  uint64 `json:"max-in"`
 	MaxOut uint64 `json:"max-out"`
+
+	// optional, 0 or missing means no limit from the API
+	MaxProxies int64 `json:"max-proxies"`
 }
 
 type ResponseCheckToken struct {
@@ -213,6 +290,10 @@ type ResponseCheckToken struct {
 type ResponseCheckProxy struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+
+	// required for proxies with several remote ports, all of them in the same
+	// order as the remote_ports param
+	RemotePorts []int `json:"remote_ports"`
 }
 
 type ErrCheckTokenFail struct {
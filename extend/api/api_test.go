@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/msg"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckProxyRemotePorts(t *testing.T) {
+	assert := assert.New(t)
+	// confirm is true if the API confirms remote_ports it receives
+	confirm := false
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := ResponseCheckProxy{Success: true}
+		if ports := r.URL.Query().Get("remote_ports"); confirm && ports != "" {
+			for _, port := range strings.Split(ports, ",") {
+				n, _ := strconv.Atoi(port)
+				resp.RemotePorts = append(resp.RemotePorts, n)
+			}
+		}
+		buf, _ := json.Marshal(&resp)
+		w.Write(buf)
+	}))
+	defer apiServer.Close()
+	s, err := NewService(apiServer.URL)
+	if !assert.NoError(err) {
+		return
+	}
+
+	single := &msg.NewProxy{ProxyName: "single", ProxyType: "tcp", RemotePort: 6000}
+	multi := &msg.NewProxy{ProxyName: "multi", ProxyType: "tcp", RemotePort: 6000, RemotePorts: []int{6000, 6001}}
+
+	ok, err := s.CheckProxy("user", single, time.Now().Unix(), "token")
+	assert.NoError(err)
+	assert.True(ok)
+	// the API only checks remote_port
+	ok, err = s.CheckProxy("user", multi, time.Now().Unix(), "token")
+	assert.Error(err)
+	assert.False(ok)
+
+	confirm = true
+	ok, err = s.CheckProxy("user", multi, time.Now().Unix(), "token")
+	assert.NoError(err)
+	assert.True(ok)
+}
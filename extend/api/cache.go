@@ -0,0 +1,91 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+type tokenCacheItem struct {
+	token  string
+	expire time.Time
+}
+
+type limitCacheItem struct {
+	inLimit    uint64
+	outLimit   uint64
+	maxProxies int64
+	expire     time.Time
+}
+
+// userCache caches the successful results of API by user,
+// so reconnecting clients don't hit the API every time.
+type userCache struct {
+	ttl    time.Duration
+	tokens map[string]tokenCacheItem
+	limits map[string]limitCacheItem
+	mu     sync.Mutex
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		ttl:    ttl,
+		tokens: make(map[string]tokenCacheItem),
+		limits: make(map[string]limitCacheItem),
+	}
+}
+
+func (c *userCache) checkToken(user string, token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.tokens[user]
+	if !ok {
+		return false
+	}
+	if time.Now().After(item.expire) {
+		delete(c.tokens, user)
+		return false
+	}
+	return item.token == token
+}
+
+func (c *userCache) setToken(user string, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[user] = tokenCacheItem{
+		token:  token,
+		expire: time.Now().Add(c.ttl),
+	}
+}
+
+func (c *userCache) getLimit(user string) (inLimit, outLimit uint64, maxProxies int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.limits[user]
+	if !ok {
+		return 0, 0, 0, false
+	}
+	if time.Now().After(item.expire) {
+		delete(c.limits, user)
+		return 0, 0, 0, false
+	}
+	return item.inLimit, item.outLimit, item.maxProxies, true
+}
+
+func (c *userCache) setLimit(user string, inLimit, outLimit uint64, maxProxies int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits[user] = limitCacheItem{
+		inLimit:    inLimit,
+		outLimit:   outLimit,
+		maxProxies: maxProxies,
+		expire:     time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate drops all cached results of user.
+func (c *userCache) invalidate(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, user)
+	delete(c.limits, user)
+}
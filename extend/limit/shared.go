@@ -0,0 +1,72 @@
+package limit
+
+import (
+	"context"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"golang.org/x/time/rate"
+)
+
+// burst of shared limiter, it must be larger than one read or write of io.Copy
+const sharedBurstLimit = 64 * 1024
+
+// NewSharedLimiter returns a limiter which can be shared by many connections
+// to cap their total traffic, returns nil if bytesPerSec is 0.
+func NewSharedLimiter(bytesPerSec uint64) *rate.Limiter {
+	if bytesPerSec == 0 {
+		return nil
+	}
+	limiter := rate.NewLimiter(rate.Limit(bytesPerSec), sharedBurstLimit)
+	limiter.AllowN(time.Now(), sharedBurstLimit) // spend initial burst
+	return limiter
+}
+
+// SharedLimitConn waits for the limiter after reading and before writing,
+// it slows down the connection instead of closing it when the limiter is saturated.
+type SharedLimitConn struct {
+	frpNet.Conn
+
+	limiter *rate.Limiter
+}
+
+// NewSharedLimitConn returns c itself if limiter is nil.
+func NewSharedLimitConn(limiter *rate.Limiter, c frpNet.Conn) frpNet.Conn {
+	if limiter == nil {
+		return c
+	}
+	return &SharedLimitConn{
+		Conn:    c,
+		limiter: limiter,
+	}
+}
+
+func (c *SharedLimitConn) Read(p []byte) (n int, err error) {
+	if len(p) > c.limiter.Burst() {
+		p = p[:c.limiter.Burst()]
+	}
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.limiter.WaitN(context.Background(), n)
+	}
+	return
+}
+
+func (c *SharedLimitConn) Write(p []byte) (n int, err error) {
+	var nw int
+	for len(p) > 0 {
+		size := len(p)
+		if size > c.limiter.Burst() {
+			size = c.limiter.Burst()
+		}
+		c.limiter.WaitN(context.Background(), size)
+		nw, err = c.Conn.Write(p[:size])
+		n += nw
+		if err != nil {
+			return
+		}
+		p = p[size:]
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package limit
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedLimitConn(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		bytesPerSec uint64 = 512 * KB
+		duration           = time.Second
+		total       int64
+		wg          sync.WaitGroup
+	)
+	limiter := NewSharedLimiter(bytesPerSec)
+	buf := make([]byte, 32*1024)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		c1, c2 := net.Pipe()
+		go io.Copy(ioutil.Discard, c2)
+
+		wg.Add(1)
+		go func(c frpNet.Conn) {
+			defer wg.Done()
+			defer c.Close()
+			for time.Since(start) < duration {
+				n, err := c.Write(buf)
+				atomic.AddInt64(&total, int64(n))
+				if err != nil {
+					return
+				}
+			}
+		}(NewSharedLimitConn(limiter, frpNet.WrapConn(c1)))
+	}
+	wg.Wait()
+
+	// all connections share one limiter, so the aggregate throughput stays under the cap
+	elapsed := time.Since(start).Seconds()
+	assert.True(float64(total) <= float64(bytesPerSec)*elapsed+float64(sharedBurstLimit),
+		"total %d bytes in %.2fs exceeds the limit", total, elapsed)
+	assert.True(float64(total) >= float64(bytesPerSec)*duration.Seconds()/2,
+		"total %d bytes in %.2fs is too slow", total, elapsed)
+}
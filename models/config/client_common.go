@@ -19,8 +19,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"unicode"
 
 	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/util"
 )
 
 // client common config
@@ -32,6 +38,7 @@ type ClientCommonConf struct {
 	LogWay            string              `json:"log_way"`
 	LogLevel          string              `json:"log_level"`
 	LogMaxDays        int64               `json:"log_max_days"`
+	LogFormat         string              `json:"log_format"`
 	Token             string              `json:"token"`
 	AdminAddr         string              `json:"admin_addr"`
 	AdminPort         int                 `json:"admin_port"`
@@ -40,13 +47,66 @@ type ClientCommonConf struct {
 	PoolCount         int                 `json:"pool_count"`
 	TcpMux            bool                `json:"tcp_mux"`
 	User              string              `json:"user"`
+	RunId             string              `json:"run_id"`
 	DnsServer         string              `json:"dns_server"`
 	LoginFailExit     bool                `json:"login_fail_exit"`
 	Start             map[string]struct{} `json:"start"`
 	Protocol          string              `json:"protocol"`
+	WebsocketPath     string              `json:"websocket_path"`
 	TLSEnable         bool                `json:"tls_enable"`
 	HeartBeatInterval int64               `json:"heartbeat_interval"`
 	HeartBeatTimeout  int64               `json:"heartbeat_timeout"`
+
+	// Reconnect delay grows exponentially from ReconnectIntervalMin to ReconnectIntervalMax seconds.
+	ReconnectIntervalMin int64 `json:"reconnect_interval_min"`
+	ReconnectIntervalMax int64 `json:"reconnect_interval_max"`
+
+	// Metas are sent to frps in login message and shown in dashboard, set by "meta_" prefixed keys.
+	Metas map[string]string `json:"metas"`
+
+	// TransportBufferSize is the size in bytes of buffers used to copy data
+	// between connections, 0 means the default size 16KB.
+	TransportBufferSize int `json:"transport_buffer_size"`
+
+	// MaxMsgLength is the max length in bytes of messages read from control
+	// connections, connections sending longer ones are closed.
+	MaxMsgLength int64 `json:"max_msg_length"`
+
+	// DnsCacheTTLS is the max seconds dns answers are cached, 0 means no cache.
+	DnsCacheTTLS int64 `json:"dns_cache_ttl_s"`
+
+	// Includes are glob patterns of files which proxies and visitors are also
+	// loaded from, relative ones are resolved against the directory of CfgFile.
+	Includes []string `json:"includes"`
+}
+
+// MaxClientMetasSize is the upper limit of total bytes of keys and values of
+// metas in common section, they are sent to frps in Login message.
+const MaxClientMetasSize = 4096
+
+// MetasSize returns total bytes of keys and values of metas.
+func MetasSize(metas map[string]string) (n int) {
+	for k, v := range metas {
+		n += len(k) + len(v)
+	}
+	return
+}
+
+// MaxRunIdLength is the upper limit of bytes of run ids sent by frpc.
+const MaxRunIdLength = 128
+
+// IsValidRunId returns true if runId can be sent to frps in Login message, run
+// ids generated by frps are also valid.
+func IsValidRunId(runId string) bool {
+	if len(runId) == 0 || len(runId) > MaxRunIdLength {
+		return false
+	}
+	for _, r := range runId {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func GetDefaultClientConf() *ClientCommonConf {
@@ -58,6 +118,7 @@ func GetDefaultClientConf() *ClientCommonConf {
 		LogWay:            "console",
 		LogLevel:          "info",
 		LogMaxDays:        3,
+		LogFormat:         "text",
 		Token:             "",
 		AdminAddr:         "127.0.0.1",
 		AdminPort:         0,
@@ -70,9 +131,16 @@ func GetDefaultClientConf() *ClientCommonConf {
 		LoginFailExit:     true,
 		Start:             make(map[string]struct{}),
 		Protocol:          "tcp",
+		WebsocketPath:     frpNet.FrpWebsocketPath,
 		TLSEnable:         false,
 		HeartBeatInterval: 30,
 		HeartBeatTimeout:  90,
+		MaxMsgLength:      msg.DefaultMaxMsgLength,
+
+		ReconnectIntervalMin: 1,
+		ReconnectIntervalMax: 20,
+
+		Metas: make(map[string]string),
 	}
 }
 
@@ -129,12 +197,23 @@ func UnmarshalClientConfFromIni(defaultCfg *ClientCommonConf, content string) (c
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "log_format"); ok {
+		if !log.IsValidLogFormat(tmpStr) {
+			err = fmt.Errorf("Parse conf error: invalid log_format")
+			return
+		}
+		cfg.LogFormat = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "token"); ok {
-		cfg.Token = tmpStr
+		if cfg.Token, err = ResolveToken(tmpStr); err != nil {
+			err = fmt.Errorf("Parse conf error: %v", err)
+			return
+		}
 	}
 
 	if tmpStr, ok = conf.Get("common", "admin_addr"); ok {
-		cfg.AdminAddr = tmpStr
+		cfg.AdminAddr = util.UnbracketHost(tmpStr)
 	}
 
 	if tmpStr, ok = conf.Get("common", "admin_port"); ok {
@@ -170,10 +249,47 @@ func UnmarshalClientConfFromIni(defaultCfg *ClientCommonConf, content string) (c
 		cfg.User = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "run_id"); ok {
+		cfg.RunId = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "dns_server"); ok {
 		cfg.DnsServer = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "transport_buffer_size"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid transport_buffer_size")
+			return
+		}
+		cfg.TransportBufferSize = int(v)
+	}
+
+	if tmpStr, ok = conf.Get("common", "max_msg_length"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v <= 0 {
+			err = fmt.Errorf("Parse conf error: invalid max_msg_length")
+			return
+		}
+		cfg.MaxMsgLength = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "dns_cache_ttl_s"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid dns_cache_ttl_s")
+			return
+		} else {
+			cfg.DnsCacheTTLS = v
+		}
+	}
+
+	if tmpStr, ok = conf.Get("common", "includes"); ok {
+		for _, pattern := range strings.Split(tmpStr, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				cfg.Includes = append(cfg.Includes, pattern)
+			}
+		}
+	}
+
 	if tmpStr, ok = conf.Get("common", "start"); ok {
 		proxyNames := strings.Split(tmpStr, ",")
 		for _, name := range proxyNames {
@@ -181,6 +297,12 @@ func UnmarshalClientConfFromIni(defaultCfg *ClientCommonConf, content string) (c
 		}
 	}
 
+	for k, v := range conf.Section("common") {
+		if strings.HasPrefix(k, "meta_") {
+			cfg.Metas[strings.TrimPrefix(k, "meta_")] = v
+		}
+	}
+
 	if tmpStr, ok = conf.Get("common", "login_fail_exit"); ok && tmpStr == "false" {
 		cfg.LoginFailExit = false
 	} else {
@@ -196,6 +318,20 @@ func UnmarshalClientConfFromIni(defaultCfg *ClientCommonConf, content string) (c
 		cfg.Protocol = tmpStr
 	}
 
+	// http_proxy from the environment is only used by tcp, other protocols
+	// connect frps directly instead of failing in Check
+	if _, ok = conf.Get("common", "http_proxy"); !ok && cfg.Protocol != "tcp" {
+		cfg.HttpProxy = ""
+	}
+
+	if tmpStr, ok = conf.Get("common", "websocket_path"); ok {
+		if !strings.HasPrefix(tmpStr, "/") {
+			err = fmt.Errorf("Parse conf error: websocket_path must start with /")
+			return
+		}
+		cfg.WebsocketPath = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "tls_enable"); ok && tmpStr == "true" {
 		cfg.TLSEnable = true
 	} else {
@@ -219,10 +355,66 @@ func UnmarshalClientConfFromIni(defaultCfg *ClientCommonConf, content string) (c
 			cfg.HeartBeatInterval = v
 		}
 	}
+
+	if tmpStr, ok = conf.Get("common", "reconnect_interval_min"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid reconnect_interval_min")
+			return
+		} else {
+			cfg.ReconnectIntervalMin = v
+		}
+	}
+
+	if tmpStr, ok = conf.Get("common", "reconnect_interval_max"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid reconnect_interval_max")
+			return
+		} else {
+			cfg.ReconnectIntervalMax = v
+		}
+	}
 	return
 }
 
+// UnmarshalClientConfFromEnv overrides some common configs by environment variables,
+// the variable name is FRPC_ followed by the upper case of the config name,
+// e.g. FRPC_SERVER_ADDR. Empty variables are ignored.
+//
+// Supported: FRPC_SERVER_ADDR, FRPC_SERVER_PORT, FRPC_TOKEN, FRPC_USER.
+func UnmarshalClientConfFromEnv(cfg *ClientCommonConf) (err error) {
+	if tmpStr := os.Getenv("FRPC_SERVER_ADDR"); tmpStr != "" {
+		cfg.ServerAddr = tmpStr
+	}
+
+	if tmpStr := os.Getenv("FRPC_SERVER_PORT"); tmpStr != "" {
+		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
+		if errRet != nil {
+			return fmt.Errorf("Parse env error: invalid FRPC_SERVER_PORT")
+		}
+		cfg.ServerPort = int(v)
+	}
+
+	if tmpStr := os.Getenv("FRPC_TOKEN"); tmpStr != "" {
+		cfg.Token = tmpStr
+	}
+
+	if tmpStr := os.Getenv("FRPC_USER"); tmpStr != "" {
+		cfg.User = tmpStr
+	}
+	return nil
+}
+
 func (cfg *ClientCommonConf) Check() (err error) {
+	if MetasSize(cfg.Metas) > MaxClientMetasSize {
+		err = fmt.Errorf("Parse conf error: total size of metas should not be greater than %d bytes", MaxClientMetasSize)
+		return
+	}
+
+	if cfg.RunId != "" && !IsValidRunId(cfg.RunId) {
+		err = fmt.Errorf("Parse conf error: invalid run_id, it should be at most %d bytes without control characters", MaxRunIdLength)
+		return
+	}
+
 	if cfg.HeartBeatInterval <= 0 {
 		err = fmt.Errorf("Parse conf error: invalid heartbeat_interval")
 		return
@@ -232,5 +424,32 @@ func (cfg *ClientCommonConf) Check() (err error) {
 		err = fmt.Errorf("Parse conf error: invalid heartbeat_timeout, heartbeat_timeout is less than heartbeat_interval")
 		return
 	}
+
+	if cfg.ReconnectIntervalMin <= 0 {
+		err = fmt.Errorf("Parse conf error: invalid reconnect_interval_min")
+		return
+	}
+
+	if cfg.ReconnectIntervalMax < cfg.ReconnectIntervalMin {
+		err = fmt.Errorf("Parse conf error: invalid reconnect_interval_max, reconnect_interval_max is less than reconnect_interval_min")
+		return
+	}
+
+	if cfg.DnsCacheTTLS < 0 {
+		err = fmt.Errorf("Parse conf error: invalid dns_cache_ttl_s")
+		return
+	}
+
+	if cfg.HttpProxy != "" {
+		if _, err = frpNet.ParseProxyUrl(cfg.HttpProxy); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid http_proxy, %v", err)
+			return
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(cfg.HttpProxy), "socks5://") && cfg.Protocol != "tcp" {
+		err = fmt.Errorf("Parse conf error: socks5 proxy in http_proxy is not supported for protocol [%s]", cfg.Protocol)
+		return
+	}
 	return
 }
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConfHttpProxyFromEnv(t *testing.T) {
+	assert := assert.New(t)
+	oldProxy, hasProxy := os.LookupEnv("http_proxy")
+	defer func() {
+		if hasProxy {
+			os.Setenv("http_proxy", oldProxy)
+		} else {
+			os.Unsetenv("http_proxy")
+		}
+	}()
+	os.Setenv("http_proxy", "socks5://127.0.0.1:1080")
+
+	// the socks5 proxy in environment variables is ignored by kcp
+	cfg, err := UnmarshalClientConfFromIni(GetDefaultClientConf(), "[common]\nprotocol = kcp\n")
+	if assert.NoError(err) {
+		assert.Equal("", cfg.HttpProxy)
+		assert.NoError(cfg.Check())
+	}
+
+	cfg, err = UnmarshalClientConfFromIni(GetDefaultClientConf(), "[common]\nprotocol = tcp\n")
+	if assert.NoError(err) {
+		assert.Equal("socks5://127.0.0.1:1080", cfg.HttpProxy)
+		assert.NoError(cfg.Check())
+	}
+
+	// but it's an error if it's set in the config file
+	cfg, err = UnmarshalClientConfFromIni(GetDefaultClientConf(),
+		"[common]\nprotocol = websocket\nhttp_proxy = socks5://127.0.0.1:1080\n")
+	if assert.NoError(err) {
+		assert.Error(cfg.Check())
+	}
+}
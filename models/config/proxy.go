@@ -15,18 +15,56 @@
 package config
 
 import (
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/fatedier/frp/models/consts"
 	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/models/plugin"
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/util"
 
 	ini "github.com/vaughan0/go-ini"
 )
 
+const (
+	// MaxProxyPoolCount is the upper limit of pool_count in proxy section.
+	MaxProxyPoolCount = 100
+
+	// MaxCustomPageSize is the upper limit of custom_503_page in proxy section,
+	// it's sent to frps in NewProxy message which has a length limit.
+	MaxCustomPageSize = 4096
+
+	// DefaultDialTimeoutS is the default dial_timeout_s of local service.
+	DefaultDialTimeoutS = 10
+
+	// DefaultLazyIdleTimeoutS is the default lazy_idle_timeout_s of tcp proxies.
+	DefaultLazyIdleTimeoutS = 600
+
+	// modes of group_sticky, users stick to one proxy of http groups by
+	// cookie or by their ip
+	GroupStickyModeCookie = "cookie"
+	GroupStickyModeIp     = "ip"
+
+	// DefaultGroupStickyCookie is the cookie name of group_sticky = cookie if
+	// group_sticky_cookie is not set.
+	DefaultGroupStickyCookie = "frp_sticky"
+
+	// DefaultGroupStickyTTLS is the default group_sticky_ttl_s.
+	DefaultGroupStickyTTLS = 600
+
+	// modes of max_conns_per_sec, user connections over the rate wait or are closed
+	ConnsRateLimitModeDelay  = "delay"
+	ConnsRateLimitModeReject = "reject"
+)
+
 var (
 	proxyConfTypeMap map[string]reflect.Type
 )
@@ -39,6 +77,7 @@ func init() {
 	proxyConfTypeMap[consts.HttpsProxy] = reflect.TypeOf(HttpsProxyConf{})
 	proxyConfTypeMap[consts.StcpProxy] = reflect.TypeOf(StcpProxyConf{})
 	proxyConfTypeMap[consts.XtcpProxy] = reflect.TypeOf(XtcpProxyConf{})
+	proxyConfTypeMap[consts.TcpMuxProxy] = reflect.TypeOf(TcpMuxProxyConf{})
 }
 
 // NewConfByType creates a empty ProxyConf object by proxyType.
@@ -73,7 +112,18 @@ func NewProxyConfFromMsg(pMsg *msg.NewProxy) (cfg ProxyConf, err error) {
 		return
 	}
 	cfg.UnmarshalFromMsg(pMsg)
-	err = cfg.CheckForSvr()
+	if err = cfg.CheckForSvr(); err != nil {
+		return
+	}
+
+	if requireEncryption && !pMsg.UseEncryption {
+		err = fmt.Errorf("proxy [%s] should set use_encryption = true, it's required by server", pMsg.ProxyName)
+		return
+	}
+	if requireCompression && !pMsg.UseCompression {
+		err = fmt.Errorf("proxy [%s] should set use_compression = true, it's required by server", pMsg.ProxyName)
+		return
+	}
 	return
 }
 
@@ -107,8 +157,81 @@ type BaseProxyConf struct {
 	Group          string `json:"group"`
 	GroupKey       string `json:"group_key"`
 
+	// only used by http proxies in a group, requests carrying the cookie of
+	// this name are sent to the same proxy in the group
+	GroupStickyCookie string `json:"group_sticky_cookie"`
+
+	// only used by http proxies in a group, "cookie" is the same as setting
+	// GroupStickyCookie. For "ip", requests from the same ip are sent to the
+	// same proxy until GroupStickyTTLS seconds after the last of them.
+	GroupSticky     string `json:"group_sticky"`
+	GroupStickyTTLS int    `json:"group_sticky_ttl_s"`
+
+	// only used by tcp and http proxies in a group, the share of traffic of
+	// this proxy ramps up linearly in GroupSlowStartS seconds after it joins
+	// the group. 0 means no slow start.
+	GroupSlowStartS int `json:"group_slow_start_s"`
+
+	// snappy, gzip or zstd, default is snappy
+	CompressionAlgorithm string `json:"compression_algorithm"`
+
+	// Close user connections if no bytes flow in either direction for
+	// ProxyIdleTimeoutS seconds. 0 means no idle timeout.
+	ProxyIdleTimeoutS int `json:"proxy_idle_timeout_s"`
+
+	// Overrides the pool_count in common section for this proxy if greater than 0.
+	PoolCount int `json:"pool_count"`
+
+	// Period in seconds of tcp keepalive for work connections and user connections,
+	// 0 means the default behavior of OS.
+	TcpKeepAlive int `json:"tcp_keepalive"`
+
 	// only used for client
 	ProxyProtocolVersion string `json:"proxy_protocol_version"`
+
+	// only used for client, destination address and port in proxy protocol
+	// header, the ones user connected to are used if empty
+	ProxyProtocolDstAddr string `json:"proxy_protocol_dst_addr"`
+	ProxyProtocolDstPort int    `json:"proxy_protocol_dst_port"`
+
+	// only used for client, overrides the global log_level for this proxy if not empty
+	LogLevel string `json:"log_level"`
+
+	// only used for client, commands run asynchronously after the proxy
+	// starts and after it stops running, e.g. removed or closed by server.
+	// Arguments are split like a shell does, but no shell is used.
+	OnStartCmd string `json:"on_start_cmd"`
+	OnStopCmd  string `json:"on_stop_cmd"`
+
+	// only used for client, name of the range section which generates this proxy
+	RangeName string `json:"range_name"`
+
+	// only used for client, proxies of sections with enabled = false are only
+	// loaded with name and type, they are never started but shown as disabled
+	Disabled bool `json:"disabled"`
+
+	// only used for client, open up to pool_count work connections once the
+	// proxy starts, they're shared by all proxies of the client
+	PoolWarmup bool `json:"pool_warmup"`
+
+	// only used for client, sent to frps when the proxy is removed, e.g. by
+	// reloading, frps closes user connections still active after
+	// DrainTimeoutS seconds, 0 means they are not closed by frps
+	DrainTimeoutS int `json:"drain_timeout_s"`
+
+	// Max number of user connections accepted per second, 0 means no limit.
+	// New connections over the rate wait for their turns if MaxConnsPerSecMode
+	// is "delay", or are closed at once if it is "reject".
+	MaxConnsPerSec     int64  `json:"max_conns_per_sec"`
+	MaxConnsPerSecMode string `json:"max_conns_per_sec_mode"`
+
+	// Bytes in both directions allowed for the proxy, frps closes it once the
+	// quota is used up and rejects it with any quota until the usage is reset
+	// by frps. 0 means no quota.
+	TrafficQuota int64 `json:"traffic_quota"`
+
+	// shown in dashboard, set by "meta_" prefixed keys
+	Metas map[string]string `json:"metas"`
 	LocalSvrConf
 	HealthCheckConf
 }
@@ -124,9 +247,35 @@ func (cfg *BaseProxyConf) compare(cmp *BaseProxyConf) bool {
 		cfg.UseCompression != cmp.UseCompression ||
 		cfg.Group != cmp.Group ||
 		cfg.GroupKey != cmp.GroupKey ||
-		cfg.ProxyProtocolVersion != cmp.ProxyProtocolVersion {
+		cfg.GroupStickyCookie != cmp.GroupStickyCookie ||
+		cfg.GroupSticky != cmp.GroupSticky ||
+		cfg.GroupStickyTTLS != cmp.GroupStickyTTLS ||
+		cfg.GroupSlowStartS != cmp.GroupSlowStartS ||
+		cfg.ProxyIdleTimeoutS != cmp.ProxyIdleTimeoutS ||
+		cfg.PoolCount != cmp.PoolCount ||
+		cfg.TcpKeepAlive != cmp.TcpKeepAlive ||
+		cfg.CompressionAlgorithm != cmp.CompressionAlgorithm ||
+		cfg.ProxyProtocolVersion != cmp.ProxyProtocolVersion ||
+		cfg.ProxyProtocolDstAddr != cmp.ProxyProtocolDstAddr ||
+		cfg.ProxyProtocolDstPort != cmp.ProxyProtocolDstPort ||
+		cfg.LogLevel != cmp.LogLevel ||
+		cfg.OnStartCmd != cmp.OnStartCmd ||
+		cfg.OnStopCmd != cmp.OnStopCmd ||
+		cfg.RangeName != cmp.RangeName ||
+		cfg.Disabled != cmp.Disabled ||
+		cfg.PoolWarmup != cmp.PoolWarmup ||
+		cfg.DrainTimeoutS != cmp.DrainTimeoutS ||
+		cfg.MaxConnsPerSec != cmp.MaxConnsPerSec ||
+		cfg.MaxConnsPerSecMode != cmp.MaxConnsPerSecMode ||
+		cfg.TrafficQuota != cmp.TrafficQuota ||
+		len(cfg.Metas) != len(cmp.Metas) {
 		return false
 	}
+	for k, v := range cfg.Metas {
+		if v2, ok := cmp.Metas[k]; !ok || v != v2 {
+			return false
+		}
+	}
 	if !cfg.LocalSvrConf.compare(&cmp.LocalSvrConf) {
 		return false
 	}
@@ -143,6 +292,18 @@ func (cfg *BaseProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.UseCompression = pMsg.UseCompression
 	cfg.Group = pMsg.Group
 	cfg.GroupKey = pMsg.GroupKey
+	cfg.GroupStickyCookie = pMsg.GroupStickyCookie
+	cfg.GroupSticky = pMsg.GroupSticky
+	cfg.GroupStickyTTLS = pMsg.GroupStickyTTLS
+	cfg.GroupSlowStartS = pMsg.GroupSlowStartS
+	cfg.ProxyIdleTimeoutS = pMsg.ProxyIdleTimeoutS
+	cfg.PoolCount = pMsg.PoolCount
+	cfg.TcpKeepAlive = pMsg.TcpKeepAlive
+	cfg.CompressionAlgorithm = pMsg.CompressionAlgorithm
+	cfg.MaxConnsPerSec = pMsg.MaxConnsPerSec
+	cfg.MaxConnsPerSecMode = pMsg.MaxConnsPerSecMode
+	cfg.TrafficQuota = pMsg.TrafficQuota
+	cfg.Metas = pMsg.Metas
 }
 
 func (cfg *BaseProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) error {
@@ -162,10 +323,116 @@ func (cfg *BaseProxyConf) UnmarshalFromIni(prefix string, name string, section i
 	if ok && tmpStr == "true" {
 		cfg.UseCompression = true
 	}
+	cfg.CompressionAlgorithm = section["compression_algorithm"]
+
+	cfg.Metas = make(map[string]string)
+	for k, v := range section {
+		if strings.HasPrefix(k, "meta_") {
+			cfg.Metas[strings.TrimPrefix(k, "meta_")] = v
+		}
+	}
 
 	cfg.Group = section["group"]
 	cfg.GroupKey = section["group_key"]
+	cfg.GroupStickyCookie = section["group_sticky_cookie"]
+	cfg.GroupSticky = section["group_sticky"]
+	switch cfg.GroupSticky {
+	case GroupStickyModeCookie:
+		if cfg.GroupStickyCookie == "" {
+			cfg.GroupStickyCookie = DefaultGroupStickyCookie
+		}
+	case GroupStickyModeIp:
+		cfg.GroupStickyTTLS = DefaultGroupStickyTTLS
+	}
 	cfg.ProxyProtocolVersion = section["proxy_protocol_version"]
+	cfg.ProxyProtocolDstAddr = section["proxy_protocol_dst_addr"]
+	cfg.LogLevel = section["log_level"]
+	cfg.OnStartCmd = section["on_start_cmd"]
+	cfg.OnStopCmd = section["on_stop_cmd"]
+
+	if tmpStr, ok = section["proxy_protocol_dst_port"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v <= 0 || v > 65535 {
+			return fmt.Errorf("Parse conf error: proxy [%s] proxy_protocol_dst_port error", name)
+		}
+		cfg.ProxyProtocolDstPort = v
+	}
+
+	if tmpStr, ok = section["group_sticky_ttl_s"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("Parse conf error: proxy [%s] group_sticky_ttl_s error", name)
+		}
+		cfg.GroupStickyTTLS = v
+	}
+
+	if tmpStr, ok = section["group_slow_start_s"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: proxy [%s] group_slow_start_s error", name)
+		}
+		cfg.GroupSlowStartS = v
+	}
+
+	if tmpStr, ok = section["proxy_idle_timeout_s"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] proxy_idle_timeout_s error", name)
+		}
+		cfg.ProxyIdleTimeoutS = v
+	}
+
+	if tmpStr, ok = section["pool_count"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] pool_count error", name)
+		}
+		cfg.PoolCount = v
+	}
+
+	if tmpStr, ok = section["pool_warmup"]; ok && tmpStr == "true" {
+		cfg.PoolWarmup = true
+	}
+
+	if tmpStr, ok = section["drain_timeout_s"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: proxy [%s] drain_timeout_s error", name)
+		}
+		cfg.DrainTimeoutS = v
+	}
+
+	if tmpStr, ok = section["tcp_keepalive"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: proxy [%s] tcp_keepalive error", name)
+		}
+		cfg.TcpKeepAlive = v
+	}
+
+	if tmpStr, ok = section["max_conns_per_sec"]; ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: proxy [%s] max_conns_per_sec error", name)
+		}
+		cfg.MaxConnsPerSec = v
+	}
+
+	if tmpStr, ok = section["traffic_quota"]; ok {
+		v, err := util.ParseByteSize(tmpStr)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] traffic_quota error, %v", name, err)
+		}
+		cfg.TrafficQuota = v
+	}
+
+	cfg.MaxConnsPerSecMode = ConnsRateLimitModeDelay
+	if tmpStr, ok = section["max_conns_per_sec_mode"]; ok {
+		if tmpStr != ConnsRateLimitModeDelay && tmpStr != ConnsRateLimitModeReject {
+			return fmt.Errorf("Parse conf error: proxy [%s] max_conns_per_sec_mode should be delay or reject", name)
+		}
+		cfg.MaxConnsPerSecMode = tmpStr
+	}
 
 	if err := cfg.LocalSvrConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return err
@@ -175,11 +442,11 @@ func (cfg *BaseProxyConf) UnmarshalFromIni(prefix string, name string, section i
 		return err
 	}
 
-	if cfg.HealthCheckType == "tcp" && cfg.Plugin == "" {
-		cfg.HealthCheckAddr = cfg.LocalIp + fmt.Sprintf(":%d", cfg.LocalPort)
+	if (cfg.HealthCheckType == "tcp" || cfg.HealthCheckType == "udp") && cfg.Plugin == "" {
+		cfg.HealthCheckAddr = net.JoinHostPort(cfg.LocalIp, strconv.Itoa(cfg.LocalPort))
 	}
 	if cfg.HealthCheckType == "http" && cfg.Plugin == "" && cfg.HealthCheckUrl != "" {
-		s := fmt.Sprintf("http://%s:%d", cfg.LocalIp, cfg.LocalPort)
+		s := "http://" + net.JoinHostPort(cfg.LocalIp, strconv.Itoa(cfg.LocalPort))
 		if !strings.HasPrefix(cfg.HealthCheckUrl, "/") {
 			s += "/"
 		}
@@ -195,6 +462,18 @@ func (cfg *BaseProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	pMsg.UseCompression = cfg.UseCompression
 	pMsg.Group = cfg.Group
 	pMsg.GroupKey = cfg.GroupKey
+	pMsg.GroupStickyCookie = cfg.GroupStickyCookie
+	pMsg.GroupSticky = cfg.GroupSticky
+	pMsg.GroupStickyTTLS = cfg.GroupStickyTTLS
+	pMsg.GroupSlowStartS = cfg.GroupSlowStartS
+	pMsg.ProxyIdleTimeoutS = cfg.ProxyIdleTimeoutS
+	pMsg.PoolCount = cfg.PoolCount
+	pMsg.TcpKeepAlive = cfg.TcpKeepAlive
+	pMsg.CompressionAlgorithm = cfg.CompressionAlgorithm
+	pMsg.MaxConnsPerSec = cfg.MaxConnsPerSec
+	pMsg.MaxConnsPerSecMode = cfg.MaxConnsPerSecMode
+	pMsg.TrafficQuota = cfg.TrafficQuota
+	pMsg.Metas = cfg.Metas
 }
 
 func (cfg *BaseProxyConf) checkForCli() (err error) {
@@ -203,6 +482,70 @@ func (cfg *BaseProxyConf) checkForCli() (err error) {
 			return fmt.Errorf("no support proxy protocol version: %s", cfg.ProxyProtocolVersion)
 		}
 	}
+	if cfg.ProxyProtocolDstAddr != "" && net.ParseIP(cfg.ProxyProtocolDstAddr) == nil {
+		return fmt.Errorf("proxy_protocol_dst_addr [%s] is not a valid ip", cfg.ProxyProtocolDstAddr)
+	}
+
+	if cfg.LogLevel != "" && !log.IsValidLogLevel(cfg.LogLevel) {
+		return fmt.Errorf("no support log level: %s", cfg.LogLevel)
+	}
+
+	if _, err = util.SplitCommand(cfg.OnStartCmd); err != nil {
+		return fmt.Errorf("invalid on_start_cmd: %v", err)
+	}
+	if _, err = util.SplitCommand(cfg.OnStopCmd); err != nil {
+		return fmt.Errorf("invalid on_stop_cmd: %v", err)
+	}
+
+	if cfg.ProxyIdleTimeoutS < 0 {
+		return fmt.Errorf("proxy_idle_timeout_s should not be less than 0")
+	}
+
+	if !frpNet.IsSupportedCompression(cfg.CompressionAlgorithm) {
+		return fmt.Errorf("unsupported compression_algorithm: %s", cfg.CompressionAlgorithm)
+	}
+
+	if cfg.PoolCount < 0 || cfg.PoolCount > MaxProxyPoolCount {
+		return fmt.Errorf("pool_count should be between 0 and %d", MaxProxyPoolCount)
+	}
+
+	if cfg.GroupStickyCookie != "" {
+		if cfg.ProxyType != consts.HttpProxy || cfg.Group == "" {
+			return fmt.Errorf("group_sticky_cookie is only supported by http proxies in a group")
+		}
+		if strings.ContainsAny(cfg.GroupStickyCookie, " \t\r\n\"(),/:;<=>?@[\\]{}") {
+			return fmt.Errorf("group_sticky_cookie [%s] is not a valid cookie name", cfg.GroupStickyCookie)
+		}
+	}
+
+	switch cfg.GroupSticky {
+	case "", GroupStickyModeCookie:
+		if cfg.GroupStickyTTLS != 0 {
+			return fmt.Errorf("group_sticky_ttl_s is only supported by group_sticky = ip")
+		}
+	case GroupStickyModeIp:
+		if cfg.ProxyType != consts.HttpProxy || cfg.Group == "" {
+			return fmt.Errorf("group_sticky is only supported by http proxies in a group")
+		}
+		if cfg.GroupStickyCookie != "" {
+			return fmt.Errorf("group_sticky_cookie can't be used with group_sticky = ip")
+		}
+		if cfg.GroupStickyTTLS <= 0 {
+			return fmt.Errorf("group_sticky_ttl_s should be greater than 0")
+		}
+	default:
+		return fmt.Errorf("group_sticky should be cookie or ip")
+	}
+
+	if cfg.GroupSlowStartS > 0 {
+		if (cfg.ProxyType != consts.TcpProxy && cfg.ProxyType != consts.HttpProxy) || cfg.Group == "" {
+			return fmt.Errorf("group_slow_start_s is only supported by tcp and http proxies in a group")
+		}
+	}
+
+	if cfg.MaxConnsPerSec > 0 && (cfg.ProxyType == consts.HttpProxy || cfg.ProxyType == consts.UdpProxy) {
+		return fmt.Errorf("max_conns_per_sec is not supported by %s proxies", cfg.ProxyType)
+	}
 
 	if err = cfg.LocalSvrConf.checkForCli(); err != nil {
 		return
@@ -216,17 +559,28 @@ func (cfg *BaseProxyConf) checkForCli() (err error) {
 // Bind info
 type BindInfoConf struct {
 	RemotePort int `json:"remote_port"`
+
+	// only used by tcp proxies listening on several ports, e.g.
+	// "remote_port = 7000,7001", RemotePort is the first one of them
+	RemotePorts []int `json:"remote_ports"`
 }
 
 func (cfg *BindInfoConf) compare(cmp *BindInfoConf) bool {
-	if cfg.RemotePort != cmp.RemotePort {
+	if cfg.RemotePort != cmp.RemotePort ||
+		len(cfg.RemotePorts) != len(cmp.RemotePorts) {
 		return false
 	}
+	for i, port := range cfg.RemotePorts {
+		if port != cmp.RemotePorts[i] {
+			return false
+		}
+	}
 	return true
 }
 
 func (cfg *BindInfoConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.RemotePort = pMsg.RemotePort
+	cfg.RemotePorts = pMsg.RemotePorts
 }
 
 func (cfg *BindInfoConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -236,10 +590,15 @@ func (cfg *BindInfoConf) UnmarshalFromIni(prefix string, name string, section in
 		v      int64
 	)
 	if tmpStr, ok = section["remote_port"]; ok {
-		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
-			return fmt.Errorf("Parse conf error: proxy [%s] remote_port error", name)
-		} else {
-			cfg.RemotePort = int(v)
+		for _, portStr := range strings.Split(tmpStr, ",") {
+			if v, err = strconv.ParseInt(strings.TrimSpace(portStr), 10, 64); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] remote_port error", name)
+			}
+			cfg.RemotePorts = append(cfg.RemotePorts, int(v))
+		}
+		cfg.RemotePort = cfg.RemotePorts[0]
+		if len(cfg.RemotePorts) == 1 {
+			cfg.RemotePorts = nil
 		}
 	} else {
 		return fmt.Errorf("Parse conf error: proxy [%s] remote_port not found", name)
@@ -249,6 +608,38 @@ func (cfg *BindInfoConf) UnmarshalFromIni(prefix string, name string, section in
 
 func (cfg *BindInfoConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	pMsg.RemotePort = cfg.RemotePort
+	pMsg.RemotePorts = cfg.RemotePorts
+}
+
+// remote_port 0 means frps will assign a random port.
+func (cfg *BindInfoConf) checkForCli() (err error) {
+	if cfg.RemotePort < 0 || cfg.RemotePort > 65535 {
+		err = fmt.Errorf("error remote_port")
+		return
+	}
+	return
+}
+
+// checkRemotePorts checks the ports of a proxy listening on several ports,
+// they should be distinct and can't be 0.
+func (cfg *BindInfoConf) checkRemotePorts() error {
+	if len(cfg.RemotePorts) == 0 {
+		return nil
+	}
+	if cfg.RemotePorts[0] != cfg.RemotePort {
+		return fmt.Errorf("remote_port should be the first one of remote ports")
+	}
+	ports := make(map[int]struct{})
+	for _, port := range cfg.RemotePorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("error remote_port [%d], random port can't be used with other ports", port)
+		}
+		if _, ok := ports[port]; ok {
+			return fmt.Errorf("duplicate remote_port [%d]", port)
+		}
+		ports[port] = struct{}{}
+	}
+	return nil
 }
 
 // Domain info
@@ -319,6 +710,9 @@ func (cfg *DomainConf) checkForSvr() (err error) {
 				return fmt.Errorf("custom domain [%s] should not belong to subdomain_host [%s]", domain, subDomainHost)
 			}
 		}
+		if !isDomainAllowed(domain) {
+			return fmt.Errorf("custom domain [%s] is not allowed by server", domain)
+		}
 	}
 
 	if cfg.SubDomain != "" {
@@ -332,10 +726,32 @@ func (cfg *DomainConf) checkForSvr() (err error) {
 	return
 }
 
+// isDomainAllowed returns true if domain is in allow_domains or matches
+// allow_domains_regex of frps, or neither of them is set.
+func isDomainAllowed(domain string) bool {
+	if len(allowDomains) == 0 && allowDomainsRegexp == nil {
+		return true
+	}
+	domain = strings.ToLower(domain)
+	for _, allowed := range allowDomains {
+		if allowed == domain {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(domain, allowed[1:]) {
+			return true
+		}
+	}
+	return allowDomainsRegexp != nil && allowDomainsRegexp.MatchString(domain)
+}
+
 // Local service info
 type LocalSvrConf struct {
 	LocalIp   string `json:"local_ip"`
 	LocalPort int    `json:"local_port"`
+	// source ip used to connect local service, empty means chosen by system
+	LocalBindIp string `json:"local_bind_ip"`
+	// timeout in seconds of connecting local service, 0 means no timeout
+	DialTimeoutS int `json:"dial_timeout_s"`
 
 	Plugin       string            `json:"plugin"`
 	PluginParams map[string]string `json:"plugin_params"`
@@ -343,7 +759,9 @@ type LocalSvrConf struct {
 
 func (cfg *LocalSvrConf) compare(cmp *LocalSvrConf) bool {
 	if cfg.LocalIp != cmp.LocalIp ||
-		cfg.LocalPort != cmp.LocalPort {
+		cfg.LocalPort != cmp.LocalPort ||
+		cfg.LocalBindIp != cmp.LocalBindIp ||
+		cfg.DialTimeoutS != cmp.DialTimeoutS {
 		return false
 	}
 	if cfg.Plugin != cmp.Plugin ||
@@ -373,6 +791,14 @@ func (cfg *LocalSvrConf) UnmarshalFromIni(prefix string, name string, section in
 		if cfg.LocalIp = section["local_ip"]; cfg.LocalIp == "" {
 			cfg.LocalIp = "127.0.0.1"
 		}
+		cfg.LocalBindIp = section["local_bind_ip"]
+
+		cfg.DialTimeoutS = DefaultDialTimeoutS
+		if tmpStr, ok := section["dial_timeout_s"]; ok {
+			if cfg.DialTimeoutS, err = strconv.Atoi(tmpStr); err != nil || cfg.DialTimeoutS < 0 {
+				return fmt.Errorf("Parse conf error: proxy [%s] dial_timeout_s error", name)
+			}
+		}
 
 		if tmpStr, ok := section["local_port"]; ok {
 			if cfg.LocalPort, err = strconv.Atoi(tmpStr); err != nil {
@@ -395,18 +821,44 @@ func (cfg *LocalSvrConf) checkForCli() (err error) {
 			err = fmt.Errorf("error local_port")
 			return
 		}
+		if cfg.LocalBindIp != "" && net.ParseIP(cfg.LocalBindIp) == nil {
+			err = fmt.Errorf("error local_bind_ip")
+			return
+		}
+		if cfg.DialTimeoutS < 0 {
+			err = fmt.Errorf("dial_timeout_s should not be less than 0")
+			return
+		}
+	} else {
+		if err = plugin.Validate(cfg.Plugin, cfg.PluginParams); err != nil {
+			err = fmt.Errorf("plugin [%s] params error: %v", cfg.Plugin, err)
+			return
+		}
 	}
 	return
 }
 
 // Health check info
 type HealthCheckConf struct {
-	HealthCheckType      string `json:"health_check_type"` // tcp | http
+	HealthCheckType      string `json:"health_check_type"` // tcp | http | udp | command
 	HealthCheckTimeoutS  int    `json:"health_check_timeout_s"`
 	HealthCheckMaxFailed int    `json:"health_check_max_failed"`
 	HealthCheckIntervalS int    `json:"health_check_interval_s"`
 	HealthCheckUrl       string `json:"health_check_url"`
 
+	// If greater than 0, health check fails when HealthCheckMaxFailed failures
+	// happen within this many seconds, no matter if they are consecutive.
+	HealthCheckFailureWindowS int `json:"health_check_failure_window_s"`
+
+	// For udp, HealthCheckUdpSend is sent to local service and the check succeeds
+	// if the response contains HealthCheckUdpExpect, any response if it's empty.
+	HealthCheckUdpSend   string `json:"health_check_udp_send"`
+	HealthCheckUdpExpect string `json:"health_check_udp_expect"`
+
+	// For command, the check succeeds if HealthCheckCommand exits with 0 in
+	// HealthCheckTimeoutS. Arguments are split by spaces, no shell is used.
+	HealthCheckCommand string `json:"health_check_command"`
+
 	// local_ip + local_port
 	HealthCheckAddr string `json:"-"`
 }
@@ -416,7 +868,11 @@ func (cfg *HealthCheckConf) compare(cmp *HealthCheckConf) bool {
 		cfg.HealthCheckTimeoutS != cmp.HealthCheckTimeoutS ||
 		cfg.HealthCheckMaxFailed != cmp.HealthCheckMaxFailed ||
 		cfg.HealthCheckIntervalS != cmp.HealthCheckIntervalS ||
-		cfg.HealthCheckUrl != cmp.HealthCheckUrl {
+		cfg.HealthCheckUrl != cmp.HealthCheckUrl ||
+		cfg.HealthCheckFailureWindowS != cmp.HealthCheckFailureWindowS ||
+		cfg.HealthCheckUdpSend != cmp.HealthCheckUdpSend ||
+		cfg.HealthCheckUdpExpect != cmp.HealthCheckUdpExpect ||
+		cfg.HealthCheckCommand != cmp.HealthCheckCommand {
 		return false
 	}
 	return true
@@ -425,6 +881,9 @@ func (cfg *HealthCheckConf) compare(cmp *HealthCheckConf) bool {
 func (cfg *HealthCheckConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
 	cfg.HealthCheckType = section["health_check_type"]
 	cfg.HealthCheckUrl = section["health_check_url"]
+	cfg.HealthCheckUdpSend = section["health_check_udp_send"]
+	cfg.HealthCheckUdpExpect = section["health_check_udp_expect"]
+	cfg.HealthCheckCommand = section["health_check_command"]
 
 	if tmpStr, ok := section["health_check_timeout_s"]; ok {
 		if cfg.HealthCheckTimeoutS, err = strconv.Atoi(tmpStr); err != nil {
@@ -443,17 +902,53 @@ func (cfg *HealthCheckConf) UnmarshalFromIni(prefix string, name string, section
 			return fmt.Errorf("Parse conf error: proxy [%s] health_check_interval_s error", name)
 		}
 	}
+
+	if tmpStr, ok := section["health_check_failure_window_s"]; ok {
+		if cfg.HealthCheckFailureWindowS, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] health_check_failure_window_s error", name)
+		}
+	}
 	return
 }
 
 func (cfg *HealthCheckConf) checkForCli() error {
-	if cfg.HealthCheckType != "" && cfg.HealthCheckType != "tcp" && cfg.HealthCheckType != "http" {
+	if cfg.HealthCheckType != "" && cfg.HealthCheckType != "tcp" && cfg.HealthCheckType != "http" &&
+		cfg.HealthCheckType != "udp" && cfg.HealthCheckType != "command" {
 		return fmt.Errorf("unsupport health check type")
 	}
 	if cfg.HealthCheckType != "" {
 		if cfg.HealthCheckType == "http" && cfg.HealthCheckUrl == "" {
 			return fmt.Errorf("health_check_url is required for health check type 'http'")
 		}
+		if cfg.HealthCheckType == "udp" && cfg.HealthCheckUdpSend == "" {
+			return fmt.Errorf("health_check_udp_send is required for health check type 'udp'")
+		}
+		if cfg.HealthCheckType == "command" && strings.TrimSpace(cfg.HealthCheckCommand) == "" {
+			return fmt.Errorf("health_check_command is required for health check type 'command'")
+		}
+	}
+	if cfg.HealthCheckType != "command" && cfg.HealthCheckCommand != "" {
+		return fmt.Errorf("health_check_command is only available for health check type 'command'")
+	}
+	if cfg.HealthCheckType != "udp" && (cfg.HealthCheckUdpSend != "" || cfg.HealthCheckUdpExpect != "") {
+		return fmt.Errorf("health_check_udp_send and health_check_udp_expect are only available for health check type 'udp'")
+	}
+	if cfg.HealthCheckFailureWindowS < 0 {
+		return fmt.Errorf("health_check_failure_window_s should not be negative")
+	}
+	if cfg.HealthCheckFailureWindowS > 0 {
+		if cfg.HealthCheckType == "" {
+			return fmt.Errorf("health_check_failure_window_s is only available when health_check_type is set")
+		}
+		// the window should be long enough to hold health_check_max_failed checks
+		intervalS := cfg.HealthCheckIntervalS
+		if intervalS <= 0 {
+			intervalS = 10
+		}
+		if cfg.HealthCheckMaxFailed > 1 && cfg.HealthCheckFailureWindowS < intervalS*(cfg.HealthCheckMaxFailed-1) {
+			return fmt.Errorf("health_check_failure_window_s is too short for %d failures with interval %d seconds",
+				cfg.HealthCheckMaxFailed, intervalS)
+		}
 	}
 	return nil
 }
@@ -462,6 +957,12 @@ func (cfg *HealthCheckConf) checkForCli() error {
 type TcpProxyConf struct {
 	BaseProxyConf
 	BindInfoConf
+
+	// If Lazy is true, frps keeps the remote port but only accepts user
+	// connections after frpc asks, and refuses them again after
+	// LazyIdleTimeoutS seconds without user connections.
+	Lazy             bool `json:"lazy"`
+	LazyIdleTimeoutS int  `json:"lazy_idle_timeout_s"`
 }
 
 func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
@@ -471,7 +972,9 @@ func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
 	}
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
-		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) {
+		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) ||
+		cfg.Lazy != cmpConf.Lazy ||
+		cfg.LazyIdleTimeoutS != cmpConf.LazyIdleTimeoutS {
 		return false
 	}
 	return true
@@ -480,6 +983,8 @@ func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *TcpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.BindInfoConf.UnmarshalFromMsg(pMsg)
+	cfg.Lazy = pMsg.Lazy
+	cfg.LazyIdleTimeoutS = pMsg.LazyIdleTimeoutS
 }
 
 func (cfg *TcpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -489,27 +994,77 @@ func (cfg *TcpProxyConf) UnmarshalFromIni(prefix string, name string, section in
 	if err = cfg.BindInfoConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+
+	if tmpStr, ok := section["lazy"]; ok && tmpStr == "true" {
+		cfg.Lazy = true
+	}
+	cfg.LazyIdleTimeoutS = DefaultLazyIdleTimeoutS
+	if tmpStr, ok := section["lazy_idle_timeout_s"]; ok {
+		if cfg.LazyIdleTimeoutS, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] lazy_idle_timeout_s error", name)
+		}
+	}
 	return
 }
 
 func (cfg *TcpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.BindInfoConf.MarshalToMsg(pMsg)
+	pMsg.Lazy = cfg.Lazy
+	pMsg.LazyIdleTimeoutS = cfg.LazyIdleTimeoutS
 }
 
 func (cfg *TcpProxyConf) CheckForCli() (err error) {
 	if err = cfg.BaseProxyConf.checkForCli(); err != nil {
 		return err
 	}
-	return
+	if err = cfg.BindInfoConf.checkForCli(); err != nil {
+		return err
+	}
+	if err = cfg.checkRemotePorts(); err != nil {
+		return err
+	}
+	return cfg.checkLazy()
+}
+
+func (cfg *TcpProxyConf) CheckForSvr() error {
+	if err := cfg.checkRemotePorts(); err != nil {
+		return err
+	}
+	return cfg.checkLazy()
+}
+
+func (cfg *TcpProxyConf) checkRemotePorts() error {
+	if len(cfg.RemotePorts) == 0 {
+		return nil
+	}
+	if cfg.Group != "" || cfg.Lazy {
+		return fmt.Errorf("proxy with several remote ports can't be lazy or in a group")
+	}
+	return cfg.BindInfoConf.checkRemotePorts()
 }
 
-func (cfg *TcpProxyConf) CheckForSvr() error { return nil }
+func (cfg *TcpProxyConf) checkLazy() error {
+	if !cfg.Lazy {
+		return nil
+	}
+	if cfg.Group != "" {
+		return fmt.Errorf("lazy proxy can't be in a group")
+	}
+	if cfg.LazyIdleTimeoutS <= 0 {
+		return fmt.Errorf("lazy_idle_timeout_s should be greater than 0")
+	}
+	return nil
+}
 
 // UDP
 type UdpProxyConf struct {
 	BaseProxyConf
 	BindInfoConf
+
+	// Work connections of this proxy are dedicated kcp connections to frps
+	// instead of the ones in the pool.
+	UseKcp bool `json:"use_kcp"`
 }
 
 func (cfg *UdpProxyConf) Compare(cmp ProxyConf) bool {
@@ -519,7 +1074,8 @@ func (cfg *UdpProxyConf) Compare(cmp ProxyConf) bool {
 	}
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
-		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) {
+		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) ||
+		cfg.UseKcp != cmpConf.UseKcp {
 		return false
 	}
 	return true
@@ -528,6 +1084,7 @@ func (cfg *UdpProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *UdpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.BindInfoConf.UnmarshalFromMsg(pMsg)
+	cfg.UseKcp = pMsg.UseKcp
 }
 
 func (cfg *UdpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -537,22 +1094,40 @@ func (cfg *UdpProxyConf) UnmarshalFromIni(prefix string, name string, section in
 	if err = cfg.BindInfoConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	if tmpStr, ok := section["use_kcp"]; ok && tmpStr == "true" {
+		cfg.UseKcp = true
+	}
 	return
 }
 
 func (cfg *UdpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.BindInfoConf.MarshalToMsg(pMsg)
+	pMsg.UseKcp = cfg.UseKcp
 }
 
 func (cfg *UdpProxyConf) CheckForCli() (err error) {
 	if err = cfg.BaseProxyConf.checkForCli(); err != nil {
 		return
 	}
+	if err = cfg.BindInfoConf.checkForCli(); err != nil {
+		return
+	}
+	if len(cfg.RemotePorts) > 0 {
+		return fmt.Errorf("several remote ports are only supported by tcp proxies")
+	}
 	return
 }
 
-func (cfg *UdpProxyConf) CheckForSvr() error { return nil }
+func (cfg *UdpProxyConf) CheckForSvr() error {
+	if len(cfg.RemotePorts) > 0 {
+		return fmt.Errorf("several remote ports are only supported by tcp proxies")
+	}
+	if cfg.UseKcp && kcpBindPort == 0 {
+		return fmt.Errorf("proxy [%s] use_kcp is not supported when kcp_bind_port is not set", cfg.ProxyName)
+	}
+	return nil
+}
 
 // HTTP
 type HttpProxyConf struct {
@@ -564,6 +1139,33 @@ type HttpProxyConf struct {
 	HttpPwd           string            `json:"http_pwd"`
 	HostHeaderRewrite string            `json:"host_header_rewrite"`
 	Headers           map[string]string `json:"headers"`
+
+	// location -> new path prefix forwarded to local service
+	LocationRewrite map[string]string `json:"location_rewrite"`
+
+	// custom domain -> locations, overrides Locations for this domain
+	DomainLocations map[string][]string `json:"domain_locations"`
+
+	// realm in WWW-Authenticate header
+	HttpAuthRealm string `json:"http_auth_realm"`
+	// requests to these paths or under them don't need basic auth
+	HttpAuthExemptPaths []string `json:"http_auth_exempt_paths"`
+
+	// gzip responses if user accepts it and local service doesn't compress them
+	HttpResponseGzip bool `json:"http_response_gzip"`
+
+	// set X-Real-IP header to the ip of user, X-Forwarded-For is always appended
+	HttpSetForwardedHeaders bool `json:"http_set_forwarded_headers"`
+
+	// websocket upgrade requests are forwarded to local service as raw tcp streams
+	Websocket bool `json:"websocket"`
+
+	// frps speaks HTTP/2 without tls (h2c) to local service, e.g. grpc servers
+	Http2Backend bool `json:"http2_backend"`
+
+	// content of the page shown when local service is unavailable,
+	// frpc reads it from the file if custom_503_page is not inline html
+	Custom503Page string `json:"custom_503_page"`
 }
 
 func (cfg *HttpProxyConf) Compare(cmp ProxyConf) bool {
@@ -578,7 +1180,16 @@ func (cfg *HttpProxyConf) Compare(cmp ProxyConf) bool {
 		cfg.HostHeaderRewrite != cmpConf.HostHeaderRewrite ||
 		cfg.HttpUser != cmpConf.HttpUser ||
 		cfg.HttpPwd != cmpConf.HttpPwd ||
-		len(cfg.Headers) != len(cmpConf.Headers) {
+		cfg.HttpAuthRealm != cmpConf.HttpAuthRealm ||
+		cfg.HttpResponseGzip != cmpConf.HttpResponseGzip ||
+		cfg.HttpSetForwardedHeaders != cmpConf.HttpSetForwardedHeaders ||
+		cfg.Websocket != cmpConf.Websocket ||
+		cfg.Http2Backend != cmpConf.Http2Backend ||
+		cfg.Custom503Page != cmpConf.Custom503Page ||
+		strings.Join(cfg.HttpAuthExemptPaths, " ") != strings.Join(cmpConf.HttpAuthExemptPaths, " ") ||
+		len(cfg.Headers) != len(cmpConf.Headers) ||
+		len(cfg.LocationRewrite) != len(cmpConf.LocationRewrite) ||
+		len(cfg.DomainLocations) != len(cmpConf.DomainLocations) {
 		return false
 	}
 
@@ -591,6 +1202,18 @@ func (cfg *HttpProxyConf) Compare(cmp ProxyConf) bool {
 			}
 		}
 	}
+
+	for k, v := range cfg.LocationRewrite {
+		if v2, ok := cmpConf.LocationRewrite[k]; !ok || v != v2 {
+			return false
+		}
+	}
+
+	for k, v := range cfg.DomainLocations {
+		if v2, ok := cmpConf.DomainLocations[k]; !ok || strings.Join(v, " ") != strings.Join(v2, " ") {
+			return false
+		}
+	}
 	return true
 }
 
@@ -603,6 +1226,15 @@ func (cfg *HttpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.HttpUser = pMsg.HttpUser
 	cfg.HttpPwd = pMsg.HttpPwd
 	cfg.Headers = pMsg.Headers
+	cfg.LocationRewrite = pMsg.LocationRewrite
+	cfg.DomainLocations = pMsg.DomainLocations
+	cfg.HttpAuthRealm = pMsg.HttpAuthRealm
+	cfg.HttpAuthExemptPaths = pMsg.HttpAuthExemptPaths
+	cfg.HttpResponseGzip = pMsg.HttpResponseGzip
+	cfg.HttpSetForwardedHeaders = pMsg.HttpSetForwardedHeaders
+	cfg.Websocket = pMsg.Websocket
+	cfg.Http2Backend = pMsg.Http2Backend
+	cfg.Custom503Page = pMsg.Custom503Page
 }
 
 func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -626,6 +1258,36 @@ func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 	cfg.HostHeaderRewrite = section["host_header_rewrite"]
 	cfg.HttpUser = section["http_user"]
 	cfg.HttpPwd = section["http_pwd"]
+	cfg.HttpAuthRealm = section["http_auth_realm"]
+	if tmpStr, ok = section["http_auth_exempt_paths"]; ok {
+		cfg.HttpAuthExemptPaths = strings.Split(tmpStr, ",")
+		for i, path := range cfg.HttpAuthExemptPaths {
+			cfg.HttpAuthExemptPaths[i] = strings.TrimSpace(path)
+		}
+	}
+	if tmpStr, ok = section["http_response_gzip"]; ok && tmpStr == "true" {
+		cfg.HttpResponseGzip = true
+	}
+	if tmpStr, ok = section["http_set_forwarded_headers"]; ok && tmpStr == "true" {
+		cfg.HttpSetForwardedHeaders = true
+	}
+	if tmpStr, ok = section["websocket"]; ok && tmpStr == "true" {
+		cfg.Websocket = true
+	}
+	if tmpStr, ok = section["http2_backend"]; ok && tmpStr == "true" {
+		cfg.Http2Backend = true
+	}
+	if tmpStr, ok = section["custom_503_page"]; ok && tmpStr != "" {
+		if strings.HasPrefix(strings.TrimSpace(tmpStr), "<") {
+			cfg.Custom503Page = tmpStr
+		} else {
+			buf, errRet := ioutil.ReadFile(tmpStr)
+			if errRet != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] read custom_503_page error: %v", name, errRet)
+			}
+			cfg.Custom503Page = string(buf)
+		}
+	}
 	cfg.Headers = make(map[string]string)
 
 	for k, v := range section {
@@ -633,6 +1295,35 @@ func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 			cfg.Headers[strings.TrimPrefix(k, "header_")] = v
 		}
 	}
+
+	// e.g. locations_static.example.com = /assets,/img
+	cfg.DomainLocations = make(map[string][]string)
+	for k, v := range section {
+		if strings.HasPrefix(k, "locations_") {
+			domain := strings.ToLower(strings.TrimPrefix(k, "locations_"))
+			locations := strings.Split(v, ",")
+			for i, location := range locations {
+				locations[i] = strings.TrimSpace(location)
+			}
+			cfg.DomainLocations[domain] = locations
+		}
+	}
+
+	// e.g. /api:/,/static:/assets
+	cfg.LocationRewrite = make(map[string]string)
+	if tmpStr, ok = section["location_rewrite"]; ok {
+		for _, item := range strings.Split(tmpStr, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			kv := strings.SplitN(item, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("Parse conf error: proxy [%s] location_rewrite [%s] format error", name, item)
+			}
+			cfg.LocationRewrite[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
 	return
 }
 
@@ -645,6 +1336,15 @@ func (cfg *HttpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	pMsg.HttpUser = cfg.HttpUser
 	pMsg.HttpPwd = cfg.HttpPwd
 	pMsg.Headers = cfg.Headers
+	pMsg.LocationRewrite = cfg.LocationRewrite
+	pMsg.DomainLocations = cfg.DomainLocations
+	pMsg.HttpAuthRealm = cfg.HttpAuthRealm
+	pMsg.HttpAuthExemptPaths = cfg.HttpAuthExemptPaths
+	pMsg.HttpResponseGzip = cfg.HttpResponseGzip
+	pMsg.HttpSetForwardedHeaders = cfg.HttpSetForwardedHeaders
+	pMsg.Websocket = cfg.Websocket
+	pMsg.Http2Backend = cfg.Http2Backend
+	pMsg.Custom503Page = cfg.Custom503Page
 }
 
 func (cfg *HttpProxyConf) CheckForCli() (err error) {
@@ -654,6 +1354,44 @@ func (cfg *HttpProxyConf) CheckForCli() (err error) {
 	if err = cfg.DomainConf.checkForCli(); err != nil {
 		return
 	}
+	if cfg.Http2Backend && cfg.Plugin != "" {
+		return fmt.Errorf("http2_backend can't be used with plugin, plugins only speak HTTP/1.1")
+	}
+	for domain := range cfg.DomainLocations {
+		found := false
+		for _, d := range cfg.CustomDomains {
+			if strings.ToLower(d) == domain {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("locations of domain [%s] is set but it's not in custom_domains", domain)
+		}
+	}
+	for location := range cfg.LocationRewrite {
+		found := false
+		for _, l := range cfg.Locations {
+			if l == location {
+				found = true
+				break
+			}
+		}
+		for _, locations := range cfg.DomainLocations {
+			for _, l := range locations {
+				if l == location {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("location_rewrite [%s] is not in locations", location)
+		}
+	}
+	if len(cfg.Custom503Page) > MaxCustomPageSize {
+		return fmt.Errorf("custom_503_page should not be larger than %d bytes", MaxCustomPageSize)
+	}
 	return
 }
 
@@ -672,6 +1410,15 @@ func (cfg *HttpProxyConf) CheckForSvr() (err error) {
 type HttpsProxyConf struct {
 	BaseProxyConf
 	DomainConf
+
+	// If VerifyClientCert is true, frps terminates tls by its own certificate and
+	// rejects users without a certificate signed by ClientCaFile, local service
+	// receives plain data. Otherwise tls is passed through to local service.
+	VerifyClientCert bool   `json:"verify_client_cert"`
+	ClientCaFile     string `json:"client_ca_file"`
+
+	// PEM content of ClientCaFile, read by frpc and sent to frps.
+	ClientCa string `json:"-"`
 }
 
 func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
@@ -681,7 +1428,10 @@ func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
 	}
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
-		!cfg.DomainConf.compare(&cmpConf.DomainConf) {
+		!cfg.DomainConf.compare(&cmpConf.DomainConf) ||
+		cfg.VerifyClientCert != cmpConf.VerifyClientCert ||
+		cfg.ClientCaFile != cmpConf.ClientCaFile ||
+		cfg.ClientCa != cmpConf.ClientCa {
 		return false
 	}
 	return true
@@ -690,6 +1440,8 @@ func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *HttpsProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.DomainConf.UnmarshalFromMsg(pMsg)
+	cfg.VerifyClientCert = pMsg.VerifyClientCert
+	cfg.ClientCa = pMsg.ClientCa
 }
 
 func (cfg *HttpsProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -699,12 +1451,26 @@ func (cfg *HttpsProxyConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.DomainConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+
+	if tmpStr, ok := section["verify_client_cert"]; ok && tmpStr == "true" {
+		cfg.VerifyClientCert = true
+	}
+	cfg.ClientCaFile = section["client_ca_file"]
+	if cfg.ClientCaFile != "" {
+		buf, errRet := ioutil.ReadFile(cfg.ClientCaFile)
+		if errRet != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] client_ca_file error: %v", name, errRet)
+		}
+		cfg.ClientCa = string(buf)
+	}
 	return
 }
 
 func (cfg *HttpsProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.DomainConf.MarshalToMsg(pMsg)
+	pMsg.VerifyClientCert = cfg.VerifyClientCert
+	pMsg.ClientCa = cfg.ClientCa
 }
 
 func (cfg *HttpsProxyConf) CheckForCli() (err error) {
@@ -714,6 +1480,12 @@ func (cfg *HttpsProxyConf) CheckForCli() (err error) {
 	if err = cfg.DomainConf.checkForCli(); err != nil {
 		return
 	}
+	if cfg.VerifyClientCert && cfg.ClientCaFile == "" {
+		return fmt.Errorf("client_ca_file is required if verify_client_cert is true")
+	}
+	if !cfg.VerifyClientCert && cfg.ClientCaFile != "" {
+		return fmt.Errorf("client_ca_file is only available if verify_client_cert is true")
+	}
 	return
 }
 
@@ -725,6 +1497,98 @@ func (cfg *HttpsProxyConf) CheckForSvr() (err error) {
 		err = fmt.Errorf("proxy [%s] domain conf check error: %v", cfg.ProxyName, err)
 		return
 	}
+	if vhostHttpsAcmeEnabled {
+		// certificates of wildcard domains can't be obtained by http-01 challenges
+		for _, domain := range cfg.CustomDomains {
+			if strings.HasPrefix(domain, "*.") {
+				return fmt.Errorf("proxy [%s] wildcard domain [%s] is not supported when vhost_https_acme_enable is true", cfg.ProxyName, domain)
+			}
+		}
+	}
+	if cfg.VerifyClientCert {
+		if !vhostHttpsTlsEnabled && !vhostHttpsAcmeEnabled {
+			return fmt.Errorf("verify_client_cert is not supported when vhost_https_tls_cert_file is not set")
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(cfg.ClientCa)) {
+			return fmt.Errorf("proxy [%s] has no valid certificate in client ca", cfg.ProxyName)
+		}
+	}
+	return
+}
+
+// TCPMUX
+type TcpMuxProxyConf struct {
+	BaseProxyConf
+	DomainConf
+
+	Multiplexer string `json:"multiplexer"`
+}
+
+func (cfg *TcpMuxProxyConf) Compare(cmp ProxyConf) bool {
+	cmpConf, ok := cmp.(*TcpMuxProxyConf)
+	if !ok {
+		return false
+	}
+
+	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
+		!cfg.DomainConf.compare(&cmpConf.DomainConf) ||
+		cfg.Multiplexer != cmpConf.Multiplexer {
+		return false
+	}
+	return true
+}
+
+func (cfg *TcpMuxProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
+	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
+	cfg.DomainConf.UnmarshalFromMsg(pMsg)
+	cfg.Multiplexer = pMsg.Multiplexer
+}
+
+func (cfg *TcpMuxProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
+	if err = cfg.BaseProxyConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
+	if err = cfg.DomainConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
+
+	cfg.Multiplexer = section["multiplexer"]
+	if cfg.Multiplexer != consts.HttpConnectTcpMultiplexer {
+		return fmt.Errorf("Parse conf error: proxy [%s] incorrect multiplexer [%s]", name, cfg.Multiplexer)
+	}
+	return
+}
+
+func (cfg *TcpMuxProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
+	cfg.BaseProxyConf.MarshalToMsg(pMsg)
+	cfg.DomainConf.MarshalToMsg(pMsg)
+	pMsg.Multiplexer = cfg.Multiplexer
+}
+
+func (cfg *TcpMuxProxyConf) CheckForCli() (err error) {
+	if err = cfg.BaseProxyConf.checkForCli(); err != nil {
+		return
+	}
+	if err = cfg.DomainConf.checkForCli(); err != nil {
+		return
+	}
+	if cfg.Multiplexer != consts.HttpConnectTcpMultiplexer {
+		return fmt.Errorf("multiplexer should be 'httpconnect'")
+	}
+	return
+}
+
+func (cfg *TcpMuxProxyConf) CheckForSvr() (err error) {
+	if cfg.Multiplexer != consts.HttpConnectTcpMultiplexer {
+		return fmt.Errorf("proxy [%s] incorrect multiplexer [%s]", cfg.ProxyName, cfg.Multiplexer)
+	}
+	if tcpMuxHttpConnectPort == 0 {
+		return fmt.Errorf("type [tcpmux] with multiplexer [httpconnect] not support when tcpmux_httpconnect_port is not set")
+	}
+	if err = cfg.DomainConf.checkForSvr(); err != nil {
+		err = fmt.Errorf("proxy [%s] domain conf check error: %v", cfg.ProxyName, err)
+		return
+	}
 	return
 }
 
@@ -734,6 +1598,15 @@ type StcpProxyConf struct {
 
 	Role string `json:"role"`
 	Sk   string `json:"sk"`
+
+	// If VisitorVerifyCert is true, frps rejects visitors without a certificate
+	// signed by VisitorCaFile. Sk is still checked, leave it empty to
+	// authenticate visitors by certificates only.
+	VisitorVerifyCert bool   `json:"visitor_verify_cert"`
+	VisitorCaFile     string `json:"visitor_ca_file"`
+
+	// PEM content of VisitorCaFile, read by frpc and sent to frps.
+	VisitorCa string `json:"-"`
 }
 
 func (cfg *StcpProxyConf) Compare(cmp ProxyConf) bool {
@@ -744,7 +1617,10 @@ func (cfg *StcpProxyConf) Compare(cmp ProxyConf) bool {
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
 		cfg.Role != cmpConf.Role ||
-		cfg.Sk != cmpConf.Sk {
+		cfg.Sk != cmpConf.Sk ||
+		cfg.VisitorVerifyCert != cmpConf.VisitorVerifyCert ||
+		cfg.VisitorCaFile != cmpConf.VisitorCaFile ||
+		cfg.VisitorCa != cmpConf.VisitorCa {
 		return false
 	}
 	return true
@@ -754,6 +1630,8 @@ func (cfg *StcpProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *StcpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.Sk = pMsg.Sk
+	cfg.VisitorVerifyCert = pMsg.VisitorVerifyCert
+	cfg.VisitorCa = pMsg.VisitorCa
 }
 
 func (cfg *StcpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -768,6 +1646,18 @@ func (cfg *StcpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 
 	cfg.Sk = section["sk"]
 
+	if tmpStr, ok := section["visitor_verify_cert"]; ok && tmpStr == "true" {
+		cfg.VisitorVerifyCert = true
+	}
+	cfg.VisitorCaFile = section["visitor_ca_file"]
+	if cfg.VisitorCaFile != "" {
+		buf, errRet := ioutil.ReadFile(cfg.VisitorCaFile)
+		if errRet != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] visitor_ca_file error: %v", name, errRet)
+		}
+		cfg.VisitorCa = string(buf)
+	}
+
 	if err = cfg.LocalSvrConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
@@ -777,6 +1667,8 @@ func (cfg *StcpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 func (cfg *StcpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	pMsg.Sk = cfg.Sk
+	pMsg.VisitorVerifyCert = cfg.VisitorVerifyCert
+	pMsg.VisitorCa = cfg.VisitorCa
 }
 
 func (cfg *StcpProxyConf) CheckForCli() (err error) {
@@ -787,10 +1679,19 @@ func (cfg *StcpProxyConf) CheckForCli() (err error) {
 		err = fmt.Errorf("role should be 'server'")
 		return
 	}
+	if cfg.VisitorVerifyCert && cfg.VisitorCaFile == "" {
+		return fmt.Errorf("visitor_ca_file is required if visitor_verify_cert is true")
+	}
+	if !cfg.VisitorVerifyCert && cfg.VisitorCaFile != "" {
+		return fmt.Errorf("visitor_ca_file is only available if visitor_verify_cert is true")
+	}
 	return
 }
 
 func (cfg *StcpProxyConf) CheckForSvr() (err error) {
+	if cfg.VisitorVerifyCert && !x509.NewCertPool().AppendCertsFromPEM([]byte(cfg.VisitorCa)) {
+		return fmt.Errorf("proxy [%s] has no valid certificate in visitor ca", cfg.ProxyName)
+	}
 	return
 }
 
@@ -800,6 +1701,16 @@ type XtcpProxyConf struct {
 
 	Role string `json:"role"`
 	Sk   string `json:"sk"`
+
+	// also accept connections relayed by frps like stcp,
+	// visitors use it when nat hole punching fails
+	FallbackToStcp bool `json:"fallback_to_stcp"`
+
+	// only used for client, detect messages are also sent to these ports of
+	// visitor with DetectTtl, it helps with symmetric nats
+	DetectPortRange string  `json:"detect_port_range"`
+	DetectPorts     []int64 `json:"-"`
+	DetectTtl       int     `json:"detect_ttl"`
 }
 
 func (cfg *XtcpProxyConf) Compare(cmp ProxyConf) bool {
@@ -811,7 +1722,10 @@ func (cfg *XtcpProxyConf) Compare(cmp ProxyConf) bool {
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
 		!cfg.LocalSvrConf.compare(&cmpConf.LocalSvrConf) ||
 		cfg.Role != cmpConf.Role ||
-		cfg.Sk != cmpConf.Sk {
+		cfg.Sk != cmpConf.Sk ||
+		cfg.FallbackToStcp != cmpConf.FallbackToStcp ||
+		cfg.DetectPortRange != cmpConf.DetectPortRange ||
+		cfg.DetectTtl != cmpConf.DetectTtl {
 		return false
 	}
 	return true
@@ -821,6 +1735,7 @@ func (cfg *XtcpProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *XtcpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.Sk = pMsg.Sk
+	cfg.FallbackToStcp = pMsg.FallbackToStcp
 }
 
 func (cfg *XtcpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -834,6 +1749,22 @@ func (cfg *XtcpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 	}
 
 	cfg.Sk = section["sk"]
+	if tmpStr, ok := section["fallback_to_stcp"]; ok && tmpStr == "true" {
+		cfg.FallbackToStcp = true
+	}
+
+	if tmpStr, ok := section["detect_port_range"]; ok {
+		cfg.DetectPortRange = tmpStr
+		if cfg.DetectPorts, err = util.ParseRangeNumbers(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] detect_port_range error, %v", name, err)
+		}
+	}
+
+	if tmpStr, ok := section["detect_ttl"]; ok {
+		if cfg.DetectTtl, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] detect_ttl error", name)
+		}
+	}
 
 	if err = cfg.LocalSvrConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
@@ -844,6 +1775,7 @@ func (cfg *XtcpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 func (cfg *XtcpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	pMsg.Sk = cfg.Sk
+	pMsg.FallbackToStcp = cfg.FallbackToStcp
 }
 
 func (cfg *XtcpProxyConf) CheckForCli() (err error) {
@@ -854,6 +1786,16 @@ func (cfg *XtcpProxyConf) CheckForCli() (err error) {
 		err = fmt.Errorf("role should be 'server'")
 		return
 	}
+	for _, port := range cfg.DetectPorts {
+		if port <= 0 || port > 65535 {
+			err = fmt.Errorf("detect_port_range has invalid port [%d]", port)
+			return
+		}
+	}
+	if cfg.DetectTtl < 0 || cfg.DetectTtl > 255 {
+		err = fmt.Errorf("detect_ttl should be between 0 and 255")
+		return
+	}
 	return
 }
 
@@ -924,11 +1866,26 @@ func LoadAllConfFromIni(prefix string, content string, startProxy map[string]str
 			continue
 		}
 
+		// disabled sections are not checked, proxies are kept by name and
+		// type, a range section is kept as one proxy, visitors are skipped
+		if tmpStr, ok := section["enabled"]; ok && tmpStr == "false" {
+			if section["role"] == "" || section["role"] == "server" {
+				cfg, errRet := newDisabledProxyConf(prefix, name, section)
+				if errRet != nil {
+					err = errRet
+					return
+				}
+				proxyConfs[cfg.GetBaseInfo().ProxyName] = cfg
+			}
+			continue
+		}
+
 		subSections := make(map[string]ini.Section)
 
+		rangePrefix := ""
 		if strings.HasPrefix(name, "range:") {
 			// range section
-			rangePrefix := strings.TrimSpace(strings.TrimPrefix(name, "range:"))
+			rangePrefix = strings.TrimSpace(strings.TrimPrefix(name, "range:"))
 			subSections, err = ParseRangeSection(rangePrefix, section)
 			if err != nil {
 				return
@@ -948,6 +1905,7 @@ func LoadAllConfFromIni(prefix string, content string, startProxy map[string]str
 					err = errRet
 					return
 				}
+				cfg.GetBaseInfo().RangeName = rangePrefix
 				proxyConfs[prefix+subName] = cfg
 			} else if role == "visitor" {
 				cfg, errRet := NewVisitorConfFromIni(prefix, subName, subSection)
@@ -965,6 +1923,113 @@ func LoadAllConfFromIni(prefix string, content string, startProxy map[string]str
 	return
 }
 
+// newDisabledProxyConf returns a proxy of the disabled section with only name
+// and type, it's named by the range name for range sections.
+func newDisabledProxyConf(prefix string, name string, section ini.Section) (ProxyConf, error) {
+	proxyType := section["type"]
+	if proxyType == "" {
+		proxyType = consts.TcpProxy
+	}
+	cfg := NewConfByType(proxyType)
+	if cfg == nil {
+		return nil, fmt.Errorf("proxy [%s] type [%s] error", name, proxyType)
+	}
+	baseInfo := cfg.GetBaseInfo()
+	if strings.HasPrefix(name, "range:") {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "range:"))
+		baseInfo.RangeName = name
+	}
+	baseInfo.ProxyName = prefix + name
+	baseInfo.ProxyType = proxyType
+	baseInfo.Disabled = true
+	return cfg, nil
+}
+
+// LoadAllConfFromFiles loads proxies and visitors from content of cfgFile and
+// the files matched by includes, relative patterns are resolved against the
+// directory of cfgFile. A name defined in more than one file is an error.
+func LoadAllConfFromFiles(prefix string, cfgFile string, content string, includes []string, startProxy map[string]struct{}) (
+	proxyConfs map[string]ProxyConf, visitorConfs map[string]VisitorConf, err error) {
+
+	proxyConfs, visitorConfs, err = LoadAllConfFromIni(prefix, content, startProxy)
+	if err != nil || len(includes) == 0 {
+		return
+	}
+
+	// file which each proxy or visitor is loaded from
+	proxySources := make(map[string]string)
+	for name := range proxyConfs {
+		proxySources[name] = cfgFile
+	}
+	visitorSources := make(map[string]string)
+	for name := range visitorConfs {
+		visitorSources[name] = cfgFile
+	}
+
+	namePrefix := prefix
+	if namePrefix != "" {
+		namePrefix += "."
+	}
+	loaded := make(map[string]struct{})
+	if absPath, errRet := filepath.Abs(cfgFile); errRet == nil {
+		loaded[absPath] = struct{}{}
+	}
+	dir := filepath.Dir(cfgFile)
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, errRet := filepath.Glob(pattern)
+		if errRet != nil {
+			err = fmt.Errorf("Parse conf error: invalid includes pattern [%s]: %v", pattern, errRet)
+			return
+		}
+
+		for _, file := range files {
+			absPath, errRet := filepath.Abs(file)
+			if errRet != nil {
+				err = errRet
+				return
+			}
+			if _, ok := loaded[absPath]; ok {
+				continue
+			}
+			loaded[absPath] = struct{}{}
+
+			fileContent, errRet := GetRenderedConfFromFile(file)
+			if errRet != nil {
+				err = fmt.Errorf("read included file [%s] error: %v", file, errRet)
+				return
+			}
+			pxyCfgs, visitorCfgs, errRet := LoadAllConfFromIni(prefix, fileContent, startProxy)
+			if errRet != nil {
+				err = fmt.Errorf("load included file [%s] error: %v", file, errRet)
+				return
+			}
+
+			for name, cfg := range pxyCfgs {
+				if src, ok := proxySources[name]; ok {
+					err = fmt.Errorf("Parse conf error: proxy [%s] in [%s] is already defined in [%s]",
+						strings.TrimPrefix(name, namePrefix), file, src)
+					return
+				}
+				proxySources[name] = file
+				proxyConfs[name] = cfg
+			}
+			for name, cfg := range visitorCfgs {
+				if src, ok := visitorSources[name]; ok {
+					err = fmt.Errorf("Parse conf error: visitor [%s] in [%s] is already defined in [%s]",
+						strings.TrimPrefix(name, namePrefix), file, src)
+					return
+				}
+				visitorSources[name] = file
+				visitorConfs[name] = cfg
+			}
+		}
+	}
+	return
+}
+
 func copySection(section ini.Section) (out ini.Section) {
 	out = make(ini.Section)
 	for k, v := range section {
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ini "github.com/vaughan0/go-ini"
+)
+
+func TestDomainConfAllowDomains(t *testing.T) {
+	assert := assert.New(t)
+	defer InitServerCfg(GetDefaultServerConf())
+
+	check := func(domains ...string) error {
+		cfg := &DomainConf{CustomDomains: domains}
+		return cfg.checkForSvr()
+	}
+
+	// no limit by default
+	InitServerCfg(GetDefaultServerConf())
+	assert.NoError(check("any.domain.com"))
+
+	svrCfg := GetDefaultServerConf()
+	svrCfg.AllowDomains = []string{"example.com", "*.example.net"}
+	svrCfg.AllowDomainsRegex = `^[a-z]+\.example\.org$`
+	InitServerCfg(svrCfg)
+	assert.NoError(check("example.com"))
+	assert.NoError(check("a.b.example.net", "Test.example.org"))
+	assert.Error(check("sub.example.com"))
+	assert.Error(check("example.net"))
+	assert.Error(check("example.com", "evil.com"))
+	assert.Error(check("a.b.example.org"))
+
+	// the regex is matched against the whole domain
+	svrCfg.AllowDomainsRegex = `example\.org`
+	InitServerCfg(svrCfg)
+	assert.NoError(check("example.org"))
+	assert.Error(check("example.org.evil.com"))
+	assert.Error(check("evil-example.org"))
+	svrCfg.AllowDomainsRegex = `a\.example\.org|b\.example\.org`
+	InitServerCfg(svrCfg)
+	assert.NoError(check("b.example.org"))
+	assert.Error(check("b.example.org.evil.com"))
+	assert.Error(check("xa.example.org"))
+
+	// subdomain is not limited by allow_domains
+	svrCfg.SubDomainHost = "frps.com"
+	InitServerCfg(svrCfg)
+	cfg := &DomainConf{SubDomain: "test"}
+	assert.NoError(cfg.checkForSvr())
+}
+
+func TestTcpProxyRemotePorts(t *testing.T) {
+	assert := assert.New(t)
+
+	newConf := func(proxyType string, section ini.Section) (ProxyConf, error) {
+		section["type"] = proxyType
+		section["local_port"] = "22"
+		return NewProxyConfFromIni("", "test", section)
+	}
+
+	cfg, err := newConf("tcp", ini.Section{"remote_port": "7000, 7001"})
+	if assert.NoError(err) {
+		tcpCfg := cfg.(*TcpProxyConf)
+		assert.Equal(7000, tcpCfg.RemotePort)
+		assert.Equal([]int{7000, 7001}, tcpCfg.RemotePorts)
+		assert.NoError(tcpCfg.CheckForSvr())
+	}
+
+	cfg, err = newConf("tcp", ini.Section{"remote_port": "7000"})
+	if assert.NoError(err) {
+		assert.Nil(cfg.(*TcpProxyConf).RemotePorts)
+	}
+
+	_, err = newConf("tcp", ini.Section{"remote_port": "7000,7000"})
+	assert.Error(err)
+	_, err = newConf("tcp", ini.Section{"remote_port": "7000,0"})
+	assert.Error(err)
+	_, err = newConf("tcp", ini.Section{"remote_port": "7000,7001", "lazy": "true"})
+	assert.Error(err)
+	_, err = newConf("udp", ini.Section{"remote_port": "7000,7001"})
+	assert.Error(err)
+}
+
+func TestHealthCheckAddrIpv6(t *testing.T) {
+	assert := assert.New(t)
+	newConf := func(checkType string) (*BaseProxyConf, error) {
+		cfg, err := NewProxyConfFromIni("", "test", ini.Section{
+			"type":              "tcp",
+			"local_ip":          "::1",
+			"local_port":        "80",
+			"remote_port":       "6000",
+			"health_check_type": checkType,
+			"health_check_url":  "status",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return cfg.GetBaseInfo(), nil
+	}
+
+	cfg, err := newConf("tcp")
+	if assert.NoError(err) {
+		assert.Equal("[::1]:80", cfg.HealthCheckAddr)
+	}
+	cfg, err = newConf("http")
+	if assert.NoError(err) {
+		assert.Equal("http://[::1]:80/status", cfg.HealthCheckUrl)
+	}
+}
+
+func TestHealthCheckCommandConf(t *testing.T) {
+	assert := assert.New(t)
+	newConf := func(section ini.Section) error {
+		section["type"] = "tcp"
+		section["local_port"] = "22"
+		section["remote_port"] = "6000"
+		_, err := NewProxyConfFromIni("", "test", section)
+		return err
+	}
+
+	assert.NoError(newConf(ini.Section{"health_check_type": "command", "health_check_command": "/bin/check ssh"}))
+	assert.Error(newConf(ini.Section{"health_check_type": "command", "health_check_command": " "}))
+	assert.Error(newConf(ini.Section{"health_check_type": "tcp", "health_check_command": "/bin/check ssh"}))
+}
+
+func TestLoadDisabledProxies(t *testing.T) {
+	assert := assert.New(t)
+	content := `
+[common]
+server_addr = 127.0.0.1
+
+[ssh]
+local_port = 22
+remote_port = 6000
+
+[web]
+enabled = false
+type = http
+local_port = 80
+
+[range:ports]
+enabled = false
+local_port = 7000-7001
+remote_port = 7000-7001
+
+[secret_visitor]
+enabled = false
+role = visitor
+type = stcp
+`
+	proxyConfs, visitorConfs, err := LoadAllConfFromIni("", content, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(proxyConfs, 3)
+	assert.False(proxyConfs["ssh"].GetBaseInfo().Disabled)
+	// disabled proxies are kept by name and type without being checked
+	if cfg, ok := proxyConfs["web"].(*HttpProxyConf); assert.True(ok) {
+		assert.True(cfg.Disabled)
+		assert.Equal("web", cfg.ProxyName)
+		assert.Equal("http", cfg.ProxyType)
+	}
+	if cfg, ok := proxyConfs["ports"].(*TcpProxyConf); assert.True(ok) {
+		assert.True(cfg.Disabled)
+		assert.Equal("ports", cfg.RangeName)
+	}
+	assert.Len(visitorConfs, 0)
+}
+
+func TestLoadAllConfFromFilesIncludes(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frpc_includes")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, content string) {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	assert.NoError(os.Mkdir(filepath.Join(dir, "conf.d"), 0700))
+	write("conf.d/a.ini", `
+[common]
+server_port = 7001
+
+[range:ports]
+local_port = 8000-8001
+remote_port = 8000-8001
+`)
+	write("conf.d/b.ini", `
+[web]
+type = http
+local_port = 80
+custom_domains = example.com
+`)
+
+	content := `
+[common]
+server_addr = 127.0.0.1
+
+[ssh]
+local_port = 22
+remote_port = 6000
+`
+	cfgFile := filepath.Join(dir, "frpc.ini")
+	proxyConfs, _, err := LoadAllConfFromFiles("user", cfgFile, content, []string{"conf.d/*.ini"}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(proxyConfs, 4)
+	for _, name := range []string{"user.ssh", "user.ports_0", "user.ports_1", "user.web"} {
+		assert.Contains(proxyConfs, name)
+	}
+
+	// a proxy generated by a range section conflicts with one in another file
+	write("conf.d/c.ini", `
+[ports_1]
+local_port = 9000
+remote_port = 9000
+`)
+	_, _, err = LoadAllConfFromFiles("user", cfgFile, content, []string{"conf.d/*.ini"}, nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "proxy [ports_1]")
+	}
+}
@@ -16,12 +16,18 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
 
 	ini "github.com/vaughan0/go-ini"
 
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/util"
+	"github.com/fatedier/frp/utils/version"
 )
 
 var (
@@ -30,6 +36,17 @@ var (
 	subDomainHost  string
 	vhostHttpPort  int
 	vhostHttpsPort int
+
+	vhostHttpsTlsEnabled  bool
+	vhostHttpsAcmeEnabled bool
+	tcpMuxHttpConnectPort int
+	kcpBindPort           int
+
+	requireEncryption  bool
+	requireCompression bool
+
+	allowDomains       []string
+	allowDomainsRegexp *regexp.Regexp
 )
 
 func InitServerCfg(cfg *ServerCommonConf) {
@@ -37,6 +54,26 @@ func InitServerCfg(cfg *ServerCommonConf) {
 	subDomainHost = cfg.SubDomainHost
 	vhostHttpPort = cfg.VhostHttpPort
 	vhostHttpsPort = cfg.VhostHttpsPort
+	vhostHttpsTlsEnabled = cfg.VhostHttpsTlsCertFile != "" && cfg.VhostHttpsTlsKeyFile != ""
+	vhostHttpsAcmeEnabled = cfg.VhostHttpsAcmeEnable
+	tcpMuxHttpConnectPort = cfg.TcpMuxHttpConnectPort
+	kcpBindPort = 0
+	if cfg.EnableKcp {
+		kcpBindPort = cfg.KcpBindPort
+	}
+	requireEncryption = cfg.RequireEncryption
+	requireCompression = cfg.RequireCompression
+	allowDomains = cfg.AllowDomains
+	allowDomainsRegexp = nil
+	if cfg.AllowDomainsRegex != "" {
+		allowDomainsRegexp = regexp.MustCompile(anchorRegex(cfg.AllowDomainsRegex))
+	}
+}
+
+// anchorRegex makes expr match whole strings, so example\.org doesn't match
+// example.org.evil.com.
+func anchorRegex(expr string) string {
+	return `^(?:` + expr + `)$`
 }
 
 // common config
@@ -46,6 +83,21 @@ type ServerCommonConf struct {
 	BindUdpPort   int    `json:"bind_udp_port"`
 	KcpBindPort   int    `json:"kcp_bind_port"`
 	ProxyBindAddr string `json:"proxy_bind_addr"`
+	WebsocketPath string `json:"websocket_path"`
+
+	// NatHoleBindAddr is the address the nat hole udp service listens on,
+	// it's same with BindAddr if not set.
+	NatHoleBindAddr string `json:"nat_hole_bind_addr"`
+
+	// ListenBacklog is the size of accept queues of bind_port and vhost ports,
+	// 0 means the system default.
+	ListenBacklog int `json:"listen_backlog"`
+	// BindReusePort sets SO_REUSEPORT on bind_port so that multiple frps can
+	// listen on it.
+	BindReusePort bool `json:"bind_reuse_port"`
+	// BindNetwork is the network of listeners on bind_port and vhost ports,
+	// tcp listens on both ipv4 and ipv6, tcp4 and tcp6 on one of them.
+	BindNetwork string `json:"bind_network"`
 
 	// If VhostHttpPort equals 0, don't listen a public port for http protocol.
 	VhostHttpPort int `json:"vhost_http_port"`
@@ -53,67 +105,187 @@ type ServerCommonConf struct {
 	// if VhostHttpsPort equals 0, don't listen a public port for https protocol
 	VhostHttpsPort int `json:"vhost_https_port"`
 
+	// Certificate used by frps to terminate tls of https proxies which verify
+	// client certificates, other https proxies are passed through by sni.
+	VhostHttpsTlsCertFile string `json:"vhost_https_tls_cert_file"`
+	VhostHttpsTlsKeyFile  string `json:"vhost_https_tls_key_file"`
+
+	// If VhostHttpsAcmeEnable is true, frps terminates tls of all https proxies
+	// with certificates obtained from an ACME CA for their domains, the http-01
+	// challenge is served on VhostHttpPort. Certificates and the account key
+	// are stored in VhostHttpsAcmeCacheDir. AllowDomains or AllowDomainsRegex
+	// is required to limit the domains.
+	VhostHttpsAcmeEnable       bool   `json:"vhost_https_acme_enable"`
+	VhostHttpsAcmeEmail        string `json:"vhost_https_acme_email"`
+	VhostHttpsAcmeCacheDir     string `json:"vhost_https_acme_cache_dir"`
+	VhostHttpsAcmeDirectoryUrl string `json:"vhost_https_acme_directory_url"`
+
+	// TcpMuxHttpConnectPort is the port of tcpmux proxies using httpconnect multiplexer,
+	// 0 means tcpmux proxies of this multiplexer are not supported.
+	TcpMuxHttpConnectPort int `json:"tcpmux_httpconnect_port"`
+
 	VhostHttpTimeout int64 `json:"vhost_http_timeout"`
 
+	// HttpResponseTimeStats measures response times of http proxies, their
+	// average and p95 are shown in dashboard.
+	HttpResponseTimeStats bool `json:"http_response_time_stats"`
+
 	DashboardAddr string `json:"dashboard_addr"`
 
 	// if DashboardPort equals 0, dashboard is not available
 	DashboardPort int    `json:"dashboard_port"`
 	DashboardUser string `json:"dashboard_user"`
 	DashboardPwd  string `json:"dashboard_pwd"`
+
+	// dashboard is served over https if both are set
+	DashboardTlsCertFile string `json:"dashboard_tls_cert_file"`
+	DashboardTlsKeyFile  string `json:"dashboard_tls_key_file"`
+
 	AssetsDir     string `json:"asserts_dir"`
 	LogFile       string `json:"log_file"`
 	LogWay        string `json:"log_way"` // console or file
 	LogLevel      string `json:"log_level"`
 	LogMaxDays    int64  `json:"log_max_days"`
+	LogFormat     string `json:"log_format"`
 	Token         string `json:"token"`
 	SubDomainHost string `json:"subdomain_host"`
+
+	// LogConnectionSummary logs the address of user, duration and traffic of
+	// each user connection of tcp, https, tcpmux, stcp and xtcp proxies when
+	// it's closed.
+	LogConnectionSummary bool `json:"log_connection_summary"`
+
+	// AllowDomains and AllowDomainsRegex limit custom_domains of proxies, a
+	// domain is allowed if it's in AllowDomains or AllowDomainsRegex matches all of it.
+	// "*.example.com" in AllowDomains allows all subdomains of example.com.
+	// There is no limit if both are empty.
+	AllowDomains      []string `json:"allow_domains"`
+	AllowDomainsRegex string   `json:"allow_domains_regex"`
+
 	TcpMux        bool   `json:"tcp_mux"`
 	Custom503Page string `json:"custom_503_page"`
 
+	// CustomAuthFailPage is the html file returned with 401 to users failing
+	// http_user and http_pwd of http proxies.
+	CustomAuthFailPage string `json:"custom_auth_fail_page"`
+
 	AllowPorts        map[int]struct{}
 	MaxPoolCount      int64 `json:"max_pool_count"`
 	MaxPortsPerClient int64 `json:"max_ports_per_client"`
 	HeartBeatTimeout  int64 `json:"heart_beat_timeout"`
-	UserConnTimeout   int64 `json:"user_conn_timeout"`
+	// UserConnTimeout is the max seconds to wait for a work connection from
+	// frpc for a user connection, which is closed after that.
+	UserConnTimeout int64 `json:"user_conn_timeout"`
+
+	// MaxProxiesPerUser limits the number of proxies of all clients logged in
+	// by the same user, 0 means no limit. It's overridden by the limit from
+	// the API if the API returns one. Without the API, the user is chosen by
+	// frpc, so it's not a limit clients can't get around.
+	MaxProxiesPerUser int64 `json:"max_proxies_per_user"`
+
+	// MaxTotalBandwidth caps the total traffic of all user connections in KB/s,
+	// 0 means no limit.
+	MaxTotalBandwidth int64 `json:"max_total_bandwidth"`
+
+	// MinClientVersion rejects frpc whose version is lower than it,
+	// empty means only the built-in compatibility rule is used.
+	MinClientVersion string `json:"min_client_version"`
+	// AllowDevClientVersion allows frpc whose version is unknown, and compares
+	// pre-release versions like 0.29.0-dev with MinClientVersion by 0.29.0.
+	AllowDevClientVersion bool `json:"allow_dev_client_version"`
+
+	// GeoIpDbFile is a csv file of "cidr,country,asn" lines used to resolve
+	// client ips on login, empty means no lookup.
+	GeoIpDbFile string `json:"geoip_db_file"`
+
+	// Reject proxies which don't set use_encryption or use_compression.
+	RequireEncryption  bool `json:"require_encryption"`
+	RequireCompression bool `json:"require_compression"`
+
+	// TlsOnly rejects frpc which doesn't connect by tls, DisableTls accepts plain
+	// connections only and skips detecting tls.
+	TlsOnly    bool `json:"tls_only"`
+	DisableTls bool `json:"disable_tls"`
+
+	// Transports frpc can connect by besides plain tcp, disabled ones don't
+	// listen at all. TLS connections are also not detected on bind_port if
+	// EnableTlsMux is false.
+	EnableWebsocket bool `json:"enable_websocket"`
+	EnableKcp       bool `json:"enable_kcp"`
+	EnableTlsMux    bool `json:"enable_tls_mux"`
+
+	// TransportBufferSize is the size in bytes of buffers used to copy data
+	// between connections, 0 means the default size 16KB.
+	TransportBufferSize int `json:"transport_buffer_size"`
+
+	// MaxMsgLength is the max length in bytes of messages read from control
+	// connections, connections sending longer ones are closed.
+	MaxMsgLength int64 `json:"max_msg_length"`
+
+	// ShutdownGracePeriodS is the max seconds frps waits for active user
+	// connections to finish when shutting down.
+	ShutdownGracePeriodS int64 `json:"shutdown_grace_period_s"`
 
 	// API
 	EnableApi  bool   `json:"api_enable"`
 	ApiBaseUrl string `json:"api_baseurl"`
 	ApiToken   string `json:"api_token"`
+	// ApiCacheTTLS caches successful token checks and speed limits of users for
+	// ApiCacheTTLS seconds, 0 means no cache.
+	ApiCacheTTLS int64 `json:"api_cache_ttl_s"`
 }
 
 func GetDefaultServerConf() *ServerCommonConf {
 	return &ServerCommonConf{
-		BindAddr:          "0.0.0.0",
-		BindPort:          7000,
-		BindUdpPort:       0,
-		KcpBindPort:       0,
-		ProxyBindAddr:     "0.0.0.0",
-		VhostHttpPort:     0,
-		VhostHttpsPort:    0,
-		VhostHttpTimeout:  60,
-		DashboardAddr:     "0.0.0.0",
-		DashboardPort:     0,
-		DashboardUser:     "admin",
-		DashboardPwd:      "admin",
-		AssetsDir:         "",
-		LogFile:           "console",
-		LogWay:            "console",
-		LogLevel:          "info",
-		LogMaxDays:        3,
-		Token:             "",
-		SubDomainHost:     "",
-		TcpMux:            true,
-		AllowPorts:        make(map[int]struct{}),
-		MaxPoolCount:      5,
-		MaxPortsPerClient: 0,
-		HeartBeatTimeout:  90,
-		UserConnTimeout:   10,
-		Custom503Page:     "",
-		EnableApi:         false,
-		ApiBaseUrl:        "",
-		ApiToken:          "",
+		BindAddr:              "0.0.0.0",
+		BindPort:              7000,
+		BindUdpPort:           0,
+		KcpBindPort:           0,
+		WebsocketPath:         frpNet.FrpWebsocketPath,
+		ProxyBindAddr:         "0.0.0.0",
+		NatHoleBindAddr:       "0.0.0.0",
+		BindNetwork:           "tcp",
+		VhostHttpPort:         0,
+		VhostHttpsPort:        0,
+		TcpMuxHttpConnectPort: 0,
+		VhostHttpTimeout:      60,
+		HttpResponseTimeStats: false,
+		DashboardAddr:         "0.0.0.0",
+		DashboardPort:         0,
+		DashboardUser:         "admin",
+		DashboardPwd:          "admin",
+		AssetsDir:             "",
+		LogFile:               "console",
+		LogWay:                "console",
+		LogLevel:              "info",
+		LogMaxDays:            3,
+		LogFormat:             "text",
+		Token:                 "",
+		SubDomainHost:         "",
+		TcpMux:                true,
+		AllowPorts:            make(map[int]struct{}),
+		MaxPoolCount:          5,
+		MaxPortsPerClient:     0,
+		MaxProxiesPerUser:     0,
+		HeartBeatTimeout:      90,
+		UserConnTimeout:       10,
+		MaxTotalBandwidth:     0,
+		MinClientVersion:      "",
+		ShutdownGracePeriodS:  30,
+		RequireEncryption:     false,
+		RequireCompression:    false,
+		TlsOnly:               false,
+		DisableTls:            false,
+		EnableWebsocket:       true,
+		EnableKcp:             true,
+		EnableTlsMux:          true,
+		MaxMsgLength:          msg.DefaultMaxMsgLength,
+		Custom503Page:         "",
+		CustomAuthFailPage:    "",
+		EnableApi:             false,
+		ApiBaseUrl:            "",
+		ApiToken:              "",
+		ApiCacheTTLS:          0,
 	}
 }
 
@@ -135,7 +307,7 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		v      int64
 	)
 	if tmpStr, ok = conf.Get("common", "bind_addr"); ok {
-		cfg.BindAddr = tmpStr
+		cfg.BindAddr = util.UnbracketHost(tmpStr)
 	}
 
 	if tmpStr, ok = conf.Get("common", "bind_port"); ok {
@@ -165,12 +337,32 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "websocket_path"); ok {
+		if !strings.HasPrefix(tmpStr, "/") {
+			err = fmt.Errorf("Parse conf error: websocket_path must start with /")
+			return
+		}
+		cfg.WebsocketPath = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "proxy_bind_addr"); ok {
-		cfg.ProxyBindAddr = tmpStr
+		cfg.ProxyBindAddr = util.UnbracketHost(tmpStr)
 	} else {
 		cfg.ProxyBindAddr = cfg.BindAddr
 	}
 
+	if tmpStr, ok = conf.Get("common", "nat_hole_bind_addr"); ok {
+		tmpStr = util.UnbracketHost(tmpStr)
+		if net.ParseIP(tmpStr) == nil {
+			err = fmt.Errorf("Parse conf error: nat_hole_bind_addr [%s] is not a valid ip", tmpStr)
+			return
+		}
+		cfg.NatHoleBindAddr = tmpStr
+	} else {
+		// bind_addr has no brackets already, it may also be a host name
+		cfg.NatHoleBindAddr = cfg.BindAddr
+	}
+
 	if tmpStr, ok = conf.Get("common", "vhost_http_port"); ok {
 		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
 			err = fmt.Errorf("Parse conf error: invalid vhost_http_port")
@@ -193,6 +385,41 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		cfg.VhostHttpsPort = 0
 	}
 
+	if tmpStr, ok = conf.Get("common", "vhost_https_tls_cert_file"); ok {
+		cfg.VhostHttpsTlsCertFile = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_tls_key_file"); ok {
+		cfg.VhostHttpsTlsKeyFile = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_acme_enable"); ok && tmpStr == "true" {
+		cfg.VhostHttpsAcmeEnable = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_acme_email"); ok {
+		cfg.VhostHttpsAcmeEmail = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_acme_cache_dir"); ok {
+		cfg.VhostHttpsAcmeCacheDir = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_acme_directory_url"); ok {
+		cfg.VhostHttpsAcmeDirectoryUrl = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "tcpmux_httpconnect_port"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid tcpmux_httpconnect_port")
+			return
+		} else {
+			cfg.TcpMuxHttpConnectPort = int(v)
+		}
+	} else {
+		cfg.TcpMuxHttpConnectPort = 0
+	}
+
 	if tmpStr, ok = conf.Get("common", "vhost_http_timeout"); ok {
 		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
 		if errRet != nil || v < 0 {
@@ -203,8 +430,12 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "http_response_time_stats"); ok && tmpStr == "true" {
+		cfg.HttpResponseTimeStats = true
+	}
+
 	if tmpStr, ok = conf.Get("common", "dashboard_addr"); ok {
-		cfg.DashboardAddr = tmpStr
+		cfg.DashboardAddr = util.UnbracketHost(tmpStr)
 	} else {
 		cfg.DashboardAddr = cfg.BindAddr
 	}
@@ -228,6 +459,14 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		cfg.DashboardPwd = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "dashboard_tls_cert_file"); ok {
+		cfg.DashboardTlsCertFile = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "dashboard_tls_key_file"); ok {
+		cfg.DashboardTlsKeyFile = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "assets_dir"); ok {
 		cfg.AssetsDir = tmpStr
 	}
@@ -252,7 +491,23 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		}
 	}
 
-	cfg.Token, _ = conf.Get("common", "token")
+	if tmpStr, ok = conf.Get("common", "log_format"); ok {
+		if !log.IsValidLogFormat(tmpStr) {
+			err = fmt.Errorf("Parse conf error: invalid log_format")
+			return
+		}
+		cfg.LogFormat = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "log_connection_summary"); ok && tmpStr == "true" {
+		cfg.LogConnectionSummary = true
+	}
+
+	tmpStr, _ = conf.Get("common", "token")
+	if cfg.Token, err = ResolveToken(tmpStr); err != nil {
+		err = fmt.Errorf("Parse conf error: %v", err)
+		return
+	}
 
 	if allowPortsStr, ok := conf.Get("common", "allow_ports"); ok {
 		// e.g. 1000-2000,2001,2002,3000-4000
@@ -293,10 +548,130 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "max_proxies_per_user"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid max_proxies_per_user")
+			return
+		}
+		cfg.MaxProxiesPerUser = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "user_conn_timeout"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v <= 0 {
+			err = fmt.Errorf("Parse conf error: invalid user_conn_timeout")
+			return
+		}
+		cfg.UserConnTimeout = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "max_total_bandwidth"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid max_total_bandwidth")
+			return
+		}
+		cfg.MaxTotalBandwidth = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "min_client_version"); ok {
+		if !version.IsValidSemver(tmpStr) {
+			err = fmt.Errorf("Parse conf error: invalid min_client_version")
+			return
+		}
+		cfg.MinClientVersion = strings.TrimSpace(tmpStr)
+	}
+
+	if tmpStr, ok = conf.Get("common", "allow_dev_client_version"); ok && tmpStr == "true" {
+		cfg.AllowDevClientVersion = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "geoip_db_file"); ok {
+		cfg.GeoIpDbFile = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "subdomain_host"); ok {
 		cfg.SubDomainHost = strings.ToLower(strings.TrimSpace(tmpStr))
 	}
 
+	if tmpStr, ok = conf.Get("common", "allow_domains"); ok {
+		for _, domain := range strings.Split(tmpStr, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				cfg.AllowDomains = append(cfg.AllowDomains, domain)
+			}
+		}
+	}
+
+	if tmpStr, ok = conf.Get("common", "allow_domains_regex"); ok {
+		if _, errRet := regexp.Compile(anchorRegex(tmpStr)); errRet != nil {
+			err = fmt.Errorf("Parse conf error: allow_domains_regex: %v", errRet)
+			return
+		}
+		cfg.AllowDomainsRegex = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "require_encryption"); ok && tmpStr == "true" {
+		cfg.RequireEncryption = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "require_compression"); ok && tmpStr == "true" {
+		cfg.RequireCompression = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "tls_only"); ok && tmpStr == "true" {
+		cfg.TlsOnly = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "disable_tls"); ok && tmpStr == "true" {
+		cfg.DisableTls = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "enable_websocket"); ok && tmpStr == "false" {
+		cfg.EnableWebsocket = false
+	}
+
+	if tmpStr, ok = conf.Get("common", "enable_kcp"); ok && tmpStr == "false" {
+		cfg.EnableKcp = false
+	}
+
+	if tmpStr, ok = conf.Get("common", "enable_tls_mux"); ok && tmpStr == "false" {
+		cfg.EnableTlsMux = false
+	}
+
+	if tmpStr, ok = conf.Get("common", "transport_buffer_size"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid transport_buffer_size")
+			return
+		}
+		cfg.TransportBufferSize = int(v)
+	}
+
+	if tmpStr, ok = conf.Get("common", "listen_backlog"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid listen_backlog")
+			return
+		}
+		cfg.ListenBacklog = int(v)
+	}
+
+	if tmpStr, ok = conf.Get("common", "bind_reuse_port"); ok && tmpStr == "true" {
+		cfg.BindReusePort = true
+	}
+
+	if tmpStr, ok = conf.Get("common", "bind_network"); ok {
+		if tmpStr != "tcp" && tmpStr != "tcp4" && tmpStr != "tcp6" {
+			err = fmt.Errorf("Parse conf error: bind_network should be tcp, tcp4 or tcp6")
+			return
+		}
+		cfg.BindNetwork = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "max_msg_length"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v <= 0 {
+			err = fmt.Errorf("Parse conf error: invalid max_msg_length")
+			return
+		}
+		cfg.MaxMsgLength = v
+	}
+
 	if tmpStr, ok = conf.Get("common", "tcp_mux"); ok && tmpStr == "false" {
 		cfg.TcpMux = false
 	} else {
@@ -307,6 +682,18 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		cfg.Custom503Page = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "custom_auth_fail_page"); ok {
+		cfg.CustomAuthFailPage = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "shutdown_grace_period_s"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid shutdown_grace_period_s")
+			return
+		}
+		cfg.ShutdownGracePeriodS = v
+	}
+
 	if tmpStr, ok = conf.Get("common", "heartbeat_timeout"); ok {
 		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
 		if errRet != nil {
@@ -331,9 +718,68 @@ func UnmarshalServerConfFromIni(defaultCfg *ServerCommonConf, content string) (c
 		cfg.ApiToken = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "api_cache_ttl_s"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid api_cache_ttl_s")
+			return
+		}
+		cfg.ApiCacheTTLS = v
+	}
+
 	return
 }
 
 func (cfg *ServerCommonConf) Check() (err error) {
+	if cfg.TlsOnly && cfg.DisableTls {
+		err = fmt.Errorf("Parse conf error: tls_only and disable_tls can't be both true")
+		return
+	}
+
+	if cfg.TlsOnly && !cfg.EnableTlsMux {
+		err = fmt.Errorf("Parse conf error: tls_only can't be true if enable_tls_mux is false")
+		return
+	}
+
+	if (cfg.DashboardTlsCertFile == "") != (cfg.DashboardTlsKeyFile == "") {
+		err = fmt.Errorf("Parse conf error: dashboard_tls_cert_file and dashboard_tls_key_file must be set together")
+		return
+	}
+
+	if (cfg.VhostHttpsTlsCertFile == "") != (cfg.VhostHttpsTlsKeyFile == "") {
+		err = fmt.Errorf("Parse conf error: vhost_https_tls_cert_file and vhost_https_tls_key_file must be set together")
+		return
+	}
+
+	if cfg.VhostHttpsAcmeEnable {
+		if cfg.VhostHttpPort == 0 || cfg.VhostHttpsPort == 0 {
+			err = fmt.Errorf("Parse conf error: vhost_https_acme_enable needs both vhost_http_port and vhost_https_port")
+			return
+		}
+		if cfg.VhostHttpsTlsCertFile != "" {
+			err = fmt.Errorf("Parse conf error: vhost_https_acme_enable can't be used with vhost_https_tls_cert_file")
+			return
+		}
+		if cfg.VhostHttpsAcmeEmail == "" || cfg.VhostHttpsAcmeCacheDir == "" {
+			err = fmt.Errorf("Parse conf error: vhost_https_acme_email and vhost_https_acme_cache_dir are required if vhost_https_acme_enable is true")
+			return
+		}
+		// otherwise frps asks the CA for certificates of any custom_domains
+		// clients send
+		if len(cfg.AllowDomains) == 0 && cfg.AllowDomainsRegex == "" {
+			err = fmt.Errorf("Parse conf error: allow_domains or allow_domains_regex is required if vhost_https_acme_enable is true")
+			return
+		}
+	}
+
+	// websocket connections are picked out by their request line on bind_port,
+	// which may be shared with vhost_http_port
+	if cfg.WebsocketPath == "/" {
+		err = fmt.Errorf("Parse conf error: websocket_path can't be /, it collides with http requests of all sites")
+		return
+	}
+	if strings.ContainsAny(cfg.WebsocketPath, " ?#") {
+		err = fmt.Errorf("Parse conf error: websocket_path can't contain spaces, ? or #")
+		return
+	}
 	return
 }
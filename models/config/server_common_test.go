@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConfBracketedAddr(t *testing.T) {
+	assert := assert.New(t)
+	content := `
+[common]
+bind_addr = [::]
+bind_port = 7000
+dashboard_port = 7500
+`
+	cfg, err := UnmarshalServerConfFromIni(GetDefaultServerConf(), content)
+	if !assert.NoError(err) {
+		return
+	}
+	// brackets are stripped so addresses can be joined with ports
+	assert.Equal("::", cfg.BindAddr)
+	assert.Equal("::", cfg.ProxyBindAddr)
+	assert.Equal("::", cfg.DashboardAddr)
+	assert.Equal("::", cfg.NatHoleBindAddr)
+
+	content = `
+[common]
+bind_addr = 0.0.0.0
+proxy_bind_addr = [::1]
+dashboard_addr = [::1]
+nat_hole_bind_addr = [::1]
+`
+	cfg, err = UnmarshalServerConfFromIni(GetDefaultServerConf(), content)
+	if assert.NoError(err) {
+		assert.Equal("0.0.0.0", cfg.BindAddr)
+		assert.Equal("::1", cfg.ProxyBindAddr)
+		assert.Equal("::1", cfg.DashboardAddr)
+		assert.Equal("::1", cfg.NatHoleBindAddr)
+	}
+}
+
+func TestServerConfAcmeAllowDomains(t *testing.T) {
+	assert := assert.New(t)
+	content := `
+[common]
+vhost_http_port = 80
+vhost_https_port = 443
+vhost_https_acme_enable = true
+vhost_https_acme_email = admin@example.com
+vhost_https_acme_cache_dir = ./acme
+`
+	// certificates of any custom_domains would be requested
+	cfg, err := UnmarshalServerConfFromIni(GetDefaultServerConf(), content)
+	if assert.NoError(err) {
+		assert.Error(cfg.Check())
+	}
+
+	for _, allow := range []string{"allow_domains = example.com", `allow_domains_regex = [a-z]+\.example\.com`} {
+		cfg, err = UnmarshalServerConfFromIni(GetDefaultServerConf(), content+allow+"\n")
+		if assert.NoError(err, allow) {
+			assert.NoError(cfg.Check(), allow)
+		}
+	}
+}
+
+func TestServerConfWebsocketPath(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/~!frp", true},
+		{"/ws", true},
+		// all http requests of vhost sites on bind_port would be websocket connections
+		{"/", false},
+		{"/ws?a=1", false},
+		{"/w s", false},
+	}
+	for _, test := range tests {
+		cfg, err := UnmarshalServerConfFromIni(GetDefaultServerConf(), "[common]\nwebsocket_path = "+test.path+"\n")
+		if !assert.NoError(err, test.path) {
+			continue
+		}
+		if test.valid {
+			assert.NoError(cfg.Check(), test.path)
+		} else {
+			assert.Error(cfg.Check(), test.path)
+		}
+	}
+}
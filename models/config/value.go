@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -16,7 +17,7 @@ func init() {
 	glbEnvs = make(map[string]string)
 	envs := os.Environ()
 	for _, env := range envs {
-		kv := strings.Split(env, "=")
+		kv := strings.SplitN(env, "=", 2)
 		if len(kv) != 2 {
 			continue
 		}
@@ -34,16 +35,49 @@ func GetValues() *Values {
 	}
 }
 
+// RenderContent renders in as a template of Values, e.g. {{ .Envs.PORT }}.
+// Errors tell the section they happen in, values rendered from missing
+// environment variables are also errors.
 func RenderContent(in string) (out string, err error) {
-	tmpl, errRet := template.New("frp").Parse(in)
-	if errRet != nil {
-		err = errRet
+	out, err = renderTemplate(in)
+	if err != nil {
+		// templates may span sections, so sections are only rendered
+		// separately to find where the error is
+		for _, sec := range splitSections(in) {
+			if _, errRet := renderTemplate(sec.content); errRet != nil && sec.name != "" {
+				err = fmt.Errorf("render section [%s] error: %v", sec.name, err)
+				break
+			}
+		}
+		return
+	}
+
+	for _, sec := range splitSections(out) {
+		for _, line := range strings.Split(sec.content, "\n") {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") ||
+				!strings.Contains(trimmed, noValue) {
+				continue
+			}
+			key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+			err = fmt.Errorf("render section [%s] error: value of [%s] is rendered from a missing environment variable", sec.name, key)
+			return
+		}
+	}
+	return
+}
+
+// noValue is rendered by text/template for missing keys of maps.
+const noValue = "<no value>"
+
+func renderTemplate(in string) (out string, err error) {
+	tmpl, err := template.New("frp").Parse(in)
+	if err != nil {
 		return
 	}
 
 	buffer := bytes.NewBufferString("")
-	v := GetValues()
-	err = tmpl.Execute(buffer, v)
+	err = tmpl.Execute(buffer, GetValues())
 	if err != nil {
 		return
 	}
@@ -51,6 +85,31 @@ func RenderContent(in string) (out string, err error) {
 	return
 }
 
+type iniSection struct {
+	name    string
+	content string
+}
+
+// splitSections splits ini content by section headers, the content before
+// the first header is in a section with empty name.
+func splitSections(in string) (sections []iniSection) {
+	cur := iniSection{}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(in, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			cur.content = strings.Join(lines, "\n")
+			sections = append(sections, cur)
+			cur = iniSection{name: strings.TrimSpace(trimmed[1 : len(trimmed)-1])}
+			lines = lines[:0]
+		}
+		lines = append(lines, line)
+	}
+	cur.content = strings.Join(lines, "\n")
+	sections = append(sections, cur)
+	return
+}
+
 func GetRenderedConfFromFile(path string) (out string, err error) {
 	var b []byte
 	b, err = ioutil.ReadFile(path)
@@ -62,3 +121,29 @@ func GetRenderedConfFromFile(path string) (out string, err error) {
 	out, err = RenderContent(content)
 	return
 }
+
+// ResolveToken returns the content of the file if token is "@/path/to/file" and
+// the value of the environment variable if token is "$ENV_VAR", otherwise token itself.
+// A leading "@@" or "$$" is an escaped literal "@" or "$".
+func ResolveToken(token string) (out string, err error) {
+	switch {
+	case strings.HasPrefix(token, "@@"), strings.HasPrefix(token, "$$"):
+		out = token[1:]
+	case strings.HasPrefix(token, "@"):
+		var b []byte
+		if b, err = ioutil.ReadFile(token[1:]); err != nil {
+			err = fmt.Errorf("read token file error: %v", err)
+			return
+		}
+		out = strings.TrimRight(string(b), "\r\n")
+	case strings.HasPrefix(token, "$"):
+		var ok bool
+		if out, ok = os.LookupEnv(token[1:]); !ok {
+			err = fmt.Errorf("environment variable [%s] of token not found", token[1:])
+			return
+		}
+	default:
+		out = token
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderContent(t *testing.T) {
+	assert := assert.New(t)
+	oldEnvs := glbEnvs
+	defer func() { glbEnvs = oldEnvs }()
+	glbEnvs = make(map[string]string)
+	for k, v := range oldEnvs {
+		glbEnvs[k] = v
+	}
+	glbEnvs["FRP_TEST_PORT"] = "6000"
+	glbEnvs["FRP_TEST_TOKEN"] = "abc=="
+
+	out, err := RenderContent(`[common]
+token = {{ .Envs.FRP_TEST_TOKEN }}
+# {{ .Envs.FRP_TEST_NOT_EXIST }}
+
+[ssh]
+remote_port = {{ .Envs.FRP_TEST_PORT }}
+{{ if .Envs.FRP_TEST_NOT_EXIST }}use_encryption = true{{ end }}
+`)
+	if assert.NoError(err) {
+		assert.Contains(out, "token = abc==")
+		assert.Contains(out, "remote_port = 6000")
+		assert.NotContains(out, "use_encryption")
+	}
+
+	_, err = RenderContent(`[common]
+server_port = 7000
+
+[web]
+local_port = {{ .Envs.FRP_TEST_NOT_EXIST }}
+`)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "[web]")
+		assert.Contains(err.Error(), "[local_port]")
+	}
+
+	_, err = RenderContent(`[common]
+server_port = 7000
+
+[web]
+local_port = {{ .Envs.FRP_TEST_PORT }
+`)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "[web]")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	assert := assert.New(t)
+	f, err := ioutil.TempFile("", "frp_token")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("file_token\n")
+	f.Close()
+	os.Setenv("FRP_TEST_RESOLVE_TOKEN", "env_token")
+	defer os.Unsetenv("FRP_TEST_RESOLVE_TOKEN")
+
+	tests := []struct {
+		token string
+		out   string
+	}{
+		{"abc", "abc"},
+		{"@" + f.Name(), "file_token"},
+		{"$FRP_TEST_RESOLVE_TOKEN", "env_token"},
+		// escaped literal tokens
+		{"@@abc", "@abc"},
+		{"$$abc", "$abc"},
+		{"$$FRP_TEST_RESOLVE_TOKEN", "$FRP_TEST_RESOLVE_TOKEN"},
+	}
+	for _, test := range tests {
+		out, err := ResolveToken(test.token)
+		if assert.NoError(err, test.token) {
+			assert.Equal(test.out, out, test.token)
+		}
+	}
+
+	_, err = ResolveToken("$FRP_TEST_NOT_EXIST")
+	assert.Error(err)
+}
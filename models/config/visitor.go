@@ -20,6 +20,7 @@ import (
 	"strconv"
 
 	"github.com/fatedier/frp/models/consts"
+	"github.com/fatedier/frp/utils/util"
 
 	ini "github.com/vaughan0/go-ini"
 )
@@ -80,6 +81,9 @@ type BaseVisitorConf struct {
 	ServerName     string `json:"server_name"`
 	BindAddr       string `json:"bind_addr"`
 	BindPort       int    `json:"bind_port"`
+
+	// used if bind_port is not set, the first free port in it is chosen
+	BindPortRange []int `json:"bind_port_range"`
 }
 
 func (cfg *BaseVisitorConf) GetBaseInfo() *BaseVisitorConf {
@@ -95,9 +99,15 @@ func (cfg *BaseVisitorConf) compare(cmp *BaseVisitorConf) bool {
 		cfg.Sk != cmp.Sk ||
 		cfg.ServerName != cmp.ServerName ||
 		cfg.BindAddr != cmp.BindAddr ||
-		cfg.BindPort != cmp.BindPort {
+		cfg.BindPort != cmp.BindPort ||
+		len(cfg.BindPortRange) != len(cmp.BindPortRange) {
 		return false
 	}
+	for i, port := range cfg.BindPortRange {
+		if port != cmp.BindPortRange[i] {
+			return false
+		}
+	}
 	return true
 }
 
@@ -110,10 +120,16 @@ func (cfg *BaseVisitorConf) check() (err error) {
 		err = fmt.Errorf("bind_addr shouldn't be empty")
 		return
 	}
-	if cfg.BindPort <= 0 {
-		err = fmt.Errorf("bind_port is required")
+	if cfg.BindPort <= 0 && len(cfg.BindPortRange) == 0 {
+		err = fmt.Errorf("bind_port or bind_port_range is required")
 		return
 	}
+	for _, port := range cfg.BindPortRange {
+		if port <= 0 || port > 65535 {
+			err = fmt.Errorf("bind_port_range has invalid port %d", port)
+			return
+		}
+	}
 	return
 }
 
@@ -142,11 +158,21 @@ func (cfg *BaseVisitorConf) UnmarshalFromIni(prefix string, name string, section
 		cfg.BindAddr = "127.0.0.1"
 	}
 
+	if tmpStr, ok = section["bind_port_range"]; ok {
+		ports, errRet := util.ParseRangeNumbers(tmpStr)
+		if errRet != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] bind_port_range incorrect, %v", name, errRet)
+		}
+		for _, port := range ports {
+			cfg.BindPortRange = append(cfg.BindPortRange, int(port))
+		}
+	}
+
 	if tmpStr, ok = section["bind_port"]; ok {
 		if cfg.BindPort, err = strconv.Atoi(tmpStr); err != nil {
 			return fmt.Errorf("Parse conf error: proxy [%s] bind_port incorrect", name)
 		}
-	} else {
+	} else if len(cfg.BindPortRange) == 0 {
 		return fmt.Errorf("Parse conf error: proxy [%s] bind_port not found", name)
 	}
 	return nil
@@ -154,6 +180,10 @@ func (cfg *BaseVisitorConf) UnmarshalFromIni(prefix string, name string, section
 
 type StcpVisitorConf struct {
 	BaseVisitorConf
+
+	// certificate sent to frps if the proxy verifies certificates of visitors
+	TlsCertFile string `json:"tls_cert_file"`
+	TlsKeyFile  string `json:"tls_key_file"`
 }
 
 func (cfg *StcpVisitorConf) Compare(cmp VisitorConf) bool {
@@ -162,7 +192,9 @@ func (cfg *StcpVisitorConf) Compare(cmp VisitorConf) bool {
 		return false
 	}
 
-	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) {
+	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) ||
+		cfg.TlsCertFile != cmpConf.TlsCertFile ||
+		cfg.TlsKeyFile != cmpConf.TlsKeyFile {
 		return false
 	}
 	return true
@@ -172,6 +204,8 @@ func (cfg *StcpVisitorConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.BaseVisitorConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	cfg.TlsCertFile = section["tls_cert_file"]
+	cfg.TlsKeyFile = section["tls_key_file"]
 	return
 }
 
@@ -179,11 +213,18 @@ func (cfg *StcpVisitorConf) Check() (err error) {
 	if err = cfg.BaseVisitorConf.check(); err != nil {
 		return
 	}
+	if (cfg.TlsCertFile == "") != (cfg.TlsKeyFile == "") {
+		err = fmt.Errorf("tls_cert_file and tls_key_file should be set together")
+		return
+	}
 	return
 }
 
 type XtcpVisitorConf struct {
 	BaseVisitorConf
+
+	// connect through frps like stcp if nat hole punching fails
+	FallbackToStcp bool `json:"fallback_to_stcp"`
 }
 
 func (cfg *XtcpVisitorConf) Compare(cmp VisitorConf) bool {
@@ -192,7 +233,8 @@ func (cfg *XtcpVisitorConf) Compare(cmp VisitorConf) bool {
 		return false
 	}
 
-	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) {
+	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) ||
+		cfg.FallbackToStcp != cmpConf.FallbackToStcp {
 		return false
 	}
 	return true
@@ -202,6 +244,9 @@ func (cfg *XtcpVisitorConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.BaseVisitorConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	if tmpStr, ok := section["fallback_to_stcp"]; ok && tmpStr == "true" {
+		cfg.FallbackToStcp = true
+	}
 	return
 }
 
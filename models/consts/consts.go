@@ -23,10 +23,19 @@ var (
 	Offline string = "offline"
 
 	// proxy type
-	TcpProxy   string = "tcp"
-	UdpProxy   string = "udp"
-	HttpProxy  string = "http"
-	HttpsProxy string = "https"
-	StcpProxy  string = "stcp"
-	XtcpProxy  string = "xtcp"
+	TcpProxy    string = "tcp"
+	UdpProxy    string = "udp"
+	HttpProxy   string = "http"
+	HttpsProxy  string = "https"
+	StcpProxy   string = "stcp"
+	XtcpProxy   string = "xtcp"
+	TcpMuxProxy string = "tcpmux"
+
+	// tcp multiplexer of tcpmux proxies
+	HttpConnectTcpMultiplexer string = "httpconnect"
+
+	// compression algorithm
+	SnappyCompression string = "snappy"
+	GzipCompression   string = "gzip"
+	ZstdCompression   string = "zstd"
 )
@@ -21,4 +21,6 @@ import (
 var (
 	ErrMsgType   = errors.New("message type error")
 	ErrCtlClosed = errors.New("control is closed")
+
+	ErrServerShuttingDown = errors.New("frps is shutting down")
 )
@@ -22,17 +22,30 @@ import (
 
 type Message = jsonMsg.Message
 
+// DefaultMaxMsgLength is the default max length in bytes of messages, longer
+// ones are rejected before being read to avoid huge allocations.
+const DefaultMaxMsgLength = 1024 * 1024
+
 var (
 	msgCtl *jsonMsg.MsgCtl
+
+	ErrMaxMsgLength = jsonMsg.ErrMaxMsgLength
 )
 
 func init() {
 	msgCtl = jsonMsg.NewMsgCtl()
+	msgCtl.SetMaxMsgLength(DefaultMaxMsgLength)
 	for typeByte, msg := range msgTypeMap {
 		msgCtl.RegisterMsg(typeByte, msg)
 	}
 }
 
+// SetMaxMsgLength sets the max length of messages read by ReadMsg and
+// ReadMsgInto, ErrMaxMsgLength is returned for longer ones.
+func SetMaxMsgLength(length int64) {
+	msgCtl.SetMaxMsgLength(length)
+}
+
 func ReadMsg(c io.Reader) (msg Message, err error) {
 	return msgCtl.ReadMsg(c)
 }
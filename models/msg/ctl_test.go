@@ -0,0 +1,47 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMsgLength(t *testing.T) {
+	assert := assert.New(t)
+	defer SetMaxMsgLength(DefaultMaxMsgLength)
+	SetMaxMsgLength(1024)
+
+	buf := bytes.NewBuffer(nil)
+	err := WriteMsg(buf, &NewProxy{ProxyName: "test", Headers: map[string]string{"X-Test": "ok"}})
+	if assert.NoError(err) {
+		m, err := ReadMsg(buf)
+		if assert.NoError(err) {
+			assert.Equal("test", m.(*NewProxy).ProxyName)
+		}
+	}
+
+	buf.Reset()
+	err = WriteMsg(buf, &NewProxy{ProxyName: "test", Headers: map[string]string{"X-Test": strings.Repeat("a", 1024)}})
+	if assert.NoError(err) {
+		_, err = ReadMsg(buf)
+		assert.Equal(ErrMaxMsgLength, err)
+	}
+}
+
+func TestMaxMsgLengthForgedHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	// a header claiming a huge body is rejected before the body is allocated
+	forge := func() *bytes.Buffer {
+		buf := bytes.NewBuffer([]byte{TypeNewProxy})
+		binary.Write(buf, binary.BigEndian, int64(1)<<62)
+		return buf
+	}
+	_, err := ReadMsg(forge())
+	assert.Equal(ErrMaxMsgLength, err)
+	err = ReadMsgInto(forge(), &NewProxy{})
+	assert.Equal(ErrMaxMsgLength, err)
+}
@@ -35,6 +35,8 @@ const (
 	TypeNatHoleResp           = 'm'
 	TypeNatHoleClientDetectOK = 'd'
 	TypeNatHoleSid            = '5'
+	TypeServerShutdown        = '6'
+	TypeOpenProxy             = '7'
 )
 
 var (
@@ -57,6 +59,8 @@ var (
 		TypeNatHoleResp:           NatHoleResp{},
 		TypeNatHoleClientDetectOK: NatHoleClientDetectOK{},
 		TypeNatHoleSid:            NatHoleSid{},
+		TypeServerShutdown:        ServerShutdown{},
+		TypeOpenProxy:             OpenProxy{},
 	}
 )
 
@@ -73,6 +77,8 @@ type Login struct {
 
 	// Some global configures.
 	PoolCount int `json:"pool_count"`
+
+	Metas map[string]string `json:"metas"`
 }
 
 type LoginResp struct {
@@ -91,37 +97,105 @@ type NewProxy struct {
 	Group          string `json:"group"`
 	GroupKey       string `json:"group_key"`
 
+	GroupStickyCookie string `json:"group_sticky_cookie"`
+	GroupSticky       string `json:"group_sticky"`
+	GroupStickyTTLS   int    `json:"group_sticky_ttl_s"`
+	GroupSlowStartS   int    `json:"group_slow_start_s"`
+
+	ProxyIdleTimeoutS int `json:"proxy_idle_timeout_s"`
+	PoolCount         int `json:"pool_count"`
+	TcpKeepAlive      int `json:"tcp_keepalive"`
+
+	CompressionAlgorithm string `json:"compression_algorithm"`
+
+	MaxConnsPerSec     int64  `json:"max_conns_per_sec"`
+	MaxConnsPerSecMode string `json:"max_conns_per_sec_mode"`
+
+	TrafficQuota int64 `json:"traffic_quota"`
+
+	Metas map[string]string `json:"metas"`
+
 	// tcp and udp only
 	RemotePort int `json:"remote_port"`
+	// tcp only, all ports if the proxy listens on several ports
+	RemotePorts []int `json:"remote_ports,omitempty"`
+
+	// tcp only
+	Lazy             bool `json:"lazy"`
+	LazyIdleTimeoutS int  `json:"lazy_idle_timeout_s"`
+
+	// udp only
+	UseKcp bool `json:"use_kcp"`
 
 	// http and https only
-	CustomDomains     []string          `json:"custom_domains"`
-	SubDomain         string            `json:"subdomain"`
-	Locations         []string          `json:"locations"`
-	HttpUser          string            `json:"http_user"`
-	HttpPwd           string            `json:"http_pwd"`
-	HostHeaderRewrite string            `json:"host_header_rewrite"`
-	Headers           map[string]string `json:"headers"`
+	CustomDomains           []string            `json:"custom_domains"`
+	SubDomain               string              `json:"subdomain"`
+	Locations               []string            `json:"locations"`
+	HttpUser                string              `json:"http_user"`
+	HttpPwd                 string              `json:"http_pwd"`
+	HttpAuthRealm           string              `json:"http_auth_realm"`
+	HttpAuthExemptPaths     []string            `json:"http_auth_exempt_paths"`
+	HttpResponseGzip        bool                `json:"http_response_gzip"`
+	HttpSetForwardedHeaders bool                `json:"http_set_forwarded_headers"`
+	Websocket               bool                `json:"websocket"`
+	Http2Backend            bool                `json:"http2_backend"`
+	Custom503Page           string              `json:"custom_503_page"`
+	HostHeaderRewrite       string              `json:"host_header_rewrite"`
+	Headers                 map[string]string   `json:"headers"`
+	LocationRewrite         map[string]string   `json:"location_rewrite"`
+	DomainLocations         map[string][]string `json:"domain_locations"`
+
+	// https only
+	VerifyClientCert bool   `json:"verify_client_cert"`
+	ClientCa         string `json:"client_ca"`
 
 	// stcp
-	Sk string `json:"sk"`
+	Sk                string `json:"sk"`
+	VisitorVerifyCert bool   `json:"visitor_verify_cert"`
+	VisitorCa         string `json:"visitor_ca"`
+
+	// xtcp
+	FallbackToStcp bool `json:"fallback_to_stcp"`
+
+	// tcpmux
+	Multiplexer string `json:"multiplexer"`
 }
 
 type NewProxyResp struct {
 	ProxyName  string `json:"proxy_name"`
 	RemoteAddr string `json:"remote_addr"`
 	Error      string `json:"error"`
+
+	// Set if frps verifies client certificates of the https proxy, old
+	// versions of frps ignore VerifyClientCert of NewProxy.
+	ClientCertVerified bool `json:"client_cert_verified,omitempty"`
 }
 
 type CloseProxy struct {
 	ProxyName string `json:"proxy_name"`
+
+	// If it's greater than 0, frps keeps user connections of the closed proxy
+	// for DrainTimeoutS seconds and closes the ones still active then.
+	DrainTimeoutS int `json:"drain_timeout_s,omitempty"`
 }
 
 type NewWorkConn struct {
 	RunId string `json:"run_id"`
+
+	// set if it's a dedicated work connection requested for this proxy
+	ProxyName string `json:"proxy_name"`
+
+	// set if it's opened by pool_warmup of frpc, frps closes unused ones if
+	// the pool has more than pool_count connections after a while
+	Warmup bool `json:"warmup,omitempty"`
 }
 
 type ReqWorkConn struct {
+	// If ProxyName is not empty, frps asks for a work connection which is only
+	// used by this proxy, it connects to Port of frps by Protocol.
+	ProxyName string `json:"proxy_name"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
 }
 
 type StartWorkConn struct {
@@ -130,6 +204,16 @@ type StartWorkConn struct {
 	DstAddr   string `json:"dst_addr"`
 	SrcPort   uint16 `json:"src_port"`
 	DstPort   uint16 `json:"dst_port"`
+
+	// short random id logged by both frps and frpc for this connection
+	TraceId string `json:"trace_id"`
+
+	// compression algorithm used by frps, empty means snappy
+	CompressionAlgorithm string `json:"compression_algorithm"`
+
+	// true if it's a user connection of xtcp proxies relayed by frps in stcp
+	// mode, other work connections of xtcp proxies are used to make nat holes
+	Relayed bool `json:"relayed"`
 }
 
 type NewVisitorConn struct {
@@ -138,6 +222,13 @@ type NewVisitorConn struct {
 	Timestamp      int64  `json:"timestamp"`
 	UseEncryption  bool   `json:"use_encryption"`
 	UseCompression bool   `json:"use_compression"`
+
+	// DER certificate chain of visitor and its signature of ProxyName,
+	// Timestamp and Nonce, only sent if visitor has a certificate. frps
+	// accepts each Nonce only once.
+	Certs    [][]byte `json:"certs,omitempty"`
+	CertSign []byte   `json:"cert_sign,omitempty"`
+	Nonce    string   `json:"nonce,omitempty"`
 }
 
 type NewVisitorConnResp struct {
@@ -145,10 +236,14 @@ type NewVisitorConnResp struct {
 	Error     string `json:"error"`
 }
 
+// Seq of Ping is echoed in Pong to measure round-trip time, it's 0 if the peer
+// is an old version.
 type Ping struct {
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 type Pong struct {
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 type UdpPacket struct {
@@ -181,3 +276,13 @@ type NatHoleClientDetectOK struct {
 type NatHoleSid struct {
 	Sid string `json:"sid"`
 }
+
+// frps sends it to all clients when it's shutting down gracefully, control
+// connections will be closed later and clients shouldn't treat it as an error.
+type ServerShutdown struct {
+}
+
+// frpc sends it to ask frps to accept user connections of a lazy tcp proxy.
+type OpenProxy struct {
+	ProxyName string `json:"proxy_name"`
+}
@@ -20,6 +20,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"os"
 
 	frpNet "github.com/fatedier/frp/utils/net"
 )
@@ -28,6 +29,23 @@ const PluginHTTPS2HTTP = "https2http"
 
 func init() {
 	Register(PluginHTTPS2HTTP, NewHTTPS2HTTPPlugin)
+	RegisterValidator(PluginHTTPS2HTTP, ValidateHTTPS2HTTPPluginParams)
+}
+
+func ValidateHTTPS2HTTPPluginParams(params map[string]string) error {
+	for _, key := range []string{"plugin_crt_path", "plugin_key_path"} {
+		path := params[key]
+		if path == "" {
+			return fmt.Errorf("%s is required", key)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s error: %v", key, err)
+		}
+	}
+	if params["plugin_local_addr"] == "" {
+		return fmt.Errorf("plugin_local_addr is required")
+	}
+	return nil
 }
 
 type HTTPS2HTTPPlugin struct {
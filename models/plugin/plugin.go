@@ -28,13 +28,35 @@ import (
 // Creators is used for create plugins to handle connections.
 var creators = make(map[string]CreatorFn)
 
+// Validators check params of plugins before they are created.
+var validators = make(map[string]ValidatorFn)
+
 // params has prefix "plugin_"
 type CreatorFn func(params map[string]string) (Plugin, error)
 
+// ValidatorFn should be lightweight and have no side effect.
+type ValidatorFn func(params map[string]string) error
+
 func Register(name string, fn CreatorFn) {
 	creators[name] = fn
 }
 
+func RegisterValidator(name string, fn ValidatorFn) {
+	validators[name] = fn
+}
+
+// Validate checks params of plugin, so misconfiguration can be found
+// before any connection is handled.
+func Validate(name string, params map[string]string) error {
+	if _, ok := creators[name]; !ok {
+		return fmt.Errorf("plugin [%s] is not registered", name)
+	}
+	if fn, ok := validators[name]; ok {
+		return fn(params)
+	}
+	return nil
+}
+
 func Create(name string, params map[string]string) (p Plugin, err error) {
 	if fn, ok := creators[name]; ok {
 		p, err = fn(params)
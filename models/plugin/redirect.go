@@ -0,0 +1,130 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+const PluginRedirect = "redirect"
+
+func init() {
+	Register(PluginRedirect, NewRedirectPlugin)
+	RegisterValidator(PluginRedirect, ValidateRedirectPluginParams)
+}
+
+func ValidateRedirectPluginParams(params map[string]string) error {
+	target := params["plugin_redirect_url"]
+	if target == "" {
+		return fmt.Errorf("plugin_redirect_url is required")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("plugin_redirect_url error: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("plugin_redirect_url [%s] should be an absolute http or https url", target)
+	}
+
+	if _, err = getRedirectCode(params); err != nil {
+		return err
+	}
+	if v, ok := params["plugin_keep_path"]; ok && v != "true" && v != "false" {
+		return fmt.Errorf("plugin_keep_path should be true or false")
+	}
+	return nil
+}
+
+// getRedirectCode returns plugin_redirect_code, default is 302.
+func getRedirectCode(params map[string]string) (int, error) {
+	tmpStr, ok := params["plugin_redirect_code"]
+	if !ok {
+		return http.StatusFound, nil
+	}
+	code, err := strconv.Atoi(tmpStr)
+	if err != nil {
+		return 0, fmt.Errorf("plugin_redirect_code error: %v", err)
+	}
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return code, nil
+	}
+	return 0, fmt.Errorf("plugin_redirect_code should be one of 301, 302, 303, 307 and 308")
+}
+
+// RedirectPlugin answers every http request with a redirect to redirectUrl
+// and closes the connection, the request is never sent to local services.
+type RedirectPlugin struct {
+	redirectUrl string
+	code        int
+	// append the path and query of requests to redirectUrl
+	keepPath bool
+
+	l *Listener
+	s *http.Server
+}
+
+func NewRedirectPlugin(params map[string]string) (Plugin, error) {
+	code, err := getRedirectCode(params)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := NewProxyListener()
+	rp := &RedirectPlugin{
+		redirectUrl: params["plugin_redirect_url"],
+		code:        code,
+		keepPath:    params["plugin_keep_path"] == "true",
+
+		l: listener,
+	}
+	rp.s = &http.Server{
+		Handler: http.HandlerFunc(rp.serveHTTP),
+	}
+	go rp.s.Serve(listener)
+	return rp, nil
+}
+
+func (rp *RedirectPlugin) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	location := rp.redirectUrl
+	if rp.keepPath {
+		location = strings.TrimSuffix(location, "/") + r.URL.RequestURI()
+	}
+	w.Header().Set("Connection", "close")
+	http.Redirect(w, r, location, rp.code)
+}
+
+func (rp *RedirectPlugin) Handle(conn io.ReadWriteCloser, realConn frpNet.Conn, extraBufToLocal []byte) {
+	wrapConn := frpNet.WrapReadWriteCloserToConn(conn, realConn)
+	rp.l.PutConn(wrapConn)
+}
+
+func (rp *RedirectPlugin) Name() string {
+	return PluginRedirect
+}
+
+func (rp *RedirectPlugin) Close() error {
+	rp.s.Close()
+	rp.l.Close()
+	return nil
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRedirectPluginParams(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		params map[string]string
+		valid  bool
+	}{
+		{map[string]string{"plugin_redirect_url": "https://example.com"}, true},
+		{map[string]string{"plugin_redirect_url": "http://example.com/new", "plugin_redirect_code": "308", "plugin_keep_path": "true"}, true},
+		{map[string]string{}, false},
+		{map[string]string{"plugin_redirect_url": "/relative"}, false},
+		{map[string]string{"plugin_redirect_url": "ftp://example.com"}, false},
+		{map[string]string{"plugin_redirect_url": "https://example.com", "plugin_redirect_code": "200"}, false},
+		{map[string]string{"plugin_redirect_url": "https://example.com", "plugin_redirect_code": "abc"}, false},
+		{map[string]string{"plugin_redirect_url": "https://example.com", "plugin_keep_path": "yes"}, false},
+	}
+	for _, test := range tests {
+		err := ValidateRedirectPluginParams(test.params)
+		if test.valid {
+			assert.NoError(err, "%v", test.params)
+		} else {
+			assert.Error(err, "%v", test.params)
+		}
+	}
+}
+
+func TestRedirectPlugin(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		params   map[string]string
+		code     int
+		location string
+	}{
+		{map[string]string{"plugin_redirect_url": "https://example.com/"}, http.StatusFound, "https://example.com/"},
+		{map[string]string{"plugin_redirect_url": "https://example.com/", "plugin_redirect_code": "301", "plugin_keep_path": "true"},
+			http.StatusMovedPermanently, "https://example.com/docs/index.html?a=1"},
+	}
+	for _, test := range tests {
+		p, err := NewRedirectPlugin(test.params)
+		if !assert.NoError(err) {
+			return
+		}
+
+		conn, peer := net.Pipe()
+		p.Handle(conn, frpNet.WrapConn(conn), nil)
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/docs/index.html?a=1", nil)
+		go req.Write(peer)
+		resp, err := http.ReadResponse(bufio.NewReader(peer), req)
+		if assert.NoError(err) {
+			resp.Body.Close()
+			assert.Equal(test.code, resp.StatusCode)
+			assert.Equal(test.location, resp.Header.Get("Location"))
+			// the connection is closed after the response
+			assert.True(resp.Close)
+		}
+		peer.Close()
+		p.Close()
+	}
+}
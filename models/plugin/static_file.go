@@ -15,8 +15,10 @@
 package plugin
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 
 	frpNet "github.com/fatedier/frp/utils/net"
 
@@ -27,6 +29,22 @@ const PluginStaticFile = "static_file"
 
 func init() {
 	Register(PluginStaticFile, NewStaticFilePlugin)
+	RegisterValidator(PluginStaticFile, ValidateStaticFilePluginParams)
+}
+
+func ValidateStaticFilePluginParams(params map[string]string) error {
+	localPath := params["plugin_local_path"]
+	if localPath == "" {
+		return fmt.Errorf("plugin_local_path is required")
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("plugin_local_path error: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("plugin_local_path [%s] is not a directory", localPath)
+	}
+	return nil
 }
 
 type StaticFilePlugin struct {
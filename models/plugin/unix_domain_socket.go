@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 
 	frpNet "github.com/fatedier/frp/utils/net"
 
@@ -28,6 +29,22 @@ const PluginUnixDomainSocket = "unix_domain_socket"
 
 func init() {
 	Register(PluginUnixDomainSocket, NewUnixDomainSocketPlugin)
+	RegisterValidator(PluginUnixDomainSocket, ValidateUnixDomainSocketPluginParams)
+}
+
+func ValidateUnixDomainSocketPluginParams(params map[string]string) error {
+	unixPath := params["plugin_unix_path"]
+	if unixPath == "" {
+		return fmt.Errorf("plugin_unix_path not found")
+	}
+	info, err := os.Stat(unixPath)
+	if err != nil {
+		return fmt.Errorf("plugin_unix_path error: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("plugin_unix_path [%s] is not a unix domain socket", unixPath)
+	}
+	return nil
 }
 
 type UnixDomainSocketPlugin struct {
@@ -56,6 +73,7 @@ func NewUnixDomainSocketPlugin(params map[string]string) (p Plugin, err error) {
 func (uds *UnixDomainSocketPlugin) Handle(conn io.ReadWriteCloser, realConn frpNet.Conn, extraBufToLocal []byte) {
 	localConn, err := net.DialUnix("unix", nil, uds.UnixAddr)
 	if err != nil {
+		conn.Close()
 		return
 	}
 	if len(extraBufToLocal) > 0 {
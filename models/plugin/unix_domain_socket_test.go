@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUnixDomainSocketPluginParams(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix domain sockets are not supported")
+	}
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frpc_uds")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	sockPath := filepath.Join(dir, "local.sock")
+	l, err := net.Listen("unix", sockPath)
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+	filePath := filepath.Join(dir, "file")
+	if !assert.NoError(ioutil.WriteFile(filePath, nil, 0644)) {
+		return
+	}
+
+	assert.NoError(ValidateUnixDomainSocketPluginParams(map[string]string{"plugin_unix_path": sockPath}))
+	assert.Error(ValidateUnixDomainSocketPluginParams(map[string]string{}))
+	assert.Error(ValidateUnixDomainSocketPluginParams(map[string]string{"plugin_unix_path": filepath.Join(dir, "not_exist")}))
+	// a regular file is not a unix domain socket
+	assert.Error(ValidateUnixDomainSocketPluginParams(map[string]string{"plugin_unix_path": filePath}))
+}
+
+func TestUnixDomainSocketPluginDialError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix domain sockets are not supported")
+	}
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frpc_uds")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewUnixDomainSocketPlugin(map[string]string{"plugin_unix_path": filepath.Join(dir, "not_exist")})
+	if !assert.NoError(err) {
+		return
+	}
+	// the user connection is closed if the local socket can't be dialed
+	conn, peer := net.Pipe()
+	p.Handle(conn, nil, nil)
+	_, err = peer.Read(make([]byte, 1))
+	assert.Error(err)
+}
@@ -1,7 +1,13 @@
 package udp
 
 import (
+	"bytes"
+	"net"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/msg"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -16,3 +22,58 @@ func TestUdpPacket(t *testing.T) {
 	assert.NoError(err)
 	assert.EqualValues(buf, newBuf)
 }
+
+func TestForwarderIPv6(t *testing.T) {
+	assert := assert.New(t)
+
+	echoConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("ipv6 is not available: %v", err)
+	}
+	defer echoConn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echoConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echoConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	// resolved in the same way as local_ip and local_port of udp proxies
+	port := echoConn.LocalAddr().(*net.UDPAddr).Port
+	dstAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if !assert.NoError(err) {
+		return
+	}
+	readCh := make(chan *msg.UdpPacket, 1)
+	sendCh := make(chan msg.Message, 1)
+	defer close(readCh)
+	Forwarder(dstAddr, readCh, sendCh)
+
+	// the packet is encoded and decoded as it's sent between frps and frpc
+	userAddr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6000}
+	var buf bytes.Buffer
+	if !assert.NoError(msg.WriteMsg(&buf, NewUdpPacket([]byte("hello"), nil, userAddr))) {
+		return
+	}
+	var udpMsg msg.UdpPacket
+	if !assert.NoError(msg.ReadMsgInto(&buf, &udpMsg)) {
+		return
+	}
+	assert.Equal(userAddr.String(), udpMsg.RemoteAddr.String())
+	readCh <- &udpMsg
+
+	select {
+	case m := <-sendCh:
+		resp := m.(*msg.UdpPacket)
+		content, err := GetContent(resp)
+		assert.NoError(err)
+		assert.Equal("hello", string(content))
+		assert.Equal(userAddr.String(), resp.RemoteAddr.String())
+	case <-time.After(3 * time.Second):
+		assert.Fail("no response from the udp echo server")
+	}
+}
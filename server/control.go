@@ -15,10 +15,12 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 	"strings"
 
@@ -28,6 +30,7 @@ import (
 	frpErr "github.com/fatedier/frp/models/errors"
 	"github.com/fatedier/frp/models/msg"
 	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/geoip"
 	"github.com/fatedier/frp/server/proxy"
 	"github.com/fatedier/frp/server/stats"
 	"github.com/fatedier/frp/utils/net"
@@ -46,21 +49,35 @@ type ControlManager struct {
 	// controls indexed by run id
 	ctlsByRunId map[string]*Control
 
+	// proxies being registered by users with max proxies, they're counted
+	// so concurrent registrations of a user can't exceed it
+	reservedProxies map[string]int
+	proxyLimitMu    sync.Mutex
+
+	// 1 means frps is shutting down, new proxies are rejected
+	shuttingDown uint32
+
 	mu sync.RWMutex
 }
 
 func NewControlManager() *ControlManager {
 	return &ControlManager{
-		ctlsByRunId: make(map[string]*Control),
+		ctlsByRunId:     make(map[string]*Control),
+		reservedProxies: make(map[string]int),
 	}
 }
 
-func (cm *ControlManager) Add(runId string, ctl *Control) (oldCtl *Control) {
+// Add replaces the control with the same run id if there is one, it fails if
+// the old control belongs to another user.
+func (cm *ControlManager) Add(runId string, ctl *Control) (oldCtl *Control, err error) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
 	oldCtl, ok := cm.ctlsByRunId[runId]
 	if ok {
+		if oldCtl.loginMsg.User != ctl.loginMsg.User {
+			return nil, fmt.Errorf("run id [%s] is in use by another user", runId)
+		}
 		oldCtl.Replaced(ctl)
 	}
 	cm.ctlsByRunId[runId] = ctl
@@ -97,6 +114,102 @@ func (cm *ControlManager) SearchById(runId string) (ctl *Control, ok bool) {
 	return
 }
 
+// GetByProxyName returns the control which the proxy belongs to.
+func (cm *ControlManager) GetByProxyName(name string) (ctl *Control, ok bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	for _, c := range cm.ctlsByRunId {
+		c.mu.RLock()
+		_, exist := c.proxies[name]
+		c.mu.RUnlock()
+		if exist {
+			return c, true
+		}
+	}
+	return
+}
+
+// CountProxiesByUser returns the number of proxies of all controls logged in
+// by user.
+func (cm *ControlManager) CountProxiesByUser(user string) (n int) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	for _, c := range cm.ctlsByRunId {
+		if c.loginMsg.User != user {
+			continue
+		}
+		c.mu.RLock()
+		n += len(c.proxies)
+		c.mu.RUnlock()
+	}
+	return
+}
+
+// reserveProxy returns false if user already has max proxies, including the
+// ones being registered. Otherwise one proxy is reserved until releaseProxy.
+func (cm *ControlManager) reserveProxy(user string, max int64) bool {
+	cm.proxyLimitMu.Lock()
+	defer cm.proxyLimitMu.Unlock()
+	if int64(cm.CountProxiesByUser(user)+cm.reservedProxies[user]) >= max {
+		return false
+	}
+	cm.reservedProxies[user]++
+	return true
+}
+
+func (cm *ControlManager) releaseProxy(user string) {
+	cm.proxyLimitMu.Lock()
+	defer cm.proxyLimitMu.Unlock()
+	cm.reservedProxies[user]--
+	if cm.reservedProxies[user] <= 0 {
+		delete(cm.reservedProxies, user)
+	}
+}
+
+// GetAll returns all controls of online clients.
+func (cm *ControlManager) GetAll() []*Control {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ctls := make([]*Control, 0, len(cm.ctlsByRunId))
+	for _, ctl := range cm.ctlsByRunId {
+		ctls = append(ctls, ctl)
+	}
+	return ctls
+}
+
+// RejectNewProxies makes all controls reject proxies registered from now on.
+func (cm *ControlManager) RejectNewProxies() {
+	atomic.StoreUint32(&cm.shuttingDown, 1)
+}
+
+func (cm *ControlManager) isShuttingDown() bool {
+	return atomic.LoadUint32(&cm.shuttingDown) == 1
+}
+
+// NotifyShutdown tells all clients that frps is shutting down.
+func (cm *ControlManager) NotifyShutdown() {
+	for _, ctl := range cm.GetAll() {
+		ctl.NotifyShutdown()
+	}
+}
+
+// CloseAll closes all controls and their proxies, it blocks until they are closed.
+func (cm *ControlManager) CloseAll() {
+	cm.mu.RLock()
+	ctls := make([]*Control, 0, len(cm.ctlsByRunId))
+	for _, ctl := range cm.ctlsByRunId {
+		ctls = append(ctls, ctl)
+	}
+	cm.mu.RUnlock()
+
+	for _, ctl := range ctls {
+		ctl.allShutdown.Start()
+	}
+	for _, ctl := range ctls {
+		ctl.WaitClosed()
+	}
+}
+
 type Control struct {
 	// all resource managers and controllers
 	rc *controller.ResourceController
@@ -122,6 +235,10 @@ type Control struct {
 	// work connections
 	workConnCh chan net.Conn
 
+	// dedicated work connections of proxies, e.g. kcp work connections of udp
+	// proxies, guarded by mu
+	dedicatedWorkConnChs map[string]chan net.Conn
+
 	// proxies in one client
 	proxies map[string]proxy.Proxy
 
@@ -142,6 +259,9 @@ type Control struct {
 	// control status
 	status string
 
+	// geographic info of the client ip, empty if geoip lookup is disabled
+	geoInfo geoip.Info
+
 	readerShutdown  *shutdown.Shutdown
 	writerShutdown  *shutdown.Shutdown
 	managerShutdown *shutdown.Shutdown
@@ -150,6 +270,10 @@ type Control struct {
 	inLimit  uint64
 	outLimit uint64
 
+	// max number of proxies of the user counted by ctlManager, 0 means no limit
+	maxProxies int64
+	ctlManager *ControlManager
+
 	mu sync.RWMutex
 }
 
@@ -217,11 +341,102 @@ func (ctl *Control) RegisterWorkConn(conn net.Conn) {
 	}
 }
 
+// shrinkWorkConnPool closes one work connection if there are more than
+// poolCount in the pool, they're warmed up by frpc but not used.
+func (ctl *Control) shrinkWorkConnPool() {
+	if len(ctl.workConnCh) <= ctl.poolCount {
+		return
+	}
+	select {
+	case workConn, ok := <-ctl.workConnCh:
+		if ok {
+			ctl.conn.Debug("close an unused warmed up work connection")
+			workConn.Close()
+		}
+	default:
+	}
+}
+
+// RegisterDedicatedWorkConn passes conn to the proxy which requests it by
+// GetDedicatedWorkConn, it's closed if the proxy doesn't wait for it.
+func (ctl *Control) RegisterDedicatedWorkConn(proxyName string, conn net.Conn) {
+	ctl.mu.RLock()
+	ch, ok := ctl.dedicatedWorkConnChs[proxyName]
+	ctl.mu.RUnlock()
+	if !ok {
+		ctl.conn.Debug("proxy [%s] doesn't wait for dedicated work connection, discarding", proxyName)
+		conn.Close()
+		return
+	}
+
+	select {
+	case ch <- conn:
+		ctl.conn.Debug("new dedicated work connection of proxy [%s] registered", proxyName)
+	default:
+		ctl.conn.Debug("dedicated work connection of proxy [%s] is already registered, discarding", proxyName)
+		conn.Close()
+	}
+}
+
+// GetDedicatedWorkConn asks frpc for a new work connection which is connected
+// to port of frps by protocol and only used by the proxy.
+func (ctl *Control) GetDedicatedWorkConn(ctx context.Context, proxyName string, protocol string, port int) (workConn net.Conn, err error) {
+	ctl.mu.Lock()
+	if ctl.status == consts.Closed {
+		ctl.mu.Unlock()
+		err = frpErr.ErrCtlClosed
+		return
+	}
+	if ctl.dedicatedWorkConnChs == nil {
+		ctl.dedicatedWorkConnChs = make(map[string]chan net.Conn)
+	}
+	ch, ok := ctl.dedicatedWorkConnChs[proxyName]
+	if !ok {
+		ch = make(chan net.Conn, 1)
+		ctl.dedicatedWorkConnChs[proxyName] = ch
+	}
+	ctl.mu.Unlock()
+
+	err = errors.PanicToError(func() {
+		ctl.sendCh <- &msg.ReqWorkConn{
+			ProxyName: proxyName,
+			Protocol:  protocol,
+			Port:      port,
+		}
+	})
+	if err != nil {
+		ctl.conn.Error("%v", err)
+		return
+	}
+
+	select {
+	case workConn = <-ch:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout trying to get dedicated work connection: %v", ctx.Err())
+		ctl.conn.Warn("%v", err)
+	}
+	return
+}
+
+// closeDedicatedWorkConns must be called with ctl.mu held.
+func (ctl *Control) closeDedicatedWorkConns(proxyName string) {
+	ch, ok := ctl.dedicatedWorkConnChs[proxyName]
+	if !ok {
+		return
+	}
+	delete(ctl.dedicatedWorkConnChs, proxyName)
+	select {
+	case workConn := <-ch:
+		workConn.Close()
+	default:
+	}
+}
+
 // When frps get one user connection, we get one work connection from the pool and return it.
 // If no workConn available in the pool, send message to frpc to get one or more
 // and wait until it is available.
-// return an error if wait timeout
-func (ctl *Control) GetWorkConn() (workConn net.Conn, err error) {
+// return an error if wait timeout, fromPool is false if we had to wait.
+func (ctl *Control) GetWorkConn(ctx context.Context) (workConn net.Conn, fromPool bool, err error) {
 	defer func() {
 		if err := recover(); err != nil {
 			ctl.conn.Error("panic error: %v", err)
@@ -237,6 +452,7 @@ func (ctl *Control) GetWorkConn() (workConn net.Conn, err error) {
 			err = frpErr.ErrCtlClosed
 			return
 		}
+		fromPool = true
 		ctl.conn.Debug("get work connection from pool")
 	default:
 		// no work connections available in the poll, send message to frpc to get more
@@ -256,8 +472,8 @@ func (ctl *Control) GetWorkConn() (workConn net.Conn, err error) {
 				return
 			}
 
-		case <-time.After(time.Duration(g.GlbServerCfg.UserConnTimeout) * time.Second):
-			err = fmt.Errorf("timeout trying to get work connection")
+		case <-ctx.Done():
+			err = fmt.Errorf("timeout trying to get work connection: %v", ctx.Err())
 			ctl.conn.Warn("%v", err)
 			return
 		}
@@ -334,6 +550,14 @@ func (ctl *Control) reader() {
 	}
 }
 
+// NotifyShutdown sends ServerShutdown to the client, it's written before the
+// control connection is closed by stoper.
+func (ctl *Control) NotifyShutdown() {
+	errors.PanicToError(func() {
+		ctl.sendCh <- &msg.ServerShutdown{}
+	})
+}
+
 func (ctl *Control) stoper() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -360,6 +584,18 @@ func (ctl *Control) stoper() {
 	for workConn := range ctl.workConnCh {
 		workConn.Close()
 	}
+	// no more dedicated work connections are waited for, the ones registered
+	// after this are discarded
+	ctl.status = consts.Closed
+	dedicatedWorkConnChs := ctl.dedicatedWorkConnChs
+	ctl.dedicatedWorkConnChs = nil
+	for _, ch := range dedicatedWorkConnChs {
+		select {
+		case workConn := <-ch:
+			workConn.Close()
+		default:
+		}
+	}
 
 	for _, pxy := range ctl.proxies {
 		pxy.Close()
@@ -419,6 +655,7 @@ func (ctl *Control) manager() {
 					ctl.conn.Warn("new proxy [%s] error: %v", m.ProxyName, err)
 				} else {
 					resp.RemoteAddr = remoteAddr
+					resp.ClientCertVerified = m.VerifyClientCert
 					ctl.conn.Info("new proxy [%s] success", m.ProxyName)
 					ctl.statsCollector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{
 						Name:      m.ProxyName,
@@ -429,10 +666,14 @@ func (ctl *Control) manager() {
 			case *msg.CloseProxy:
 				ctl.CloseProxy(m)
 				ctl.conn.Info("close proxy [%s] success", m.ProxyName)
+			case *msg.OpenProxy:
+				if err := ctl.OpenProxy(m); err != nil {
+					ctl.conn.Warn("open proxy [%s] error: %v", m.ProxyName, err)
+				}
 			case *msg.Ping:
 				ctl.lastPing = time.Now()
 				ctl.conn.Debug("receive heartbeat")
-				ctl.sendCh <- &msg.Pong{}
+				ctl.sendCh <- &msg.Pong{Seq: m.Seq}
 			}
 		}
 	}
@@ -454,6 +695,24 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		return remoteAddr, err
 	}
 
+	if ctl.ctlManager != nil && ctl.ctlManager.isShuttingDown() {
+		return remoteAddr, frpErr.ErrServerShuttingDown
+	}
+
+	if ctl.rc.TrafficQuotaManager != nil && ctl.rc.TrafficQuotaManager.Exceeded(pxyMsg.ProxyName, pxyMsg.TrafficQuota) {
+		return remoteAddr, fmt.Errorf("proxy [%s] has used up its traffic quota", pxyMsg.ProxyName)
+	}
+
+	// udp proxies with use_kcp get their own kcp work connections instead of the ones in pool
+	getWorkConn := ctl.GetWorkConn
+	if udpConf, ok := pxyConf.(*config.UdpProxyConf); ok && udpConf.UseKcp {
+		getWorkConn = func(ctx context.Context) (net.Conn, bool, error) {
+			workConn, err := ctl.GetDedicatedWorkConn(ctx, pxyMsg.ProxyName, "kcp", g.GlbServerCfg.KcpBindPort)
+			return workConn, false, err
+		}
+		workConn = getWorkConn
+	}
+
 	if g.GlbServerCfg.EnableApi {
 
 		nowTime := time.Now().Unix()
@@ -467,12 +726,12 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 			return remoteAddr, fmt.Errorf("invalid proxy configuration")
 		}
 
-		workConn = func() (frpNet.Conn, error) {
-			fconn, err := ctl.GetWorkConn()
+		workConn = func(ctx context.Context) (frpNet.Conn, bool, error) {
+			fconn, fromPool, err := getWorkConn(ctx)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
-			return limit.NewLimitConn(ctl.inLimit, ctl.outLimit, fconn), nil
+			return limit.NewLimitConn(ctl.inLimit, ctl.outLimit, fconn), fromPool, nil
 		}
 	}
 
@@ -483,17 +742,23 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		return remoteAddr, err
 	}
 
-	err = ctl.pxyManager.Add(pxyMsg.ProxyName, pxy)
-	if err != nil {
-		return
+	// the reservation is released after the proxy is added to ctl.proxies or
+	// fails to run
+	if ctl.maxProxies > 0 && ctl.ctlManager != nil {
+		if !ctl.ctlManager.reserveProxy(ctl.loginMsg.User, ctl.maxProxies) {
+			err = fmt.Errorf("exceed the max proxies [%d] of user [%s]", ctl.maxProxies, ctl.loginMsg.User)
+			return
+		}
+		defer ctl.ctlManager.releaseProxy(ctl.loginMsg.User)
 	}
 
-	// Check ports used number in each client
+	// Check ports used number in each client before the proxy is visible to others,
+	// so a rejected proxy is never left in pxyManager.
 	if g.GlbServerCfg.MaxPortsPerClient > 0 {
 		ctl.mu.Lock()
 		if ctl.portsUsedNum+pxy.GetUsedPortsNum() > int(g.GlbServerCfg.MaxPortsPerClient) {
 			ctl.mu.Unlock()
-			err = fmt.Errorf("exceed the max_ports_per_client")
+			err = fmt.Errorf("exceed the max_ports_per_client [%d]", g.GlbServerCfg.MaxPortsPerClient)
 			return
 		}
 		ctl.portsUsedNum = ctl.portsUsedNum + pxy.GetUsedPortsNum()
@@ -508,6 +773,16 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		}()
 	}
 
+	err = ctl.pxyManager.Add(pxyMsg.ProxyName, pxy)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			ctl.pxyManager.Del(pxyMsg.ProxyName)
+		}
+	}()
+
 	remoteAddr, err = pxy.Run()
 	if err != nil {
 		return
@@ -518,6 +793,13 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		}
 	}()
 
+	// frps may start shutting down while the proxy is running, its listeners
+	// are not closed by Service.Shutdown then
+	if ctl.ctlManager != nil && ctl.ctlManager.isShuttingDown() {
+		err = frpErr.ErrServerShuttingDown
+		return
+	}
+
 	ctl.mu.Lock()
 	ctl.proxies[pxy.GetName()] = pxy
 	ctl.mu.Unlock()
@@ -536,8 +818,12 @@ func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) (err error) {
 		ctl.portsUsedNum = ctl.portsUsedNum - pxy.GetUsedPortsNum()
 	}
 	pxy.Close()
+	if closeMsg.DrainTimeoutS > 0 {
+		pxy.Drain(time.Duration(closeMsg.DrainTimeoutS) * time.Second)
+	}
 	ctl.pxyManager.Del(pxy.GetName())
 	delete(ctl.proxies, closeMsg.ProxyName)
+	ctl.closeDedicatedWorkConns(closeMsg.ProxyName)
 	ctl.mu.Unlock()
 
 	ctl.statsCollector.Mark(stats.TypeCloseProxy, &stats.CloseProxyPayload{
@@ -546,3 +832,30 @@ func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) (err error) {
 	})
 	return
 }
+
+// OpenProxy starts accepting user connections of a lazy proxy.
+func (ctl *Control) OpenProxy(openMsg *msg.OpenProxy) error {
+	ctl.mu.RLock()
+	pxy, ok := ctl.proxies[openMsg.ProxyName]
+	ctl.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("proxy not found")
+	}
+
+	lazyPxy, ok := pxy.(proxy.LazyProxy)
+	if !ok {
+		return fmt.Errorf("proxy is not lazy")
+	}
+	return lazyPxy.Open()
+}
+
+// CloseProxyByServer closes the proxy and tells the client not to register it
+// again until its configure file is reloaded.
+func (ctl *Control) CloseProxyByServer(name string) {
+	ctl.CloseProxy(&msg.CloseProxy{ProxyName: name})
+	ctl.conn.Info("close proxy [%s] by server", name)
+
+	errors.PanicToError(func() {
+		ctl.sendCh <- &msg.CloseProxy{ProxyName: name}
+	})
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/extend/api"
+	"github.com/fatedier/frp/g"
+	frpErr "github.com/fatedier/frp/models/errors"
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/ports"
+	"github.com/fatedier/frp/server/proxy"
+	"github.com/fatedier/frp/server/stats"
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestResourceController() *controller.ResourceController {
+	return &controller.ResourceController{
+		TcpPortManager: ports.NewPortManager("tcp", "127.0.0.1", make(map[int]struct{})),
+	}
+}
+
+// newTestControl adds a control of user logged in with runId to cm, it's not
+// started so proxies can be registered directly.
+func newTestControl(cm *ControlManager, rc *controller.ResourceController, user string, runId string) *Control {
+	ctlConn, _ := net.Pipe()
+	ctl := NewControl(rc, proxy.NewProxyManager(), stats.NewInternalCollector(false), frpNet.WrapConn(ctlConn),
+		&msg.Login{User: user, RunId: runId}, 0, 0)
+	ctl.ctlManager = cm
+	cm.Add(runId, ctl)
+	return ctl
+}
+
+func newTcpProxyMsg(t *testing.T, name string) *msg.NewProxy {
+	return &msg.NewProxy{
+		ProxyName:  name,
+		ProxyType:  "tcp",
+		RemotePort: freePort(t, "tcp", "127.0.0.1"),
+	}
+}
+
+func closeTestControl(ctl *Control) {
+	for name := range ctl.proxies {
+		ctl.CloseProxy(&msg.CloseProxy{ProxyName: name})
+	}
+}
+
+func TestRegisterProxyWhenShuttingDown(t *testing.T) {
+	assert := assert.New(t)
+	cm := NewControlManager()
+	ctl := newTestControl(cm, newTestResourceController(), "user", "user-1")
+	defer closeTestControl(ctl)
+
+	_, err := ctl.RegisterProxy(newTcpProxyMsg(t, "before"))
+	assert.NoError(err)
+
+	cm.RejectNewProxies()
+	pxyMsg := newTcpProxyMsg(t, "after")
+	_, err = ctl.RegisterProxy(pxyMsg)
+	assert.Equal(frpErr.ErrServerShuttingDown, err)
+	assert.Len(ctl.proxies, 1)
+
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(pxyMsg.RemotePort)))
+	assert.Error(err)
+}
+
+func TestRegisterProxyOverTrafficQuota(t *testing.T) {
+	assert := assert.New(t)
+	cm := NewControlManager()
+	rc := newTestResourceController()
+	rc.TrafficQuotaManager = controller.NewTrafficQuotaManager()
+	ctl := newTestControl(cm, rc, "user", "user-1")
+	defer closeTestControl(ctl)
+
+	rc.TrafficQuotaManager.Add("tcp", 100, 100)
+	pxyMsg := newTcpProxyMsg(t, "tcp")
+	pxyMsg.TrafficQuota = 100
+	_, err := ctl.RegisterProxy(pxyMsg)
+	assert.Error(err)
+	assert.Len(ctl.proxies, 0)
+
+	// a larger quota or no quota doesn't help
+	pxyMsg.TrafficQuota = 200
+	_, err = ctl.RegisterProxy(pxyMsg)
+	assert.Error(err)
+	pxyMsg.TrafficQuota = 0
+	_, err = ctl.RegisterProxy(pxyMsg)
+	assert.Error(err)
+	assert.Len(ctl.proxies, 0)
+
+	// registered again after the usage is reset
+	rc.TrafficQuotaManager.Reset("tcp")
+	pxyMsg.TrafficQuota = 100
+	_, err = ctl.RegisterProxy(pxyMsg)
+	assert.NoError(err)
+}
+
+func TestGetWorkConnFromPool(t *testing.T) {
+	assert := assert.New(t)
+	ctl := newTestControl(NewControlManager(), newTestResourceController(), "user", "user-1")
+
+	p1, _ := net.Pipe()
+	c1 := frpNet.WrapConn(p1)
+	ctl.workConnCh <- c1
+	workConn, fromPool, err := ctl.GetWorkConn(context.Background())
+	if assert.NoError(err) {
+		assert.Equal(c1, workConn)
+		assert.True(fromPool)
+	}
+
+	// the pool is empty, so it waits for a new one from frpc
+	p2, _ := net.Pipe()
+	c2 := frpNet.WrapConn(p2)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ctl.workConnCh <- c2
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	workConn, fromPool, err = ctl.GetWorkConn(ctx)
+	if assert.NoError(err) {
+		assert.Equal(c2, workConn)
+		assert.False(fromPool)
+	}
+}
+
+func TestShrinkWorkConnPool(t *testing.T) {
+	assert := assert.New(t)
+	ctl := newTestControl(NewControlManager(), newTestResourceController(), "user", "user-1")
+	ctl.poolCount = 1
+
+	conns := make([]net.Conn, 0)
+	for i := 0; i < 3; i++ {
+		c, frpcConn := net.Pipe()
+		defer frpcConn.Close()
+		ctl.RegisterWorkConn(frpNet.WrapConn(c))
+		conns = append(conns, frpcConn)
+	}
+	// unused warmed up connections are closed until pool_count are left
+	for i := 0; i < 3; i++ {
+		ctl.shrinkWorkConnPool()
+	}
+	assert.Len(ctl.workConnCh, 1)
+
+	closed := 0
+	for _, c := range conns {
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err == io.EOF {
+			closed++
+		}
+	}
+	assert.Equal(2, closed)
+}
+
+func TestMaxProxiesPerUser(t *testing.T) {
+	assert := assert.New(t)
+	cm := NewControlManager()
+	rc := newTestResourceController()
+	// two clients logged in by the same user share the limit
+	ctl1 := newTestControl(cm, rc, "user", "user-1")
+	defer closeTestControl(ctl1)
+	ctl2 := newTestControl(cm, rc, "user", "user-2")
+	defer closeTestControl(ctl2)
+	other := newTestControl(cm, rc, "other", "other-1")
+	defer closeTestControl(other)
+	for _, ctl := range []*Control{ctl1, ctl2, other} {
+		ctl.maxProxies = 2
+	}
+
+	_, err := ctl1.RegisterProxy(newTcpProxyMsg(t, "user.a"))
+	assert.NoError(err)
+	_, err = ctl2.RegisterProxy(newTcpProxyMsg(t, "user.b"))
+	assert.NoError(err)
+	assert.Equal(2, cm.CountProxiesByUser("user"))
+	_, err = ctl1.RegisterProxy(newTcpProxyMsg(t, "user.c"))
+	assert.Error(err)
+	_, err = ctl2.RegisterProxy(newTcpProxyMsg(t, "user.c"))
+	assert.Error(err)
+
+	// proxies of other users are not counted
+	_, err = other.RegisterProxy(newTcpProxyMsg(t, "other.a"))
+	assert.NoError(err)
+
+	ctl1.CloseProxy(&msg.CloseProxy{ProxyName: "user.a"})
+
+	// a proxy failing to run doesn't keep its reservation
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	pxyMsg := newTcpProxyMsg(t, "user.c")
+	pxyMsg.RemotePort = l.Addr().(*net.TCPAddr).Port
+	_, err = ctl2.RegisterProxy(pxyMsg)
+	assert.Error(err)
+	l.Close()
+	assert.Len(cm.reservedProxies, 0)
+
+	_, err = ctl2.RegisterProxy(newTcpProxyMsg(t, "user.c"))
+	assert.NoError(err)
+	assert.Equal(2, cm.CountProxiesByUser("user"))
+
+	// proxies being registered are counted
+	assert.True(cm.reserveProxy("other", 2))
+	assert.False(cm.reserveProxy("other", 2))
+	cm.releaseProxy("other")
+	assert.True(cm.reserveProxy("other", 2))
+	cm.releaseProxy("other")
+}
+
+func TestGetUserLimit(t *testing.T) {
+	assert := assert.New(t)
+	oldCfg := *g.GlbServerCfg
+	defer func() { *g.GlbServerCfg = oldCfg }()
+	g.GlbServerCfg.MaxProxiesPerUser = 5
+
+	// max-proxies of user "limited" is set by the API
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := api.ResponseGetLimit{MaxIn: 1024, MaxOut: 2048}
+		if r.URL.Query().Get("user") == "limited" {
+			resp.MaxProxies = 3
+		}
+		buf, _ := json.Marshal(struct {
+			Status int `json:"status"`
+			api.ResponseGetLimit
+		}{200, resp})
+		w.Write(buf)
+	}))
+	defer apiServer.Close()
+	apiService, err := api.NewService(apiServer.URL)
+	if !assert.NoError(err) {
+		return
+	}
+	svr := &Service{apiService: apiService}
+
+	inLimit, outLimit, maxProxies, err := svr.getUserLimit("limited", time.Now().Unix())
+	if assert.NoError(err) {
+		assert.EqualValues(1024, inLimit)
+		assert.EqualValues(2048, outLimit)
+		assert.EqualValues(3, maxProxies)
+	}
+	_, _, maxProxies, err = svr.getUserLimit("user", time.Now().Unix())
+	if assert.NoError(err) {
+		assert.EqualValues(5, maxProxies)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// AcmeManager obtains and renews certificates from an ACME CA for domains of
+// https proxies. Only domains registered by running proxies are allowed, so
+// users can't make frps request certificates of arbitrary names.
+type AcmeManager struct {
+	m *autocert.Manager
+
+	domains map[string]int
+	mu      sync.RWMutex
+}
+
+func NewAcmeManager(email string, cacheDir string, directoryUrl string) *AcmeManager {
+	am := &AcmeManager{
+		domains: make(map[string]int),
+	}
+	am.m = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: am.hostPolicy,
+		Email:      email,
+	}
+	if directoryUrl != "" {
+		am.m.Client = &acme.Client{DirectoryURL: directoryUrl}
+	}
+	return am
+}
+
+func (am *AcmeManager) hostPolicy(ctx context.Context, host string) error {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	if _, ok := am.domains[strings.ToLower(host)]; !ok {
+		return fmt.Errorf("domain [%s] is not registered by any https proxy", host)
+	}
+	return nil
+}
+
+// AddDomain allows certificates of domain until it's removed as many times
+// as it's added.
+func (am *AcmeManager) AddDomain(domain string) {
+	am.mu.Lock()
+	am.domains[strings.ToLower(domain)]++
+	am.mu.Unlock()
+}
+
+func (am *AcmeManager) RemoveDomain(domain string) {
+	domain = strings.ToLower(domain)
+	am.mu.Lock()
+	if am.domains[domain] <= 1 {
+		delete(am.domains, domain)
+	} else {
+		am.domains[domain]--
+	}
+	am.mu.Unlock()
+}
+
+// TlsConfig returns a new tls config serving certificates of the manager.
+// Decrypted connections are sent to local services as they are, so only
+// HTTP/1.1 is negotiated.
+func (am *AcmeManager) TlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: am.m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+	}
+}
+
+// HttpHandler serves http-01 challenges and passes other requests to fallback.
+func (am *AcmeManager) HttpHandler(fallback http.Handler) http.Handler {
+	return am.m.HTTPHandler(fallback)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcmeManagerHostPolicy(t *testing.T) {
+	assert := assert.New(t)
+	am := NewAcmeManager("admin@example.com", "", "")
+	ctx := context.Background()
+
+	assert.Error(am.hostPolicy(ctx, "example.com"))
+
+	am.AddDomain("Example.com")
+	am.AddDomain("example.com")
+	assert.NoError(am.hostPolicy(ctx, "example.com"))
+	assert.Error(am.hostPolicy(ctx, "sub.example.com"))
+
+	// allowed until all proxies of the domain are closed
+	am.RemoveDomain("example.com")
+	assert.NoError(am.hostPolicy(ctx, "EXAMPLE.com"))
+	am.RemoveDomain("example.com")
+	assert.Error(am.hostPolicy(ctx, "example.com"))
+}
+
+func TestAcmeManagerHttpHandler(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frps_acme")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	am := NewAcmeManager("admin@example.com", dir, "")
+
+	fallbackCalled := false
+	handler := am.HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalled = true
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	// requests of http proxies fall through to the vhost reverse proxy
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/index.html", nil))
+	assert.True(fallbackCalled)
+	assert.Equal(http.StatusTeapot, w.Code)
+
+	// challenges are answered by the manager, only for domains of proxies
+	challenge := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/token", nil))
+		return w.Code
+	}
+	fallbackCalled = false
+	assert.Equal(http.StatusForbidden, challenge())
+	am.AddDomain("example.com")
+	assert.Equal(http.StatusNotFound, challenge())
+	assert.False(fallbackCalled)
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+)
+
+// TrafficQuotaManager counts traffic of proxies with traffic_quota by proxy
+// name. The usage is kept after proxies are closed, so a proxy which has used
+// up its quota can't register again until the usage is reset.
+//
+// The quota is set by frpc, so once a proxy has used it up, it's rejected
+// whatever quota it registers with later, including 0.
+type TrafficQuotaManager struct {
+	used     map[string]int64
+	exceeded map[string]struct{}
+
+	// called in a new goroutine when a proxy uses up its quota
+	exceedFn func(name string)
+
+	mu sync.Mutex
+}
+
+func NewTrafficQuotaManager() *TrafficQuotaManager {
+	return &TrafficQuotaManager{
+		used:     make(map[string]int64),
+		exceeded: make(map[string]struct{}),
+	}
+}
+
+func (m *TrafficQuotaManager) SetExceedHandler(fn func(name string)) {
+	m.mu.Lock()
+	m.exceedFn = fn
+	m.mu.Unlock()
+}
+
+// Add adds bytes to the usage of proxy name, the exceed handler is called
+// once the usage reaches quota.
+func (m *TrafficQuotaManager) Add(name string, quota int64, bytes int64) {
+	if quota <= 0 || bytes <= 0 {
+		return
+	}
+	m.mu.Lock()
+	before := m.used[name]
+	m.used[name] = before + bytes
+	crossed := before < quota && before+bytes >= quota
+	if crossed {
+		m.exceeded[name] = struct{}{}
+	}
+	fn := m.exceedFn
+	m.mu.Unlock()
+
+	if crossed && fn != nil {
+		go fn(name)
+	}
+}
+
+// Exceeded returns true if proxy name has used up quota, or any quota it was
+// registered with before.
+func (m *TrafficQuotaManager) Exceeded(name string, quota int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.exceeded[name]; ok {
+		return true
+	}
+	return quota > 0 && m.used[name] >= quota
+}
+
+// Reset clears the usage of proxy name and returns the bytes it used.
+func (m *TrafficQuotaManager) Reset(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used := m.used[name]
+	delete(m.used, name)
+	delete(m.exceeded, name)
+	return used
+}
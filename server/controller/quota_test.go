@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficQuotaManager(t *testing.T) {
+	assert := assert.New(t)
+	m := NewTrafficQuotaManager()
+	exceedCh := make(chan string, 10)
+	m.SetExceedHandler(func(name string) {
+		exceedCh <- name
+	})
+	waitExceeded := func() []string {
+		names := make([]string, 0)
+		for {
+			select {
+			case name := <-exceedCh:
+				names = append(names, name)
+			case <-time.After(200 * time.Millisecond):
+				return names
+			}
+		}
+	}
+
+	// proxies without quota are not counted
+	m.Add("free", 0, 100)
+	assert.False(m.Exceeded("free", 0))
+	assert.EqualValues(0, m.Reset("free"))
+
+	m.Add("tcp", 100, 60)
+	assert.False(m.Exceeded("tcp", 100))
+	assert.Empty(waitExceeded())
+
+	// the handler is called once when the usage crosses the quota
+	m.Add("tcp", 100, 60)
+	m.Add("tcp", 100, 10)
+	assert.True(m.Exceeded("tcp", 100))
+	assert.Equal([]string{"tcp"}, waitExceeded())
+	assert.False(m.Exceeded("other", 100))
+
+	// frpc can't get rid of the quota by registering with a larger one or 0
+	assert.True(m.Exceeded("tcp", 200))
+	assert.True(m.Exceeded("tcp", 0))
+
+	// usage is cleared by reset
+	assert.EqualValues(130, m.Reset("tcp"))
+	assert.False(m.Exceeded("tcp", 100))
+	assert.False(m.Exceeded("tcp", 0))
+	m.Add("tcp", 100, 100)
+	assert.True(m.Exceeded("tcp", 100))
+	assert.Equal([]string{"tcp"}, waitExceeded())
+}
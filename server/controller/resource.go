@@ -15,10 +15,15 @@
 package controller
 
 import (
+	"crypto/tls"
+
 	"github.com/fatedier/frp/models/nathole"
 	"github.com/fatedier/frp/server/group"
 	"github.com/fatedier/frp/server/ports"
+	"github.com/fatedier/frp/utils/tcpmux"
 	"github.com/fatedier/frp/utils/vhost"
+
+	"golang.org/x/time/rate"
 )
 
 // All resource managers and controllers
@@ -44,6 +49,21 @@ type ResourceController struct {
 	// For https proxies, route requests to different clients by hostname and other information
 	VhostHttpsMuxer *vhost.HttpsMuxer
 
+	// Certificate of vhost_https_tls_cert_file, used by https proxies which verify client certificates
+	VhostHttpsCertificate *tls.Certificate
+
+	// Obtains certificates of https proxies if vhost_https_acme_enable is true
+	AcmeManager *AcmeManager
+
+	// For tcpmux proxies, route connections to different clients by the host of HTTP CONNECT requests
+	TcpMuxHttpConnectMuxer *tcpmux.HttpConnectTcpMuxer
+
 	// Controller for nat hole connections
 	NatHoleController *nathole.NatHoleController
+
+	// Shared by all user connections to cap the total bandwidth, nil means no limit
+	BandwidthLimiter *rate.Limiter
+
+	// Counts traffic of proxies with traffic_quota
+	TrafficQuotaManager *TrafficQuotaManager
 }
@@ -15,9 +15,11 @@
 package controller
 
 import (
+	"crypto/x509"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/util"
@@ -25,10 +27,21 @@ import (
 	frpIo "github.com/fatedier/golib/io"
 )
 
+// visitorCertSignTimeout is the max difference between the timestamp signed by
+// visitor certificate and now. Nonces of signatures are remembered for twice
+// of it, so each signature can only be used once.
+const visitorCertSignTimeout = 15 * time.Minute
+
 // Manager for visitor listeners.
 type VisitorManager struct {
 	visitorListeners map[string]*frpNet.CustomListener
 	skMap            map[string]string
+	// certificates of visitors are verified if the proxy has a ca pool
+	caMap map[string]*x509.CertPool
+
+	// nonces of verified certificate signatures and when they can be forgotten
+	usedNonces map[string]time.Time
+	nonceMu    sync.Mutex
 
 	mu sync.RWMutex
 }
@@ -37,10 +50,14 @@ func NewVisitorManager() *VisitorManager {
 	return &VisitorManager{
 		visitorListeners: make(map[string]*frpNet.CustomListener),
 		skMap:            make(map[string]string),
+		caMap:            make(map[string]*x509.CertPool),
+		usedNonces:       make(map[string]time.Time),
 	}
 }
 
-func (vm *VisitorManager) Listen(name string, sk string) (l *frpNet.CustomListener, err error) {
+// Listen creates the listener of visitor connections for proxy name, visitors
+// must have a certificate signed by visitorCAs if it's not nil.
+func (vm *VisitorManager) Listen(name string, sk string, visitorCAs *x509.CertPool) (l *frpNet.CustomListener, err error) {
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
 
@@ -52,11 +69,14 @@ func (vm *VisitorManager) Listen(name string, sk string) (l *frpNet.CustomListen
 	l = frpNet.NewCustomListener()
 	vm.visitorListeners[name] = l
 	vm.skMap[name] = sk
+	if visitorCAs != nil {
+		vm.caMap[name] = visitorCAs
+	}
 	return
 }
 
 func (vm *VisitorManager) NewConn(name string, conn frpNet.Conn, timestamp int64, signKey string,
-	useEncryption bool, useCompression bool) (err error) {
+	certs [][]byte, certSign []byte, nonce string, useEncryption bool, useCompression bool) (err error) {
 
 	vm.mu.RLock()
 	defer vm.mu.RUnlock()
@@ -67,6 +87,16 @@ func (vm *VisitorManager) NewConn(name string, conn frpNet.Conn, timestamp int64
 			err = fmt.Errorf("visitor connection of [%s] auth failed", name)
 			return
 		}
+		if visitorCAs, ok := vm.caMap[name]; ok {
+			if err = verifyVisitorCert(name, timestamp, nonce, certs, certSign, visitorCAs); err != nil {
+				err = fmt.Errorf("visitor connection of [%s] certificate verification failed: %v", name, err)
+				return
+			}
+			if !vm.useNonce(nonce) {
+				err = fmt.Errorf("visitor connection of [%s] certificate verification failed: signature is replayed", name)
+				return
+			}
+		}
 
 		var rwc io.ReadWriteCloser = conn
 		if useEncryption {
@@ -92,4 +122,38 @@ func (vm *VisitorManager) CloseListener(name string) {
 
 	delete(vm.visitorListeners, name)
 	delete(vm.skMap, name)
+	delete(vm.caMap, name)
+}
+
+// useNonce returns false if nonce has been used by another signature.
+func (vm *VisitorManager) useNonce(nonce string) bool {
+	vm.nonceMu.Lock()
+	defer vm.nonceMu.Unlock()
+	now := time.Now()
+	for n, expire := range vm.usedNonces {
+		if now.After(expire) {
+			delete(vm.usedNonces, n)
+		}
+	}
+	if _, ok := vm.usedNonces[nonce]; ok {
+		return false
+	}
+	vm.usedNonces[nonce] = now.Add(2 * visitorCertSignTimeout)
+	return true
+}
+
+func verifyVisitorCert(name string, timestamp int64, nonce string, certs [][]byte, certSign []byte,
+	visitorCAs *x509.CertPool) error {
+
+	if len(certs) == 0 {
+		return fmt.Errorf("visitor has no certificate")
+	}
+	if nonce == "" {
+		return fmt.Errorf("signature has no nonce")
+	}
+	diff := time.Since(time.Unix(timestamp, 0))
+	if diff > visitorCertSignTimeout || diff < -visitorCertSignTimeout {
+		return fmt.Errorf("signature is expired")
+	}
+	return util.VerifyCertSign(certs, visitorCAs, []byte(util.GetVisitorCertSignData(name, timestamp, nonce)), certSign)
 }
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVisitorCert(t *testing.T) (*x509.CertPool, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return roots, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestVisitorCertSignReplay(t *testing.T) {
+	assert := assert.New(t)
+	roots, cert := newTestVisitorCert(t)
+	vm := NewVisitorManager()
+	l, err := vm.Listen("secret_tcp", "sk", roots)
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+
+	now := time.Now().Unix()
+	newConn := func(nonce string) error {
+		sign, err := util.SignByCert(cert, []byte(util.GetVisitorCertSignData("secret_tcp", now, nonce)))
+		if err != nil {
+			return err
+		}
+		c, _ := net.Pipe()
+		return vm.NewConn("secret_tcp", frpNet.WrapConn(c), now, util.GetAuthKey("sk", now),
+			cert.Certificate, sign, nonce, false, false)
+	}
+
+	assert.NoError(newConn("nonce1"))
+	// the same signature can't be used by another connection
+	assert.Error(newConn("nonce1"))
+	assert.NoError(newConn("nonce2"))
+	assert.Error(newConn(""))
+}
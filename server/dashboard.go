@@ -15,9 +15,10 @@
 package server
 
 import (
-	"fmt"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/fatedier/frp/assets"
@@ -34,16 +35,24 @@ var (
 
 func (svr *Service) RunDashboardServer(addr string, port int) (err error) {
 	// url router
-	router := mux.NewRouter()
+	root := mux.NewRouter()
 
+	// health checks don't require auth, see health.go
+	root.HandleFunc("/healthz", svr.ApiHealthz).Methods("GET")
+	root.HandleFunc("/readyz", svr.ApiReadyz).Methods("GET")
+
+	router := root.PathPrefix("/").Subrouter()
 	user, passwd := g.GlbServerCfg.DashboardUser, g.GlbServerCfg.DashboardPwd
 	router.Use(frpNet.NewHttpAuthMiddleware(user, passwd).Middleware)
 
 	// api, see dashboard_api.go
 	router.HandleFunc("/api/serverinfo", svr.ApiServerInfo).Methods("GET")
+	router.HandleFunc("/api/proxy/close/{name}", svr.ApiCloseProxy).Methods("GET")
+	router.HandleFunc("/api/proxy/reset_quota/{name}", svr.ApiResetProxyQuota).Methods("POST")
 	router.HandleFunc("/api/proxy/{type}", svr.ApiProxyByType).Methods("GET")
 	router.HandleFunc("/api/proxy/{type}/{name}", svr.ApiProxyByTypeAndName).Methods("GET")
 	router.HandleFunc("/api/traffic/{name}", svr.ApiProxyTraffic).Methods("GET")
+	router.HandleFunc("/api/client", svr.ApiClients).Methods("GET")
 	router.HandleFunc("/api/client/close/{user}", svr.ApiCloseClient).Methods("GET")
 
 	// view
@@ -54,21 +63,37 @@ func (svr *Service) RunDashboardServer(addr string, port int) (err error) {
 		http.Redirect(w, r, "/static/", http.StatusMovedPermanently)
 	})
 
-	address := fmt.Sprintf("%s:%d", addr, port)
+	address := net.JoinHostPort(addr, strconv.Itoa(port))
 	server := &http.Server{
 		Addr:         address,
-		Handler:      router,
+		Handler:      root,
 		ReadTimeout:  httpServerReadTimeout,
 		WriteTimeout: httpServerWriteTimeout,
 	}
 	if address == "" {
 		address = ":http"
 	}
+
+	certFile, keyFile := g.GlbServerCfg.DashboardTlsCertFile, g.GlbServerCfg.DashboardTlsKeyFile
+	if certFile != "" && keyFile != "" {
+		cert, errRet := tls.LoadX509KeyPair(certFile, keyFile)
+		if errRet != nil {
+			return errRet
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
 	ln, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
 
-	go server.Serve(ln)
+	if server.TLSConfig != nil {
+		go server.ServeTLS(ln, "", "")
+	} else {
+		go server.Serve(ln)
+	}
 	return
 }
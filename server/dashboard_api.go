@@ -17,6 +17,7 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
 
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
@@ -94,7 +95,8 @@ type BaseOutConf struct {
 
 type TcpOutConf struct {
 	BaseOutConf
-	RemotePort int `json:"remote_port"`
+	RemotePort  int   `json:"remote_port"`
+	RemotePorts []int `json:"remote_ports,omitempty"`
 }
 
 type UdpOutConf struct {
@@ -114,6 +116,12 @@ type HttpsOutConf struct {
 	config.DomainConf
 }
 
+type TcpMuxOutConf struct {
+	BaseOutConf
+	config.DomainConf
+	Multiplexer string `json:"multiplexer"`
+}
+
 type StcpOutConf struct {
 	BaseOutConf
 }
@@ -132,6 +140,8 @@ func getConfByType(proxyType string) interface{} {
 		return &HttpOutConf{}
 	case consts.HttpsProxy:
 		return &HttpsOutConf{}
+	case consts.TcpMuxProxy:
+		return &TcpMuxOutConf{}
 	case consts.StcpProxy:
 		return &StcpOutConf{}
 	case consts.XtcpProxy:
@@ -151,6 +161,14 @@ type ProxyStatsInfo struct {
 	LastStartTime   string      `json:"last_start_time"`
 	LastCloseTime   string      `json:"last_close_time"`
 	Status          string      `json:"status"`
+	PoolHits        int64       `json:"pool_hits"`
+	PoolMisses      int64       `json:"pool_misses"`
+	PoolRetries     int64       `json:"pool_retries"`
+	PoolTimeouts    int64       `json:"pool_timeouts"`
+	RejectedConns   int64       `json:"rejected_conns"`
+
+	AvgResponseTimeMs float64 `json:"avg_response_time_ms,omitempty"`
+	P95ResponseTimeMs float64 `json:"p95_response_time_ms,omitempty"`
 }
 
 type GetProxyInfoResp struct {
@@ -205,6 +223,13 @@ func (svr *Service) getProxyStatsByType(proxyType string) (proxyInfos []*ProxySt
 		proxyInfo.CurConns = ps.CurConns
 		proxyInfo.LastStartTime = ps.LastStartTime
 		proxyInfo.LastCloseTime = ps.LastCloseTime
+		proxyInfo.PoolHits = ps.PoolHits
+		proxyInfo.PoolMisses = ps.PoolMisses
+		proxyInfo.PoolRetries = ps.PoolRetries
+		proxyInfo.PoolTimeouts = ps.PoolTimeouts
+		proxyInfo.RejectedConns = ps.RejectedConns
+		proxyInfo.AvgResponseTimeMs = ps.AvgResponseTimeMs
+		proxyInfo.P95ResponseTimeMs = ps.P95ResponseTimeMs
 		proxyInfos = append(proxyInfos, proxyInfo)
 	}
 	return
@@ -220,6 +245,14 @@ type GetProxyStatsResp struct {
 	LastStartTime   string      `json:"last_start_time"`
 	LastCloseTime   string      `json:"last_close_time"`
 	Status          string      `json:"status"`
+	PoolHits        int64       `json:"pool_hits"`
+	PoolMisses      int64       `json:"pool_misses"`
+	PoolRetries     int64       `json:"pool_retries"`
+	PoolTimeouts    int64       `json:"pool_timeouts"`
+	RejectedConns   int64       `json:"rejected_conns"`
+
+	AvgResponseTimeMs float64 `json:"avg_response_time_ms,omitempty"`
+	P95ResponseTimeMs float64 `json:"p95_response_time_ms,omitempty"`
 }
 
 // api/proxy/:type/:name
@@ -279,6 +312,13 @@ func (svr *Service) getProxyStatsByTypeAndName(proxyType string, proxyName strin
 		proxyInfo.CurConns = ps.CurConns
 		proxyInfo.LastStartTime = ps.LastStartTime
 		proxyInfo.LastCloseTime = ps.LastCloseTime
+		proxyInfo.PoolHits = ps.PoolHits
+		proxyInfo.PoolMisses = ps.PoolMisses
+		proxyInfo.PoolRetries = ps.PoolRetries
+		proxyInfo.PoolTimeouts = ps.PoolTimeouts
+		proxyInfo.RejectedConns = ps.RejectedConns
+		proxyInfo.AvgResponseTimeMs = ps.AvgResponseTimeMs
+		proxyInfo.P95ResponseTimeMs = ps.P95ResponseTimeMs
 		code = 200
 	}
 
@@ -323,6 +363,71 @@ func (svr *Service) ApiProxyTraffic(w http.ResponseWriter, r *http.Request) {
 	res.Msg = string(buf)
 }
 
+// Get online clients info.
+type ClientInfo struct {
+	RunId      string            `json:"run_id"`
+	User       string            `json:"user"`
+	Hostname   string            `json:"hostname"`
+	Os         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Version    string            `json:"version"`
+	Address    string            `json:"address"`
+	Metas      map[string]string `json:"metas"`
+	ProxyNames []string          `json:"proxy_names"`
+	Country    string            `json:"country,omitempty"`
+	Asn        string            `json:"asn,omitempty"`
+}
+
+type GetClientInfoResp struct {
+	Clients []*ClientInfo `json:"clients"`
+}
+
+// api/client
+func (svr *Service) ApiClients(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	clientInfoResp := GetClientInfoResp{
+		Clients: make([]*ClientInfo, 0),
+	}
+	for _, ctl := range svr.ctlManager.GetAll() {
+		clientInfo := &ClientInfo{
+			RunId:      ctl.runId,
+			User:       ctl.loginMsg.User,
+			Hostname:   ctl.loginMsg.Hostname,
+			Os:         ctl.loginMsg.Os,
+			Arch:       ctl.loginMsg.Arch,
+			Version:    ctl.loginMsg.Version,
+			Address:    ctl.conn.RemoteAddr().String(),
+			Metas:      ctl.loginMsg.Metas,
+			ProxyNames: make([]string, 0),
+			Country:    ctl.geoInfo.Country,
+			Asn:        ctl.geoInfo.Asn,
+		}
+		ctl.mu.RLock()
+		for name := range ctl.proxies {
+			clientInfo.ProxyNames = append(clientInfo.ProxyNames, name)
+		}
+		ctl.mu.RUnlock()
+		sort.Strings(clientInfo.ProxyNames)
+		clientInfoResp.Clients = append(clientInfoResp.Clients, clientInfo)
+	}
+	sort.Slice(clientInfoResp.Clients, func(i, j int) bool {
+		return clientInfoResp.Clients[i].RunId < clientInfoResp.Clients[j].RunId
+	})
+
+	buf, _ := json.Marshal(&clientInfoResp)
+	res.Msg = string(buf)
+}
+
 type CloseUserResp struct {
 	Status int    `json:"status"`
 	Msg    string `json:"message"`
@@ -350,3 +455,59 @@ func (svr *Service) ApiCloseClient(w http.ResponseWriter, r *http.Request) {
 	buf, _ = json.Marshal(&resp)
 	w.Write(buf)
 }
+
+type CloseProxyResp struct {
+	Status     int    `json:"status"`
+	Msg        string `json:"message"`
+	RemotePort int    `json:"remote_port"`
+}
+
+func (svr *Service) ApiCloseProxy(w http.ResponseWriter, r *http.Request) {
+	var (
+		buf  []byte
+		resp = CloseProxyResp{}
+	)
+	params := mux.Vars(r)
+	name := params["name"]
+	defer func() {
+		log.Info("Http response [/api/proxy/close/{name}]: code [%d]", resp.Status)
+	}()
+	log.Info("Http request: [/api/proxy/close/{name}] %#v", name)
+	remotePort, err := svr.CloseProxy(name)
+	if err != nil {
+		resp.Status = 404
+		resp.Msg = err.Error()
+	} else {
+		resp.Status = 200
+		resp.Msg = "OK"
+		resp.RemotePort = remotePort
+	}
+	buf, _ = json.Marshal(&resp)
+	w.Write(buf)
+}
+
+type ResetProxyQuotaResp struct {
+	Status int    `json:"status"`
+	Msg    string `json:"message"`
+	Used   int64  `json:"used"`
+}
+
+// ApiResetProxyQuota clears the traffic used by proxy name, so it can register
+// again after using up its traffic_quota.
+func (svr *Service) ApiResetProxyQuota(w http.ResponseWriter, r *http.Request) {
+	var (
+		buf  []byte
+		resp = ResetProxyQuotaResp{}
+	)
+	params := mux.Vars(r)
+	name := params["name"]
+	defer func() {
+		log.Info("Http response [/api/proxy/reset_quota/{name}]: code [%d]", resp.Status)
+	}()
+	log.Info("Http request: [/api/proxy/reset_quota/{name}] %#v", name)
+	resp.Status = 200
+	resp.Msg = "OK"
+	resp.Used = svr.rc.TrafficQuotaManager.Reset(name)
+	buf, _ = json.Marshal(&resp)
+	w.Write(buf)
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Info is the geographic info of an ip, fields are empty if unknown.
+type Info struct {
+	Country string `json:"country,omitempty"`
+	Asn     string `json:"asn,omitempty"`
+}
+
+// Lookuper resolves client ips to Info, operators can implement it with their own
+// GeoIP database.
+type Lookuper interface {
+	Lookup(ip net.IP) (Info, error)
+}
+
+type LookupFunc func(ip net.IP) (Info, error)
+
+func (f LookupFunc) Lookup(ip net.IP) (Info, error) {
+	return f(ip)
+}
+
+type cidrEntry struct {
+	ipNet *net.IPNet
+	info  Info
+}
+
+// CidrLookuper looks up ips from a list of cidrs, the most specific cidr wins.
+type CidrLookuper struct {
+	entries []cidrEntry
+}
+
+// NewCidrLookuperFromFile reads cidrs from a csv file, each line is
+// "cidr,country,asn", e.g. "1.0.0.0/24,AU,AS13335".
+// Empty lines and lines starting with '#' are ignored.
+func NewCidrLookuperFromFile(file string) (*CidrLookuper, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewCidrLookuper(f)
+}
+
+func NewCidrLookuper(rd io.Reader) (*CidrLookuper, error) {
+	l := &CidrLookuper{
+		entries: make([]cidrEntry, 0),
+	}
+	scanner := bufio.NewScanner(rd)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid geoip line %d: %s", lineNo, line)
+		}
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid geoip line %d: %v", lineNo, err)
+		}
+		l.entries = append(l.entries, cidrEntry{
+			ipNet: ipNet,
+			info: Info{
+				Country: strings.TrimSpace(fields[1]),
+				Asn:     strings.TrimSpace(fields[2]),
+			},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *CidrLookuper) Lookup(ip net.IP) (info Info, err error) {
+	bestOnes := -1
+	for _, entry := range l.entries {
+		if !entry.ipNet.Contains(ip) {
+			continue
+		}
+		if ones, _ := entry.ipNet.Mask.Size(); ones > bestOnes {
+			bestOnes = ones
+			info = entry.info
+		}
+	}
+	if bestOnes < 0 {
+		err = fmt.Errorf("no geoip info of %s", ip)
+	}
+	return
+}
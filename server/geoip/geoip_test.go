@@ -0,0 +1,40 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidrLookuper(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `
+# cidr,country,asn
+10.0.0.0/8,US,AS1
+10.1.0.0/16,CN,AS2
+2001:db8::/32,DE,AS3
+`
+	l, err := NewCidrLookuper(strings.NewReader(content))
+	assert.NoError(err)
+
+	info, err := l.Lookup(net.ParseIP("10.2.0.1"))
+	assert.NoError(err)
+	assert.Equal(Info{Country: "US", Asn: "AS1"}, info)
+
+	info, err = l.Lookup(net.ParseIP("10.1.0.1"))
+	assert.NoError(err)
+	assert.Equal(Info{Country: "CN", Asn: "AS2"}, info)
+
+	info, err = l.Lookup(net.ParseIP("2001:db8::1"))
+	assert.NoError(err)
+	assert.Equal("DE", info.Country)
+
+	_, err = l.Lookup(net.ParseIP("192.168.0.1"))
+	assert.Error(err)
+
+	_, err = NewCidrLookuper(strings.NewReader("10.0.0.0/8,US"))
+	assert.Error(err)
+}
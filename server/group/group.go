@@ -16,6 +16,8 @@ package group
 
 import (
 	"errors"
+	"math/rand"
+	"time"
 )
 
 var (
@@ -25,3 +27,30 @@ var (
 	ErrGroupDifferentPort = errors.New("group should have same remote port")
 	ErrProxyRepeated      = errors.New("group proxy repeated")
 )
+
+// slowStart ramps up the share of traffic of a group member linearly in
+// duration after it joins the group.
+type slowStart struct {
+	joinTime time.Time
+	duration time.Duration
+}
+
+func newSlowStart(duration time.Duration) slowStart {
+	return slowStart{
+		joinTime: time.Now(),
+		duration: duration,
+	}
+}
+
+// accept returns false if the member should skip a connection while it is
+// warming up, the probability of accepting grows from 0 to 1 in duration.
+func (s slowStart) accept() bool {
+	if s.duration <= 0 {
+		return true
+	}
+	elapsed := time.Since(s.joinTime)
+	if elapsed >= s.duration {
+		return true
+	}
+	return rand.Float64()*float64(s.duration) < float64(elapsed)
+}
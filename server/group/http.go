@@ -2,8 +2,11 @@ package group
 
 import (
 	"fmt"
+	"hash/fnv"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	frpNet "github.com/fatedier/frp/utils/net"
 
@@ -25,7 +28,7 @@ func NewHTTPGroupController(vhostRouter *vhost.VhostRouters) *HTTPGroupControlle
 	}
 }
 
-func (ctl *HTTPGroupController) Register(proxyName, group, groupKey string,
+func (ctl *HTTPGroupController) Register(proxyName, group, groupKey string, slowStart time.Duration,
 	routeConfig vhost.VhostRouteConfig) (err error) {
 
 	indexKey := httpGroupIndex(group, routeConfig.Domain, routeConfig.Location)
@@ -37,7 +40,7 @@ func (ctl *HTTPGroupController) Register(proxyName, group, groupKey string,
 	}
 	ctl.mu.Unlock()
 
-	return g.Register(proxyName, group, groupKey, routeConfig)
+	return g.Register(proxyName, group, groupKey, slowStart, routeConfig)
 }
 
 func (ctl *HTTPGroupController) UnRegister(proxyName, group, domain, location string) {
@@ -56,27 +59,45 @@ func (ctl *HTTPGroupController) UnRegister(proxyName, group, domain, location st
 }
 
 type HTTPGroup struct {
-	group    string
-	groupKey string
-	domain   string
-	location string
+	group        string
+	groupKey     string
+	domain       string
+	location     string
+	stickyCookie string
+	stickyIpTTL  time.Duration
 
 	createFuncs map[string]vhost.CreateConnFunc
 	pxyNames    []string
+	stickyIds   map[string]string // sticky id -> proxy name
+	slowStarts  map[string]slowStart
 	index       uint64
 	ctl         *HTTPGroupController
 	mu          sync.RWMutex
+
+	// only used if stickyIpTTL is greater than 0
+	ipSessions map[string]ipSession // user ip -> session
+	lastPurge  time.Time
+	ipMu       sync.Mutex
+}
+
+// ipSession records the proxy serving requests from an ip.
+type ipSession struct {
+	proxyName string
+	expire    time.Time
 }
 
 func NewHTTPGroup(ctl *HTTPGroupController) *HTTPGroup {
 	return &HTTPGroup{
 		createFuncs: make(map[string]vhost.CreateConnFunc),
 		pxyNames:    make([]string, 0),
+		stickyIds:   make(map[string]string),
+		slowStarts:  make(map[string]slowStart),
 		ctl:         ctl,
+		ipSessions:  make(map[string]ipSession),
 	}
 }
 
-func (g *HTTPGroup) Register(proxyName, group, groupKey string,
+func (g *HTTPGroup) Register(proxyName, group, groupKey string, slowStart time.Duration,
 	routeConfig vhost.VhostRouteConfig) (err error) {
 
 	g.mu.Lock()
@@ -85,6 +106,12 @@ func (g *HTTPGroup) Register(proxyName, group, groupKey string,
 		// the first proxy in this group
 		tmp := routeConfig // copy object
 		tmp.CreateConnFn = g.createConn
+		if tmp.StickyCookie != "" {
+			tmp.CreateStickyConnFn = g.createStickyConn
+		}
+		if tmp.StickyIpTTL > 0 {
+			tmp.CreateConnFn = g.createIpStickyConn
+		}
 		err = g.ctl.vhostRouter.Add(routeConfig.Domain, routeConfig.Location, &tmp)
 		if err != nil {
 			return
@@ -94,8 +121,11 @@ func (g *HTTPGroup) Register(proxyName, group, groupKey string,
 		g.groupKey = groupKey
 		g.domain = routeConfig.Domain
 		g.location = routeConfig.Location
+		g.stickyCookie = routeConfig.StickyCookie
+		g.stickyIpTTL = routeConfig.StickyIpTTL
 	} else {
-		if g.group != group || g.domain != routeConfig.Domain || g.location != routeConfig.Location {
+		if g.group != group || g.domain != routeConfig.Domain || g.location != routeConfig.Location ||
+			g.stickyCookie != routeConfig.StickyCookie || g.stickyIpTTL != routeConfig.StickyIpTTL {
 			err = ErrGroupParamsInvalid
 			return
 		}
@@ -110,6 +140,8 @@ func (g *HTTPGroup) Register(proxyName, group, groupKey string,
 	}
 	g.createFuncs[proxyName] = routeConfig.CreateConnFn
 	g.pxyNames = append(g.pxyNames, proxyName)
+	g.stickyIds[stickyId(proxyName)] = proxyName
+	g.slowStarts[proxyName] = newSlowStart(slowStart)
 	return nil
 }
 
@@ -117,6 +149,8 @@ func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	delete(g.createFuncs, proxyName)
+	delete(g.stickyIds, stickyId(proxyName))
+	delete(g.slowStarts, proxyName)
 	for i, name := range g.pxyNames {
 		if name == proxyName {
 			g.pxyNames = append(g.pxyNames[:i], g.pxyNames[i+1:]...)
@@ -140,7 +174,7 @@ func (g *HTTPGroup) createConn(remoteAddr string) (frpNet.Conn, error) {
 	domain := g.domain
 	location := g.location
 	if len(g.pxyNames) > 0 {
-		name := g.pxyNames[int(newIndex)%len(g.pxyNames)]
+		name := g.nextProxy(newIndex)
 		f, _ = g.createFuncs[name]
 	}
 	g.mu.RUnlock()
@@ -152,6 +186,115 @@ func (g *HTTPGroup) createConn(remoteAddr string) (frpNet.Conn, error) {
 	return f(remoteAddr)
 }
 
+// createStickyConn creates the connection by the proxy session refers to. If
+// the session has no id yet, a proxy is chosen by turns. If the proxy has left
+// the group, the id is rehashed to one of the remaining proxies, so users of
+// the same proxy keep sticking together. session.Id is set to the id of the
+// chosen proxy.
+func (g *HTTPGroup) createStickyConn(remoteAddr string, session *vhost.StickySession) (frpNet.Conn, error) {
+	var f vhost.CreateConnFunc
+	newIndex := atomic.AddUint64(&g.index, 1)
+
+	g.mu.RLock()
+	group := g.group
+	domain := g.domain
+	location := g.location
+	if len(g.pxyNames) > 0 {
+		name, ok := g.stickyIds[session.Id]
+		if !ok {
+			if session.Id != "" {
+				h := fnv.New32a()
+				h.Write([]byte(session.Id))
+				name = g.pxyNames[int(uint64(h.Sum32())%uint64(len(g.pxyNames)))]
+			} else {
+				name = g.nextProxy(newIndex)
+			}
+		}
+		f = g.createFuncs[name]
+		session.Id = stickyId(name)
+	}
+	g.mu.RUnlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("no CreateConnFunc for http group [%s], domain [%s], location [%s]", group, domain, location)
+	}
+
+	return f(remoteAddr)
+}
+
+// createIpStickyConn creates the connection by the proxy which served the last
+// request from the ip of remoteAddr if it's within stickyIpTTL and the proxy is
+// still in the group, otherwise a proxy is chosen by turns.
+func (g *HTTPGroup) createIpStickyConn(remoteAddr string) (frpNet.Conn, error) {
+	var f vhost.CreateConnFunc
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	now := time.Now()
+
+	g.mu.RLock()
+	group := g.group
+	domain := g.domain
+	location := g.location
+	if len(g.pxyNames) > 0 {
+		g.ipMu.Lock()
+		g.purgeIpSessions(now)
+		session, ok := g.ipSessions[ip]
+		if ok && now.Before(session.expire) {
+			f = g.createFuncs[session.proxyName]
+		}
+		if f == nil {
+			session.proxyName = g.nextProxy(atomic.AddUint64(&g.index, 1))
+			f = g.createFuncs[session.proxyName]
+		}
+		session.expire = now.Add(g.stickyIpTTL)
+		g.ipSessions[ip] = session
+		g.ipMu.Unlock()
+	}
+	g.mu.RUnlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("no CreateConnFunc for http group [%s], domain [%s], location [%s]", group, domain, location)
+	}
+
+	return f(remoteAddr)
+}
+
+// purgeIpSessions deletes expired sessions at most once per stickyIpTTL.
+// g.ipMu should be held.
+func (g *HTTPGroup) purgeIpSessions(now time.Time) {
+	if now.Sub(g.lastPurge) < g.stickyIpTTL {
+		return
+	}
+	g.lastPurge = now
+	for ip, session := range g.ipSessions {
+		if !now.Before(session.expire) {
+			delete(g.ipSessions, ip)
+		}
+	}
+}
+
+// nextProxy returns the proxy at index in round robin, proxies skipping it in
+// slow start are passed over unless all of them do. g.mu should be held.
+func (g *HTTPGroup) nextProxy(index uint64) string {
+	for i := 0; i < len(g.pxyNames); i++ {
+		name := g.pxyNames[int((index+uint64(i))%uint64(len(g.pxyNames)))]
+		if g.slowStarts[name].accept() {
+			return name
+		}
+	}
+	return g.pxyNames[int(index%uint64(len(g.pxyNames)))]
+}
+
+// stickyId is the value of sticky cookie for proxyName, it doesn't expose
+// the proxy name to users.
+func stickyId(proxyName string) string {
+	h := fnv.New64a()
+	h.Write([]byte(proxyName))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
 func httpGroupIndex(group, domain, location string) string {
 	return fmt.Sprintf("%s_%s_%s", group, domain, location)
 }
@@ -0,0 +1,140 @@
+package group
+
+import (
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPGroupSticky(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewHTTPGroupController(vhost.NewVhostRouters())
+
+	var served string
+	register := func(name string) error {
+		return ctl.Register(name, "web", "key", 0, vhost.VhostRouteConfig{
+			Domain:       "example.com",
+			StickyCookie: "frp_sticky",
+			CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+				served = name
+				return nil, nil
+			},
+		})
+	}
+	assert.NoError(register("a"))
+	assert.NoError(register("b"))
+	assert.NoError(register("c"))
+	assert.Equal(ErrGroupParamsInvalid, ctl.Register("d", "web", "key", 0, vhost.VhostRouteConfig{Domain: "example.com"}))
+
+	g := ctl.groups[httpGroupIndex("web", "example.com", "")]
+	session := &vhost.StickySession{}
+	g.createStickyConn("", session)
+	first := served
+	assert.Equal(stickyId(first), session.Id)
+	for i := 0; i < 5; i++ {
+		g.createStickyConn("", session)
+		assert.Equal(first, served)
+	}
+
+	// users of the removed proxy are rehashed to the same remaining one
+	ctl.UnRegister(first, "web", "example.com", "")
+	oldId := session.Id
+	g.createStickyConn("", session)
+	second := served
+	assert.NotEqual(first, second)
+	assert.Equal(stickyId(second), session.Id)
+
+	other := &vhost.StickySession{Id: oldId}
+	g.createStickyConn("", other)
+	assert.Equal(second, served)
+}
+
+func TestHTTPGroupSlowStart(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewHTTPGroupController(vhost.NewVhostRouters())
+
+	counts := make(map[string]int)
+	register := func(name string, slowStart time.Duration) error {
+		return ctl.Register(name, "web", "key", slowStart, vhost.VhostRouteConfig{
+			Domain: "example.com",
+			CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+				counts[name]++
+				return nil, nil
+			},
+		})
+	}
+	dispatch := func() {
+		counts = make(map[string]int)
+		g := ctl.groups[httpGroupIndex("web", "example.com", "")]
+		for i := 0; i < 100; i++ {
+			g.createConn("")
+		}
+	}
+	assert.NoError(register("a", 0))
+	assert.NoError(register("b", time.Minute))
+
+	// b has just joined and skips requests
+	dispatch()
+	assert.Equal(100, counts["a"])
+
+	// b has warmed up
+	g := ctl.groups[httpGroupIndex("web", "example.com", "")]
+	g.slowStarts["b"] = slowStart{joinTime: time.Now().Add(-time.Minute), duration: time.Minute}
+	dispatch()
+	assert.Equal(50, counts["a"])
+	assert.Equal(50, counts["b"])
+
+	// ramp starts again after b rejoins
+	ctl.UnRegister("b", "web", "example.com", "")
+	assert.NoError(register("b", time.Minute))
+	dispatch()
+	assert.Equal(100, counts["a"])
+}
+
+func TestHTTPGroupStickyIp(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewHTTPGroupController(vhost.NewVhostRouters())
+
+	var served string
+	register := func(name string, ttl time.Duration) error {
+		return ctl.Register(name, "web", "key", 0, vhost.VhostRouteConfig{
+			Domain:      "example.com",
+			StickyIpTTL: ttl,
+			CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+				served = name
+				return nil, nil
+			},
+		})
+	}
+	assert.NoError(register("a", time.Minute))
+	assert.NoError(register("b", time.Minute))
+	assert.Equal(ErrGroupParamsInvalid, register("c", time.Hour))
+
+	g := ctl.groups[httpGroupIndex("web", "example.com", "")]
+	g.createIpStickyConn("1.1.1.1:1000")
+	first := served
+	for i := 0; i < 5; i++ {
+		g.createIpStickyConn("1.1.1.1:2000")
+		assert.Equal(first, served)
+	}
+	// other ips are still dispatched by turns
+	g.createIpStickyConn("2.2.2.2:1000")
+	assert.NotEqual(first, served)
+
+	// expired session is dispatched by turns again
+	g.ipSessions["1.1.1.1"] = ipSession{proxyName: first, expire: time.Now()}
+	index := g.index
+	g.createIpStickyConn("1.1.1.1:1000")
+	assert.Equal(index+1, g.index)
+	assert.True(g.ipSessions["1.1.1.1"].expire.After(time.Now()))
+
+	// the proxy has left the group
+	ctl.UnRegister(first, "web", "example.com", "")
+	g.createIpStickyConn("1.1.1.1:1000")
+	assert.NotEqual(first, served)
+	assert.Equal(served, g.ipSessions["1.1.1.1"].proxyName)
+}
@@ -15,15 +15,20 @@
 package group
 
 import (
-	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fatedier/frp/server/ports"
-
-	gerr "github.com/fatedier/golib/errors"
 )
 
+// TcpGroupHandler serves a user connection of the group, it returns false if
+// the proxy can't serve it now, e.g. frpc doesn't provide work connections,
+// then the connection is dispatched to the next proxy in the group.
+type TcpGroupHandler func(c net.Conn) bool
+
 // TcpGroupCtl manage all TcpGroups
 type TcpGroupCtl struct {
 	groups map[string]*TcpGroup
@@ -44,7 +49,7 @@ func NewTcpGroupCtl(portManager *ports.PortManager) *TcpGroupCtl {
 // Listen is the wrapper for TcpGroup's Listen
 // If there are no group, we will create one here
 func (tgc *TcpGroupCtl) Listen(proxyName string, group string, groupKey string,
-	addr string, port int) (l net.Listener, realPort int, err error) {
+	addr string, port int, slowStart time.Duration, handler TcpGroupHandler) (l net.Listener, realPort int, err error) {
 
 	tgc.mu.Lock()
 	tcpGroup, ok := tgc.groups[group]
@@ -54,7 +59,7 @@ func (tgc *TcpGroupCtl) Listen(proxyName string, group string, groupKey string,
 	}
 	tgc.mu.Unlock()
 
-	return tcpGroup.Listen(proxyName, group, groupKey, addr, port)
+	return tcpGroup.Listen(proxyName, group, groupKey, addr, port, slowStart, handler)
 }
 
 // RemoveGroup remove TcpGroup from controller
@@ -64,7 +69,7 @@ func (tgc *TcpGroupCtl) RemoveGroup(group string) {
 	delete(tgc.groups, group)
 }
 
-// TcpGroup route connections to different proxies
+// TcpGroup route connections to different proxies in round robin
 type TcpGroup struct {
 	group    string
 	groupKey string
@@ -72,27 +77,28 @@ type TcpGroup struct {
 	port     int
 	realPort int
 
-	acceptCh chan net.Conn
-	index    uint64
-	tcpLn    net.Listener
-	lns      []*TcpGroupListener
-	ctl      *TcpGroupCtl
-	mu       sync.Mutex
+	index uint64
+	tcpLn net.Listener
+	lns   []*TcpGroupListener
+	ctl   *TcpGroupCtl
+	mu    sync.Mutex
 }
 
 // NewTcpGroup return a new TcpGroup
 func NewTcpGroup(ctl *TcpGroupCtl) *TcpGroup {
 	return &TcpGroup{
-		lns:      make([]*TcpGroupListener, 0),
-		ctl:      ctl,
-		acceptCh: make(chan net.Conn),
+		lns: make([]*TcpGroupListener, 0),
+		ctl: ctl,
 	}
 }
 
-// Listen will return a new TcpGroupListener
+// Listen will return a new TcpGroupListener, connections dispatched to it are
+// served by handler. Its share of connections ramps up in slowStart if it's
+// greater than 0.
 // if TcpGroup already has a listener, just add a new TcpGroupListener to the queues
 // otherwise, listen on the real address
-func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr string, port int) (ln *TcpGroupListener, realPort int, err error) {
+func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr string, port int,
+	slowStart time.Duration, handler TcpGroupHandler) (ln *TcpGroupListener, realPort int, err error) {
 	tg.mu.Lock()
 	defer tg.mu.Unlock()
 	if len(tg.lns) == 0 {
@@ -101,12 +107,13 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 		if err != nil {
 			return
 		}
-		tcpLn, errRet := net.Listen("tcp", fmt.Sprintf("%s:%d", addr, port))
+		tcpLn, errRet := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(realPort)))
 		if errRet != nil {
+			tg.ctl.portManager.Release(realPort)
 			err = errRet
 			return
 		}
-		ln = newTcpGroupListener(group, tg, tcpLn.Addr())
+		ln = newTcpGroupListener(group, tg, tcpLn.Addr(), slowStart, handler)
 
 		tg.group = group
 		tg.groupKey = groupKey
@@ -115,9 +122,6 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 		tg.realPort = realPort
 		tg.tcpLn = tcpLn
 		tg.lns = append(tg.lns, ln)
-		if tg.acceptCh == nil {
-			tg.acceptCh = make(chan net.Conn)
-		}
 		go tg.worker()
 	} else {
 		// address and port in the same group must be equal
@@ -133,7 +137,7 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = ErrGroupAuthFailed
 			return
 		}
-		ln = newTcpGroupListener(group, tg, tg.lns[0].Addr())
+		ln = newTcpGroupListener(group, tg, tg.lns[0].Addr(), slowStart, handler)
 		realPort = tg.realPort
 		tg.lns = append(tg.lns, ln)
 	}
@@ -147,17 +151,39 @@ func (tg *TcpGroup) worker() {
 		if err != nil {
 			return
 		}
-		err = gerr.PanicToError(func() {
-			tg.acceptCh <- c
-		})
-		if err != nil {
-			return
-		}
+		go tg.dispatch(c)
 	}
 }
 
-func (tg *TcpGroup) Accept() <-chan net.Conn {
-	return tg.acceptCh
+// dispatch passes c to proxies in round robin until one of them serves it,
+// c is closed if none of them can. Proxies skipping c in slow start are tried
+// at last.
+func (tg *TcpGroup) dispatch(c net.Conn) {
+	tg.mu.Lock()
+	lns := make([]*TcpGroupListener, len(tg.lns))
+	copy(lns, tg.lns)
+	tg.mu.Unlock()
+
+	if len(lns) > 0 {
+		start := atomic.AddUint64(&tg.index, 1)
+		var skipped []*TcpGroupListener
+		for i := 0; i < len(lns); i++ {
+			ln := lns[(start+uint64(i))%uint64(len(lns))]
+			if !ln.slowStart.accept() {
+				skipped = append(skipped, ln)
+				continue
+			}
+			if ln.handler(c) {
+				return
+			}
+		}
+		for _, ln := range skipped {
+			if ln.handler(c) {
+				return
+			}
+		}
+	}
+	c.Close()
 }
 
 // CloseListener remove the TcpGroupListener from the TcpGroup
@@ -171,43 +197,40 @@ func (tg *TcpGroup) CloseListener(ln *TcpGroupListener) {
 		}
 	}
 	if len(tg.lns) == 0 {
-		close(tg.acceptCh)
 		tg.tcpLn.Close()
 		tg.ctl.portManager.Release(tg.realPort)
 		tg.ctl.RemoveGroup(tg.group)
 	}
 }
 
-// TcpGroupListener
+// TcpGroupListener is the membership of a proxy in TcpGroup, connections are
+// passed to its handler instead of being accepted.
 type TcpGroupListener struct {
 	groupName string
 	group     *TcpGroup
+	handler   TcpGroupHandler
+	slowStart slowStart
 
 	addr    net.Addr
 	closeCh chan struct{}
 }
 
-func newTcpGroupListener(name string, group *TcpGroup, addr net.Addr) *TcpGroupListener {
+func newTcpGroupListener(name string, group *TcpGroup, addr net.Addr, slowStart time.Duration,
+	handler TcpGroupHandler) *TcpGroupListener {
 	return &TcpGroupListener{
 		groupName: name,
 		group:     group,
+		handler:   handler,
+		slowStart: newSlowStart(slowStart),
 		addr:      addr,
 		closeCh:   make(chan struct{}),
 	}
 }
 
-// Accept will accept connections from TcpGroup
+// Accept blocks until the listener is closed, connections are served by handler.
 func (ln *TcpGroupListener) Accept() (c net.Conn, err error) {
-	var ok bool
-	select {
-	case <-ln.closeCh:
-		return nil, ErrListenerClosed
-	case c, ok = <-ln.group.Accept():
-		if !ok {
-			return nil, ErrListenerClosed
-		}
-		return c, nil
-	}
+	<-ln.closeCh
+	return nil, ErrListenerClosed
 }
 
 func (ln *TcpGroupListener) Addr() net.Addr {
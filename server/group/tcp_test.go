@@ -0,0 +1,66 @@
+package group
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/server/ports"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTcpGroupDispatch(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewTcpGroupCtl(ports.NewPortManager("tcp", "127.0.0.1", map[int]struct{}{}))
+
+	served := make(chan string, 16)
+	newHandler := func(name string, ok bool) TcpGroupHandler {
+		return func(c net.Conn) bool {
+			if !ok {
+				return false
+			}
+			served <- name
+			c.Close()
+			return true
+		}
+	}
+
+	ln1, _, err := ctl.Listen("a", "test", "key", "127.0.0.1", 0, 0, newHandler("a", true))
+	if !assert.NoError(err) {
+		return
+	}
+	defer ln1.Close()
+	ln2, _, err := ctl.Listen("b", "test", "key", "127.0.0.1", 0, 0, newHandler("b", false))
+	if !assert.NoError(err) {
+		return
+	}
+	defer ln2.Close()
+	ln3, _, err := ctl.Listen("c", "test", "key", "127.0.0.1", 0, 0, newHandler("c", true))
+	if !assert.NoError(err) {
+		return
+	}
+	defer ln3.Close()
+
+	_, _, err = ctl.Listen("d", "test", "wrong", "127.0.0.1", 0, 0, newHandler("d", true))
+	assert.Equal(ErrGroupAuthFailed, err)
+
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		c, err := net.Dial("tcp", ln1.Addr().String())
+		if !assert.NoError(err) {
+			return
+		}
+		select {
+		case name := <-served:
+			counts[name]++
+		case <-time.After(time.Second):
+			assert.Fail("user connection is not served")
+		}
+		c.Close()
+	}
+	// connections dispatched to b are passed to the next proxy
+	assert.Equal(0, counts["b"])
+	assert.Equal(6, counts["a"]+counts["c"])
+	assert.True(counts["a"] >= 2 && counts["c"] >= 2)
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+const (
+	ComponentStarting = "starting"
+	ComponentRunning  = "running"
+	ComponentStopped  = "stopped"
+)
+
+// components records status of goroutines serving frps, e.g. listeners
+// accepting clients and the nat hole controller.
+type components struct {
+	status map[string]string
+	mu     sync.RWMutex
+}
+
+func newComponents() *components {
+	return &components{
+		status: make(map[string]string),
+	}
+}
+
+func (c *components) set(name string, status string) {
+	c.mu.Lock()
+	c.status[name] = status
+	c.mu.Unlock()
+}
+
+// get returns a copy of all status, alive is false if any component has
+// stopped, ready is true only if all of them are running.
+func (c *components) get() (status map[string]string, alive bool, ready bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status = make(map[string]string, len(c.status))
+	alive, ready = true, true
+	for name, s := range c.status {
+		status[name] = s
+		if s == ComponentStopped {
+			alive = false
+		}
+		if s != ComponentRunning {
+			ready = false
+		}
+	}
+	return
+}
+
+// runComponent runs fn in a new goroutine named name, it's recorded as stopped
+// after fn returns. It's only recorded as running when fn starts serving, e.g.
+// calls Accept of a listener wrapped by servingListener.
+func (svr *Service) runComponent(name string, fn func()) {
+	svr.components.set(name, ComponentStarting)
+	go func() {
+		fn()
+		svr.components.set(name, ComponentStopped)
+	}()
+}
+
+// servingListener records component name as running before the first Accept,
+// then the component is waiting for connections on the listening port.
+type servingListener struct {
+	net.Listener
+	name       string
+	components *components
+	once       sync.Once
+}
+
+func (svr *Service) newServingListener(name string, l net.Listener) net.Listener {
+	return &servingListener{
+		Listener:   l,
+		name:       name,
+		components: svr.components,
+	}
+}
+
+func (l *servingListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { l.components.set(l.name, ComponentRunning) })
+	return l.Listener.Accept()
+}
+
+// servingFrpListener is the same as servingListener for frpNet.Listener.
+type servingFrpListener struct {
+	frpNet.Listener
+	name       string
+	components *components
+	once       sync.Once
+}
+
+func (svr *Service) newServingFrpListener(name string, l frpNet.Listener) frpNet.Listener {
+	return &servingFrpListener{
+		Listener:   l,
+		name:       name,
+		components: svr.components,
+	}
+}
+
+func (l *servingFrpListener) Accept() (frpNet.Conn, error) {
+	l.once.Do(func() { l.components.set(l.name, ComponentRunning) })
+	return l.Listener.Accept()
+}
+
+type HealthResp struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// healthz returns 200 if no goroutine serving frps has exited. Listeners are
+// closed on purpose when shutting down, frps is still alive then.
+func (svr *Service) ApiHealthz(w http.ResponseWriter, r *http.Request) {
+	status, alive, _ := svr.components.get()
+	alive = alive || atomic.LoadUint32(&svr.shuttingDown) == 1
+	writeHealth(w, alive, status)
+}
+
+// readyz returns 200 once all listeners and the nat hole controller are
+// running, and frps is not shutting down.
+func (svr *Service) ApiReadyz(w http.ResponseWriter, r *http.Request) {
+	status, _, ready := svr.components.get()
+	ready = ready && atomic.LoadUint32(&svr.running) == 1 && atomic.LoadUint32(&svr.shuttingDown) == 0
+	writeHealth(w, ready, status)
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, status map[string]string) {
+	resp := HealthResp{
+		Status:     "ok",
+		Components: status,
+	}
+	code := http.StatusOK
+	if !ok {
+		resp.Status = "fail"
+		code = http.StatusServiceUnavailable
+	}
+	buf, _ := json.Marshal(&resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf)
+}
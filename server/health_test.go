@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getHealth returns the status code and response of the health api handler.
+func getHealth(handler http.HandlerFunc) (code int, resp HealthResp) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	assert := assert.New(t)
+	svr, restore := newTestService(t, "127.0.0.1", nil)
+	defer restore()
+
+	// listeners for clients are not serving before Run
+	code, _ := getHealth(svr.ApiHealthz)
+	assert.Equal(http.StatusOK, code)
+	code, resp := getHealth(svr.ApiReadyz)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("fail", resp.Status)
+
+	go svr.Run()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if code, resp = getHealth(svr.ApiReadyz); code == http.StatusOK {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !assert.Equal(http.StatusOK, code) {
+		return
+	}
+	assert.Equal("ok", resp.Status)
+	for _, name := range []string{"muxer", "vhost_http", "listener"} {
+		assert.Equal(ComponentRunning, resp.Components[name], name)
+	}
+	code, _ = getHealth(svr.ApiHealthz)
+	assert.Equal(http.StatusOK, code)
+
+	// not ready but still alive when listeners are closed for shutting down
+	svr.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	code, resp = getHealth(svr.ApiReadyz)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal(ComponentStopped, resp.Components["muxer"])
+	code, resp = getHealth(svr.ApiHealthz)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("ok", resp.Status)
+}
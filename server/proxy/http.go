@@ -18,7 +18,9 @@ import (
 	"io"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/fatedier/frp/extend/limit"
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/server/stats"
@@ -38,11 +40,21 @@ type HttpProxy struct {
 
 func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 	routeConfig := vhost.VhostRouteConfig{
-		RewriteHost:  pxy.cfg.HostHeaderRewrite,
-		Headers:      pxy.cfg.Headers,
-		Username:     pxy.cfg.HttpUser,
-		Password:     pxy.cfg.HttpPwd,
-		CreateConnFn: pxy.GetRealConn,
+		RewriteHost:         pxy.cfg.HostHeaderRewrite,
+		Headers:             pxy.cfg.Headers,
+		Username:            pxy.cfg.HttpUser,
+		Password:            pxy.cfg.HttpPwd,
+		AuthRealm:           pxy.cfg.HttpAuthRealm,
+		AuthExemptPaths:     pxy.cfg.HttpAuthExemptPaths,
+		ResponseGzip:        pxy.cfg.HttpResponseGzip,
+		SetForwardedHeaders: pxy.cfg.HttpSetForwardedHeaders,
+		Websocket:           pxy.cfg.Websocket,
+		Http2Backend:        pxy.cfg.Http2Backend,
+		Custom503Page:       pxy.cfg.Custom503Page,
+		ResponseTimeFn:      pxy.markResponseTime,
+		StickyCookie:        pxy.cfg.GroupStickyCookie,
+		StickyIpTTL:         time.Duration(pxy.cfg.GroupStickyTTLS) * time.Second,
+		CreateConnFn:        pxy.GetRealConn,
 	}
 
 	locations := pxy.cfg.Locations
@@ -63,14 +75,23 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 		}
 
 		routeConfig.Domain = domain
-		for _, location := range locations {
+		domainLocations := locations
+		if dl, ok := pxy.cfg.DomainLocations[strings.ToLower(domain)]; ok && len(dl) > 0 {
+			domainLocations = dl
+		}
+		for _, location := range domainLocations {
 			routeConfig.Location = location
+			routeConfig.RewriteLocation = location
+			if newLocation, ok := pxy.cfg.LocationRewrite[location]; ok {
+				routeConfig.RewriteLocation = newLocation
+			}
 			tmpDomain := routeConfig.Domain
 			tmpLocation := routeConfig.Location
 
 			// handle group
 			if pxy.cfg.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey,
+					time.Duration(pxy.cfg.GroupSlowStartS)*time.Second, routeConfig)
 				if err != nil {
 					return
 				}
@@ -97,12 +118,17 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 		routeConfig.Domain = pxy.cfg.SubDomain + "." + g.GlbServerCfg.SubDomainHost
 		for _, location := range locations {
 			routeConfig.Location = location
+			routeConfig.RewriteLocation = location
+			if newLocation, ok := pxy.cfg.LocationRewrite[location]; ok {
+				routeConfig.RewriteLocation = newLocation
+			}
 			tmpDomain := routeConfig.Domain
 			tmpLocation := routeConfig.Location
 
 			// handle group
 			if pxy.cfg.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey,
+					time.Duration(pxy.cfg.GroupSlowStartS)*time.Second, routeConfig)
 				if err != nil {
 					return
 				}
@@ -154,9 +180,10 @@ func (pxy *HttpProxy) GetRealConn(remoteAddr string) (workConn frpNet.Conn, err
 		}
 	}
 	if pxy.cfg.UseCompression {
-		rwc = frpIo.WithCompression(rwc)
+		rwc = frpNet.WithCompression(rwc, pxy.cfg.CompressionAlgorithm)
 	}
 	workConn = frpNet.WrapReadWriteCloserToConn(rwc, tmpConn)
+	workConn = limit.NewSharedLimitConn(pxy.rc.BandwidthLimiter, workConn)
 	workConn = frpNet.WrapStatsConn(workConn, pxy.updateStatsAfterClosedConn)
 	pxy.statsCollector.Mark(stats.TypeOpenConnection, &stats.OpenConnectionPayload{ProxyName: pxy.GetName()})
 	return
@@ -173,6 +200,14 @@ func (pxy *HttpProxy) updateStatsAfterClosedConn(totalRead, totalWrite int64) {
 		ProxyName:    name,
 		TrafficBytes: totalRead,
 	})
+	pxy.addTraffic(totalRead + totalWrite)
+}
+
+func (pxy *HttpProxy) markResponseTime(d time.Duration) {
+	pxy.statsCollector.Mark(stats.TypeHttpResponseTime, &stats.HttpResponseTimePayload{
+		ProxyName:    pxy.GetName(),
+		ResponseTime: d,
+	})
 }
 
 func (pxy *HttpProxy) Close() {
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/stats"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpProxyRunWithIPv6Domain(t *testing.T) {
+	assert := assert.New(t)
+	g.GlbServerCfg.VhostHttpPort = 8080
+
+	routers := vhost.NewVhostRouters()
+	rc := &controller.ResourceController{
+		HttpReverseProxy: vhost.NewHttpReverseProxy(vhost.HttpReverseProxyOptions{}, routers),
+	}
+
+	cfg := &config.HttpProxyConf{}
+	cfg.ProxyName = "ipv6"
+	cfg.ProxyType = "http"
+	cfg.CustomDomains = []string{"[2001:db8::1]", "2001:db8::2"}
+	cfg.Locations = []string{""}
+
+	pxy, err := NewProxy("test", rc, stats.NewInternalCollector(false), 0, nil, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	remoteAddr, err := pxy.Run()
+	if !assert.NoError(err) {
+		return
+	}
+	defer pxy.Close()
+
+	assert.Equal("[2001:db8::1]:8080,[2001:db8::2]:8080", remoteAddr)
+
+	_, ok := routers.Get("2001:db8::1", "/")
+	assert.True(ok)
+	_, ok = routers.Get("[2001:db8::1]", "/")
+	assert.True(ok)
+	_, ok = routers.Get("2001:db8::2", "/")
+	assert.True(ok)
+	_, ok = routers.Get("2001:db8::3", "/")
+	assert.False(ok)
+}
@@ -15,10 +15,14 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"strings"
 
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/utils/log"
 	"github.com/fatedier/frp/utils/util"
 	"github.com/fatedier/frp/utils/vhost"
 )
@@ -26,10 +30,38 @@ import (
 type HttpsProxy struct {
 	*BaseProxy
 	cfg *config.HttpsProxyConf
+
+	// domains added to AcmeManager
+	acmeDomains []string
+}
+
+// tlsConfig returns nil if tls is passed through to frpc.
+func (pxy *HttpsProxy) tlsConfig() (tlsConfig *tls.Config, err error) {
+	if pxy.rc.AcmeManager != nil {
+		tlsConfig = pxy.rc.AcmeManager.TlsConfig()
+	} else if pxy.cfg.VerifyClientCert {
+		if pxy.rc.VhostHttpsCertificate == nil {
+			err = fmt.Errorf("vhost https tls certificate is not set")
+			return
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{*pxy.rc.VhostHttpsCertificate},
+		}
+	}
+	if pxy.cfg.VerifyClientCert {
+		clientCAs := x509.NewCertPool()
+		clientCAs.AppendCertsFromPEM([]byte(pxy.cfg.ClientCa))
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
 }
 
 func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
 	routeConfig := &vhost.VhostRouteConfig{}
+	if routeConfig.TlsConfig, err = pxy.tlsConfig(); err != nil {
+		return
+	}
 
 	defer func() {
 		if err != nil {
@@ -48,9 +80,10 @@ func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
 			err = errRet
 			return
 		}
-		l.AddLogPrefix(pxy.name)
+		l.AddKeyLogPrefix(log.ProxyKey, pxy.name)
 		pxy.Info("https proxy listen for host [%s]", routeConfig.Domain)
 		pxy.listeners = append(pxy.listeners, l)
+		pxy.addAcmeDomain(routeConfig.Domain)
 		addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, g.GlbServerCfg.VhostHttpsPort))
 	}
 
@@ -61,9 +94,10 @@ func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
 			err = errRet
 			return
 		}
-		l.AddLogPrefix(pxy.name)
+		l.AddKeyLogPrefix(log.ProxyKey, pxy.name)
 		pxy.Info("https proxy listen for host [%s]", routeConfig.Domain)
 		pxy.listeners = append(pxy.listeners, l)
+		pxy.addAcmeDomain(routeConfig.Domain)
 		addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, int(g.GlbServerCfg.VhostHttpsPort)))
 	}
 
@@ -76,6 +110,17 @@ func (pxy *HttpsProxy) GetConf() config.ProxyConf {
 	return pxy.cfg
 }
 
+func (pxy *HttpsProxy) addAcmeDomain(domain string) {
+	if pxy.rc.AcmeManager != nil {
+		pxy.rc.AcmeManager.AddDomain(domain)
+		pxy.acmeDomains = append(pxy.acmeDomains, domain)
+	}
+}
+
 func (pxy *HttpsProxy) Close() {
 	pxy.BaseProxy.Close()
+	for _, domain := range pxy.acmeDomains {
+		pxy.rc.AcmeManager.RemoveDomain(domain)
+	}
+	pxy.acmeDomains = nil
 }
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/stats"
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCert returns a certificate signed by parent, or a self-signed CA if
+// parent is nil.
+func newTestCert(t *testing.T, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	usage x509.ExtKeyUsage) (*x509.Certificate, tls.Certificate) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestHttpsProxyVerifyClientCert(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+	_, serverCert := newTestCert(t, 1, nil, nil, x509.ExtKeyUsageServerAuth)
+	ca, caCert := newTestCert(t, 2, nil, nil, x509.ExtKeyUsageClientAuth)
+	_, clientCert := newTestCert(t, 3, ca, caCert.PrivateKey.(*ecdsa.PrivateKey), x509.ExtKeyUsageClientAuth)
+	_, untrustedCert := newTestCert(t, 4, nil, nil, x509.ExtKeyUsageClientAuth)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	muxer, err := vhost.NewHttpsMuxer(frpNet.WrapLogListener(l), 2*time.Second)
+	if !assert.NoError(err) {
+		return
+	}
+	defer muxer.Close()
+	rc := &controller.ResourceController{
+		VhostHttpsMuxer:       muxer,
+		VhostHttpsCertificate: &serverCert,
+	}
+
+	cfg := &config.HttpsProxyConf{}
+	cfg.ProxyName = "https"
+	cfg.ProxyType = "https"
+	cfg.CustomDomains = []string{"example.com"}
+	cfg.VerifyClientCert = true
+	cfg.ClientCa = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	// user connections passing the handshake ask for work connections
+	acceptCh := make(chan struct{}, 10)
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		acceptCh <- struct{}{}
+		return nil, false, fmt.Errorf("no work connection")
+	}
+	pxy, err := NewProxy("test", rc, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "https", ProxyType: "https"})
+	if _, err = pxy.Run(); !assert.NoError(err) {
+		return
+	}
+	defer pxy.Close()
+
+	tests := []struct {
+		name     string
+		certs    []tls.Certificate
+		accepted bool
+	}{
+		{"no certificate", nil, false},
+		{"untrusted certificate", []tls.Certificate{untrustedCert}, false},
+		{"certificate signed by client_ca", []tls.Certificate{clientCert}, true},
+	}
+	for _, test := range tests {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(err) {
+			return
+		}
+		tlsConn := tls.Client(c, &tls.Config{
+			ServerName:         "example.com",
+			InsecureSkipVerify: true,
+			Certificates:       test.certs,
+		})
+		// with tls 1.3 the client finishes its handshake before frps checks
+		// the certificate, so it's judged by whether the connection is accepted
+		go tlsConn.Handshake()
+
+		select {
+		case <-acceptCh:
+			assert.True(test.accepted, "%s is accepted", test.name)
+		case <-time.After(500 * time.Millisecond):
+			assert.False(test.accepted, "%s is not accepted", test.name)
+		}
+		tlsConn.Close()
+	}
+}
+
+func TestHttpsProxyTlsConfig(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frps_acme")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	ca, _ := newTestCert(t, 1, nil, nil, x509.ExtKeyUsageClientAuth)
+	rc := &controller.ResourceController{
+		AcmeManager: controller.NewAcmeManager("admin@example.com", dir, ""),
+	}
+	newPxy := func(verifyClientCert bool) *HttpsProxy {
+		cfg := &config.HttpsProxyConf{}
+		cfg.VerifyClientCert = verifyClientCert
+		cfg.ClientCa = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+		return &HttpsProxy{BaseProxy: &BaseProxy{rc: rc}, cfg: cfg}
+	}
+
+	// certificates come from the ACME CA, client certificates are verified too
+	tlsConfig, err := newPxy(true).tlsConfig()
+	if assert.NoError(err) && assert.NotNil(tlsConfig) {
+		assert.NotNil(tlsConfig.GetCertificate)
+		assert.Equal(tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+		assert.NotNil(tlsConfig.ClientCAs)
+	}
+	tlsConfig, err = newPxy(false).tlsConfig()
+	if assert.NoError(err) && assert.NotNil(tlsConfig) {
+		assert.Equal(tls.NoClientCert, tlsConfig.ClientAuth)
+	}
+
+	// without ACME, tls is passed through unless client certificates are verified
+	rc.AcmeManager = nil
+	tlsConfig, err = newPxy(false).tlsConfig()
+	assert.NoError(err)
+	assert.Nil(tlsConfig)
+	_, err = newPxy(true).tlsConfig()
+	assert.Error(err)
+}
@@ -15,14 +15,17 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/extend/cumu"
+	"github.com/fatedier/frp/extend/limit"
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
@@ -30,11 +33,32 @@ import (
 	"github.com/fatedier/frp/server/stats"
 	"github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/util"
 
 	frpIo "github.com/fatedier/golib/io"
+	"golang.org/x/time/rate"
 )
 
-type GetWorkConnFn func() (frpNet.Conn, error)
+// GetWorkConnFn returns an error if no work connection is available before
+// ctx is done. fromPool is true if the work connection was already in the pool
+// without waiting for frpc.
+type GetWorkConnFn func(ctx context.Context) (workConn frpNet.Conn, fromPool bool, err error)
+
+// number of user connections joined with work connections now
+var activeUserConns int64
+
+// WaitUserConns blocks until all joined user connections are closed,
+// it returns false if they are still active when ctx is done.
+func WaitUserConns(ctx context.Context) bool {
+	for atomic.LoadInt64(&activeUserConns) > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return true
+}
 
 type Proxy interface {
 	Run() (remoteAddr string, err error)
@@ -42,7 +66,13 @@ type Proxy interface {
 	GetConf() config.ProxyConf
 	GetWorkConnFromPool(src, dst net.Addr) (workConn frpNet.Conn, err error)
 	GetUsedPortsNum() int
+	// CloseListeners stops accepting user connections, joined ones are kept
+	// until the proxy is closed.
+	CloseListeners()
 	Close()
+	// Drain closes user connections which are still active after timeout, it's
+	// called after Close.
+	Drain(timeout time.Duration)
 	log.Logger
 }
 
@@ -51,9 +81,26 @@ type BaseProxy struct {
 	rc             *controller.ResourceController
 	statsCollector stats.Collector
 	listeners      []frpNet.Listener
+	listenersMu    sync.Mutex
 	usedPortsNum   int
 	poolCount      int
+	compression    string
+	keepAlive      time.Duration
 	getWorkConnFn  GetWorkConnFn
+	trafficQuota   int64
+
+	// done after the proxy is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// limits the accepting rate of user connections, nil means no limit
+	connLimiter    *rate.Limiter
+	rejectOverRate bool
+
+	// joined user connections, new ones are closed at once after drained
+	userConns   map[io.Closer]struct{}
+	drained     bool
+	userConnsMu sync.Mutex
 
 	mu sync.RWMutex
 	log.Logger
@@ -67,24 +114,115 @@ func (pxy *BaseProxy) GetUsedPortsNum() int {
 	return pxy.usedPortsNum
 }
 
+// Context is done after the proxy is closed.
+func (pxy *BaseProxy) Context() context.Context {
+	return pxy.ctx
+}
+
+func (pxy *BaseProxy) CloseListeners() {
+	pxy.listenersMu.Lock()
+	listeners := pxy.listeners
+	pxy.listeners = nil
+	pxy.listenersMu.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
 func (pxy *BaseProxy) Close() {
 	pxy.Info("proxy closing")
-	for _, l := range pxy.listeners {
-		l.Close()
+	pxy.cancel()
+	pxy.CloseListeners()
+}
+
+func (pxy *BaseProxy) Drain(timeout time.Duration) {
+	time.AfterFunc(timeout, func() {
+		pxy.userConnsMu.Lock()
+		conns := pxy.userConns
+		pxy.userConns = nil
+		pxy.drained = true
+		pxy.userConnsMu.Unlock()
+		if len(conns) > 0 {
+			pxy.Info("close %d user connections still active after draining", len(conns))
+		}
+		for c := range conns {
+			c.Close()
+		}
+	})
+}
+
+// addTraffic counts bytes of user connections toward traffic_quota of the
+// proxy.
+func (pxy *BaseProxy) addTraffic(bytes int64) {
+	if pxy.trafficQuota > 0 && pxy.rc != nil && pxy.rc.TrafficQuotaManager != nil {
+		pxy.rc.TrafficQuotaManager.Add(pxy.name, pxy.trafficQuota, bytes)
+	}
+}
+
+// trackUserConn records c until the returned function is called, so it can
+// be closed by Drain.
+func (pxy *BaseProxy) trackUserConn(c io.Closer) (untrack func()) {
+	pxy.userConnsMu.Lock()
+	defer pxy.userConnsMu.Unlock()
+	if pxy.drained {
+		c.Close()
+		return func() {}
+	}
+	if pxy.userConns == nil {
+		pxy.userConns = make(map[io.Closer]struct{})
+	}
+	pxy.userConns[c] = struct{}{}
+	return func() {
+		pxy.userConnsMu.Lock()
+		defer pxy.userConnsMu.Unlock()
+		delete(pxy.userConns, c)
 	}
 }
 
 // GetWorkConnFromPool try to get a new work connections from pool
 // for quickly response, we immediately send the StartWorkConn message to frpc after take out one from pool
 func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn frpNet.Conn, err error) {
+	return pxy.getWorkConnFromPool(src, dst, false)
+}
+
+// getWorkConnFromPool sets Relayed of the StartWorkConn message to relayed.
+func (pxy *BaseProxy) getWorkConnFromPool(src, dst net.Addr, relayed bool) (workConn frpNet.Conn, err error) {
+	var (
+		retries  int64
+		fromPool bool
+	)
+	// frpc should provide a work connection in user_conn_timeout, and waiting
+	// stops at once if the proxy is closed
+	ctx, cancel := context.WithTimeout(pxy.Context(), time.Duration(g.GlbServerCfg.UserConnTimeout)*time.Second)
+	defer cancel()
+	defer func() {
+		pxy.statsCollector.Mark(stats.TypeWorkConnPool, &stats.WorkConnPoolPayload{
+			ProxyName: pxy.GetName(),
+			Hit:       err == nil && retries == 0 && fromPool,
+			Retries:   retries,
+			Timeout:   err != nil && ctx.Err() == context.DeadlineExceeded,
+		})
+	}()
+
 	// try all connections from the pool
 	for i := 0; i < pxy.poolCount+1; i++ {
-		if workConn, err = pxy.getWorkConnFn(); err != nil {
-			pxy.Warn("failed to get work connection: %v", err)
+		if workConn, fromPool, err = pxy.getWorkConnFn(ctx); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				pxy.Error("no work connection in %ds, close the user connection", g.GlbServerCfg.UserConnTimeout)
+			} else {
+				pxy.Warn("failed to get work connection: %v", err)
+			}
 			return
 		}
-		pxy.Info("get a new work connection: [%s]", workConn.RemoteAddr().String())
-		workConn.AddLogPrefix(pxy.GetName())
+		// trace id is logged by both frps and frpc for this work connection,
+		// it's only empty if no random data can be read
+		traceId, _ := util.RandIdWithLen(4)
+		pxy.Info("get a new work connection: [%s], trace id [%s]", workConn.RemoteAddr().String(), traceId)
+		workConn.AddKeyLogPrefix(log.ProxyKey, pxy.GetName())
+		workConn.AddKeyLogPrefix(log.TraceKey, traceId)
+		if errRet := frpNet.SetTcpKeepAlive(workConn, pxy.keepAlive); errRet != nil {
+			workConn.Debug("set tcp keepalive error: %v", errRet)
+		}
 
 		var (
 			srcAddr    string
@@ -103,16 +241,21 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn frpNet.Co
 			dstAddr, dstPortStr, _ = net.SplitHostPort(dst.String())
 			dstPort, _ = strconv.Atoi(dstPortStr)
 		}
-		err := msg.WriteMsg(workConn, &msg.StartWorkConn{
+		err = msg.WriteMsg(workConn, &msg.StartWorkConn{
 			ProxyName: pxy.GetName(),
 			SrcAddr:   srcAddr,
 			SrcPort:   uint16(srcPort),
 			DstAddr:   dstAddr,
 			DstPort:   uint16(dstPort),
+			TraceId:   traceId,
+			Relayed:   relayed,
+
+			CompressionAlgorithm: pxy.compression,
 		})
 		if err != nil {
 			workConn.Warn("failed to send message to work connection from pool: %v, times: %d", err, i)
 			workConn.Close()
+			retries++
 		} else {
 			break
 		}
@@ -140,27 +283,82 @@ func (pxy *BaseProxy) startListenHandler(p Proxy, handler func(Proxy, frpNet.Con
 					return
 				}
 				pxy.Debug("get a user connection [%s]", c.RemoteAddr().String())
+				if !pxy.allowUserConn() {
+					pxy.Debug("reject user connection [%s] over max_conns_per_sec", c.RemoteAddr().String())
+					c.Close()
+					continue
+				}
+				if err = frpNet.SetTcpKeepAlive(c, pxy.keepAlive); err != nil {
+					pxy.Debug("set tcp keepalive error: %v", err)
+				}
+				c = limit.NewSharedLimitConn(pxy.rc.BandwidthLimiter, c)
 				go handler(p, c, pxy.statsCollector)
 			}
 		}(listener)
 	}
 }
 
+// allowUserConn applies max_conns_per_sec to a new user connection, it blocks
+// until the connection can be served in delay mode, and returns false if the
+// connection should be closed in reject mode.
+func (pxy *BaseProxy) allowUserConn() bool {
+	if pxy.connLimiter == nil {
+		return true
+	}
+	if !pxy.rejectOverRate {
+		pxy.connLimiter.Wait(context.Background())
+		return true
+	}
+	if pxy.connLimiter.Allow() {
+		return true
+	}
+	pxy.statsCollector.Mark(stats.TypeRejectConnection, &stats.RejectConnectionPayload{
+		ProxyName: pxy.GetName(),
+	})
+	return false
+}
+
 func NewProxy(runId string, rc *controller.ResourceController, statsCollector stats.Collector, poolCount int,
 	getWorkConnFn GetWorkConnFn, pxyConf config.ProxyConf) (pxy Proxy, err error) {
 
+	// pool_count of proxy has higher priority than the one of client
+	if pxyPoolCount := pxyConf.GetBaseInfo().PoolCount; pxyPoolCount > 0 {
+		poolCount = pxyPoolCount
+		if int64(poolCount) > g.GlbServerCfg.MaxPoolCount {
+			poolCount = int(g.GlbServerCfg.MaxPoolCount)
+		}
+	}
+
+	// fall back to the default compression algorithm if frpc asks for an unknown one,
+	// frpc will follow the algorithm in StartWorkConn message
+	baseInfo := pxyConf.GetBaseInfo()
+	if !frpNet.IsSupportedCompression(baseInfo.CompressionAlgorithm) {
+		baseInfo.CompressionAlgorithm = ""
+	}
+
 	basePxy := BaseProxy{
 		name:           pxyConf.GetBaseInfo().ProxyName,
 		rc:             rc,
 		statsCollector: statsCollector,
 		listeners:      make([]frpNet.Listener, 0),
 		poolCount:      poolCount,
+		compression:    baseInfo.CompressionAlgorithm,
+		keepAlive:      time.Duration(baseInfo.TcpKeepAlive) * time.Second,
 		getWorkConnFn:  getWorkConnFn,
-		Logger:         log.NewPrefixLogger(runId),
+		trafficQuota:   baseInfo.TrafficQuota,
+		Logger:         log.NewKeyPrefixLogger(log.RunIdKey, runId),
+	}
+	basePxy.ctx, basePxy.cancel = context.WithCancel(context.Background())
+	if baseInfo.MaxConnsPerSec > 0 {
+		basePxy.connLimiter = rate.NewLimiter(rate.Limit(baseInfo.MaxConnsPerSec), int(baseInfo.MaxConnsPerSec))
+		basePxy.rejectOverRate = baseInfo.MaxConnsPerSecMode == config.ConnsRateLimitModeReject
 	}
 	switch cfg := pxyConf.(type) {
 	case *config.TcpProxyConf:
 		basePxy.usedPortsNum = 1
+		if len(cfg.RemotePorts) > 0 {
+			basePxy.usedPortsNum = len(cfg.RemotePorts)
+		}
 		pxy = &TcpProxy{
 			BaseProxy: &basePxy,
 			cfg:       cfg,
@@ -191,15 +389,20 @@ func NewProxy(runId string, rc *controller.ResourceController, statsCollector st
 			BaseProxy: &basePxy,
 			cfg:       cfg,
 		}
+	case *config.TcpMuxProxyConf:
+		pxy = &TcpMuxProxy{
+			BaseProxy: &basePxy,
+			cfg:       cfg,
+		}
 	default:
 		return pxy, fmt.Errorf("proxy type not support")
 	}
-	pxy.AddLogPrefix(pxy.GetName())
+	pxy.AddKeyLogPrefix(log.ProxyKey, pxy.GetName())
 	return
 }
 
 // HandleUserTcpConnection is used for incoming tcp user connections.
-// It can be used for tcp, http, https type.
+// It can be used for tcp, http, https and tcpmux type.
 func HandleUserTcpConnection(pxy Proxy, userConn frpNet.Conn, statsCollector stats.Collector) {
 	defer userConn.Close()
 
@@ -208,48 +411,78 @@ func HandleUserTcpConnection(pxy Proxy, userConn frpNet.Conn, statsCollector sta
 	if err != nil {
 		return
 	}
+	joinUserConn(pxy, userConn, workConn, statsCollector)
+}
+
+// joinUserConn joins userConn with workConn until one of them is closed, both
+// of them are closed after it returns.
+func joinUserConn(pxy Proxy, userConn frpNet.Conn, workConn frpNet.Conn, statsCollector stats.Collector) {
+	defer userConn.Close()
 	defer workConn.Close()
 
+	var err error
+	atomic.AddInt64(&activeUserConns, 1)
+	defer atomic.AddInt64(&activeUserConns, -1)
+	if t, ok := pxy.(interface{ trackUserConn(io.Closer) func() }); ok {
+		defer t.trackUserConn(userConn)()
+	}
+
 	var local io.ReadWriteCloser = workConn
 	cfg := pxy.GetConf().GetBaseInfo()
 	if cfg.UseEncryption {
 		local, err = frpIo.WithEncryption(local, []byte(g.GlbServerCfg.Token))
 		if err != nil {
-			pxy.Error("create encryption stream error: %v", err)
+			workConn.Error("create encryption stream error: %v", err)
 			return
 		}
 	}
 	if cfg.UseCompression {
-		local = frpIo.WithCompression(local)
+		local = frpNet.WithCompression(local, cfg.CompressionAlgorithm)
 	}
-	pxy.Debug("join connections, workConn(l[%s] r[%s]) userConn(l[%s] r[%s])", workConn.LocalAddr().String(),
+	workConn.Debug("join connections, workConn(l[%s] r[%s]) userConn(l[%s] r[%s])", workConn.LocalAddr().String(),
 		workConn.RemoteAddr().String(), userConn.LocalAddr().String(), userConn.RemoteAddr().String())
 
 	statsCollector.Mark(stats.TypeOpenConnection, &stats.OpenConnectionPayload{ProxyName: pxy.GetName()})
+	startTime := time.Now()
 	cc := cumu.NewCumuConn(userConn)
+	quota, _ := pxy.(interface{ addTraffic(int64) })
+	markTraffic := func() {
+		in, out := cc.OutCount(), cc.InCount()
+		statsCollector.Mark(stats.TypeAddTrafficIn, &stats.AddTrafficInPayload{
+			ProxyName:    pxy.GetName(),
+			TrafficBytes: in,
+		})
+		statsCollector.Mark(stats.TypeAddTrafficOut, &stats.AddTrafficOutPayload{
+			ProxyName:    pxy.GetName(),
+			TrafficBytes: out,
+		})
+		if quota != nil {
+			quota.addTraffic(in + out)
+		}
+	}
 	endSig := make(chan int)
-	go func(cc *cumu.Conn, ch chan int) {
+	go func(ch chan int) {
 		for {
 			select {
 			case <-ch:
 				return
 			default:
 				time.Sleep(1 * time.Second)
-				statsCollector.Mark(stats.TypeAddTrafficIn, &stats.AddTrafficInPayload{
-					ProxyName:    pxy.GetName(),
-					TrafficBytes: cc.OutCount(),
-				})
-				statsCollector.Mark(stats.TypeAddTrafficOut, &stats.AddTrafficOutPayload{
-					ProxyName:    pxy.GetName(),
-					TrafficBytes: cc.InCount(),
-				})
+				markTraffic()
 			}
 		}
-	}(cc, endSig)
-	frpIo.Join(local, cc)
+	}(endSig)
+	inCount, outCount := frpNet.JoinWithIdleTimeout(local, cc, time.Duration(cfg.ProxyIdleTimeoutS)*time.Second, g.GlbServerCfg.TransportBufferSize)
 	statsCollector.Mark(stats.TypeCloseConnection, &stats.CloseConnectionPayload{ProxyName: pxy.GetName()})
 	endSig <- 1
-	pxy.Debug("join connections closed")
+	// bytes after the last mark
+	markTraffic()
+	workConn.Debug("join connections closed")
+	if g.GlbServerCfg.LogConnectionSummary {
+		// inCount is from user to frpc, outCount is from frpc to user
+		workConn.Info("connection summary: user [%s], duration [%s], bytes in [%d], bytes out [%d]",
+			userConn.RemoteAddr().String(), time.Since(startTime).Round(time.Millisecond), inCount, outCount)
+	}
 }
 
 type ProxyManager struct {
@@ -284,6 +517,15 @@ func (pm *ProxyManager) Del(name string) {
 	delete(pm.pxys, name)
 }
 
+// CloseListeners stops all proxies from accepting user connections.
+func (pm *ProxyManager) CloseListeners() {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	for _, pxy := range pm.pxys {
+		pxy.CloseListeners()
+	}
+}
+
 func (pm *ProxyManager) GetByName(name string) (pxy Proxy, ok bool) {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
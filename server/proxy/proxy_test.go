@@ -0,0 +1,453 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/consts"
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/ports"
+	"github.com/fatedier/frp/server/stats"
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxConnsPerSecReject(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	cfg.MaxConnsPerSec = 3
+	cfg.MaxConnsPerSecMode = config.ConnsRateLimitModeReject
+
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, nil, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+	basePxy := pxy.(*TcpProxy).BaseProxy
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if basePxy.allowUserConn() {
+			allowed++
+		}
+	}
+	assert.Equal(3, allowed)
+	assert.EqualValues(2, collector.GetProxiesByTypeAndName("tcp", "tcp").RejectedConns)
+}
+
+func TestDrain(t *testing.T) {
+	assert := assert.New(t)
+	pxy := &BaseProxy{Logger: log.NewPrefixLogger("")}
+
+	c1, p1 := net.Pipe()
+	c2, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	pxy.trackUserConn(c1)
+	untrack := pxy.trackUserConn(c2)
+	untrack()
+
+	pxy.Drain(50 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	// only tracked connections are closed
+	_, err := c1.Write([]byte("a"))
+	assert.Error(err)
+	go p2.Read(make([]byte, 1))
+	_, err = c2.Write([]byte("a"))
+	assert.NoError(err)
+
+	// connections joined after draining are closed at once
+	c3, p3 := net.Pipe()
+	defer p3.Close()
+	pxy.trackUserConn(c3)
+	_, err = c3.Write([]byte("a"))
+	assert.Error(err)
+}
+
+func TestGetWorkConnTimeout(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+	oldTimeout := g.GlbServerCfg.UserConnTimeout
+	g.GlbServerCfg.UserConnTimeout = 1
+	defer func() { g.GlbServerCfg.UserConnTimeout = oldTimeout }()
+
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	// frpc never provides work connections
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		<-ctx.Done()
+		return nil, false, ctx.Err()
+	}
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+	start := time.Now()
+	_, err = pxy.GetWorkConnFromPool(nil, nil)
+	assert.Error(err)
+	assert.True(time.Since(start) >= time.Second)
+	assert.EqualValues(1, collector.GetProxiesByTypeAndName("tcp", "tcp").PoolTimeouts)
+
+	// waiting stops once the proxy is closed
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pxy.(*TcpProxy).BaseProxy.Close()
+	}()
+	start = time.Now()
+	_, err = pxy.GetWorkConnFromPool(nil, nil)
+	assert.Error(err)
+	assert.True(time.Since(start) < time.Second)
+	assert.EqualValues(1, collector.GetProxiesByTypeAndName("tcp", "tcp").PoolTimeouts)
+}
+
+func TestCompressionAlgorithmFallback(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+
+	// frpc follows the algorithm in StartWorkConn, unknown ones fall back to snappy
+	for algorithm, expected := range map[string]string{
+		consts.GzipCompression: consts.GzipCompression,
+		consts.ZstdCompression: consts.ZstdCompression,
+		"lz4":                  "",
+	} {
+		cfg := &config.TcpProxyConf{}
+		cfg.ProxyName = "tcp"
+		cfg.ProxyType = "tcp"
+		cfg.UseCompression = true
+		cfg.CompressionAlgorithm = algorithm
+
+		workConn, frpcConn := net.Pipe()
+		getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+			return frpNet.WrapConn(workConn), true, nil
+		}
+		pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+		if !assert.NoError(err) {
+			return
+		}
+		collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+		msgCh := make(chan msg.Message, 1)
+		go func() {
+			m, _ := msg.ReadMsg(frpcConn)
+			msgCh <- m
+		}()
+		_, err = pxy.GetWorkConnFromPool(nil, nil)
+		if assert.NoError(err) {
+			m, ok := (<-msgCh).(*msg.StartWorkConn)
+			if assert.True(ok) {
+				assert.Equal(expected, m.CompressionAlgorithm, algorithm)
+			}
+		}
+		workConn.Close()
+		frpcConn.Close()
+	}
+}
+
+func TestWorkConnPoolHit(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	fromPool := true
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		workConn, frpcConn := net.Pipe()
+		go msg.ReadMsg(frpcConn)
+		return frpNet.WrapConn(workConn), fromPool, nil
+	}
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+	_, err = pxy.GetWorkConnFromPool(nil, nil)
+	assert.NoError(err)
+	// a work connection frpc is asked for after the pool is empty is a miss
+	fromPool = false
+	_, err = pxy.GetWorkConnFromPool(nil, nil)
+	assert.NoError(err)
+
+	ps := collector.GetProxiesByTypeAndName("tcp", "tcp")
+	assert.EqualValues(1, ps.PoolHits)
+	assert.EqualValues(1, ps.PoolMisses)
+}
+
+func TestWorkConnTraceId(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	msgCh := make(chan msg.Message, 2)
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		workConn, frpcConn := net.Pipe()
+		go func() {
+			m, _ := msg.ReadMsg(frpcConn)
+			msgCh <- m
+		}()
+		return frpNet.WrapConn(workConn), true, nil
+	}
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+	// every work connection gets its own trace id sent to frpc
+	traceIds := make(map[string]struct{})
+	for i := 0; i < 2; i++ {
+		workConn, err := pxy.GetWorkConnFromPool(nil, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		m, ok := (<-msgCh).(*msg.StartWorkConn)
+		if assert.True(ok) {
+			assert.Regexp("^[0-9a-f]{8}$", m.TraceId)
+			traceIds[m.TraceId] = struct{}{}
+		}
+		workConn.Close()
+	}
+	assert.Len(traceIds, 2)
+}
+
+func TestLazyTcpProxy(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+	oldBindAddr := g.GlbServerCfg.ProxyBindAddr
+	g.GlbServerCfg.ProxyBindAddr = "127.0.0.1"
+	defer func() { g.GlbServerCfg.ProxyBindAddr = oldBindAddr }()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	// user connections accepted by the proxy ask for work connections
+	acceptCh := make(chan struct{}, 10)
+	accepted := func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		defer c.Close()
+		select {
+		case <-acceptCh:
+			return true
+		case <-time.After(300 * time.Millisecond):
+			return false
+		}
+	}
+	reserved := func() bool {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return true
+		}
+		l.Close()
+		return false
+	}
+
+	newLazyProxy := func(idleTimeoutS int) *TcpProxy {
+		cfg := &config.TcpProxyConf{}
+		cfg.ProxyName = "tcp"
+		cfg.ProxyType = "tcp"
+		cfg.RemotePort = port
+		cfg.Lazy = true
+		cfg.LazyIdleTimeoutS = idleTimeoutS
+		rc := &controller.ResourceController{
+			TcpPortManager: ports.NewPortManager("tcp", "127.0.0.1", make(map[int]struct{})),
+		}
+		getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+			acceptCh <- struct{}{}
+			return nil, false, fmt.Errorf("no work connection")
+		}
+		pxy, err := NewProxy("test", rc, collector, 0, getWorkConn, cfg)
+		if !assert.NoError(err) {
+			return nil
+		}
+		collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+		_, err = pxy.Run()
+		if !assert.NoError(err) {
+			return nil
+		}
+		return pxy.(*TcpProxy)
+	}
+
+	pxy := newLazyProxy(1)
+	if pxy == nil {
+		return
+	}
+	// the port is reserved but user connections are refused until frpc asks
+	assert.True(reserved())
+	assert.False(accepted())
+	assert.NoError(pxy.Open())
+	assert.True(accepted())
+
+	// refused after it's idle for lazy_idle_timeout_s, the port is still reserved
+	time.Sleep(1500 * time.Millisecond)
+	assert.False(accepted())
+	assert.True(reserved())
+
+	// accepted again on demand
+	assert.NoError(pxy.Open())
+	assert.True(accepted())
+	pxy.Close()
+	assert.False(reserved())
+	assert.Error(pxy.Open())
+
+	// closing while accepting user connections releases the port at once
+	pxy = newLazyProxy(600)
+	if pxy == nil {
+		return
+	}
+	assert.NoError(pxy.Open())
+	assert.True(accepted())
+	pxy.Close()
+	assert.False(reserved())
+}
+
+func TestXtcpRelayedWorkConn(t *testing.T) {
+	assert := assert.New(t)
+	collector := stats.NewInternalCollector(true)
+
+	cfg := &config.XtcpProxyConf{}
+	cfg.ProxyName = "xtcp"
+	cfg.ProxyType = "xtcp"
+	cfg.FallbackToStcp = true
+	workConnCh := make(chan net.Conn, 2)
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		workConn, frpcConn := net.Pipe()
+		workConnCh <- frpcConn
+		return frpNet.WrapConn(workConn), true, nil
+	}
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "xtcp", ProxyType: "xtcp"})
+	xtcpPxy := pxy.(*XtcpProxy)
+
+	// user connections relayed from visitors, and work connections for nat holes
+	for _, relayed := range []bool{true, false} {
+		msgCh := make(chan msg.Message, 1)
+		go func() {
+			frpcConn := <-workConnCh
+			defer frpcConn.Close()
+			m, _ := msg.ReadMsg(frpcConn)
+			msgCh <- m
+		}()
+		var workConn frpNet.Conn
+		if relayed {
+			src := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 10000}
+			workConn, err = xtcpPxy.GetWorkConnFromPool(src, nil)
+		} else {
+			workConn, err = xtcpPxy.BaseProxy.GetWorkConnFromPool(nil, nil)
+		}
+		if assert.NoError(err) {
+			m, ok := (<-msgCh).(*msg.StartWorkConn)
+			if assert.True(ok) {
+				assert.Equal(relayed, m.Relayed)
+			}
+			workConn.Close()
+		}
+	}
+}
+
+func TestLogConnectionSummary(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frps_log")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "frps.log")
+	log.InitLog("file", logFile, "info", 1, "json")
+	defer func() {
+		log.SetLogFile("console", "", 0)
+		log.SetLogFormat("text")
+		log.SetLogLevel("warn")
+	}()
+	oldSummary := g.GlbServerCfg.LogConnectionSummary
+	g.GlbServerCfg.LogConnectionSummary = true
+	defer func() { g.GlbServerCfg.LogConnectionSummary = oldSummary }()
+
+	collector := stats.NewInternalCollector(true)
+	cfg := &config.TcpProxyConf{}
+	cfg.ProxyName = "tcp"
+	cfg.ProxyType = "tcp"
+	// frpc replies "world" to the user after reading "hello"
+	getWorkConn := func(ctx context.Context) (frpNet.Conn, bool, error) {
+		workConn, frpcConn := net.Pipe()
+		go func() {
+			defer frpcConn.Close()
+			if _, err := msg.ReadMsg(frpcConn); err != nil {
+				return
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(frpcConn, buf); err != nil {
+				return
+			}
+			frpcConn.Write([]byte("world!"))
+		}()
+		return frpNet.WrapConn(workConn), true, nil
+	}
+	pxy, err := NewProxy("test", &controller.ResourceController{}, collector, 0, getWorkConn, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+	collector.Mark(stats.TypeNewProxy, &stats.NewProxyPayload{Name: "tcp", ProxyType: "tcp"})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+	userConn, err := l.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		HandleUserTcpConnection(pxy, frpNet.WrapConn(userConn), collector)
+		close(done)
+	}()
+	c.Write([]byte("hello"))
+	buf, _ := ioutil.ReadAll(c)
+	assert.Equal("world!", string(buf))
+	<-done
+
+	content, err := ioutil.ReadFile(logFile)
+	if assert.NoError(err) {
+		assert.Contains(string(content), fmt.Sprintf("user [%s]", c.LocalAddr().String()))
+		assert.Contains(string(content), "bytes in [5], bytes out [6]")
+	}
+}
@@ -15,7 +15,10 @@
 package proxy
 
 import (
+	"crypto/x509"
+
 	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/utils/log"
 )
 
 type StcpProxy struct {
@@ -24,12 +27,17 @@ type StcpProxy struct {
 }
 
 func (pxy *StcpProxy) Run() (remoteAddr string, err error) {
-	listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Sk)
+	var visitorCAs *x509.CertPool
+	if pxy.cfg.VisitorVerifyCert {
+		visitorCAs = x509.NewCertPool()
+		visitorCAs.AppendCertsFromPEM([]byte(pxy.cfg.VisitorCa))
+	}
+	listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Sk, visitorCAs)
 	if errRet != nil {
 		err = errRet
 		return
 	}
-	listener.AddLogPrefix(pxy.name)
+	listener.AddKeyLogPrefix(log.ProxyKey, pxy.name)
 	pxy.listeners = append(pxy.listeners, listener)
 	pxy.Info("stcp proxy custom listen success")
 
@@ -16,22 +16,45 @@ package proxy
 
 import (
 	"fmt"
+	"net"
+	"sync"
+	"time"
 
+	"github.com/fatedier/frp/extend/limit"
 	"github.com/fatedier/frp/g"
 	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/server/stats"
+	"github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
 )
 
+// LazyProxy is implemented by proxies which only accept user connections after
+// frpc asks.
+type LazyProxy interface {
+	Open() error
+}
+
 type TcpProxy struct {
 	*BaseProxy
 	cfg *config.TcpProxyConf
 
 	realPort int
+	// ports after the first one of a proxy listening on several ports
+	extraPorts []int
+
+	// only used by lazy proxies
+	opened     bool
+	userConns  int
+	lastActive time.Time
+	closed     bool
+	lazyMu     sync.Mutex
 }
 
 func (pxy *TcpProxy) Run() (remoteAddr string, err error) {
 	if pxy.cfg.Group != "" {
-		l, realPort, errRet := pxy.rc.TcpGroupCtl.Listen(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, g.GlbServerCfg.ProxyBindAddr, pxy.cfg.RemotePort)
+		l, realPort, errRet := pxy.rc.TcpGroupCtl.Listen(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey,
+			g.GlbServerCfg.ProxyBindAddr, pxy.cfg.RemotePort, time.Duration(pxy.cfg.GroupSlowStartS)*time.Second,
+			pxy.handleGroupUserConn)
 		if errRet != nil {
 			err = errRet
 			return
@@ -42,10 +65,12 @@ func (pxy *TcpProxy) Run() (remoteAddr string, err error) {
 			}
 		}()
 		pxy.realPort = realPort
+		// user connections are dispatched to handleGroupUserConn by the group,
+		// the listener is only kept to leave the group when closing
 		listener := frpNet.WrapLogListener(l)
-		listener.AddLogPrefix(pxy.name)
+		listener.AddKeyLogPrefix(log.ProxyKey, pxy.name)
 		pxy.listeners = append(pxy.listeners, listener)
-		pxy.Info("tcp proxy listen port [%d] in group [%s]", pxy.cfg.RemotePort, pxy.cfg.Group)
+		pxy.Info("tcp proxy listen port [%d] in group [%s]", pxy.realPort, pxy.cfg.Group)
 	} else {
 		pxy.realPort, err = pxy.rc.TcpPortManager.Acquire(pxy.name, pxy.cfg.RemotePort)
 		if err != nil {
@@ -53,7 +78,15 @@ func (pxy *TcpProxy) Run() (remoteAddr string, err error) {
 		}
 		defer func() {
 			if err != nil {
+				for _, l := range pxy.listeners {
+					l.Close()
+				}
+				pxy.listeners = nil
 				pxy.rc.TcpPortManager.Release(pxy.realPort)
+				for _, port := range pxy.extraPorts {
+					pxy.rc.TcpPortManager.Release(port)
+				}
+				pxy.extraPorts = nil
 			}
 		}()
 		listener, errRet := frpNet.ListenTcp(g.GlbServerCfg.ProxyBindAddr, pxy.realPort)
@@ -61,24 +94,173 @@ func (pxy *TcpProxy) Run() (remoteAddr string, err error) {
 			err = errRet
 			return
 		}
-		listener.AddLogPrefix(pxy.name)
+		listener.AddKeyLogPrefix(log.ProxyKey, pxy.name)
 		pxy.listeners = append(pxy.listeners, listener)
-		pxy.Info("tcp proxy listen port [%d]", pxy.cfg.RemotePort)
+		pxy.Info("tcp proxy listen port [%d]", pxy.realPort)
+
+		if err = pxy.listenExtraPorts(); err != nil {
+			return
+		}
 	}
 
 	pxy.cfg.RemotePort = pxy.realPort
 	remoteAddr = fmt.Sprintf(":%d", pxy.realPort)
-	pxy.startListenHandler(pxy, HandleUserTcpConnection)
+	for _, port := range pxy.extraPorts {
+		remoteAddr += fmt.Sprintf(",:%d", port)
+	}
+	if pxy.cfg.Lazy {
+		// the port is kept listening, but user connections are refused until
+		// frpc asks
+		pxy.startListenHandler(pxy, pxy.handleLazyUserConn)
+	} else if pxy.cfg.Group == "" {
+		pxy.startListenHandler(pxy, HandleUserTcpConnection)
+	}
 	return
 }
 
+// listenExtraPorts listens on the other ports of a proxy with several remote
+// ports, user connections of all listeners are handled by this proxy.
+func (pxy *TcpProxy) listenExtraPorts() error {
+	if len(pxy.cfg.RemotePorts) == 0 {
+		return nil
+	}
+	for _, port := range pxy.cfg.RemotePorts[1:] {
+		realPort, err := pxy.rc.TcpPortManager.Acquire(pxy.name, port)
+		if err != nil {
+			return err
+		}
+		pxy.extraPorts = append(pxy.extraPorts, realPort)
+
+		listener, err := frpNet.ListenTcp(g.GlbServerCfg.ProxyBindAddr, realPort)
+		if err != nil {
+			return err
+		}
+		listener.AddKeyLogPrefix(log.ProxyKey, pxy.name)
+		pxy.listeners = append(pxy.listeners, listener)
+		pxy.Info("tcp proxy listen port [%d]", realPort)
+	}
+	return nil
+}
+
+// handleGroupUserConn serves a user connection dispatched by the tcp group, it
+// returns false without closing c if no work connection can be got from frpc,
+// so the group can try other proxies.
+func (pxy *TcpProxy) handleGroupUserConn(c net.Conn) bool {
+	userConn := frpNet.WrapConn(c)
+	pxy.Debug("get a user connection [%s]", userConn.RemoteAddr().String())
+	if !pxy.allowUserConn() {
+		pxy.Debug("reject user connection [%s] over max_conns_per_sec", userConn.RemoteAddr().String())
+		userConn.Close()
+		return true
+	}
+	if err := frpNet.SetTcpKeepAlive(userConn, pxy.keepAlive); err != nil {
+		pxy.Debug("set tcp keepalive error: %v", err)
+	}
+	workConn, err := pxy.GetWorkConnFromPool(userConn.RemoteAddr(), userConn.LocalAddr())
+	if err != nil {
+		pxy.Warn("dispatch user connection [%s] to other proxies in group [%s]",
+			userConn.RemoteAddr().String(), pxy.cfg.Group)
+		return false
+	}
+	userConn = limit.NewSharedLimitConn(pxy.rc.BandwidthLimiter, userConn)
+	go joinUserConn(pxy, userConn, workConn, pxy.statsCollector)
+	return true
+}
+
 func (pxy *TcpProxy) GetConf() config.ProxyConf {
 	return pxy.cfg
 }
 
+// Open starts accepting user connections of a lazy proxy if it isn't, they are
+// refused again after it has no user connections for lazy_idle_timeout_s.
+func (pxy *TcpProxy) Open() error {
+	if !pxy.cfg.Lazy {
+		return fmt.Errorf("proxy [%s] is not lazy", pxy.name)
+	}
+
+	pxy.lazyMu.Lock()
+	defer pxy.lazyMu.Unlock()
+	if pxy.closed {
+		return fmt.Errorf("proxy [%s] is closed", pxy.name)
+	}
+	pxy.lastActive = time.Now()
+	if pxy.opened {
+		return nil
+	}
+	pxy.opened = true
+	go pxy.closeWhenIdle()
+	pxy.Info("lazy tcp proxy accepts user connections on port [%d]", pxy.realPort)
+	return nil
+}
+
+func (pxy *TcpProxy) handleLazyUserConn(p Proxy, userConn frpNet.Conn, statsCollector stats.Collector) {
+	pxy.lazyMu.Lock()
+	if !pxy.opened {
+		pxy.lazyMu.Unlock()
+		pxy.Debug("refuse user connection [%s] of idle lazy proxy", userConn.RemoteAddr().String())
+		userConn.Close()
+		return
+	}
+	pxy.userConns++
+	pxy.lazyMu.Unlock()
+	defer func() {
+		pxy.lazyMu.Lock()
+		pxy.userConns--
+		pxy.lastActive = time.Now()
+		pxy.lazyMu.Unlock()
+	}()
+	HandleUserTcpConnection(p, userConn, statsCollector)
+}
+
+func (pxy *TcpProxy) closeWhenIdle() {
+	timeout := time.Duration(pxy.cfg.LazyIdleTimeoutS) * time.Second
+	for {
+		pxy.lazyMu.Lock()
+		if !pxy.opened {
+			// proxy is closed
+			pxy.lazyMu.Unlock()
+			return
+		}
+		wait := timeout
+		if pxy.userConns == 0 {
+			idle := time.Since(pxy.lastActive)
+			if idle >= timeout {
+				pxy.opened = false
+				pxy.lazyMu.Unlock()
+				pxy.Info("lazy tcp proxy is idle, refuse user connections on port [%d]", pxy.realPort)
+				return
+			}
+			wait = timeout - idle
+		}
+		pxy.lazyMu.Unlock()
+		select {
+		case <-pxy.Context().Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// CloseListeners also stops a lazy proxy from accepting user connections again
+// when frpc asks.
+func (pxy *TcpProxy) CloseListeners() {
+	pxy.lazyMu.Lock()
+	pxy.closed = true
+	pxy.opened = false
+	pxy.lazyMu.Unlock()
+	pxy.BaseProxy.CloseListeners()
+}
+
 func (pxy *TcpProxy) Close() {
+	pxy.lazyMu.Lock()
+	pxy.closed = true
+	pxy.opened = false
+	pxy.lazyMu.Unlock()
 	pxy.BaseProxy.Close()
 	if pxy.cfg.Group == "" {
 		pxy.rc.TcpPortManager.Release(pxy.realPort)
+		for _, port := range pxy.extraPorts {
+			pxy.rc.TcpPortManager.Release(port)
+		}
 	}
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/fatedier/frp/g"
@@ -67,7 +68,7 @@ func (pxy *UdpProxy) Run() (remoteAddr string, err error) {
 
 	remoteAddr = fmt.Sprintf(":%d", pxy.realPort)
 	pxy.cfg.RemotePort = pxy.realPort
-	addr, errRet := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", g.GlbServerCfg.ProxyBindAddr, pxy.realPort))
+	addr, errRet := net.ResolveUDPAddr("udp", net.JoinHostPort(g.GlbServerCfg.ProxyBindAddr, strconv.Itoa(pxy.realPort)))
 	if errRet != nil {
 		err = errRet
 		return
@@ -118,6 +119,7 @@ func (pxy *UdpProxy) Run() (remoteAddr string, err error) {
 						ProxyName:    pxy.GetName(),
 						TrafficBytes: int64(len(m.Content)),
 					})
+					pxy.addTraffic(int64(len(m.Content)))
 				}); errRet != nil {
 					conn.Close()
 					pxy.Info("reader goroutine for udp work connection closed")
@@ -147,6 +149,7 @@ func (pxy *UdpProxy) Run() (remoteAddr string, err error) {
 						ProxyName:    pxy.GetName(),
 						TrafficBytes: int64(len(udpMsg.Content)),
 					})
+					pxy.addTraffic(int64(len(udpMsg.Content)))
 					continue
 				}
 			case <-ctx.Done():
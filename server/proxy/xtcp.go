@@ -16,9 +16,12 @@ package proxy
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
 
 	"github.com/fatedier/golib/errors"
 )
@@ -36,6 +39,19 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 		err = fmt.Errorf("xtcp is not supported in frps")
 		return
 	}
+	if pxy.cfg.FallbackToStcp {
+		listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Sk, nil)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		listener.AddKeyLogPrefix(log.ProxyKey, pxy.name)
+		pxy.listeners = append(pxy.listeners, listener)
+		pxy.Info("xtcp proxy accepts relayed connections from visitors")
+
+		pxy.startListenHandler(pxy, HandleUserTcpConnection)
+	}
+
 	sidCh := pxy.rc.NatHoleController.ListenClient(pxy.GetName(), pxy.cfg.Sk)
 	go func() {
 		for {
@@ -44,7 +60,7 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 				break
 			case sidRequest := <-sidCh:
 				sr := sidRequest
-				workConn, errRet := pxy.GetWorkConnFromPool(nil, nil)
+				workConn, errRet := pxy.BaseProxy.GetWorkConnFromPool(nil, nil)
 				if errRet != nil {
 					continue
 				}
@@ -82,6 +98,12 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 	return
 }
 
+// GetWorkConnFromPool is only called for user connections relayed from
+// visitors, frpc handles them like stcp instead of making a nat hole.
+func (pxy *XtcpProxy) GetWorkConnFromPool(src, dst net.Addr) (frpNet.Conn, error) {
+	return pxy.getWorkConnFromPool(src, dst, true)
+}
+
 func (pxy *XtcpProxy) GetConf() config.ProxyConf {
 	return pxy.cfg
 }
@@ -89,6 +111,9 @@ func (pxy *XtcpProxy) GetConf() config.ProxyConf {
 func (pxy *XtcpProxy) Close() {
 	pxy.BaseProxy.Close()
 	pxy.rc.NatHoleController.CloseClient(pxy.GetName())
+	if pxy.cfg.FallbackToStcp {
+		pxy.rc.VisitorManager.CloseListener(pxy.GetName())
+	}
 	errors.PanicToError(func() {
 		close(pxy.closeCh)
 	})
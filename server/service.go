@@ -16,6 +16,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -27,19 +28,24 @@ import (
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/assets"
 	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
 	"github.com/fatedier/frp/models/nathole"
 	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/geoip"
 	"github.com/fatedier/frp/server/group"
 	"github.com/fatedier/frp/server/ports"
 	"github.com/fatedier/frp/server/proxy"
 	"github.com/fatedier/frp/server/stats"
 	"github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/tcpmux"
 	"github.com/fatedier/frp/utils/util"
 	"github.com/fatedier/frp/utils/version"
 	"github.com/fatedier/frp/utils/vhost"
@@ -48,10 +54,15 @@ import (
 	fmux "github.com/hashicorp/yamux"
 
 	"github.com/fatedier/frp/extend/api"
+	"github.com/fatedier/frp/extend/limit"
 )
 
 const (
 	connReadTimeout time.Duration = 10 * time.Second
+
+	// work connections warmed up by frpc are closed after this if they're not
+	// used and the pool has more than pool_count connections
+	warmWorkConnIdleTimeout time.Duration = 5 * time.Minute
 )
 
 var ServerService *Service
@@ -61,6 +72,9 @@ type Service struct {
 	// Dispatch connections to different handlers listen on same port
 	muxer *mux.Mux
 
+	// Underlying tcp listener of muxer
+	tcpListener net.Listener
+
 	// Accept connections from client
 	listener frpNet.Listener
 
@@ -82,6 +96,9 @@ type Service struct {
 	// HTTP vhost router
 	httpVhostRouter *vhost.VhostRouters
 
+	// Serve http requests of vhost http port, nil if it's disabled
+	httpServer *http.Server
+
 	// All resource managers and controllers
 	rc *controller.ResourceController
 
@@ -89,10 +106,27 @@ type Service struct {
 	statsCollector stats.Collector
 
 	tlsConfig *tls.Config
+
+	// API service used to verify users, shared by all logins for caching
+	apiService *api.Service
+
+	// resolve client ips to geographic info, nil means disabled
+	geoIpLookuper geoip.Lookuper
+
+	// status of goroutines serving frps, reported by /healthz and /readyz
+	components *components
+
+	// 1 means Run has started all components
+	running uint32
+
+	// 1 means frps is shutting down
+	shuttingDown   uint32
+	shutdownDoneCh chan struct{}
 }
 
 func NewService() (svr *Service, err error) {
 	cfg := &g.GlbServerCfg.ServerCommonConf
+	msg.SetMaxMsgLength(cfg.MaxMsgLength)
 	svr = &Service{
 		ctlManager: NewControlManager(),
 		pxyManager: proxy.NewProxyManager(),
@@ -100,11 +134,38 @@ func NewService() (svr *Service, err error) {
 			VisitorManager: controller.NewVisitorManager(),
 			TcpPortManager: ports.NewPortManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
 			UdpPortManager: ports.NewPortManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+
+			BandwidthLimiter:    limit.NewSharedLimiter(uint64(cfg.MaxTotalBandwidth) * limit.KB),
+			TrafficQuotaManager: controller.NewTrafficQuotaManager(),
 		},
 		httpVhostRouter: vhost.NewVhostRouters(),
 		tlsConfig:       generateTLSConfig(),
+		components:      newComponents(),
+		shutdownDoneCh:  make(chan struct{}),
+	}
+
+	if cfg.EnableApi {
+		svr.apiService, err = api.NewService(cfg.ApiBaseUrl)
+		if err != nil {
+			err = fmt.Errorf("Create API service error, %v", err)
+			return
+		}
+		svr.apiService.SetCacheTTL(time.Duration(cfg.ApiCacheTTLS) * time.Second)
+	}
+
+	if cfg.GeoIpDbFile != "" {
+		svr.geoIpLookuper, err = geoip.NewCidrLookuperFromFile(cfg.GeoIpDbFile)
+		if err != nil {
+			err = fmt.Errorf("Load geoip db file error, %v", err)
+			return
+		}
 	}
 
+	svr.rc.TrafficQuotaManager.SetExceedHandler(func(name string) {
+		log.Info("proxy [%s] has used up its traffic quota", name)
+		svr.CloseProxy(name)
+	})
+
 	// Init group controller
 	svr.rc.TcpGroupCtl = group.NewTcpGroupCtl(svr.rc.TcpPortManager)
 
@@ -120,6 +181,7 @@ func NewService() (svr *Service, err error) {
 
 	// Init 404 not found page
 	vhost.ServiceUnavailablePagePath = cfg.Custom503Page
+	vhost.AuthFailPagePath = cfg.CustomAuthFailPage
 
 	var (
 		httpMuxOn  bool
@@ -135,21 +197,22 @@ func NewService() (svr *Service, err error) {
 	}
 
 	// Listen for accepting connections from client.
-	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.BindPort))
+	ln, err := frpNet.ListenTcpWithOptions(cfg.BindNetwork, net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.BindPort)), cfg.ListenBacklog, cfg.BindReusePort)
 	if err != nil {
 		err = fmt.Errorf("Create server listener error, %v", err)
 		return
 	}
 
-	svr.muxer = mux.NewMux(ln)
-	go svr.muxer.Serve()
+	svr.tcpListener = ln
+	svr.muxer = mux.NewMux(svr.newServingListener("muxer", ln))
+	svr.runComponent("muxer", func() { svr.muxer.Serve() })
 	ln = svr.muxer.DefaultListener()
 
 	svr.listener = frpNet.WrapLogListener(ln)
 	log.Info("frps tcp listen on %s:%d", cfg.BindAddr, cfg.BindPort)
 
 	// Listen for accepting connections from client using kcp protocol.
-	if cfg.KcpBindPort > 0 {
+	if cfg.EnableKcp && cfg.KcpBindPort > 0 {
 		svr.kcpListener, err = frpNet.ListenKcp(cfg.BindAddr, cfg.KcpBindPort)
 		if err != nil {
 			err = fmt.Errorf("Listen on kcp address udp [%s:%d] error: %v", cfg.BindAddr, cfg.KcpBindPort, err)
@@ -159,35 +222,50 @@ func NewService() (svr *Service, err error) {
 	}
 
 	// Listen for accepting connections from client using websocket protocol.
-	websocketPrefix := []byte("GET " + frpNet.FrpWebsocketPath)
-	websocketLn := svr.muxer.Listen(0, uint32(len(websocketPrefix)), func(data []byte) bool {
-		return bytes.Equal(data, websocketPrefix)
-	})
-	svr.websocketListener = frpNet.NewWebsocketListener(websocketLn)
+	if cfg.EnableWebsocket {
+		// match the whole path, so requests under websocket_path of vhost
+		// http sites on the same port aren't taken
+		websocketPrefix := []byte("GET " + cfg.WebsocketPath + " ")
+		websocketLn := svr.muxer.Listen(0, uint32(len(websocketPrefix)), func(data []byte) bool {
+			return bytes.HasPrefix(data, websocketPrefix)
+		})
+		svr.websocketListener = frpNet.NewWebsocketListener(websocketLn, cfg.WebsocketPath)
+	}
+
+	if cfg.VhostHttpsAcmeEnable {
+		svr.rc.AcmeManager = controller.NewAcmeManager(cfg.VhostHttpsAcmeEmail, cfg.VhostHttpsAcmeCacheDir, cfg.VhostHttpsAcmeDirectoryUrl)
+	}
 
 	// Create http vhost muxer.
 	if cfg.VhostHttpPort > 0 {
 		rp := vhost.NewHttpReverseProxy(vhost.HttpReverseProxyOptions{
 			ResponseHeaderTimeoutS: cfg.VhostHttpTimeout,
+			ResponseTimeStats:      cfg.HttpResponseTimeStats,
 		}, svr.httpVhostRouter)
 		svr.rc.HttpReverseProxy = rp
 
-		address := fmt.Sprintf("%s:%d", cfg.ProxyBindAddr, cfg.VhostHttpPort)
+		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.VhostHttpPort))
+		var handler http.Handler = rp
+		if svr.rc.AcmeManager != nil {
+			handler = svr.rc.AcmeManager.HttpHandler(rp)
+		}
 		server := &http.Server{
 			Addr:    address,
-			Handler: rp,
+			Handler: handler,
 		}
 		var l net.Listener
 		if httpMuxOn {
 			l = svr.muxer.ListenHttp(1)
 		} else {
-			l, err = net.Listen("tcp", address)
+			l, err = frpNet.ListenTcpWithOptions(cfg.BindNetwork, address, cfg.ListenBacklog, false)
 			if err != nil {
 				err = fmt.Errorf("Create vhost http listener error, %v", err)
 				return
 			}
 		}
-		go server.Serve(l)
+		svr.httpServer = server
+		l = svr.newServingListener("vhost_http", l)
+		svr.runComponent("vhost_http", func() { server.Serve(l) })
 		log.Info("http service listen on %s:%d", cfg.ProxyBindAddr, cfg.VhostHttpPort)
 	}
 
@@ -197,7 +275,7 @@ func NewService() (svr *Service, err error) {
 		if httpsMuxOn {
 			l = svr.muxer.ListenHttps(1)
 		} else {
-			l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort))
+			l, err = frpNet.ListenTcpWithOptions(cfg.BindNetwork, net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.VhostHttpsPort)), cfg.ListenBacklog, false)
 			if err != nil {
 				err = fmt.Errorf("Create server listener error, %v", err)
 				return
@@ -210,25 +288,56 @@ func NewService() (svr *Service, err error) {
 			return
 		}
 		log.Info("https service listen on %s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort)
+
+		if cfg.VhostHttpsTlsCertFile != "" && cfg.VhostHttpsTlsKeyFile != "" {
+			cert, errRet := tls.LoadX509KeyPair(cfg.VhostHttpsTlsCertFile, cfg.VhostHttpsTlsKeyFile)
+			if errRet != nil {
+				err = fmt.Errorf("Load vhost https tls certificate error, %v", errRet)
+				return
+			}
+			svr.rc.VhostHttpsCertificate = &cert
+		}
 	}
 
-	// frp tls listener
-	tlsListener := svr.muxer.Listen(1, 1, func(data []byte) bool {
-		return int(data[0]) == frpNet.FRP_TLS_HEAD_BYTE
-	})
-	svr.tlsListener = frpNet.WrapLogListener(tlsListener)
+	// Create tcpmux httpconnect multiplexer.
+	if cfg.TcpMuxHttpConnectPort > 0 {
+		var l net.Listener
+		l, err = frpNet.ListenTcpWithOptions(cfg.BindNetwork, net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.TcpMuxHttpConnectPort)), cfg.ListenBacklog, false)
+		if err != nil {
+			err = fmt.Errorf("Create server listener error, %v", err)
+			return
+		}
+
+		svr.rc.TcpMuxHttpConnectMuxer, err = tcpmux.NewHttpConnectTcpMuxer(frpNet.WrapLogListener(l), 30*time.Second)
+		if err != nil {
+			err = fmt.Errorf("Create vhost tcpMuxer error, %v", err)
+			return
+		}
+		log.Info("tcpmux httpconnect multiplexer listen on %s:%d", cfg.ProxyBindAddr, cfg.TcpMuxHttpConnectPort)
+	}
+
+	// frp tls listener, connections from frpc starting with FRP_TLS_HEAD_BYTE are
+	// routed to it by the muxer. HandleListener still detects tls on every listener,
+	// so plain connections on bind_port, kcp and websocket are rejected if tls_only
+	// is true. If disable_tls is true, neither the listener nor the detection exists.
+	if svr.tlsEnabled() {
+		tlsListener := svr.muxer.Listen(1, 1, func(data []byte) bool {
+			return int(data[0]) == frpNet.FRP_TLS_HEAD_BYTE
+		})
+		svr.tlsListener = frpNet.WrapLogListener(tlsListener)
+	}
 
 	// Create nat hole controller.
 	if cfg.BindUdpPort > 0 {
 		var nc *nathole.NatHoleController
-		addr := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.BindUdpPort)
+		addr := net.JoinHostPort(cfg.NatHoleBindAddr, strconv.Itoa(cfg.BindUdpPort))
 		nc, err = nathole.NewNatHoleController(addr)
 		if err != nil {
 			err = fmt.Errorf("Create nat hole controller error, %v", err)
 			return
 		}
 		svr.rc.NatHoleController = nc
-		log.Info("nat hole udp service listen on %s:%d", cfg.BindAddr, cfg.BindUdpPort)
+		log.Info("nat hole udp service listen on %s", addr)
 	}
 
 	var statsEnable bool
@@ -249,16 +358,99 @@ func NewService() (svr *Service, err error) {
 
 func (svr *Service) Run() {
 	if svr.rc.NatHoleController != nil {
-		go svr.rc.NatHoleController.Run()
+		// the udp port is listened already, it's serving once packets are read
+		svr.runComponent("nat_hole", func() {
+			svr.components.set("nat_hole", ComponentRunning)
+			svr.rc.NatHoleController.Run()
+		})
+	}
+	if svr.kcpListener != nil {
+		svr.runComponent("kcp_listener", func() { svr.HandleListener(svr.newServingFrpListener("kcp_listener", svr.kcpListener)) })
+	}
+	if svr.websocketListener != nil {
+		svr.runComponent("websocket_listener", func() { svr.HandleListener(svr.newServingFrpListener("websocket_listener", svr.websocketListener)) })
+	}
+	if svr.tlsListener != nil {
+		svr.runComponent("tls_listener", func() { svr.HandleListener(svr.newServingFrpListener("tls_listener", svr.tlsListener)) })
 	}
-	if g.GlbServerCfg.KcpBindPort > 0 {
-		go svr.HandleListener(svr.kcpListener)
+	svr.runComponent("listener", func() { svr.HandleListener(svr.newServingFrpListener("listener", svr.listener)) })
+	atomic.StoreUint32(&svr.running, 1)
+
+	<-svr.shutdownDoneCh
+}
+
+// Shutdown stops accepting new clients and user connections and notifies all
+// clients, then waits for active user connections to finish until ctx is done,
+// at last closes all clients and proxies.
+func (svr *Service) Shutdown(ctx context.Context) {
+	if !atomic.CompareAndSwapUint32(&svr.shuttingDown, 0, 1) {
+		return
+	}
+	defer close(svr.shutdownDoneCh)
+
+	log.Info("frps is shutting down, stop accepting new clients")
+	// default listener of muxer can't be closed, so close the underlying one
+	svr.tcpListener.Close()
+	svr.listener.Close()
+	if svr.kcpListener != nil {
+		svr.kcpListener.Close()
+	}
+	if svr.websocketListener != nil {
+		svr.websocketListener.Close()
+	}
+	if svr.tlsListener != nil {
+		svr.tlsListener.Close()
+	}
+
+	log.Info("stop accepting new user connections")
+	// proxies registered after closing listeners would accept user
+	// connections again, so reject them first
+	svr.ctlManager.RejectNewProxies()
+	svr.pxyManager.CloseListeners()
+	if svr.rc.VhostHttpsMuxer != nil {
+		svr.rc.VhostHttpsMuxer.Close()
+	}
+	if svr.rc.TcpMuxHttpConnectMuxer != nil {
+		svr.rc.TcpMuxHttpConnectMuxer.Close()
+	}
+	// requests being served by the vhost http server are waited for too
+	httpDoneCh := make(chan struct{})
+	go func() {
+		if svr.httpServer != nil {
+			svr.httpServer.Shutdown(ctx)
+		}
+		close(httpDoneCh)
+	}()
+
+	svr.ctlManager.NotifyShutdown()
+
+	if !proxy.WaitUserConns(ctx) {
+		log.Warn("user connections are still active after grace period, close them")
 	}
+	<-httpDoneCh
+	svr.ctlManager.CloseAll()
+	log.Info("frps shutdown success")
+}
 
-	go svr.HandleListener(svr.websocketListener)
-	go svr.HandleListener(svr.tlsListener)
+// tlsEnabled returns true if frpc can connect frps by tls.
+func (svr *Service) tlsEnabled() bool {
+	return !g.GlbServerCfg.DisableTls && g.GlbServerCfg.EnableTlsMux
+}
 
-	svr.HandleListener(svr.listener)
+// getUserLimit returns bandwidth limits and the max number of proxies of user
+// from the API, max-proxies of the API overrides max_proxies_per_user if it's
+// greater than 0.
+func (svr *Service) getUserLimit(user string, nowTime int64) (inLimit, outLimit uint64, maxProxies int64, err error) {
+	var apiMaxProxies int64
+	inLimit, outLimit, apiMaxProxies, err = svr.apiService.GetProxyLimit(user, nowTime, g.GlbServerCfg.ApiToken)
+	if err != nil {
+		return
+	}
+	maxProxies = g.GlbServerCfg.MaxProxiesPerUser
+	if apiMaxProxies > 0 {
+		maxProxies = apiMaxProxies
+	}
+	return
 }
 
 func (svr *Service) HandleListener(l frpNet.Listener) {
@@ -270,23 +462,30 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 			return
 		}
 
-		log.Trace("start check TLS connection...")
-		originConn := c
-		c, err = frpNet.CheckAndEnableTLSServerConnWithTimeout(c, svr.tlsConfig, connReadTimeout)
-		if err != nil {
-			log.Warn("CheckAndEnableTLSServerConnWithTimeout error: %v", err)
-			originConn.Close()
-			continue
-		}
-		log.Trace("success check TLS connection")
-
 		// Start a new goroutine for dealing connections.
 		go func(frpConn frpNet.Conn) {
+			// tls is detected here so clients sending nothing can't block accepting others
+			if svr.tlsEnabled() {
+				log.Trace("start check TLS connection...")
+				tlsConn, err := frpNet.CheckAndEnableTLSServerConnWithTimeout(frpConn, svr.tlsConfig, g.GlbServerCfg.TlsOnly, connReadTimeout)
+				if err != nil {
+					log.Warn("CheckAndEnableTLSServerConnWithTimeout error: %v", err)
+					frpConn.Close()
+					return
+				}
+				frpConn = tlsConn
+				log.Trace("success check TLS connection")
+			}
+
 			dealFn := func(conn frpNet.Conn) {
 				var rawMsg msg.Message
 				conn.SetReadDeadline(time.Now().Add(connReadTimeout))
 				if rawMsg, err = msg.ReadMsg(conn); err != nil {
-					log.Trace("Failed to read message: %v", err)
+					if err == msg.ErrMaxMsgLength {
+						log.Warn("Message from [%s] exceeds max_msg_length, close the connection", conn.RemoteAddr().String())
+					} else {
+						log.Trace("Failed to read message: %v", err)
+					}
 					conn.Close()
 					return
 				}
@@ -364,6 +563,11 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 		err = fmt.Errorf("%s", msg)
 		return
 	}
+	if minVersion := g.GlbServerCfg.MinClientVersion; minVersion != "" &&
+		!version.MeetsMinVersion(loginMsg.Version, minVersion, g.GlbServerCfg.AllowDevClientVersion) {
+		err = fmt.Errorf("frpc version [%s] is not allowed, please upgrade it to at least %s", loginMsg.Version, minVersion)
+		return
+	}
 
 	// Check auth.
 	if util.GetAuthKey(g.GlbServerCfg.Token, loginMsg.Timestamp) != loginMsg.PrivilegeKey {
@@ -371,19 +575,21 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 		return
 	}
 
+	if config.MetasSize(loginMsg.Metas) > config.MaxClientMetasSize {
+		err = fmt.Errorf("total size of metas should not be greater than %d bytes", config.MaxClientMetasSize)
+		return
+	}
+
 	var (
-		inLimit  uint64
-		outLimit uint64
+		inLimit    uint64
+		outLimit   uint64
+		maxProxies = g.GlbServerCfg.MaxProxiesPerUser
 	)
 
 	if g.GlbServerCfg.EnableApi {
 
 		nowTime := time.Now().Unix()
-
-		s, err := api.NewService(g.GlbServerCfg.ApiBaseUrl)
-		if err != nil {
-			return err
-		}
+		s := svr.apiService
 
 		r := regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
 		mm := r.FindAllStringSubmatch(loginMsg.User, -1)
@@ -403,7 +609,7 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 			return fmt.Errorf("authorization failed")
 		}
 
-		inLimit, outLimit, err = s.GetProxyLimit(loginMsg.User, nowTime, g.GlbServerCfg.ApiToken)
+		inLimit, outLimit, maxProxies, err = svr.getUserLimit(loginMsg.User, nowTime)
 		if err != nil {
 			return err
 		}
@@ -412,21 +618,34 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 
 	// If client's RunId is empty, it's a new client, we just create a new controller.
 	// Otherwise, we check if there is one controller has the same run id. If so, we release previous controller and start new one.
+	// RunId may be set by run_id of frpc to be stable across restarts.
 	if loginMsg.RunId == "" {
 		randid, err := util.RandId()
 		if err != nil {
 			return err
 		}
 		loginMsg.RunId = loginMsg.User + "-" + randid
+	} else if !config.IsValidRunId(loginMsg.RunId) {
+		err = fmt.Errorf("invalid run id [%s]", loginMsg.RunId)
+		return
 	}
 
 	ctl := NewControl(svr.rc, svr.pxyManager, svr.statsCollector, ctlConn, loginMsg, inLimit, outLimit)
+	ctl.maxProxies = maxProxies
+	ctl.ctlManager = svr.ctlManager
+	if svr.geoIpLookuper != nil {
+		ctl.geoInfo = svr.lookupGeoInfo(ctlConn)
+	}
 
-	if oldCtl := svr.ctlManager.Add(loginMsg.RunId, ctl); oldCtl != nil {
+	oldCtl, err := svr.ctlManager.Add(loginMsg.RunId, ctl)
+	if err != nil {
+		return
+	}
+	if oldCtl != nil {
 		oldCtl.allShutdown.WaitDone()
 	}
 
-	ctlConn.AddLogPrefix(loginMsg.RunId)
+	ctlConn.AddKeyLogPrefix(log.RunIdKey, loginMsg.RunId)
 	ctl.Start()
 
 	// for statistics
@@ -440,6 +659,30 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 	return
 }
 
+// SetGeoIpLookuper replaces the lookuper used to resolve client ips on login,
+// nil disables it. It should be called before Run.
+func (svr *Service) SetGeoIpLookuper(l geoip.Lookuper) {
+	svr.geoIpLookuper = l
+}
+
+func (svr *Service) lookupGeoInfo(ctlConn frpNet.Conn) (info geoip.Info) {
+	host, _, err := net.SplitHostPort(ctlConn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+	info, err = svr.geoIpLookuper.Lookup(ip)
+	if err != nil {
+		ctlConn.Debug("lookup geoip info of [%s] error: %v", host, err)
+		return
+	}
+	ctlConn.Info("client geoip info: country [%s] asn [%s]", info.Country, info.Asn)
+	return
+}
+
 // RegisterWorkConn register a new work connection to control and proxies need it.
 func (svr *Service) RegisterWorkConn(workConn frpNet.Conn, newMsg *msg.NewWorkConn) {
 	ctl, exist := svr.ctlManager.GetById(newMsg.RunId)
@@ -447,13 +690,20 @@ func (svr *Service) RegisterWorkConn(workConn frpNet.Conn, newMsg *msg.NewWorkCo
 		workConn.Warn("No client control found for run id [%s]", newMsg.RunId)
 		return
 	}
+	if newMsg.ProxyName != "" {
+		ctl.RegisterDedicatedWorkConn(newMsg.ProxyName, workConn)
+		return
+	}
 	ctl.RegisterWorkConn(workConn)
+	if newMsg.Warmup {
+		time.AfterFunc(warmWorkConnIdleTimeout, ctl.shrinkWorkConnPool)
+	}
 	return
 }
 
 func (svr *Service) RegisterVisitorConn(visitorConn frpNet.Conn, newMsg *msg.NewVisitorConn) error {
 	return svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
-		newMsg.UseEncryption, newMsg.UseCompression)
+		newMsg.Certs, newMsg.CertSign, newMsg.Nonce, newMsg.UseEncryption, newMsg.UseCompression)
 }
 
 // Setup a bare-bones TLS config for the server
@@ -477,6 +727,28 @@ func generateTLSConfig() *tls.Config {
 	return &tls.Config{Certificates: []tls.Certificate{tlsCert}}
 }
 
+// CloseProxy closes the proxy by name and returns the remote port it used,
+// remotePort is 0 if it's not a tcp or udp proxy.
+func (svr *Service) CloseProxy(name string) (remotePort int, err error) {
+	pxy, ok := svr.pxyManager.GetByName(name)
+	if !ok {
+		return 0, fmt.Errorf("proxy not found")
+	}
+	ctl, ok := svr.ctlManager.GetByProxyName(name)
+	if !ok {
+		return 0, fmt.Errorf("proxy not found")
+	}
+
+	switch cfg := pxy.GetConf().(type) {
+	case *config.TcpProxyConf:
+		remotePort = cfg.RemotePort
+	case *config.UdpProxyConf:
+		remotePort = cfg.RemotePort
+	}
+	ctl.CloseProxyByServer(name)
+	return
+}
+
 func (svr *Service) CloseUser(user string) error {
 	ctl, ok := svr.ctlManager.SearchById(user)
 	if !ok {
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/fatedier/frp/assets/frps/statik"
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/server/proxy"
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// freePort returns a port of network which is not in use on addr now.
+func freePort(t *testing.T, network string, addr string) int {
+	var (
+		a   net.Addr
+		err error
+	)
+	if network == "udp" {
+		var c net.PacketConn
+		c, err = net.ListenPacket("udp", net.JoinHostPort(addr, "0"))
+		if err == nil {
+			a = c.LocalAddr()
+			c.Close()
+		}
+	} else {
+		var l net.Listener
+		l, err = net.Listen("tcp", net.JoinHostPort(addr, "0"))
+		if err == nil {
+			a = l.Addr()
+			l.Close()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(a.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// newTestService creates a service listening on bindAddr with all vhost
+// ports enabled, setup can change other options if it's not nil. The global
+// server config is restored by the returned function.
+func newTestService(t *testing.T, bindAddr string, setup func(cfg *config.ServerCommonConf)) (svr *Service, restore func()) {
+	oldCfg := *g.GlbServerCfg
+	restore = func() { *g.GlbServerCfg = oldCfg }
+
+	cfg := &g.GlbServerCfg.ServerCommonConf
+	cfg.BindAddr = bindAddr
+	cfg.BindPort = freePort(t, "tcp", bindAddr)
+	cfg.ProxyBindAddr = bindAddr
+	cfg.VhostHttpPort = freePort(t, "tcp", bindAddr)
+	cfg.VhostHttpsPort = freePort(t, "tcp", bindAddr)
+	cfg.TcpMuxHttpConnectPort = freePort(t, "tcp", bindAddr)
+	if setup != nil {
+		setup(cfg)
+	}
+
+	svr, err := NewService()
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return svr, restore
+}
+
+// echoWorkConn returns a work connection echoing data of the user connection
+// after reading the StartWorkConn message.
+func echoWorkConn(ctx context.Context) (frpNet.Conn, bool, error) {
+	workConn, frpcConn := net.Pipe()
+	go func() {
+		defer frpcConn.Close()
+		if _, err := msg.ReadMsg(frpcConn); err != nil {
+			return
+		}
+		io.Copy(frpcConn, frpcConn)
+	}()
+	return frpNet.WrapConn(workConn), true, nil
+}
+
+func TestShutdownRefusesUserConns(t *testing.T) {
+	assert := assert.New(t)
+	svr, restore := newTestService(t, "127.0.0.1", nil)
+	defer restore()
+
+	pxyCfg := &config.TcpProxyConf{}
+	pxyCfg.ProxyName = "tcp"
+	pxyCfg.ProxyType = "tcp"
+	pxyCfg.RemotePort = freePort(t, "tcp", "127.0.0.1")
+	pxy, err := proxy.NewProxy("test", svr.rc, svr.statsCollector, 0, echoWorkConn, pxyCfg)
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = pxy.Run()
+	if !assert.NoError(err) {
+		return
+	}
+	defer pxy.Close()
+	svr.pxyManager.Add("tcp", pxy)
+
+	addrs := []string{
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(pxyCfg.RemotePort)),
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(g.GlbServerCfg.VhostHttpPort)),
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(g.GlbServerCfg.VhostHttpsPort)),
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(g.GlbServerCfg.TcpMuxHttpConnectPort)),
+	}
+	// vhost http server starts serving in a new goroutine
+	time.Sleep(100 * time.Millisecond)
+	for _, addr := range addrs {
+		c, err := net.Dial("tcp", addr)
+		if assert.NoError(err) {
+			c.Close()
+		}
+	}
+
+	// an active user connection keeps frps in the grace period
+	userConn, err := net.Dial("tcp", addrs[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer userConn.Close()
+	buf := make([]byte, 5)
+	userConn.Write([]byte("hello"))
+	_, err = io.ReadFull(userConn, buf)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	doneCh := make(chan struct{})
+	go func() {
+		svr.Shutdown(ctx)
+		close(doneCh)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	for _, addr := range addrs {
+		c, err := net.Dial("tcp", addr)
+		if assert.Error(err, addr) {
+			continue
+		}
+		c.Close()
+	}
+	select {
+	case <-doneCh:
+		assert.Fail("shutdown doesn't wait for the active user connection")
+		return
+	default:
+	}
+
+	// the joined connection still works until it's closed
+	userConn.Write([]byte("world"))
+	_, err = io.ReadFull(userConn, buf)
+	if assert.NoError(err) {
+		assert.Equal("world", string(buf))
+	}
+	userConn.Close()
+	select {
+	case <-doneCh:
+	case <-time.After(2 * time.Second):
+		assert.Fail("shutdown isn't done after user connections are closed")
+	}
+}
+
+func TestNewServiceIpv6(t *testing.T) {
+	assert := assert.New(t)
+	if l, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("ipv6 is not supported")
+	} else {
+		l.Close()
+	}
+
+	svr, restore := newTestService(t, "::", func(cfg *config.ServerCommonConf) {
+		cfg.EnableKcp = true
+		cfg.KcpBindPort = freePort(t, "udp", "::")
+		cfg.BindUdpPort = freePort(t, "udp", "::")
+		cfg.NatHoleBindAddr = "::"
+	})
+	defer restore()
+	defer svr.Shutdown(context.Background())
+	go svr.Run()
+
+	tcpCfg := &config.TcpProxyConf{}
+	tcpCfg.ProxyName = "tcp"
+	tcpCfg.ProxyType = "tcp"
+	tcpCfg.RemotePort = freePort(t, "tcp", "::")
+	udpCfg := &config.UdpProxyConf{}
+	udpCfg.ProxyName = "udp"
+	udpCfg.ProxyType = "udp"
+	udpCfg.RemotePort = freePort(t, "udp", "::")
+	for _, pxyCfg := range []config.ProxyConf{tcpCfg, udpCfg} {
+		pxy, err := proxy.NewProxy("test", svr.rc, svr.statsCollector, 0, echoWorkConn, pxyCfg)
+		if !assert.NoError(err) {
+			return
+		}
+		_, err = pxy.Run()
+		if !assert.NoError(err, pxyCfg.GetBaseInfo().ProxyName) {
+			return
+		}
+		defer pxy.Close()
+	}
+
+	cfg := g.GlbServerCfg
+	for _, port := range []int{cfg.BindPort, cfg.VhostHttpPort, cfg.VhostHttpsPort, cfg.TcpMuxHttpConnectPort, tcpCfg.RemotePort} {
+		c, err := net.Dial("tcp", net.JoinHostPort("::1", strconv.Itoa(port)))
+		if assert.NoError(err) {
+			c.Close()
+		}
+	}
+	// udp ports are in use by frps
+	for _, port := range []int{cfg.KcpBindPort, cfg.BindUdpPort, udpCfg.RemotePort} {
+		c, err := net.ListenPacket("udp", net.JoinHostPort("::", strconv.Itoa(port)))
+		if assert.Error(err) {
+			continue
+		}
+		c.Close()
+	}
+}
+
+func TestWebsocketPathSharedWithVhostHttp(t *testing.T) {
+	assert := assert.New(t)
+	svr, restore := newTestService(t, "127.0.0.1", func(cfg *config.ServerCommonConf) {
+		cfg.VhostHttpPort = cfg.BindPort
+		cfg.EnableWebsocket = true
+		cfg.WebsocketPath = "/ws"
+	})
+	defer restore()
+	defer svr.Shutdown(context.Background())
+	go svr.Run()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(g.GlbServerCfg.BindPort))
+	c, err := frpNet.ConnectWebsocketServer(addr, "/ws")
+	if assert.NoError(err) {
+		c.Close()
+	}
+
+	// requests under websocket_path are still served by vhost http, which
+	// has no site registered
+	resp, err := http.Get("http://" + addr + "/ws/index.html")
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
@@ -26,6 +26,10 @@ type internalCollector struct {
 	enable bool
 	info   *ServerStatistics
 	mu     sync.Mutex
+
+	// proxy name -> *WorkConnPoolStatistics
+	// it's not protected by mu to avoid lock contention when getting work connections
+	workConnPools sync.Map
 }
 
 func NewInternalCollector(enable bool) Collector {
@@ -63,6 +67,7 @@ func (collector *internalCollector) ClearUselessInfo() {
 	for name, data := range collector.info.ProxyStatistics {
 		if !data.LastCloseTime.IsZero() && time.Since(data.LastCloseTime) > time.Duration(7*24)*time.Hour {
 			delete(collector.info.ProxyStatistics, name)
+			collector.workConnPools.Delete(name)
 			log.Trace("clear proxy [%s]'s statistics data, lastCloseTime: [%s]", name, data.LastCloseTime.String())
 		}
 	}
@@ -86,10 +91,16 @@ func (collector *internalCollector) Mark(statsType StatsType, payload interface{
 		collector.openConnection(v)
 	case *CloseConnectionPayload:
 		collector.closeConnection(v)
+	case *RejectConnectionPayload:
+		collector.rejectConnection(v)
 	case *AddTrafficInPayload:
 		collector.addTrafficIn(v)
 	case *AddTrafficOutPayload:
 		collector.addTrafficOut(v)
+	case *WorkConnPoolPayload:
+		collector.workConnPool(v)
+	case *HttpResponseTimePayload:
+		collector.httpResponseTime(v)
 	}
 }
 
@@ -114,11 +125,13 @@ func (collector *internalCollector) newProxy(payload *NewProxyPayload) {
 	proxyStats, ok := collector.info.ProxyStatistics[payload.Name]
 	if !(ok && proxyStats.ProxyType == payload.ProxyType) {
 		proxyStats = &ProxyStatistics{
-			Name:       payload.Name,
-			ProxyType:  payload.ProxyType,
-			CurConns:   metric.NewCounter(),
-			TrafficIn:  metric.NewDateCounter(ReserveDays),
-			TrafficOut: metric.NewDateCounter(ReserveDays),
+			Name:          payload.Name,
+			ProxyType:     payload.ProxyType,
+			CurConns:      metric.NewCounter(),
+			RejectedConns: metric.NewCounter(),
+			TrafficIn:     metric.NewDateCounter(ReserveDays),
+			TrafficOut:    metric.NewDateCounter(ReserveDays),
+			ResponseTimes: metric.NewDurationWindow(ResponseTimeWindow),
 		}
 		collector.info.ProxyStatistics[payload.Name] = proxyStats
 	}
@@ -160,6 +173,15 @@ func (collector *internalCollector) closeConnection(payload *CloseConnectionPayl
 	}
 }
 
+func (collector *internalCollector) rejectConnection(payload *RejectConnectionPayload) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	proxyStats, ok := collector.info.ProxyStatistics[payload.ProxyName]
+	if ok {
+		proxyStats.RejectedConns.Inc(1)
+	}
+}
+
 func (collector *internalCollector) addTrafficIn(payload *AddTrafficInPayload) {
 	collector.info.TotalTrafficIn.Inc(payload.TrafficBytes)
 
@@ -186,6 +208,58 @@ func (collector *internalCollector) addTrafficOut(payload *AddTrafficOutPayload)
 	}
 }
 
+func (collector *internalCollector) workConnPool(payload *WorkConnPoolPayload) {
+	v, ok := collector.workConnPools.Load(payload.ProxyName)
+	if !ok {
+		v, _ = collector.workConnPools.LoadOrStore(payload.ProxyName, &WorkConnPoolStatistics{
+			Hits:     metric.NewCounter(),
+			Misses:   metric.NewCounter(),
+			Retries:  metric.NewCounter(),
+			Timeouts: metric.NewCounter(),
+		})
+	}
+	poolStats := v.(*WorkConnPoolStatistics)
+	if payload.Hit {
+		poolStats.Hits.Inc(1)
+	} else {
+		poolStats.Misses.Inc(1)
+	}
+	if payload.Retries > 0 {
+		poolStats.Retries.Inc(payload.Retries)
+	}
+	if payload.Timeout {
+		poolStats.Timeouts.Inc(1)
+	}
+}
+
+func (collector *internalCollector) httpResponseTime(payload *HttpResponseTimePayload) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	proxyStats, ok := collector.info.ProxyStatistics[payload.ProxyName]
+	if ok {
+		proxyStats.ResponseTimes.Add(payload.ResponseTime)
+	}
+}
+
+func fillResponseTimeStats(ps *ProxyStats, proxyStats *ProxyStatistics) {
+	if proxyStats.ResponseTimes.Count() == 0 {
+		return
+	}
+	ps.AvgResponseTimeMs = float64(proxyStats.ResponseTimes.Avg()) / float64(time.Millisecond)
+	ps.P95ResponseTimeMs = float64(proxyStats.ResponseTimes.Percentile(95)) / float64(time.Millisecond)
+}
+
+func (collector *internalCollector) fillWorkConnPoolStats(ps *ProxyStats) {
+	if v, ok := collector.workConnPools.Load(ps.Name); ok {
+		poolStats := v.(*WorkConnPoolStatistics)
+		ps.PoolHits = poolStats.Hits.Count()
+		ps.PoolMisses = poolStats.Misses.Count()
+		ps.PoolRetries = poolStats.Retries.Count()
+		ps.PoolTimeouts = poolStats.Timeouts.Count()
+	}
+}
+
 func (collector *internalCollector) GetServer() *ServerStats {
 	collector.mu.Lock()
 	defer collector.mu.Unlock()
@@ -218,6 +292,7 @@ func (collector *internalCollector) GetProxiesByType(proxyType string) []*ProxyS
 			TodayTrafficIn:  proxyStats.TrafficIn.TodayCount(),
 			TodayTrafficOut: proxyStats.TrafficOut.TodayCount(),
 			CurConns:        proxyStats.CurConns.Count(),
+			RejectedConns:   proxyStats.RejectedConns.Count(),
 		}
 		if !proxyStats.LastStartTime.IsZero() {
 			ps.LastStartTime = proxyStats.LastStartTime.Format("01-02 15:04:05")
@@ -225,6 +300,8 @@ func (collector *internalCollector) GetProxiesByType(proxyType string) []*ProxyS
 		if !proxyStats.LastCloseTime.IsZero() {
 			ps.LastCloseTime = proxyStats.LastCloseTime.Format("01-02 15:04:05")
 		}
+		collector.fillWorkConnPoolStats(ps)
+		fillResponseTimeStats(ps, proxyStats)
 		res = append(res, ps)
 	}
 	return res
@@ -249,6 +326,7 @@ func (collector *internalCollector) GetProxiesByTypeAndName(proxyType string, pr
 			TodayTrafficIn:  proxyStats.TrafficIn.TodayCount(),
 			TodayTrafficOut: proxyStats.TrafficOut.TodayCount(),
 			CurConns:        proxyStats.CurConns.Count(),
+			RejectedConns:   proxyStats.RejectedConns.Count(),
 		}
 		if !proxyStats.LastStartTime.IsZero() {
 			res.LastStartTime = proxyStats.LastStartTime.Format("01-02 15:04:05")
@@ -256,6 +334,8 @@ func (collector *internalCollector) GetProxiesByTypeAndName(proxyType string, pr
 		if !proxyStats.LastCloseTime.IsZero() {
 			res.LastCloseTime = proxyStats.LastCloseTime.Format("01-02 15:04:05")
 		}
+		collector.fillWorkConnPoolStats(res)
+		fillResponseTimeStats(res, proxyStats)
 		break
 	}
 	return
@@ -22,6 +22,10 @@ import (
 
 const (
 	ReserveDays = 7
+
+	// ResponseTimeWindow is the number of recent http responses whose
+	// response times are used in statistics.
+	ResponseTimeWindow = 100
 )
 
 type StatsType int
@@ -35,6 +39,9 @@ const (
 	TypeCloseConnection
 	TypeAddTrafficIn
 	TypeAddTrafficOut
+	TypeWorkConnPool
+	TypeHttpResponseTime
+	TypeRejectConnection
 )
 
 type ServerStats struct {
@@ -53,6 +60,18 @@ type ProxyStats struct {
 	LastStartTime   string
 	LastCloseTime   string
 	CurConns        int64
+	PoolHits        int64
+	PoolMisses      int64
+	PoolRetries     int64
+	PoolTimeouts    int64
+
+	// user connections closed because of max_conns_per_sec
+	RejectedConns int64
+
+	// response times of http proxies in milliseconds, they are 0 if
+	// http_response_time_stats of frps is not enabled
+	AvgResponseTimeMs float64
+	P95ResponseTimeMs float64
 }
 
 type ProxyTrafficInfo struct {
@@ -67,10 +86,25 @@ type ProxyStatistics struct {
 	TrafficIn     metric.DateCounter
 	TrafficOut    metric.DateCounter
 	CurConns      metric.Counter
+	RejectedConns metric.Counter
+	ResponseTimes metric.DurationWindow
 	LastStartTime time.Time
 	LastCloseTime time.Time
 }
 
+// WorkConnPoolStatistics are updated by atomic operations only,
+// so they can be used in the hot path of getting work connections.
+type WorkConnPoolStatistics struct {
+	// the first work connection got from pool is available
+	Hits metric.Counter
+	// the first work connection is broken or no work connection can be got
+	Misses metric.Counter
+	// work connections which are broken and dropped
+	Retries metric.Counter
+	// no work connection can be got in user_conn_timeout
+	Timeouts metric.Counter
+}
+
 type ServerStatistics struct {
 	TotalTrafficIn  metric.DateCounter
 	TotalTrafficOut metric.DateCounter
@@ -118,6 +152,10 @@ type CloseConnectionPayload struct {
 	ProxyName string
 }
 
+type RejectConnectionPayload struct {
+	ProxyName string
+}
+
 type AddTrafficInPayload struct {
 	ProxyName    string
 	TrafficBytes int64
@@ -127,3 +165,15 @@ type AddTrafficOutPayload struct {
 	ProxyName    string
 	TrafficBytes int64
 }
+
+type WorkConnPoolPayload struct {
+	ProxyName string
+	Hit       bool
+	Retries   int64
+	Timeout   bool
+}
+
+type HttpResponseTimePayload struct {
+	ProxyName    string
+	ResponseTime time.Duration
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys used by AddKeyLogPrefix, they are field names in json format.
+const (
+	RunIdKey = "run_id"
+	ProxyKey = "proxy"
+	TraceKey = "trace_id"
+)
+
+var (
+	// jsonFormat is true if logs are output as one json object per line
+	jsonFormat bool
+
+	jsonOut io.Writer = os.Stdout
+	jsonMu  sync.Mutex
+)
+
+type jsonRecord struct {
+	Time   string   `json:"time"`
+	Level  string   `json:"level"`
+	File   string   `json:"file"`
+	RunId  string   `json:"run_id,omitempty"`
+	Proxy  string   `json:"proxy,omitempty"`
+	Trace  string   `json:"trace_id,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+	Msg    string   `json:"msg"`
+}
+
+// SetLogFormat sets the output format of logs, SetLogFile should be called
+// after it.
+// logFormat: text or json
+func SetLogFormat(logFormat string) {
+	jsonFormat = logFormat == "json"
+}
+
+// IsValidLogFormat returns true if logFormat can be used in SetLogFormat.
+func IsValidLogFormat(logFormat string) bool {
+	switch logFormat {
+	case "text", "json":
+		return true
+	}
+	return false
+}
+
+func setJsonOutput(logWay string, logFile string, maxdays int64) {
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	if f, ok := jsonOut.(*dailyFile); ok {
+		f.Close()
+	}
+	if logWay == "console" {
+		jsonOut = os.Stdout
+	} else {
+		jsonOut = newDailyFile(logFile, maxdays)
+	}
+}
+
+func writeJson(level string, prefix []string, fields map[string]string, format string, v ...interface{}) {
+	record := jsonRecord{
+		Time:   time.Now().Format("2006/01/02 15:04:05"),
+		Level:  level,
+		File:   callerFile(3),
+		RunId:  fields[RunIdKey],
+		Proxy:  fields[ProxyKey],
+		Trace:  fields[TraceKey],
+		Prefix: prefix,
+		Msg:    format,
+	}
+	if len(v) > 0 {
+		record.Msg = fmt.Sprintf(format, v...)
+	}
+	buf, err := json.Marshal(&record)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+
+	jsonMu.Lock()
+	jsonOut.Write(buf)
+	jsonMu.Unlock()
+}
+
+// callerFile returns file:line of the caller, wrappers of methods promoted from
+// embedded log.Logger are skipped.
+func callerFile(skip int) string {
+	for i := skip; i < skip+5; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		if file == "<autogenerated>" {
+			continue
+		}
+		return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	return "???"
+}
+
+// dailyFile appends logs to filename, the file of each day is renamed to
+// name.2006-01-02.ext and removed after maxdays.
+type dailyFile struct {
+	filename string
+	maxdays  int64
+
+	file    *os.File
+	openDay time.Time
+}
+
+func newDailyFile(filename string, maxdays int64) *dailyFile {
+	return &dailyFile{
+		filename: filename,
+		maxdays:  maxdays,
+	}
+}
+
+func (f *dailyFile) Write(p []byte) (n int, err error) {
+	now := time.Now()
+	if f.file == nil || !sameDay(f.openDay, now) {
+		if err = f.rotate(now); err != nil {
+			return
+		}
+	}
+	return f.file.Write(p)
+}
+
+func (f *dailyFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *dailyFile) rotate(now time.Time) (err error) {
+	f.Close()
+
+	// logs written before today are moved to the file of their day
+	if info, errRet := os.Stat(f.filename); errRet == nil && !sameDay(info.ModTime(), now) {
+		os.Rename(f.filename, f.dayFilename(info.ModTime()))
+		f.removeOutdated(now)
+	}
+
+	f.file, err = os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return
+	}
+	f.openDay = now
+	return nil
+}
+
+func (f *dailyFile) dayFilename(day time.Time) string {
+	ext := filepath.Ext(f.filename)
+	return strings.TrimSuffix(f.filename, ext) + "." + day.Format("2006-01-02") + ext
+}
+
+func (f *dailyFile) removeOutdated(now time.Time) {
+	if f.maxdays <= 0 {
+		return
+	}
+	ext := filepath.Ext(f.filename)
+	files, err := filepath.Glob(strings.TrimSuffix(f.filename, ext) + ".*" + ext)
+	if err != nil {
+		return
+	}
+	deadline := now.Add(-time.Duration(f.maxdays) * 24 * time.Hour)
+	for _, name := range files {
+		if info, err := os.Stat(name); err == nil && info.ModTime().Before(deadline) {
+			os.Remove(name)
+		}
+	}
+}
+
+func sameDay(t1, t2 time.Time) bool {
+	y1, m1, d1 := t1.Date()
+	y2, m2, d2 := t2.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonLog(t *testing.T) {
+	assert := assert.New(t)
+	oldFormat, oldOut := jsonFormat, jsonOut
+	defer func() { jsonFormat, jsonOut = oldFormat, oldOut }()
+	buf := bytes.NewBuffer(nil)
+	SetLogFormat("json")
+	jsonOut = buf
+
+	logger := NewKeyPrefixLogger(RunIdKey, "abc")
+	logger.AddKeyLogPrefix(ProxyKey, "ssh")
+	logger.AddKeyLogPrefix(TraceKey, "1a2b3c4d")
+	logger.AddLogPrefix("plain")
+	logger.Warn("hello %s", "world")
+
+	var record map[string]interface{}
+	if !assert.NoError(json.Unmarshal(buf.Bytes(), &record)) {
+		return
+	}
+	assert.True(strings.HasSuffix(buf.String(), "}\n"))
+	assert.Len(record, 8)
+	_, err := time.Parse("2006/01/02 15:04:05", record["time"].(string))
+	assert.NoError(err)
+	assert.Equal("warn", record["level"])
+	assert.True(strings.HasPrefix(record["file"].(string), "json_test.go:"), record["file"])
+	assert.Equal("abc", record["run_id"])
+	assert.Equal("ssh", record["proxy"])
+	assert.Equal("1a2b3c4d", record["trace_id"])
+	assert.Equal([]interface{}{"abc", "ssh", "1a2b3c4d", "plain"}, record["prefix"])
+	assert.Equal("hello world", record["msg"])
+
+	// fields of empty prefixes are omitted
+	buf.Reset()
+	NewPrefixLogger("").Error("no prefix")
+	record = nil
+	if assert.NoError(json.Unmarshal(buf.Bytes(), &record)) {
+		assert.Len(record, 4)
+		assert.Equal("error", record["level"])
+		assert.Equal("no prefix", record["msg"])
+	}
+}
+
+func TestDailyFileRotate(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frp_log")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	yesterday := now.Add(-24 * time.Hour)
+	twoDaysAgo := now.Add(-48 * time.Hour)
+	tenDaysAgo := now.Add(-240 * time.Hour)
+	filename := filepath.Join(dir, "frps.log")
+	dayFilename := func(day time.Time) string {
+		return filepath.Join(dir, "frps."+day.Format("2006-01-02")+".log")
+	}
+	writeFile := func(name string, content string, modTime time.Time) {
+		assert.NoError(ioutil.WriteFile(name, []byte(content), 0660))
+		assert.NoError(os.Chtimes(name, modTime, modTime))
+	}
+	assertContent := func(name string, content string) {
+		buf, err := ioutil.ReadFile(name)
+		if assert.NoError(err, name) {
+			assert.Equal(content, string(buf), name)
+		}
+	}
+	writeFile(dayFilename(tenDaysAgo), "ten days ago\n", tenDaysAgo)
+	writeFile(filename, "yesterday\n", yesterday)
+
+	// logs of yesterday are moved when it's opened, outdated files are removed
+	f := newDailyFile(filename, 3)
+	defer f.Close()
+	_, err = f.Write([]byte("today\n"))
+	assert.NoError(err)
+	assertContent(filename, "today\n")
+	assertContent(dayFilename(yesterday), "yesterday\n")
+	_, err = os.Stat(dayFilename(tenDaysAgo))
+	assert.True(os.IsNotExist(err))
+
+	// the file is rotated once the day changes while writing
+	f.openDay = twoDaysAgo
+	assert.NoError(os.Chtimes(filename, twoDaysAgo, twoDaysAgo))
+	_, err = f.Write([]byte("next day\n"))
+	assert.NoError(err)
+	assertContent(filename, "next day\n")
+	assertContent(dayFilename(twoDaysAgo), "today\n")
+}
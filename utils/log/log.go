@@ -23,13 +23,19 @@ import (
 // Log is the under log object
 var Log *logs.BeeLogger
 
+// level is the global log level, PrefixLogger can override it.
+var level = logs.LevelWarn
+
 func init() {
 	Log = logs.NewLogger(200)
 	Log.EnableFuncCallDepth(true)
 	Log.SetLogFuncCallDepth(Log.GetLogFuncCallDepth() + 1)
+	// Log levels are filtered by ourselves.
+	Log.SetLevel(logs.LevelTrace)
 }
 
-func InitLog(logWay string, logFile string, logLevel string, maxdays int64) {
+func InitLog(logWay string, logFile string, logLevel string, maxdays int64, logFormat string) {
+	SetLogFormat(logFormat)
 	SetLogFile(logWay, logFile, maxdays)
 	SetLogLevel(logLevel)
 }
@@ -37,6 +43,10 @@ func InitLog(logWay string, logFile string, logLevel string, maxdays int64) {
 // SetLogFile to configure log params
 // logWay: file or console
 func SetLogFile(logWay string, logFile string, maxdays int64) {
+	if jsonFormat {
+		setJsonOutput(logWay, logFile, maxdays)
+		return
+	}
 	if logWay == "console" {
 		Log.SetLogger("console", "")
 	} else {
@@ -48,49 +58,91 @@ func SetLogFile(logWay string, logFile string, maxdays int64) {
 // SetLogLevel set log level, default is warning
 // value: error, warning, info, debug, trace
 func SetLogLevel(logLevel string) {
-	level := 4 // warning
+	level = parseLogLevel(logLevel)
+}
+
+func parseLogLevel(logLevel string) int {
 	switch logLevel {
 	case "error":
-		level = 3
+		return logs.LevelError
 	case "warn":
-		level = 4
+		return logs.LevelWarn
 	case "info":
-		level = 6
+		return logs.LevelInfo
 	case "debug":
-		level = 7
+		return logs.LevelDebug
 	case "trace":
-		level = 8
+		return logs.LevelTrace
 	default:
-		level = 4
+		return logs.LevelWarn
 	}
-	Log.SetLevel(level)
+}
+
+// IsValidLogLevel returns true if logLevel can be used in SetLogLevel.
+func IsValidLogLevel(logLevel string) bool {
+	switch logLevel {
+	case "error", "warn", "info", "debug", "trace":
+		return true
+	}
+	return false
 }
 
 // wrap log
 
 func Error(format string, v ...interface{}) {
-	Log.Error(format, v...)
+	if logs.LevelError <= level {
+		if jsonFormat {
+			writeJson("error", nil, nil, format, v...)
+			return
+		}
+		Log.Error(format, v...)
+	}
 }
 
 func Warn(format string, v ...interface{}) {
-	Log.Warn(format, v...)
+	if logs.LevelWarn <= level {
+		if jsonFormat {
+			writeJson("warn", nil, nil, format, v...)
+			return
+		}
+		Log.Warn(format, v...)
+	}
 }
 
 func Info(format string, v ...interface{}) {
-	Log.Info(format, v...)
+	if logs.LevelInfo <= level {
+		if jsonFormat {
+			writeJson("info", nil, nil, format, v...)
+			return
+		}
+		Log.Info(format, v...)
+	}
 }
 
 func Debug(format string, v ...interface{}) {
-	Log.Debug(format, v...)
+	if logs.LevelDebug <= level {
+		if jsonFormat {
+			writeJson("debug", nil, nil, format, v...)
+			return
+		}
+		Log.Debug(format, v...)
+	}
 }
 
 func Trace(format string, v ...interface{}) {
-	Log.Trace(format, v...)
+	if logs.LevelTrace <= level {
+		if jsonFormat {
+			writeJson("trace", nil, nil, format, v...)
+			return
+		}
+		Log.Trace(format, v...)
+	}
 }
 
 // Logger is the log interface
 type Logger interface {
 	AddLogPrefix(string)
+	AddKeyLogPrefix(string, string)
 	GetPrefixStr() string
 	GetAllPrefix() []string
 	ClearLogPrefix()
@@ -104,6 +156,13 @@ type Logger interface {
 type PrefixLogger struct {
 	prefix    string
 	allPrefix []string
+
+	// fields holds prefixes added with a key, they are output as separate
+	// fields in json format
+	fields map[string]string
+
+	// if level is 0, use the global log level
+	level int
 }
 
 func NewPrefixLogger(prefix string) *PrefixLogger {
@@ -114,6 +173,15 @@ func NewPrefixLogger(prefix string) *PrefixLogger {
 	return logger
 }
 
+// NewKeyPrefixLogger returns a PrefixLogger with prefix added by AddKeyLogPrefix.
+func NewKeyPrefixLogger(key string, prefix string) *PrefixLogger {
+	logger := &PrefixLogger{
+		allPrefix: make([]string, 0),
+	}
+	logger.AddKeyLogPrefix(key, prefix)
+	return logger
+}
+
 func (pl *PrefixLogger) AddLogPrefix(prefix string) {
 	if len(prefix) == 0 {
 		return
@@ -123,6 +191,20 @@ func (pl *PrefixLogger) AddLogPrefix(prefix string) {
 	pl.allPrefix = append(pl.allPrefix, prefix)
 }
 
+// AddKeyLogPrefix is the same as AddLogPrefix in text format, the prefix is also
+// output as field key in json format, e.g. RunIdKey and ProxyKey.
+func (pl *PrefixLogger) AddKeyLogPrefix(key string, prefix string) {
+	if len(prefix) == 0 {
+		return
+	}
+
+	pl.AddLogPrefix(prefix)
+	if pl.fields == nil {
+		pl.fields = make(map[string]string)
+	}
+	pl.fields[key] = prefix
+}
+
 func (pl *PrefixLogger) GetPrefixStr() string {
 	return pl.prefix
 }
@@ -134,24 +216,72 @@ func (pl *PrefixLogger) GetAllPrefix() []string {
 func (pl *PrefixLogger) ClearLogPrefix() {
 	pl.prefix = ""
 	pl.allPrefix = make([]string, 0)
+	pl.fields = nil
+}
+
+// SetLogLevel overrides the global log level for this logger only.
+// Empty logLevel means using the global log level.
+func (pl *PrefixLogger) SetLogLevel(logLevel string) {
+	if logLevel == "" {
+		pl.level = 0
+		return
+	}
+	pl.level = parseLogLevel(logLevel)
+}
+
+func (pl *PrefixLogger) enabled(l int) bool {
+	if pl.level != 0 {
+		return l <= pl.level
+	}
+	return l <= level
 }
 
 func (pl *PrefixLogger) Error(format string, v ...interface{}) {
-	Log.Error(pl.prefix+format, v...)
+	if pl.enabled(logs.LevelError) {
+		if jsonFormat {
+			writeJson("error", pl.allPrefix, pl.fields, format, v...)
+			return
+		}
+		Log.Error(pl.prefix+format, v...)
+	}
 }
 
 func (pl *PrefixLogger) Warn(format string, v ...interface{}) {
-	Log.Warn(pl.prefix+format, v...)
+	if pl.enabled(logs.LevelWarn) {
+		if jsonFormat {
+			writeJson("warn", pl.allPrefix, pl.fields, format, v...)
+			return
+		}
+		Log.Warn(pl.prefix+format, v...)
+	}
 }
 
 func (pl *PrefixLogger) Info(format string, v ...interface{}) {
-	Log.Info(pl.prefix+format, v...)
+	if pl.enabled(logs.LevelInfo) {
+		if jsonFormat {
+			writeJson("info", pl.allPrefix, pl.fields, format, v...)
+			return
+		}
+		Log.Info(pl.prefix+format, v...)
+	}
 }
 
 func (pl *PrefixLogger) Debug(format string, v ...interface{}) {
-	Log.Debug(pl.prefix+format, v...)
+	if pl.enabled(logs.LevelDebug) {
+		if jsonFormat {
+			writeJson("debug", pl.allPrefix, pl.fields, format, v...)
+			return
+		}
+		Log.Debug(pl.prefix+format, v...)
+	}
 }
 
 func (pl *PrefixLogger) Trace(format string, v ...interface{}) {
-	Log.Trace(pl.prefix+format, v...)
+	if pl.enabled(logs.LevelTrace) {
+		if jsonFormat {
+			writeJson("trace", pl.allPrefix, pl.fields, format, v...)
+			return
+		}
+		Log.Trace(pl.prefix+format, v...)
+	}
 }
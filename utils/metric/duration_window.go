@@ -0,0 +1,98 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DurationWindow keeps the latest durations, e.g. response times, and
+// calculates statistics of them.
+type DurationWindow interface {
+	Add(time.Duration)
+	Count() int
+	Avg() time.Duration
+	// Percentile returns the duration which p percent of durations don't exceed, p is in [0, 100].
+	Percentile(p float64) time.Duration
+}
+
+func NewDurationWindow(size int) DurationWindow {
+	if size <= 0 {
+		size = 1
+	}
+	return &StandardDurationWindow{
+		samples: make([]time.Duration, size),
+	}
+}
+
+type StandardDurationWindow struct {
+	samples []time.Duration
+	next    int
+	count   int
+
+	mu sync.Mutex
+}
+
+func (w *StandardDurationWindow) Add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.count < len(w.samples) {
+		w.count++
+	}
+}
+
+func (w *StandardDurationWindow) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *StandardDurationWindow) Avg() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.count == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 0; i < w.count; i++ {
+		sum += w.samples[i]
+	}
+	return sum / time.Duration(w.count)
+}
+
+func (w *StandardDurationWindow) Percentile(p float64) time.Duration {
+	w.mu.Lock()
+	sorted := make([]time.Duration, w.count)
+	copy(sorted, w.samples[:w.count])
+	w.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// nearest rank
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
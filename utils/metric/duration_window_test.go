@@ -0,0 +1,25 @@
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurationWindow(t *testing.T) {
+	assert := assert.New(t)
+	w := NewDurationWindow(10)
+	assert.EqualValues(0, w.Avg())
+	assert.EqualValues(0, w.Percentile(95))
+
+	for i := 1; i <= 20; i++ {
+		w.Add(time.Duration(i) * time.Millisecond)
+	}
+	// only the latest 10 durations are kept
+	assert.Equal(10, w.Count())
+	assert.Equal(15500*time.Microsecond, w.Avg())
+	assert.Equal(20*time.Millisecond, w.Percentile(95))
+	assert.Equal(15*time.Millisecond, w.Percentile(50))
+	assert.Equal(11*time.Millisecond, w.Percentile(0))
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/fatedier/frp/models/consts"
+
+	frpIo "github.com/fatedier/golib/io"
+	"github.com/klauspost/compress/zstd"
+)
+
+// WithCompression wraps rwc with the compression algorithm,
+// snappy is used if algorithm is empty or unknown.
+func WithCompression(rwc io.ReadWriteCloser, algorithm string) io.ReadWriteCloser {
+	switch algorithm {
+	case consts.GzipCompression:
+		return newGzipReadWriteCloser(rwc)
+	case consts.ZstdCompression:
+		return newZstdReadWriteCloser(rwc)
+	default:
+		return frpIo.WithCompression(rwc)
+	}
+}
+
+// IsSupportedCompression returns true if algorithm can be used in WithCompression.
+func IsSupportedCompression(algorithm string) bool {
+	switch algorithm {
+	case "", consts.SnappyCompression, consts.GzipCompression, consts.ZstdCompression:
+		return true
+	default:
+		return false
+	}
+}
+
+type gzipReadWriteCloser struct {
+	rwc io.ReadWriteCloser
+	r   *gzip.Reader
+	w   *gzip.Writer
+}
+
+func newGzipReadWriteCloser(rwc io.ReadWriteCloser) *gzipReadWriteCloser {
+	return &gzipReadWriteCloser{
+		rwc: rwc,
+		w:   gzip.NewWriter(rwc),
+	}
+}
+
+func (gz *gzipReadWriteCloser) Read(p []byte) (n int, err error) {
+	// gzip.NewReader blocks until the header is received, so create it on first read
+	if gz.r == nil {
+		if gz.r, err = gzip.NewReader(gz.rwc); err != nil {
+			return
+		}
+	}
+	return gz.r.Read(p)
+}
+
+func (gz *gzipReadWriteCloser) Write(p []byte) (n int, err error) {
+	if n, err = gz.w.Write(p); err != nil {
+		return
+	}
+	// flush every write so that the peer can read it immediately
+	err = gz.w.Flush()
+	return
+}
+
+func (gz *gzipReadWriteCloser) Close() error {
+	gz.w.Close()
+	return gz.rwc.Close()
+}
+
+// zstd streams use one goroutine and a small window, since there may be many
+// of them at the same time.
+const zstdWindowSize = 1 << 20
+
+type zstdReadWriteCloser struct {
+	rwc io.ReadWriteCloser
+	r   *zstd.Decoder
+	w   *zstd.Encoder
+	err error
+}
+
+func newZstdReadWriteCloser(rwc io.ReadWriteCloser) *zstdReadWriteCloser {
+	zs := &zstdReadWriteCloser{rwc: rwc}
+	zs.w, zs.err = zstd.NewWriter(rwc, zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdWindowSize), zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if zs.err == nil {
+		zs.r, zs.err = zstd.NewReader(rwc, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxMemory(64*zstdWindowSize))
+	}
+	return zs
+}
+
+func (zs *zstdReadWriteCloser) Read(p []byte) (n int, err error) {
+	if zs.err != nil {
+		return 0, zs.err
+	}
+	return zs.r.Read(p)
+}
+
+func (zs *zstdReadWriteCloser) Write(p []byte) (n int, err error) {
+	if zs.err != nil {
+		return 0, zs.err
+	}
+	if n, err = zs.w.Write(p); err != nil {
+		return
+	}
+	// flush every write so that the peer can read it immediately
+	err = zs.w.Flush()
+	return
+}
+
+func (zs *zstdReadWriteCloser) Close() error {
+	if zs.w != nil {
+		zs.w.Close()
+	}
+	if zs.r != nil {
+		zs.r.Close()
+	}
+	return zs.rwc.Close()
+}
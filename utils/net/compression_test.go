@@ -0,0 +1,36 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/fatedier/frp/models/consts"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCompression(t *testing.T) {
+	assert := assert.New(t)
+	for _, algorithm := range []string{consts.SnappyCompression, consts.GzipCompression, consts.ZstdCompression} {
+		c1, c2 := net.Pipe()
+		w := WithCompression(c1, algorithm)
+		r := WithCompression(c2, algorithm)
+
+		// each write can be read before the next one
+		for _, msg := range []string{"hello", "world"} {
+			data := bytes.Repeat([]byte(msg), 100)
+			go w.Write(data)
+			buf := make([]byte, len(data))
+			_, err := io.ReadFull(r, buf)
+			if assert.NoError(err, algorithm) {
+				assert.Equal(data, buf, algorithm)
+			}
+		}
+		// closing writes trailers, nobody reads them from the pipe
+		c2.Close()
+		w.Close()
+		r.Close()
+	}
+}
@@ -20,12 +20,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/utils/log"
 
-	gnet "github.com/fatedier/golib/net"
+	frpIo "github.com/fatedier/golib/io"
+	"github.com/fatedier/golib/pool"
 	kcp "github.com/fatedier/kcp-go"
 )
 
@@ -166,6 +169,120 @@ func (statsConn *StatsConn) Close() (err error) {
 	return
 }
 
+type activityReadWriteCloser struct {
+	io.ReadWriteCloser
+
+	lastActive *int64
+}
+
+func (rwc *activityReadWriteCloser) Read(p []byte) (n int, err error) {
+	n, err = rwc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(rwc.lastActive, time.Now().UnixNano())
+	}
+	return
+}
+
+func (rwc *activityReadWriteCloser) Write(p []byte) (n int, err error) {
+	n, err = rwc.ReadWriteCloser.Write(p)
+	if n > 0 {
+		atomic.StoreInt64(rwc.lastActive, time.Now().UnixNano())
+	}
+	return
+}
+
+// Join works like Join in golib, but copies data by buffers of bufSize bytes.
+// Each joined pair uses two buffers, so larger ones speed up large transfers at
+// the cost of memory per connection. If bufSize is not greater than 0, it's the
+// same as Join in golib which uses 16KB buffers.
+func Join(c1 io.ReadWriteCloser, c2 io.ReadWriteCloser, bufSize int) (inCount int64, outCount int64) {
+	if bufSize <= 0 {
+		return frpIo.Join(c1, c2)
+	}
+
+	var wait sync.WaitGroup
+	pipe := func(to io.ReadWriteCloser, from io.ReadWriteCloser, count *int64) {
+		defer to.Close()
+		defer from.Close()
+		defer wait.Done()
+
+		buf := getJoinBuf(bufSize)
+		defer putJoinBuf(buf)
+		*count, _ = io.CopyBuffer(to, from, buf)
+	}
+
+	wait.Add(2)
+	go pipe(c1, c2, &inCount)
+	go pipe(c2, c1, &outCount)
+	wait.Wait()
+	return
+}
+
+// joinBufPools keeps buffers of sizes which aren't size classes of golib pool,
+// they must not be put into golib pool which would hand them out for other sizes.
+var joinBufPools sync.Map
+
+func isPoolSizeClass(size int) bool {
+	return size == 16*1024 || size == 5*1024 || size == 2*1024 || size == 1*1024
+}
+
+func getJoinBuf(size int) []byte {
+	if isPoolSizeClass(size) {
+		return pool.GetBuf(size)
+	}
+	p, _ := joinBufPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} { return make([]byte, size) },
+	})
+	return p.(*sync.Pool).Get().([]byte)
+}
+
+func putJoinBuf(buf []byte) {
+	if isPoolSizeClass(cap(buf)) {
+		pool.PutBuf(buf)
+		return
+	}
+	if p, ok := joinBufPools.Load(cap(buf)); ok {
+		p.(*sync.Pool).Put(buf[:cap(buf)])
+	}
+}
+
+// JoinWithIdleTimeout works like Join, but both connections will be closed
+// if no bytes flow in either direction for idleTimeout.
+// If idleTimeout is not greater than 0, it's the same as Join.
+func JoinWithIdleTimeout(c1 io.ReadWriteCloser, c2 io.ReadWriteCloser, idleTimeout time.Duration, bufSize int) (inCount int64, outCount int64) {
+	if idleTimeout <= 0 {
+		return Join(c1, c2, bufSize)
+	}
+
+	lastActive := time.Now().UnixNano()
+	closeCh := make(chan struct{})
+	go func() {
+		checkInterval := idleTimeout / 2
+		if checkInterval > time.Second {
+			checkInterval = time.Second
+		}
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, atomic.LoadInt64(&lastActive))) > idleTimeout {
+					c1.Close()
+					c2.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	inCount, outCount = Join(&activityReadWriteCloser{ReadWriteCloser: c1, lastActive: &lastActive},
+		&activityReadWriteCloser{ReadWriteCloser: c2, lastActive: &lastActive}, bufSize)
+	close(closeCh)
+	return
+}
+
 func ConnectServer(protocol string, addr string) (c Conn, err error) {
 	switch protocol {
 	case "tcp":
@@ -191,26 +308,33 @@ func ConnectServer(protocol string, addr string) (c Conn, err error) {
 	}
 }
 
-func ConnectServerByProxy(proxyUrl string, protocol string, addr string) (c Conn, err error) {
+// ConnectServerByProxy connects frps at addr, websocketPath is only used by
+// websocket protocol.
+func ConnectServerByProxy(proxyUrl string, protocol string, addr string, websocketPath string) (c Conn, err error) {
 	switch protocol {
 	case "tcp":
 		var conn net.Conn
-		if conn, err = gnet.DialTcpByProxy(proxyUrl, addr); err != nil {
+		if conn, err = DialTcpByProxy(proxyUrl, addr); err != nil {
 			return
 		}
 		return WrapConn(conn), nil
-	case "kcp":
-		// http proxy is not supported for kcp
-		return ConnectServer(protocol, addr)
-	case "websocket":
-		return ConnectWebsocketServer(addr)
+	case "kcp", "websocket":
+		// http proxy is not supported for kcp and websocket, socks5 proxy is
+		// not supported either but connecting directly may not be expected
+		if strings.HasPrefix(strings.ToLower(proxyUrl), "socks5://") {
+			return nil, fmt.Errorf("socks5 proxy is not supported for protocol [%s]", protocol)
+		}
+		if protocol == "kcp" {
+			return ConnectServer(protocol, addr)
+		}
+		return ConnectWebsocketServer(addr, websocketPath)
 	default:
 		return nil, fmt.Errorf("unsupport protocol: %s", protocol)
 	}
 }
 
-func ConnectServerByProxyWithTLS(proxyUrl string, protocol string, addr string, tlsConfig *tls.Config) (c Conn, err error) {
-	c, err = ConnectServerByProxy(proxyUrl, protocol, addr)
+func ConnectServerByProxyWithTLS(proxyUrl string, protocol string, addr string, websocketPath string, tlsConfig *tls.Config) (c Conn, err error) {
+	c, err = ConnectServerByProxy(proxyUrl, protocol, addr, websocketPath)
 	if err != nil {
 		return
 	}
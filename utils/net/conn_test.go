@@ -0,0 +1,74 @@
+package net
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/fatedier/golib/pool"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainRWC hides ReadFrom and WriteTo of tcp connections like the wrappers of
+// frp do, so data is always copied by the buffers of Join.
+type plainRWC struct {
+	io.ReadWriteCloser
+}
+
+// benchmarkJoin transfers size bytes from a tcp connection to another one
+// through Join with buffers of bufSize bytes.
+func benchmarkJoin(b *testing.B, bufSize int, size int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make([]byte, 64*1024)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		srcPeer, _ := l.Accept()
+		dst, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		dstPeer, _ := l.Accept()
+
+		go Join(plainRWC{srcPeer}, plainRWC{dstPeer}, bufSize)
+		go func() {
+			for sent := int64(0); sent < size; sent += int64(len(data)) {
+				src.Write(data)
+			}
+			src.Close()
+		}()
+		io.Copy(ioutil.Discard, dst)
+		dst.Close()
+	}
+}
+
+func BenchmarkJoin(b *testing.B) {
+	for _, bufSize := range []int{0, 4 * 1024, 64 * 1024, 256 * 1024} {
+		b.Run(fmt.Sprintf("buf_%d", bufSize), func(b *testing.B) {
+			benchmarkJoin(b, bufSize, 64*1024*1024)
+		})
+	}
+}
+
+func TestJoinBufPool(t *testing.T) {
+	assert := assert.New(t)
+	for _, size := range []int{4 * 1024, 16 * 1024, 64 * 1024} {
+		buf := getJoinBuf(size)
+		assert.Len(buf, size)
+		putJoinBuf(buf)
+	}
+	// buffers of other sizes aren't handed out by golib pool
+	assert.Equal(16*1024, cap(pool.GetBuf(16*1024)))
+	assert.Equal(2*1024, cap(pool.GetBuf(2*1024)))
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsQueryTimeout limits each query sent to dns server by DnsCache.
+const dnsQueryTimeout = 5 * time.Second
+
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DnsCache caches dns answers got by the go resolver, answers are cached for the
+// min ttl of their records and at most maxTTL.
+// Concurrent queries of the same question are sent to dns server only once.
+// Truncated answers aren't cached, the go resolver queries them again over tcp.
+type DnsCache struct {
+	maxTTL time.Duration
+	dial   DialFunc
+
+	entries map[dnsQuestion]*dnsCacheEntry
+	calls   map[dnsCallKey]*dnsCall
+	mu      sync.Mutex
+}
+
+type dnsQuestion struct {
+	name  string
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+}
+
+// dnsCallKey separates queries over udp and tcp, since answers over udp may be
+// truncated.
+type dnsCallKey struct {
+	dnsQuestion
+	network string
+}
+
+type dnsCacheEntry struct {
+	resp    []byte
+	expires time.Time
+}
+
+type dnsCall struct {
+	done chan struct{}
+	resp []byte
+	err  error
+}
+
+// NewDnsCache returns a DnsCache which sends queries by connections from dial,
+// use net.Dialer.DialContext if dial is nil.
+func NewDnsCache(maxTTL time.Duration, dial DialFunc) *DnsCache {
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	return &DnsCache{
+		maxTTL:  maxTTL,
+		dial:    dial,
+		entries: make(map[dnsQuestion]*dnsCacheEntry),
+		calls:   make(map[dnsCallKey]*dnsCall),
+	}
+}
+
+// Resolver returns a go resolver using dc.
+func (dc *DnsCache) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     dc.Dial,
+	}
+}
+
+// Dial is used as Dial of net.Resolver, the returned connection answers queries
+// from cache if possible.
+func (dc *DnsCache) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return &dnsCacheConn{
+		dc:      dc,
+		ctx:     ctx,
+		network: network,
+		address: address,
+	}, nil
+}
+
+func (dc *DnsCache) exchange(ctx context.Context, network, address string, deadline time.Time, query []byte) (resp []byte, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	key := dnsQuestion{
+		name:  strings.ToLower(q.Name.String()),
+		typ:   q.Type,
+		class: q.Class,
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	dc.mu.Lock()
+	if entry, ok := dc.entries[key]; ok {
+		if time.Now().Before(entry.expires) {
+			dc.mu.Unlock()
+			return withDnsID(entry.resp, h.ID), nil
+		}
+		delete(dc.entries, key)
+	}
+	callKey := dnsCallKey{dnsQuestion: key, network: network}
+	call, ok := dc.calls[callKey]
+	if !ok {
+		call = &dnsCall{
+			done: make(chan struct{}),
+		}
+		dc.calls[callKey] = call
+		go dc.doCall(callKey, call, address, h.ID, query)
+	}
+	dc.mu.Unlock()
+
+	// every lookup waits by its own ctx, the query goes on if one gives up
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return withDnsID(call.resp, h.ID), nil
+}
+
+// doCall sends query of key to dns server and caches the response. It's not
+// bound to the lookup starting it since others may wait for the same answer.
+func (dc *DnsCache) doCall(key dnsCallKey, call *dnsCall, address string, id uint16, query []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsQueryTimeout)
+	defer cancel()
+	call.resp, call.err = dc.roundTrip(ctx, key.network, address, id, query)
+
+	dc.mu.Lock()
+	delete(dc.calls, key)
+	if call.err == nil {
+		if ttl := dc.cacheTTL(call.resp); ttl > 0 {
+			dc.clearExpired()
+			dc.entries[key.dnsQuestion] = &dnsCacheEntry{
+				resp:    call.resp,
+				expires: time.Now().Add(ttl),
+			}
+		}
+	}
+	dc.mu.Unlock()
+	close(call.done)
+}
+
+// roundTrip sends query to dns server and returns the response with the same id.
+func (dc *DnsCache) roundTrip(ctx context.Context, network, address string, id uint16, query []byte) (resp []byte, err error) {
+	conn, err := dc.dial(ctx, network, address)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err = conn.Write(query); err != nil {
+			return
+		}
+		buf := make([]byte, 65535)
+		for {
+			n, errRet := conn.Read(buf)
+			if errRet != nil {
+				return nil, errRet
+			}
+			// ignore responses of other queries like go resolver does
+			if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+				return buf[:n], nil
+			}
+		}
+	}
+
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err = conn.Write(buf); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	resp = make([]byte, binary.BigEndian.Uint16(buf))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != id {
+		return nil, fmt.Errorf("invalid dns response")
+	}
+	return
+}
+
+// cacheTTL returns how long resp can be cached, 0 means it shouldn't be cached.
+func (dc *DnsCache) cacheTTL(resp []byte) time.Duration {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil || h.Truncated ||
+		(h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError) {
+		return 0
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return 0
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return 0
+	}
+
+	ttl := dc.maxTTL
+	if h.RCode == dnsmessage.RCodeSuccess && len(answers) > 0 {
+		for _, answer := range answers {
+			if recordTTL := time.Duration(answer.Header.TTL) * time.Second; recordTTL < ttl {
+				ttl = recordTTL
+			}
+		}
+		return ttl
+	}
+
+	// negative answers, e.g. AAAA queries of ipv4 only hosts, are cached by
+	// the SOA record in authority section like RFC 2308
+	for {
+		rh, err := p.AuthorityHeader()
+		if err != nil {
+			return 0
+		}
+		if rh.Type != dnsmessage.TypeSOA {
+			if err = p.SkipAuthority(); err != nil {
+				return 0
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			return 0
+		}
+		if recordTTL := time.Duration(rh.TTL) * time.Second; recordTTL < ttl {
+			ttl = recordTTL
+		}
+		if minTTL := time.Duration(soa.MinTTL) * time.Second; minTTL < ttl {
+			ttl = minTTL
+		}
+		return ttl
+	}
+}
+
+func (dc *DnsCache) clearExpired() {
+	now := time.Now()
+	for key, entry := range dc.entries {
+		if !now.Before(entry.expires) {
+			delete(dc.entries, key)
+		}
+	}
+}
+
+func withDnsID(resp []byte, id uint16) []byte {
+	buf := make([]byte, len(resp))
+	copy(buf, resp)
+	binary.BigEndian.PutUint16(buf, id)
+	return buf
+}
+
+// dnsCacheConn is a stream connection for the go resolver, queries written are
+// answered by DnsCache.
+type dnsCacheConn struct {
+	dc       *DnsCache
+	ctx      context.Context
+	network  string
+	address  string
+	deadline time.Time
+
+	wbuf bytes.Buffer
+	rbuf bytes.Buffer
+}
+
+func (c *dnsCacheConn) Read(b []byte) (n int, err error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+func (c *dnsCacheConn) Write(b []byte) (n int, err error) {
+	c.wbuf.Write(b)
+	data := c.wbuf.Bytes()
+	if len(data) < 2 {
+		return len(b), nil
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return len(b), nil
+	}
+
+	resp, err := c.dc.exchange(c.ctx, c.network, c.address, c.deadline, data[2:2+length])
+	c.wbuf.Next(2 + length)
+	if err != nil {
+		return 0, err
+	}
+	var prefix [2]byte
+	binary.BigEndian.PutUint16(prefix[:], uint16(len(resp)))
+	c.rbuf.Write(prefix[:])
+	c.rbuf.Write(resp)
+	return len(b), nil
+}
+
+func (c *dnsCacheConn) Close() error {
+	return nil
+}
+
+func (c *dnsCacheConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (c *dnsCacheConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (c *dnsCacheConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dnsCacheConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dnsCacheConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
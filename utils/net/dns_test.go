@@ -0,0 +1,179 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDnsServer answers every A query with 10.0.0.1 by a record of ttl
+// seconds, other queries get no answers but a SOA record with the same ttl.
+type fakeDnsServer struct {
+	ttl uint32
+	// answers are sent after it's closed if it's not nil
+	release chan struct{}
+	// answers over udp are truncated
+	truncateUdp bool
+
+	// count the connections
+	queries    int64
+	tcpQueries int64
+}
+
+func (s *fakeDnsServer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	atomic.AddInt64(&s.queries, 1)
+	if network == "tcp" {
+		atomic.AddInt64(&s.tcpQueries, 1)
+	}
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		var length uint16
+		if err := binary.Read(server, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(server, query); err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(query)
+		if err != nil {
+			return
+		}
+		q, err := p.Question()
+		if err != nil {
+			return
+		}
+		if s.release != nil {
+			<-s.release
+		}
+
+		truncated := s.truncateUdp && strings.HasPrefix(network, "udp")
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true, Truncated: truncated})
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		switch {
+		case truncated:
+			// no records
+		case q.Type == dnsmessage.TypeA:
+			b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: s.ttl},
+				dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}})
+		default:
+			zone := dnsmessage.MustNewName("example.com.")
+			b.StartAuthorities()
+			b.SOAResource(dnsmessage.ResourceHeader{Name: zone, Class: q.Class, TTL: s.ttl},
+				dnsmessage.SOAResource{NS: zone, MBox: zone, MinTTL: s.ttl})
+		}
+		resp, err := b.Finish()
+		if err != nil {
+			return
+		}
+		binary.Write(server, binary.BigEndian, uint16(len(resp)))
+		server.Write(resp)
+	}()
+	return client, nil
+}
+
+func TestDnsCache(t *testing.T) {
+	assert := assert.New(t)
+	server := &fakeDnsServer{ttl: 60}
+	resolver := NewDnsCache(time.Minute, server.Dial).Resolver()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		addrs, err := resolver.LookupIPAddr(ctx, "frp.example.com.")
+		if assert.NoError(err) && assert.Len(addrs, 1) {
+			assert.Equal("10.0.0.1", addrs[0].IP.String())
+		}
+	}
+	// the A query and the AAAA query are sent only once
+	assert.EqualValues(2, atomic.LoadInt64(&server.queries))
+
+	// literal ips never reach dns server
+	addrs, err := resolver.LookupIPAddr(ctx, "192.168.1.10")
+	if assert.NoError(err) && assert.Len(addrs, 1) {
+		assert.Equal("192.168.1.10", addrs[0].IP.String())
+	}
+	assert.EqualValues(2, atomic.LoadInt64(&server.queries))
+}
+
+func TestDnsCacheZeroTTL(t *testing.T) {
+	assert := assert.New(t)
+	server := &fakeDnsServer{ttl: 0}
+	resolver := NewDnsCache(time.Minute, server.Dial).Resolver()
+
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupIPAddr(context.Background(), "frp.example.com.")
+		assert.NoError(err)
+	}
+	// answers with ttl 0 are not cached
+	assert.EqualValues(4, atomic.LoadInt64(&server.queries))
+}
+
+func TestDnsCacheCoalesce(t *testing.T) {
+	assert := assert.New(t)
+	server := &fakeDnsServer{ttl: 60, release: make(chan struct{})}
+	resolver := NewDnsCache(time.Minute, server.Dial).Resolver()
+
+	// the lookup starting the query gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErrCh := make(chan error, 1)
+	go func() {
+		_, err := resolver.LookupIP(ctx, "ip4", "frp.example.com.")
+		firstErrCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := resolver.LookupIP(context.Background(), "ip4", "frp.example.com.")
+			if assert.NoError(err) && assert.Len(addrs, 1) {
+				assert.Equal("10.0.0.1", addrs[0].String())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-firstErrCh:
+		assert.Error(err)
+	case <-time.After(time.Second):
+		assert.Fail("canceled lookup still waits for the answer")
+	}
+
+	// others still get the answer of the same query
+	close(server.release)
+	wg.Wait()
+	assert.EqualValues(1, atomic.LoadInt64(&server.queries))
+}
+
+func TestDnsCacheTruncated(t *testing.T) {
+	assert := assert.New(t)
+	server := &fakeDnsServer{ttl: 60, truncateUdp: true}
+	resolver := NewDnsCache(time.Minute, server.Dial).Resolver()
+
+	for i := 0; i < 2; i++ {
+		addrs, err := resolver.LookupIP(context.Background(), "ip4", "frp.example.com.")
+		if assert.NoError(err) && assert.Len(addrs, 1) {
+			assert.Equal("10.0.0.1", addrs[0].String())
+		}
+	}
+	// the truncated answer over udp is queried again over tcp by the go
+	// resolver, only the full answer is cached
+	assert.EqualValues(2, atomic.LoadInt64(&server.queries))
+	assert.EqualValues(1, atomic.LoadInt64(&server.tcpQueries))
+}
@@ -17,6 +17,7 @@ package net
 import (
 	"fmt"
 	"net"
+	"strconv"
 
 	"github.com/fatedier/frp/utils/log"
 
@@ -32,7 +33,7 @@ type KcpListener struct {
 }
 
 func ListenKcp(bindAddr string, bindPort int) (l *KcpListener, err error) {
-	listener, err := kcp.ListenWithOptions(fmt.Sprintf("%s:%d", bindAddr, bindPort), nil, 10, 3)
+	listener, err := kcp.ListenWithOptions(net.JoinHostPort(bindAddr, strconv.Itoa(bindPort)), nil, 10, 3)
 	if err != nil {
 		return l, err
 	}
@@ -0,0 +1,70 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ListenTcpWithOptions listens on address like net.Listen, network should be
+// tcp, tcp4 or tcp6. If backlog is greater
+// than 0, it's used as the size of the accept queue instead of the system default,
+// it's still capped by the system, e.g. net.core.somaxconn on linux. If reusePort
+// is true, SO_REUSEPORT is set so that processes can listen on the same address.
+// Both are only supported on linux and bsd systems including macOS.
+func ListenTcpWithOptions(network string, address string, backlog int, reusePort bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) (err error) {
+			if errRet := c.Control(func(fd uintptr) {
+				err = setReusePort(fd)
+			}); errRet != nil {
+				return errRet
+			}
+			return
+		}
+	}
+	l, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	if backlog <= 0 {
+		return l, nil
+	}
+
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, fmt.Errorf("listener of %s is not a tcp listener", address)
+	}
+	rc, err := tl.SyscallConn()
+	if err == nil {
+		// listen again on the socket to change the size of its accept queue
+		errRet := rc.Control(func(fd uintptr) {
+			err = setBacklog(fd, backlog)
+		})
+		if errRet != nil {
+			err = errRet
+		}
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
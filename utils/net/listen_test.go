@@ -0,0 +1,40 @@
+package net
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenTcpWithOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT spreads connections on linux only")
+	}
+	assert := assert.New(t)
+	l1, err := ListenTcpWithOptions("tcp", "127.0.0.1:0", 128, true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer l1.Close()
+
+	// another listener on the same port
+	l2, err := ListenTcpWithOptions("tcp", l1.Addr().String(), 128, true)
+	if assert.NoError(err) {
+		l2.Close()
+	}
+	_, err = ListenTcpWithOptions("tcp", l1.Addr().String(), 0, false)
+	assert.Error(err)
+}
+
+func TestListenTcpWithOptionsNetwork(t *testing.T) {
+	assert := assert.New(t)
+	_, err := ListenTcpWithOptions("tcp4", "[::1]:0", 0, false)
+	assert.Error(err)
+
+	l, err := ListenTcpWithOptions("tcp6", "[::1]:0", 0, false)
+	if err != nil {
+		t.Skipf("ipv6 is not available: %v", err)
+	}
+	l.Close()
+}
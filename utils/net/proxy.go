@@ -0,0 +1,110 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	gnet "github.com/fatedier/golib/net"
+)
+
+// ParseProxyUrl parses proxyUrl used to connect frps, the scheme must be http,
+// https or socks5 and user info is used for authentication.
+func ParseProxyUrl(proxyUrl string) (*url.URL, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy url scheme must be http, https or socks5, not [%s]", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy url [%s] has no host", proxyUrl)
+	}
+	return u, nil
+}
+
+// DialTcpByProxy is the same as DialTcpByProxy of golib, but also supports https
+// proxies which are connected by tls before sending CONNECT.
+func DialTcpByProxy(proxyUrl string, addr string) (c net.Conn, err error) {
+	if proxyUrl == "" {
+		return gnet.DialTcpByProxy(proxyUrl, addr)
+	}
+	u, err := ParseProxyUrl(proxyUrl)
+	if err != nil {
+		return
+	}
+	if strings.ToLower(u.Scheme) != "https" {
+		return gnet.DialTcpByProxy(proxyUrl, addr)
+	}
+	return DialTcpByHttpsProxy(u, addr, nil)
+}
+
+// DialTcpByHttpsProxy connects addr by CONNECT through the https proxy, the
+// certificate of proxy is verified with tlsConfig, nil means the default config.
+func DialTcpByHttpsProxy(proxyUrl *url.URL, addr string, tlsConfig *tls.Config) (c net.Conn, err error) {
+	host := proxyUrl.Host
+	if proxyUrl.Port() == "" {
+		host = net.JoinHostPort(proxyUrl.Hostname(), "443")
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = proxyUrl.Hostname()
+	}
+	c, err = tls.Dial("tcp", host, tlsConfig)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest("CONNECT", "http://"+addr, nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if proxyUrl.User != nil {
+		passwd, _ := proxyUrl.User.Password()
+		auth := proxyUrl.User.Username() + ":" + passwd
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	if err = req.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		c.Close()
+		return nil, fmt.Errorf("DialTcpByHttpsProxy error, StatusCode [%d]", resp.StatusCode)
+	}
+	return c, nil
+}
@@ -17,6 +17,8 @@ package net
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/fatedier/frp/utils/log"
 )
@@ -30,7 +32,7 @@ type TcpListener struct {
 }
 
 func ListenTcp(bindAddr string, bindPort int) (l *TcpListener, err error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", bindAddr, bindPort))
+	tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(bindPort)))
 	if err != nil {
 		return l, err
 	}
@@ -109,3 +111,51 @@ func ConnectTcpServer(addr string) (c Conn, err error) {
 	c = NewTcpConn(conn)
 	return
 }
+
+// ConnectTcpServerFrom connects addr with localIp as the source address, the
+// source address is chosen by system if localIp is empty.
+// Dialing fails after timeout if it's greater than 0.
+func ConnectTcpServerFrom(localIp string, addr string, timeout time.Duration) (c Conn, err error) {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+	if localIp != "" {
+		localAddr, errRet := net.ResolveTCPAddr("tcp", net.JoinHostPort(localIp, "0"))
+		if errRet != nil {
+			return nil, errRet
+		}
+		dialer.LocalAddr = localAddr
+	}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	c = NewTcpConn(conn)
+	return
+}
+
+// SetTcpKeepAlive enables keepalive with period on c if period is greater than 0.
+// Connections wrapped by frp are unwrapped first, it returns an error if c is not
+// a tcp connection, e.g. a stream of tcp mux session.
+func SetTcpKeepAlive(c net.Conn, period time.Duration) error {
+	if period <= 0 {
+		return nil
+	}
+	for {
+		switch conn := c.(type) {
+		case *net.TCPConn:
+			if err := conn.SetKeepAlive(true); err != nil {
+				return err
+			}
+			return conn.SetKeepAlivePeriod(period)
+		case *TcpConn:
+			c = conn.Conn
+		case *WrapLogConn:
+			c = conn.Conn
+		case *CloseNotifyConn:
+			c = conn.Conn
+		default:
+			return fmt.Errorf("tcp keepalive is not supported by %T", c)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package net
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/fatedier/frp/utils/log"
@@ -33,7 +33,11 @@ type WebsocketListener struct {
 
 // NewWebsocketListener to handle websocket connections
 // ln: tcp listener for websocket connections
-func NewWebsocketListener(ln net.Listener) (wl *WebsocketListener) {
+// path: http path of websocket connections, FrpWebsocketPath if it's empty
+func NewWebsocketListener(ln net.Listener, path string) (wl *WebsocketListener) {
+	if path == "" {
+		path = FrpWebsocketPath
+	}
 	wl = &WebsocketListener{
 		Addr:   ln.Addr(),
 		accept: make(chan Conn),
@@ -41,7 +45,7 @@ func NewWebsocketListener(ln net.Listener) (wl *WebsocketListener) {
 	}
 
 	muxer := http.NewServeMux()
-	muxer.Handle(FrpWebsocketPath, websocket.Handler(func(c *websocket.Conn) {
+	muxer.Handle(path, websocket.Handler(func(c *websocket.Conn) {
 		notifyCh := make(chan struct{})
 		conn := WrapCloseNotifyConn(c, func() {
 			close(notifyCh)
@@ -60,11 +64,11 @@ func NewWebsocketListener(ln net.Listener) (wl *WebsocketListener) {
 }
 
 func ListenWebsocket(bindAddr string, bindPort int) (*WebsocketListener, error) {
-	tcpLn, err := net.Listen("tcp", fmt.Sprintf("%s:%d", bindAddr, bindPort))
+	tcpLn, err := net.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(bindPort)))
 	if err != nil {
 		return nil, err
 	}
-	l := NewWebsocketListener(tcpLn)
+	l := NewWebsocketListener(tcpLn, "")
 	return l, nil
 }
 
@@ -81,8 +85,12 @@ func (p *WebsocketListener) Close() error {
 }
 
 // addr: domain:port
-func ConnectWebsocketServer(addr string) (Conn, error) {
-	addr = "ws://" + addr + FrpWebsocketPath
+// path: http path of websocket connections, FrpWebsocketPath if it's empty
+func ConnectWebsocketServer(addr string, path string) (Conn, error) {
+	if path == "" {
+		path = FrpWebsocketPath
+	}
+	addr = "ws://" + addr + path
 	uri, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,81 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcpmux
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/vhost"
+
+	frpIo "github.com/fatedier/golib/io"
+)
+
+// HttpConnectTcpMuxer routes tcp connections by the host of the HTTP CONNECT
+// request sent at the beginning of them.
+type HttpConnectTcpMuxer struct {
+	*vhost.VhostMuxer
+}
+
+func NewHttpConnectTcpMuxer(listener frpNet.Listener, timeout time.Duration) (*HttpConnectTcpMuxer, error) {
+	mux, err := vhost.NewVhostMuxer(listener, getHostFromHttpConnect, nil, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	mux.SetSuccessHookFunc(sendHttpOk)
+	mux.SetFailHookFunc(sendHttpNotFound)
+	return &HttpConnectTcpMuxer{mux}, nil
+}
+
+func getHostFromHttpConnect(c frpNet.Conn) (_ frpNet.Conn, _ map[string]string, err error) {
+	reqInfoMap := make(map[string]string, 0)
+	rd := bufio.NewReader(c)
+	req, err := http.ReadRequest(rd)
+	if err != nil {
+		return nil, reqInfoMap, err
+	}
+	if req.Method != "CONNECT" {
+		return nil, reqInfoMap, fmt.Errorf("connections to tcpmux port must be of method CONNECT")
+	}
+
+	host := req.Host
+	if h, _, errRet := net.SplitHostPort(req.Host); errRet == nil {
+		host = h
+	}
+	if host == "" {
+		return nil, reqInfoMap, fmt.Errorf("no host in CONNECT request")
+	}
+	reqInfoMap["Host"] = host
+	reqInfoMap["Scheme"] = "tcp"
+
+	// data sent right after the request without waiting for the response is kept
+	if rd.Buffered() > 0 {
+		return frpNet.WrapReadWriteCloserToConn(frpIo.WrapReadWriteCloser(rd, c, c.Close), c), reqInfoMap, nil
+	}
+	return c, reqInfoMap, nil
+}
+
+func sendHttpOk(c frpNet.Conn) error {
+	_, err := c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return err
+}
+
+func sendHttpNotFound(c frpNet.Conn) {
+	c.Write([]byte("HTTP/1.1 404 Not Found\r\n\r\n"))
+}
@@ -0,0 +1,110 @@
+package tcpmux
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHostFromHttpConnect(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		name string
+		req  string
+		// empty if the request is rejected
+		host string
+		data string
+	}{
+		{"malformed", "hello world\r\n\r\n", "", ""},
+		{"not connect", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", ""},
+		{"missing host", "CONNECT / HTTP/1.1\r\n\r\n", "", ""},
+		{"host and port", "CONNECT Example.com:22 HTTP/1.1\r\nHost: Example.com:22\r\n\r\n", "Example.com", ""},
+		{"host only", "CONNECT example.com HTTP/1.1\r\n\r\n", "example.com", ""},
+		{"ipv6", "CONNECT [::1]:22 HTTP/1.1\r\n\r\n", "::1", ""},
+		{"data after request", "CONNECT example.com:22 HTTP/1.1\r\n\r\nSSH-2.0", "example.com", "SSH-2.0"},
+	}
+	for _, test := range tests {
+		c, userConn := net.Pipe()
+		go userConn.Write([]byte(test.req))
+
+		conn, reqInfoMap, err := getHostFromHttpConnect(frpNet.WrapConn(c))
+		if test.host == "" {
+			assert.Error(err, test.name)
+		} else if assert.NoError(err, test.name) {
+			assert.Equal(test.host, reqInfoMap["Host"], test.name)
+			if test.data != "" {
+				buf := make([]byte, len(test.data))
+				_, err = io.ReadFull(conn, buf)
+				assert.NoError(err, test.name)
+				assert.Equal(test.data, string(buf), test.name)
+			}
+		}
+		c.Close()
+		userConn.Close()
+	}
+}
+
+func TestHttpConnectTcpMuxer(t *testing.T) {
+	assert := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	muxer, err := NewHttpConnectTcpMuxer(frpNet.WrapLogListener(l), 2*time.Second)
+	if !assert.NoError(err) {
+		return
+	}
+	defer muxer.Close()
+	vl, err := muxer.Listen(&vhost.VhostRouteConfig{Domain: "example.com"})
+	if !assert.NoError(err) {
+		return
+	}
+	defer vl.Close()
+	go func() {
+		for {
+			c, err := vl.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	// connect returns the status code of the response to CONNECT host
+	connect := func(host string) (net.Conn, int) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(err) {
+			return nil, 0
+		}
+		c.Write([]byte("CONNECT " + host + " HTTP/1.1\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if !assert.NoError(err, host) {
+			c.Close()
+			return nil, 0
+		}
+		return c, resp.StatusCode
+	}
+
+	c, code := connect("example.com:22")
+	if assert.Equal(http.StatusOK, code) {
+		c.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(err)
+		assert.Equal("ping", string(buf))
+		c.Close()
+	}
+
+	c, code = connect("other.com:22")
+	if assert.Equal(http.StatusNotFound, code) {
+		c.Close()
+	}
+}
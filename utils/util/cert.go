@@ -0,0 +1,84 @@
+// Copyright 2019 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// SignByCert signs data by the private key of cert, the signature can be
+// verified by VerifyCertSign with cert.Certificate.
+func SignByCert(cert tls.Certificate, data []byte) (sign []byte, err error) {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key of certificate can't be used to sign")
+	}
+	digest := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// VerifyCertSign verifies the certificate chain certs against roots, then
+// verifies sign of data by the first certificate. Only RSA and ECDSA keys are
+// supported.
+func VerifyCertSign(certs [][]byte, roots *x509.CertPool, data []byte, sign []byte) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate")
+	}
+	leaf, err := x509.ParseCertificate(certs[0])
+	if err != nil {
+		return fmt.Errorf("parse certificate error: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, buf := range certs[1:] {
+		cert, err := x509.ParseCertificate(buf)
+		if err != nil {
+			return fmt.Errorf("parse certificate error: %v", err)
+		}
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return err
+	}
+
+	var algo x509.SignatureAlgorithm
+	switch leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algo = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		algo = x509.ECDSAWithSHA256
+	default:
+		return fmt.Errorf("unsupported public key type of certificate")
+	}
+	return leaf.CheckSignature(algo, data, sign)
+}
+
+// GetVisitorCertSignData returns the data which stcp visitors sign by their
+// certificates when connecting to proxy name, nonce is random for each
+// connection so frps can reject replayed signatures.
+func GetVisitorCertSignData(name string, timestamp int64, nonce string) string {
+	return fmt.Sprintf("frp visitor %s %d %s", name, timestamp, nonce)
+}
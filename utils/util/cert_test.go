@@ -0,0 +1,69 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCert(t *testing.T, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestVerifyCertSign(t *testing.T) {
+	assert := assert.New(t)
+	ca, caCert := newTestCert(t, 1, nil, nil)
+	_, visitorCert := newTestCert(t, 2, ca, caCert.PrivateKey.(*ecdsa.PrivateKey))
+	_, otherCert := newTestCert(t, 3, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	data := []byte(GetVisitorCertSignData("secret_tcp", 1488720000, "nonce"))
+
+	sign, err := SignByCert(visitorCert, data)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(VerifyCertSign(visitorCert.Certificate, roots, data, sign))
+	// signature of other data
+	assert.Error(VerifyCertSign(visitorCert.Certificate, roots, []byte("other"), sign))
+
+	// certificate not signed by ca
+	sign, err = SignByCert(otherCert, data)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Error(VerifyCertSign(otherCert.Certificate, roots, data, sign))
+	assert.Error(VerifyCertSign(nil, roots, data, sign))
+}
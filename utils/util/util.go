@@ -19,6 +19,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -48,11 +49,26 @@ func GetAuthKey(token string, timestamp int64) (key string) {
 	return hex.EncodeToString(data)
 }
 
+// UnbracketHost returns host without the square brackets enclosing IPv6
+// literals, e.g. [::1] to ::1, so it can be passed to net.JoinHostPort.
+func UnbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// CanonicalAddr returns host:port, the port is omitted if it's 80 or 443.
+// IPv6 literal hosts are enclosed in square brackets.
 func CanonicalAddr(host string, port int) (addr string) {
+	host = UnbracketHost(host)
 	if port == 80 || port == 443 {
 		addr = host
+		if strings.Contains(host, ":") {
+			addr = "[" + host + "]"
+		}
 	} else {
-		addr = fmt.Sprintf("%s:%d", host, port)
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
 	}
 	return
 }
@@ -101,3 +117,82 @@ func ParseRangeNumbers(rangeStr string) (numbers []int64, err error) {
 	}
 	return
 }
+
+// ParseByteSize parses sizes like "512", "100KB", "10MB" and "1GB" in bytes,
+// units are powers of 1024 and case insensitive.
+func ParseByteSize(sizeStr string) (size int64, err error) {
+	numStr := strings.ToUpper(strings.TrimSpace(sizeStr))
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(numStr, unit.suffix) {
+			numStr = strings.TrimSpace(strings.TrimSuffix(numStr, unit.suffix))
+			scale = unit.scale
+			break
+		}
+	}
+	v, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("size [%s] is invalid", sizeStr)
+	}
+	if v > (1<<63-1)/scale {
+		return 0, fmt.Errorf("size [%s] is too large", sizeStr)
+	}
+	return v * scale, nil
+}
+
+// SplitCommand splits command into arguments by spaces like a shell does
+// without expanding anything. Single and double quotes keep spaces in an
+// argument, and a backslash escapes the next character outside single quotes.
+func SplitCommand(command string) (args []string, err error) {
+	var (
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("command [%s] has an unterminated quote or escape", command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -46,3 +46,70 @@ func TestParseRangeNumbers(t *testing.T) {
 	_, err = ParseRangeNumbers("3-a")
 	assert.Error(err)
 }
+
+func TestUnbracketHost(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("::", UnbracketHost("[::]"))
+	assert.Equal("::1", UnbracketHost("::1"))
+	assert.Equal("fe80::1%eth0", UnbracketHost("[fe80::1%eth0]"))
+	assert.Equal("0.0.0.0", UnbracketHost("0.0.0.0"))
+	assert.Equal("[::1", UnbracketHost("[::1"))
+}
+
+func TestCanonicalAddr(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("example.com", CanonicalAddr("example.com", 80))
+	assert.Equal("example.com:8080", CanonicalAddr("example.com", 8080))
+	assert.Equal("[2001:db8::1]", CanonicalAddr("2001:db8::1", 443))
+	assert.Equal("[2001:db8::1]:8080", CanonicalAddr("2001:db8::1", 8080))
+	assert.Equal("[2001:db8::1]:8080", CanonicalAddr("[2001:db8::1]", 8080))
+}
+
+func TestParseByteSize(t *testing.T) {
+	assert := assert.New(t)
+	for sizeStr, expected := range map[string]int64{
+		"512":    512,
+		"512B":   512,
+		"100KB":  100 << 10,
+		"10 MB":  10 << 20,
+		"1gb":    1 << 30,
+		"2TB":    2 << 40,
+		" 0GB  ": 0,
+	} {
+		size, err := ParseByteSize(sizeStr)
+		if assert.NoError(err, sizeStr) {
+			assert.Equal(expected, size, sizeStr)
+		}
+	}
+
+	for _, sizeStr := range []string{"", "GB", "-1MB", "1.5GB", "1PB", "9999999999TB"} {
+		_, err := ParseByteSize(sizeStr)
+		assert.Error(err, sizeStr)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"/bin/echo a  b", []string{"/bin/echo", "a", "b"}},
+		{`notify "proxy started" 'it''s up'`, []string{"notify", "proxy started", "its up"}},
+		{`notify "say \"hi\"" 'a\b' a\ b`, []string{"notify", `say "hi"`, `a\b`, "a b"}},
+		{`notify "" x`, []string{"notify", "", "x"}},
+	}
+	for _, test := range tests {
+		args, err := SplitCommand(test.command)
+		if assert.NoError(err, test.command) {
+			assert.Equal(test.expected, args, test.command)
+		}
+	}
+
+	for _, command := range []string{`notify "started`, `notify 'started`, `notify \`} {
+		_, err := SplitCommand(command)
+		assert.Error(err, command)
+	}
+}
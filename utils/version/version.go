@@ -15,6 +15,7 @@
 package version
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -54,6 +55,91 @@ func Compat(client string) (ok bool, msg string) {
 	return true, ""
 }
 
+// parseSemver splits a semantic version like "v0.28.2-beta" into
+// numeric parts and pre-release part.
+func parseSemver(v string) (nums [3]int64, preRelease string, ok bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, preRelease = v[:i], v[i+1:]
+	}
+
+	arr := strings.Split(v, ".")
+	if len(arr) == 0 || len(arr) > 3 {
+		return nums, "", false
+	}
+	for i, s := range arr {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, preRelease, true
+}
+
+// IsValidSemver returns true if v can be used in CompareSemver.
+func IsValidSemver(v string) bool {
+	_, _, ok := parseSemver(v)
+	return ok
+}
+
+// CompareSemver compares two semantic versions, it returns -1 if a < b,
+// 1 if a > b and 0 if they are equal.
+// A pre-release version is lower than the normal one, e.g. 0.28.0-beta < 0.28.0.
+// An invalid version is lower than any valid version.
+func CompareSemver(a string, b string) int {
+	na, pa, okA := parseSemver(a)
+	nb, pb, okB := parseSemver(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+
+	for i := 0; i < 3; i++ {
+		if na[i] < nb[i] {
+			return -1
+		} else if na[i] > nb[i] {
+			return 1
+		}
+	}
+
+	switch {
+	case pa == pb:
+		return 0
+	case pa == "":
+		return 1
+	case pb == "":
+		return -1
+	case pa < pb:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// MeetsMinVersion returns true if v isn't lower than min. If allowDev is true,
+// invalid versions are allowed and pre-release ones are compared without their
+// pre-release part, e.g. 0.29.0-dev meets 0.29.0.
+func MeetsMinVersion(v string, min string, allowDev bool) bool {
+	nums, preRelease, ok := parseSemver(v)
+	if allowDev {
+		if !ok {
+			return true
+		}
+		if preRelease != "" {
+			v = fmt.Sprintf("%d.%d.%d", nums[0], nums[1], nums[2])
+		}
+	}
+	return CompareSemver(v, min) >= 0
+}
+
 func LessThan(client string, server string) bool {
 	vc := Proto(client)
 	vs := Proto(server)
@@ -63,3 +63,23 @@ func TestCompact(t *testing.T) {
 	ok, _ = Compat("0.10.0")
 	assert.False(ok)
 }
+
+func TestCompareSemver(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, CompareSemver("0.28.2", "v0.28.2"))
+	assert.Equal(-1, CompareSemver("0.9.0", "0.10.0"))
+	assert.Equal(1, CompareSemver("1.0.0", "0.28.2"))
+	assert.Equal(-1, CompareSemver("0.28", "0.28.1"))
+	assert.Equal(-1, CompareSemver("0.28.0-beta", "0.28.0"))
+	assert.Equal(-1, CompareSemver("invalid", "0.1.0"))
+
+	assert.True(IsValidSemver("v0.28.2-rc1"))
+	assert.False(IsValidSemver("0.28.x"))
+
+	assert.True(MeetsMinVersion("0.29.0", "0.28.0", false))
+	assert.False(MeetsMinVersion("0.29.0-dev", "0.29.0", false))
+	assert.True(MeetsMinVersion("0.29.0-dev", "0.29.0", true))
+	assert.False(MeetsMinVersion("0.28.0-dev", "0.29.0", true))
+	assert.False(MeetsMinVersion("unknown", "0.29.0", false))
+	assert.True(MeetsMinVersion("unknown", "0.29.0", true))
+}
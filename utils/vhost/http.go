@@ -16,25 +16,58 @@ package vhost
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	frpLog "github.com/fatedier/frp/utils/log"
 
+	frpIo "github.com/fatedier/golib/io"
 	"github.com/fatedier/golib/pool"
+	"golang.org/x/net/http2"
 )
 
 var (
 	ErrNoDomain = errors.New("no such domain")
+
+	// responses with these content types are already compressed or streamed
+	noGzipContentTypes = []string{
+		"image/",
+		"video/",
+		"audio/",
+		"font/woff",
+		"text/event-stream",
+		"application/zip",
+		"application/gzip",
+		"application/x-gzip",
+		"application/x-bzip2",
+		"application/x-7z-compressed",
+		"application/x-rar-compressed",
+		"application/octet-stream",
+	}
 )
 
 func getHostFromAddr(addr string) (host string) {
+	// IPv6 literal, e.g. [2001:db8::1]:8080 or [2001:db8::1]
+	if strings.HasPrefix(addr, "[") {
+		if i := strings.Index(addr, "]"); i > 0 {
+			return addr[1:i]
+		}
+	}
+	// IPv6 literal without port
+	if strings.Count(addr, ":") > 1 {
+		return addr
+	}
+
 	strs := strings.Split(addr, ":")
 	if len(strs) > 1 {
 		host = strs[0]
@@ -46,6 +79,10 @@ func getHostFromAddr(addr string) (host string) {
 
 type HttpReverseProxyOptions struct {
 	ResponseHeaderTimeoutS int64
+
+	// Measure response times of backends and pass them to ResponseTimeFn
+	// of routes.
+	ResponseTimeStats bool
 }
 
 type HttpReverseProxy struct {
@@ -74,33 +111,152 @@ func NewHttpReverseProxy(option HttpReverseProxyOptions, vhostRouter *VhostRoute
 			}
 			req.URL.Host = req.Host
 
+			if newPath, ok := rp.GetRewritePath(oldHost, url); ok {
+				req.URL.Path = newPath
+				req.URL.RawPath = ""
+			}
+
 			headers := rp.GetHeaders(oldHost, url)
 			for k, v := range headers {
 				req.Header.Set(k, v)
 			}
+
+			if rp.GetSetForwardedHeaders(oldHost, url) {
+				remote := req.Context().Value("remote").(string)
+				if ip, _, err := net.SplitHostPort(remote); err == nil {
+					req.Header.Set("X-Real-IP", ip)
+				}
+			}
 		},
-		Transport: &http.Transport{
-			ResponseHeaderTimeout: rp.responseHeaderTimeout,
-			DisableKeepAlives:     true,
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				url := ctx.Value("url").(string)
-				host := getHostFromAddr(ctx.Value("host").(string))
-				remote := ctx.Value("remote").(string)
-				return rp.CreateConnection(host, url, remote)
+		Transport: &backendTransport{
+			rp: rp,
+			http1: &http.Transport{
+				ResponseHeaderTimeout: rp.responseHeaderTimeout,
+				DisableKeepAlives:     true,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return rp.createConnByCtx(ctx)
+				},
+			},
+			http2: &http2.Transport{
+				AllowHTTP: true,
 			},
 		},
+		ModifyResponse: func(resp *http.Response) error {
+			url := resp.Request.Context().Value("url").(string)
+			host := getHostFromAddr(resp.Request.Context().Value("host").(string))
+			if rp.GetResponseGzip(host, url) {
+				gzipResponse(resp)
+			}
+			setStickyCookie(resp)
+			return nil
+		},
 		BufferPool: newWrapPool(),
 		ErrorLog:   log.New(newWrapLogger(), "", 0),
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
 			frpLog.Warn("do http proxy request error: %v", err)
 			rw.WriteHeader(http.StatusServiceUnavailable)
-			rw.Write(getServiceUnavailablePageContent())
+			// req may be the outgoing request whose host and path are rewritten
+			host, url := req.Host, req.URL.Path
+			if v, ok := req.Context().Value("host").(string); ok {
+				host = v
+			}
+			if v, ok := req.Context().Value("url").(string); ok {
+				url = v
+			}
+			rw.Write(rp.getServiceUnavailablePage(getHostFromAddr(host), url))
 		},
 	}
+	if option.ResponseTimeStats {
+		proxy.Transport = &responseTimeTransport{
+			RoundTripper: proxy.Transport,
+			rp:           rp,
+		}
+	}
 	rp.proxy = proxy
 	return rp
 }
 
+// backendTransport sends requests to backends by HTTP/1.1, or HTTP/2 without
+// tls if Http2Backend of the route is true. Each request uses a new
+// connection created by CreateConnFn.
+type backendTransport struct {
+	rp    *HttpReverseProxy
+	http1 *http.Transport
+	http2 *http2.Transport
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.Context().Value("url").(string)
+	host := getHostFromAddr(req.Context().Value("host").(string))
+	if !t.rp.GetHttp2Backend(host, url) {
+		return t.http1.RoundTrip(req)
+	}
+
+	conn, err := t.rp.createConnByCtx(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	cc, err := t.http2.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// like ResponseHeaderTimeout of http1 transport
+	timer := time.AfterFunc(t.rp.responseHeaderTimeout, func() {
+		cc.Close()
+	})
+	resp, err := cc.RoundTrip(req)
+	timer.Stop()
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	// HTTP/2 allows Content-Length with trailers, but users of HTTP/1.1 only
+	// receive trailers of chunked responses
+	if len(resp.Trailer) > 0 {
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	resp.Body = &http2Body{
+		ReadCloser: resp.Body,
+		cc:         cc,
+	}
+	return resp, nil
+}
+
+// http2Body closes the connection of the response after it's read.
+type http2Body struct {
+	io.ReadCloser
+	cc *http2.ClientConn
+}
+
+func (b *http2Body) Close() error {
+	err := b.ReadCloser.Close()
+	b.cc.Close()
+	return err
+}
+
+// responseTimeTransport measures the time until response headers are received,
+// response bodies are still streamed by the reverse proxy.
+type responseTimeTransport struct {
+	http.RoundTripper
+	rp *HttpReverseProxy
+}
+
+func (t *responseTimeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	url := req.Context().Value("url").(string)
+	host := getHostFromAddr(req.Context().Value("host").(string))
+	if fn := t.rp.GetResponseTimeFn(host, url); fn != nil {
+		fn(time.Since(start))
+	}
+	return resp, nil
+}
+
 // Register register the route config to reverse proxy
 // reverse proxy will use CreateConnFn from routeCfg to create a connection to the remote service
 func (rp *HttpReverseProxy) Register(routeCfg VhostRouteConfig) error {
@@ -132,6 +288,106 @@ func (rp *HttpReverseProxy) GetHeaders(domain string, location string) (headers
 	return
 }
 
+func (rp *HttpReverseProxy) GetResponseGzip(domain string, location string) bool {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).ResponseGzip
+	}
+	return false
+}
+
+func (rp *HttpReverseProxy) GetSetForwardedHeaders(domain string, location string) bool {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).SetForwardedHeaders
+	}
+	return false
+}
+
+func (rp *HttpReverseProxy) GetResponseTimeFn(domain string, location string) func(time.Duration) {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).ResponseTimeFn
+	}
+	return nil
+}
+
+func (rp *HttpReverseProxy) GetHttp2Backend(domain string, location string) bool {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).Http2Backend
+	}
+	return false
+}
+
+func (rp *HttpReverseProxy) GetWebsocket(domain string, location string) bool {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).Websocket
+	}
+	return false
+}
+
+// getServiceUnavailablePage returns the custom 503 page of the route if it's set,
+// otherwise the global one.
+func (rp *HttpReverseProxy) getServiceUnavailablePage(domain string, location string) []byte {
+	vr, ok := rp.getVhost(domain, location)
+	if ok && vr.payload.(*VhostRouteConfig).Custom503Page != "" {
+		return []byte(vr.payload.(*VhostRouteConfig).Custom503Page)
+	}
+	return getServiceUnavailablePageContent()
+}
+
+// GetRewritePath returns the new request path if the location of route config should be rewritten.
+func (rp *HttpReverseProxy) GetRewritePath(domain string, path string) (newPath string, ok bool) {
+	vr, exist := rp.getVhost(domain, path)
+	if !exist {
+		return
+	}
+	routeCfg := vr.payload.(*VhostRouteConfig)
+	if routeCfg.RewriteLocation == routeCfg.Location {
+		return
+	}
+
+	rest := strings.TrimPrefix(path, routeCfg.Location)
+	if rest == "" {
+		newPath = routeCfg.RewriteLocation
+	} else {
+		newPath = strings.TrimSuffix(routeCfg.RewriteLocation, "/") + "/" + strings.TrimPrefix(rest, "/")
+	}
+	if !strings.HasPrefix(newPath, "/") {
+		newPath = "/" + newPath
+	}
+	return newPath, true
+}
+
+// GetStickyCookie returns the name of the sticky cookie of the route.
+func (rp *HttpReverseProxy) GetStickyCookie(domain string, location string) string {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig).StickyCookie
+	}
+	return ""
+}
+
+// createConnByCtx creates a connection for the request whose host, url and
+// remote address are saved in ctx. Requests carrying a StickySession are sent
+// to the backend it refers to if the route is sticky.
+func (rp *HttpReverseProxy) createConnByCtx(ctx context.Context) (net.Conn, error) {
+	url := ctx.Value("url").(string)
+	host := getHostFromAddr(ctx.Value("host").(string))
+	remote := ctx.Value("remote").(string)
+	if session, ok := ctx.Value("sticky").(*StickySession); ok {
+		vr, ok := rp.getVhost(host, url)
+		if ok {
+			if fn := vr.payload.(*VhostRouteConfig).CreateStickyConnFn; fn != nil {
+				return fn(remote, session)
+			}
+		}
+	}
+	return rp.CreateConnection(host, url, remote)
+}
+
 // CreateConnection create a new connection by route config
 func (rp *HttpReverseProxy) CreateConnection(domain string, location string, remoteAddr string) (net.Conn, error) {
 	vr, ok := rp.getVhost(domain, location)
@@ -147,8 +403,12 @@ func (rp *HttpReverseProxy) CreateConnection(domain string, location string, rem
 func (rp *HttpReverseProxy) CheckAuth(domain, location, user, passwd string) bool {
 	vr, ok := rp.getVhost(domain, location)
 	if ok {
-		checkUser := vr.payload.(*VhostRouteConfig).Username
-		checkPasswd := vr.payload.(*VhostRouteConfig).Password
+		routeCfg := vr.payload.(*VhostRouteConfig)
+		if isAuthExempt(location, routeCfg.AuthExemptPaths) {
+			return true
+		}
+		checkUser := routeCfg.Username
+		checkPasswd := routeCfg.Password
 		if (checkUser != "" || checkPasswd != "") && (checkUser != user || checkPasswd != passwd) {
 			return false
 		}
@@ -156,6 +416,31 @@ func (rp *HttpReverseProxy) CheckAuth(domain, location, user, passwd string) boo
 	return true
 }
 
+// isAuthExempt returns true if location equals one of exemptPaths or is under
+// it. location is cleaned first so "/status/../admin" doesn't match "/status".
+func isAuthExempt(location string, exemptPaths []string) bool {
+	location = path.Clean("/" + location)
+	for _, p := range exemptPaths {
+		if p == "" {
+			continue
+		}
+		p = path.Clean("/" + p)
+		if location == p || strings.HasPrefix(location, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (rp *HttpReverseProxy) GetAuthRealm(domain, location string) (realm string) {
+	realm = "Restricted"
+	vr, ok := rp.getVhost(domain, location)
+	if ok && vr.payload.(*VhostRouteConfig).AuthRealm != "" {
+		realm = vr.payload.(*VhostRouteConfig).AuthRealm
+	}
+	return
+}
+
 // getVhost get vhost router by domain and location
 func (rp *HttpReverseProxy) getVhost(domain string, location string) (vr *VhostRouter, ok bool) {
 	// first we check the full hostname
@@ -191,13 +476,175 @@ func (rp *HttpReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	location := req.URL.Path
 	user, passwd, _ := req.BasicAuth()
 	if !rp.CheckAuth(domain, location, user, passwd) {
-		rw.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", rp.GetAuthRealm(domain, location)))
+		if page := getAuthFailPageContent(); page != nil {
+			rw.Header().Set("Content-Type", "text/html")
+			rw.WriteHeader(http.StatusUnauthorized)
+			rw.Write(page)
+		} else {
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+		return
+	}
+	if name := rp.GetStickyCookie(domain, location); name != "" {
+		session := &StickySession{}
+		if c, err := req.Cookie(name); err == nil {
+			session.Id = c.Value
+		}
+		ctx := context.WithValue(req.Context(), "sticky", session)
+		ctx = context.WithValue(ctx, "sticky_cookie", name)
+		req = req.WithContext(ctx)
+	}
+	if strings.EqualFold(upgradeType(req.Header), "websocket") && rp.GetWebsocket(domain, location) {
+		rp.serveWebsocket(rw, req)
 		return
 	}
 	rp.proxy.ServeHTTP(rw, req)
 }
 
+// setStickyCookie sets the sticky cookie in resp if the request is served by
+// another backend than the one in its cookie, e.g. the first request of a user.
+func setStickyCookie(resp *http.Response) {
+	ctx := resp.Request.Context()
+	session, ok := ctx.Value("sticky").(*StickySession)
+	if !ok || session.Id == "" {
+		return
+	}
+	name := ctx.Value("sticky_cookie").(string)
+	if c, err := resp.Request.Cookie(name); err == nil && c.Value == session.Id {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    session.Id,
+		Path:     "/",
+		HttpOnly: true,
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
+
+// serveWebsocket writes the upgrade request to the backend connection and joins
+// it with the hijacked user connection, so frames are passed through as they are.
+func (rp *HttpReverseProxy) serveWebsocket(rw http.ResponseWriter, req *http.Request) {
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "websocket is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), "url", req.URL.Path)
+	ctx = context.WithValue(ctx, "host", req.Host)
+	ctx = context.WithValue(ctx, "remote", req.RemoteAddr)
+	outreq := req.WithContext(ctx)
+	reqUpType := upgradeType(req.Header)
+	rp.proxy.Director(outreq)
+
+	// header rewrites must not break the handshake
+	outreq.Header.Set("Connection", "Upgrade")
+	outreq.Header.Set("Upgrade", reqUpType)
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior, ok := outreq.Header["X-Forwarded-For"]; ok {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	remote, err := rp.createConnByCtx(ctx)
+	if err != nil {
+		rp.proxy.getErrorHandler()(rw, req, err)
+		return
+	}
+	if err = outreq.Write(remote); err != nil {
+		remote.Close()
+		rp.proxy.getErrorHandler()(rw, req, err)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		remote.Close()
+		frpLog.Warn("hijack websocket connection error: %v", err)
+		return
+	}
+	// data sent by user right after the request
+	if n := brw.Reader.Buffered(); n > 0 {
+		buf, _ := brw.Reader.Peek(n)
+		if _, err = remote.Write(buf); err != nil {
+			remote.Close()
+			conn.Close()
+			return
+		}
+	}
+	frpIo.Join(conn, remote)
+}
+
+// acceptsGzip reports whether gzip is an acceptable encoding in the
+// Accept-Encoding header, an explicit "gzip" takes precedence over "*" and a
+// zero quality value like "gzip;q=0" means it's not acceptable.
+func acceptsGzip(acceptEncoding string) bool {
+	gzipAccepted, anyAccepted := -1, -1
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		accepted := 1
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err != nil || q <= 0 {
+					accepted = 0
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip", "x-gzip":
+			gzipAccepted = accepted
+		case "*":
+			anyAccepted = accepted
+		}
+	}
+	if gzipAccepted >= 0 {
+		return gzipAccepted == 1
+	}
+	return anyAccepted == 1
+}
+
+// gzipResponse compresses the body of a complete 200 response if the user
+// accepts gzip encoding and the response is neither encoded nor of an already
+// compressed content type.
+func gzipResponse(resp *http.Response) {
+	if resp.Request.Method == "HEAD" ||
+		resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Content-Range") != "" ||
+		resp.Header.Get("Content-Encoding") != "" ||
+		!acceptsGzip(resp.Request.Header.Get("Accept-Encoding")) {
+		return
+	}
+
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	for _, t := range noGzipContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return
+		}
+	}
+
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+
+	resp.Body = pr
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+}
+
 type wrapPool struct{}
 
 func newWrapPool() *wrapPool { return &wrapPool{} }
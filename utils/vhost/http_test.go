@@ -0,0 +1,380 @@
+package vhost
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/websocket"
+)
+
+// newWebsocketEchoProxy returns a reverse proxy in front of a websocket echo
+// server, routeCfg is registered with the connection to the echo server.
+func newWebsocketEchoProxy(t *testing.T, routeCfg VhostRouteConfig) (server *httptest.Server, closeFn func()) {
+	backend := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var frame string
+		for websocket.Message.Receive(ws, &frame) == nil {
+			websocket.Message.Send(ws, "echo: "+frame)
+		}
+	}))
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	routeCfg.Domain = "127.0.0.1"
+	routeCfg.CreateConnFn = func(remoteAddr string) (frpNet.Conn, error) {
+		c, err := net.Dial("tcp", backend.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return frpNet.WrapConn(c), nil
+	}
+	if err := rp.Register(routeCfg); err != nil {
+		backend.Close()
+		t.Fatal(err)
+	}
+	server = httptest.NewServer(rp)
+	return server, func() {
+		server.Close()
+		backend.Close()
+	}
+}
+
+// assertWebsocketEcho sends frames through the websocket endpoint of server
+// and checks they are echoed back.
+func assertWebsocketEcho(assert *assert.Assertions, server *httptest.Server) {
+	ws, err := websocket.Dial("ws"+server.URL[len("http"):]+"/ws", "", "http://127.0.0.1/")
+	if !assert.NoError(err) {
+		return
+	}
+	defer ws.Close()
+
+	for _, frame := range []string{"hello", "world"} {
+		if !assert.NoError(websocket.Message.Send(ws, frame)) {
+			return
+		}
+		var resp string
+		if !assert.NoError(websocket.Message.Receive(ws, &resp)) {
+			return
+		}
+		assert.Equal("echo: "+frame, resp)
+	}
+}
+
+func TestHttpReverseProxyWebsocket(t *testing.T) {
+	assert := assert.New(t)
+
+	server, closeFn := newWebsocketEchoProxy(t, VhostRouteConfig{
+		// rewriting Connection header breaks the handshake without Websocket
+		Headers:   map[string]string{"Connection": "close"},
+		Websocket: true,
+	})
+	defer closeFn()
+	assertWebsocketEcho(assert, server)
+
+	// normal requests are still handled by the reverse proxy
+	resp, err := http.Get(server.URL + "/ws")
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestHttpReverseProxyWebsocketUpgrade(t *testing.T) {
+	assert := assert.New(t)
+
+	// without Websocket, upgrade requests are sent by the reverse proxy and
+	// the connection is hijacked after 101 Switching Protocols
+	server, closeFn := newWebsocketEchoProxy(t, VhostRouteConfig{})
+	defer closeFn()
+	assertWebsocketEcho(assert, server)
+}
+
+func TestHttpReverseProxyResponseTime(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	responseTimes := make(chan time.Duration, 1)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{ResponseTimeStats: true}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:   "127.0.0.1",
+		Location: "",
+		ResponseTimeFn: func(d time.Duration) {
+			responseTimes <- d
+		},
+		CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+			c, err := net.Dial("tcp", backend.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return frpNet.WrapConn(c), nil
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	server := httptest.NewServer(rp)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	select {
+	case d := <-responseTimes:
+		assert.True(d >= 50*time.Millisecond)
+	default:
+		assert.Fail("response time is not recorded")
+	}
+}
+
+func TestHttpReverseProxyAuthFailPage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_vhost")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	AuthFailPagePath = filepath.Join(dir, "401.html")
+	defer func() { AuthFailPagePath = "" }()
+	if !assert.NoError(ioutil.WriteFile(AuthFailPagePath, []byte("<h1>no access</h1>"), 0600)) {
+		return
+	}
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain:   "127.0.0.1",
+		Username: "user",
+		Password: "pwd",
+		CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+			return nil, nil
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	server := httptest.NewServer(rp)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal("text/html", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(resp.Header.Get("WWW-Authenticate"))
+	assert.Equal("<h1>no access</h1>", string(body))
+}
+
+func TestHttpReverseProxyHttp2Backend(t *testing.T) {
+	assert := assert.New(t)
+
+	// h2c backend with prior knowledge
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+	h2s := &http2.Server{}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go h2s.ServeConn(c, &http2.ServeConnOpts{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Trailer", "X-Status")
+					w.Write([]byte(r.Proto))
+					w.Header().Set("X-Status", "done")
+				}),
+			})
+		}
+	}()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain:       "127.0.0.1",
+		Location:     "",
+		Http2Backend: true,
+		CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return frpNet.WrapConn(c), nil
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	server := httptest.NewServer(rp)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(err)
+		assert.Equal("HTTP/2.0", string(body))
+		assert.Equal("done", resp.Trailer.Get("X-Status"))
+	}
+}
+
+func TestHttpReverseProxyAuthExemptPaths(t *testing.T) {
+	assert := assert.New(t)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:          "example.com",
+		Username:        "user",
+		Password:        "pwd",
+		AuthExemptPaths: []string{"/status", "/public/"},
+		CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+			return nil, nil
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	tests := []struct {
+		location string
+		exempt   bool
+	}{
+		{"/status", true},
+		{"/status/", true},
+		{"/status/health", true},
+		{"/public", true},
+		{"/public/index.html", true},
+		{"/statusXYZ", false},
+		{"/status/../admin", false},
+		{"/status/./../admin", false},
+		{"/public/../../admin", false},
+		{"/admin", false},
+		{"/", false},
+	}
+	for _, test := range tests {
+		assert.Equal(test.exempt, rp.CheckAuth("example.com", test.location, "", ""), test.location)
+		assert.True(rp.CheckAuth("example.com", test.location, "user", "pwd"), test.location)
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	assert := assert.New(t)
+	newResp := func(acceptEncoding string, status int, header http.Header) *http.Response {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Content-Type", "text/plain")
+		return &http.Response{
+			StatusCode: status,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader("hello")),
+			Request:    req,
+		}
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		status         int
+		header         http.Header
+		gzipped        bool
+	}{
+		{"gzip accepted", "deflate, gzip", http.StatusOK, nil, true},
+		{"any accepted", "*", http.StatusOK, nil, true},
+		{"gzip not accepted", "deflate", http.StatusOK, nil, false},
+		{"zero quality", "gzip;q=0", http.StatusOK, nil, false},
+		{"zero quality with any", "*, gzip; q=0.0", http.StatusOK, nil, false},
+		{"partial content", "gzip", http.StatusPartialContent, http.Header{"Content-Range": {"bytes 0-4/10"}}, false},
+		{"content range", "gzip", http.StatusOK, http.Header{"Content-Range": {"bytes 0-4/5"}}, false},
+		{"not found", "gzip", http.StatusNotFound, nil, false},
+	}
+	for _, test := range tests {
+		resp := newResp(test.acceptEncoding, test.status, test.header)
+		gzipResponse(resp)
+		if !test.gzipped {
+			assert.Empty(resp.Header.Get("Content-Encoding"), test.name)
+			continue
+		}
+		if !assert.Equal("gzip", resp.Header.Get("Content-Encoding"), test.name) {
+			continue
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if assert.NoError(err, test.name) {
+			buf, err := ioutil.ReadAll(gz)
+			assert.NoError(err, test.name)
+			assert.Equal("hello", string(buf), test.name)
+		}
+	}
+}
+
+func TestHttpReverseProxySetForwardedHeaders(t *testing.T) {
+	assert := assert.New(t)
+	// the backend echoes the X-Real-IP header it gets
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Real-IP")))
+	}))
+	defer backend.Close()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	for _, location := range []string{"/set", "/unset"} {
+		err := rp.Register(VhostRouteConfig{
+			Domain:              "127.0.0.1",
+			Location:            location,
+			SetForwardedHeaders: location == "/set",
+			CreateConnFn: func(remoteAddr string) (frpNet.Conn, error) {
+				c, err := net.Dial("tcp", backend.Listener.Addr().String())
+				if err != nil {
+					return nil, err
+				}
+				return frpNet.WrapConn(c), nil
+			},
+		})
+		if !assert.NoError(err) {
+			return
+		}
+	}
+	server := httptest.NewServer(rp)
+	defer server.Close()
+
+	get := func(location string) string {
+		req, _ := http.NewRequest("GET", server.URL+location, nil)
+		// X-Real-IP sent by users can't be trusted
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(err) {
+			return ""
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return string(buf)
+	}
+	assert.Equal("127.0.0.1", get("/set"))
+	assert.Equal("10.0.0.1", get("/unset"))
+}
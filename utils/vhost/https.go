@@ -27,7 +27,11 @@ import (
 )
 
 const (
-	typeClientHello uint8 = 1 // Type client hello
+	recordTypeHandshake uint8 = 22 // Type handshake record
+	typeClientHello     uint8 = 1  // Type client hello
+
+	recordHeaderLen       = 5
+	maxHandshakeRecordLen = 16384 + 2048
 )
 
 // TLS extension numbers
@@ -58,18 +62,30 @@ func readHandshake(rd io.Reader) (host string, err error) {
 	origin := data
 	defer pool.PutBuf(origin)
 
-	_, err = io.ReadFull(rd, data[:47])
+	// read the whole TLS record which contains the ClientHello message,
+	// it may be larger than one read if there are many extensions
+	_, err = io.ReadFull(rd, data[:recordHeaderLen])
 	if err != nil {
 		return
 	}
-
-	length, err := rd.Read(data[47:])
+	if uint8(data[0]) != recordTypeHandshake {
+		err = fmt.Errorf("readHandshake: record type[%d] is not handshake", uint16(data[0]))
+		return
+	}
+	recordLen := int(data[3])<<8 | int(data[4])
+	if recordLen < 42 || recordLen > maxHandshakeRecordLen {
+		err = fmt.Errorf("readHandshake: recordLen[%d] is invalid", recordLen)
+		return
+	}
+	if recordHeaderLen+recordLen > len(data) {
+		data = make([]byte, recordHeaderLen+recordLen)
+		copy(data, origin[:recordHeaderLen])
+	}
+	_, err = io.ReadFull(rd, data[recordHeaderLen:recordHeaderLen+recordLen])
 	if err != nil {
 		return
-	} else {
-		length += 47
 	}
-	data = data[:length]
+	data = data[:recordHeaderLen+recordLen]
 	if uint8(data[5]) != typeClientHello {
 		err = fmt.Errorf("readHandshake: type[%d] is not clientHello", uint16(data[5]))
 		return
@@ -178,7 +194,7 @@ func readHandshake(rd io.Reader) (host string, err error) {
 		}
 		data = data[length:]
 	}
-	err = fmt.Errorf("Unknow error")
+	err = fmt.Errorf("readHandshake: there is no server name in client hello")
 	return
 }
 
@@ -0,0 +1,96 @@
+package vhost
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpsMuxerSNIRouting(t *testing.T) {
+	assert := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	muxer, err := NewHttpsMuxer(frpNet.WrapLogListener(l), 2*time.Second)
+	if !assert.NoError(err) {
+		return
+	}
+	defer muxer.Close()
+	var failed int64
+	muxer.SetFailHookFunc(func(c frpNet.Conn) {
+		atomic.AddInt64(&failed, 1)
+	})
+
+	// domain of the listener accepting connections is sent to acceptCh
+	acceptCh := make(chan string, 10)
+	for _, domain := range []string{"example.com", "*.wild.com"} {
+		vl, err := muxer.Listen(&VhostRouteConfig{Domain: domain})
+		if !assert.NoError(err) {
+			return
+		}
+		defer vl.Close()
+		go func(vl *Listener) {
+			for {
+				c, err := vl.Accept()
+				if err != nil {
+					return
+				}
+				c.Close()
+				acceptCh <- vl.Name()
+			}
+		}(vl)
+	}
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com", "example.com"},
+		{"a.wild.com", "*.wild.com"},
+		{"a.b.wild.com", "*.wild.com"},
+		{"www.example.com", ""},
+		{"wild.com", ""},
+		{"other.com", ""},
+	}
+	for _, test := range tests {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(err) {
+			return
+		}
+		// the handshake fails once the connection is closed by either side
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- tls.Client(c, &tls.Config{ServerName: test.serverName, InsecureSkipVerify: true}).Handshake()
+		}()
+
+		if test.expected != "" {
+			select {
+			case name := <-acceptCh:
+				assert.Equal(test.expected, name, test.serverName)
+			case <-time.After(time.Second):
+				assert.Fail("connection is not accepted", test.serverName)
+			}
+		} else {
+			select {
+			case <-errCh:
+			case <-time.After(time.Second):
+				assert.Fail("unmatched connection is not closed", test.serverName)
+			}
+			select {
+			case name := <-acceptCh:
+				assert.Fail("unmatched connection is accepted", "%s by %s", test.serverName, name)
+			default:
+			}
+		}
+		c.Close()
+	}
+	assert.EqualValues(3, atomic.LoadInt64(&failed))
+}
@@ -15,16 +15,18 @@
 package vhost
 
 import (
-	"bytes"
 	"io/ioutil"
 	"net/http"
 
 	frpLog "github.com/fatedier/frp/utils/log"
-	"github.com/fatedier/frp/utils/version"
 )
 
 var (
 	ServiceUnavailablePagePath = ""
+
+	// page returned to users failing http basic auth of proxies, empty means
+	// a plain text response
+	AuthFailPagePath = ""
 )
 
 const (
@@ -91,21 +93,18 @@ func getServiceUnavailablePageContent() []byte {
 	return buf
 }
 
-func notFoundResponse() *http.Response {
-	header := make(http.Header)
-	header.Set("server", "frp/"+version.Full()+"-sakurapanel")
-	header.Set("Content-Type", "text/html")
-
-	res := &http.Response{
-		Status:     "Service Unavailable",
-		StatusCode: 503,
-		Proto:      "HTTP/1.0",
-		ProtoMajor: 1,
-		ProtoMinor: 0,
-		Header:     header,
-		Body:       ioutil.NopCloser(bytes.NewReader(getServiceUnavailablePageContent())),
+// getAuthFailPageContent returns nil if AuthFailPagePath is not set or can't
+// be read.
+func getAuthFailPageContent() []byte {
+	if AuthFailPagePath == "" {
+		return nil
 	}
-	return res
+	buf, err := ioutil.ReadFile(AuthFailPagePath)
+	if err != nil {
+		frpLog.Warn("read custom auth fail page error: %v", err)
+		return nil
+	}
+	return buf
 }
 
 func noAuthResponse() *http.Response {
@@ -29,7 +29,17 @@ func NewVhostRouters() *VhostRouters {
 	}
 }
 
+// normalizeDomain removes the square brackets of IPv6 literal,
+// so [2001:db8::1] and 2001:db8::1 are the same domain.
+func normalizeDomain(domain string) string {
+	if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
+		return domain[1 : len(domain)-1]
+	}
+	return domain
+}
+
 func (r *VhostRouters) Add(domain, location string, payload interface{}) error {
+	domain = normalizeDomain(domain)
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
@@ -55,6 +65,7 @@ func (r *VhostRouters) Add(domain, location string, payload interface{}) error {
 }
 
 func (r *VhostRouters) Del(domain, location string) {
+	domain = normalizeDomain(domain)
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
@@ -72,6 +83,7 @@ func (r *VhostRouters) Del(domain, location string) {
 }
 
 func (r *VhostRouters) Get(host, path string) (vr *VhostRouter, exist bool) {
+	host = normalizeDomain(host)
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
@@ -13,6 +13,7 @@
 package vhost
 
 import (
+	"crypto/tls"
 	"fmt"
 	"strings"
 	"time"
@@ -26,14 +27,18 @@ import (
 type muxFunc func(frpNet.Conn) (frpNet.Conn, map[string]string, error)
 type httpAuthFunc func(frpNet.Conn, string, string, string) (bool, error)
 type hostRewriteFunc func(frpNet.Conn, string) (frpNet.Conn, error)
+type failHookFunc func(frpNet.Conn)
+type successHookFunc func(frpNet.Conn) error
 
 type VhostMuxer struct {
-	listener       frpNet.Listener
-	timeout        time.Duration
-	vhostFunc      muxFunc
-	authFunc       httpAuthFunc
-	rewriteFunc    hostRewriteFunc
-	registryRouter *VhostRouters
+	listener        frpNet.Listener
+	timeout         time.Duration
+	vhostFunc       muxFunc
+	authFunc        httpAuthFunc
+	rewriteFunc     hostRewriteFunc
+	failHookFunc    failHookFunc
+	successHookFunc successHookFunc
+	registryRouter  *VhostRouters
 }
 
 func NewVhostMuxer(listener frpNet.Listener, vhostFunc muxFunc, authFunc httpAuthFunc, rewriteFunc hostRewriteFunc, timeout time.Duration) (mux *VhostMuxer, err error) {
@@ -49,8 +54,35 @@ func NewVhostMuxer(listener frpNet.Listener, vhostFunc muxFunc, authFunc httpAut
 	return mux, nil
 }
 
+// SetFailHookFunc sets the function called before closing the connection
+// which doesn't match any registered listener.
+func (v *VhostMuxer) SetFailHookFunc(f failHookFunc) {
+	v.failHookFunc = f
+}
+
+// SetSuccessHookFunc sets the function called after the connection matches
+// a registered listener and before it's accepted.
+func (v *VhostMuxer) SetSuccessHookFunc(f successHookFunc) {
+	v.successHookFunc = f
+}
+
+// Close stops accepting connections, the ones already routed to listeners
+// are not affected.
+func (v *VhostMuxer) Close() error {
+	return v.listener.Close()
+}
+
 type CreateConnFunc func(remoteAddr string) (frpNet.Conn, error)
 
+// StickySession is the value of the sticky cookie of a request. Id is empty
+// if the request has no such cookie, CreateStickyConnFunc sets it to the id of
+// the backend serving the request.
+type StickySession struct {
+	Id string
+}
+
+type CreateStickyConnFunc func(remoteAddr string, session *StickySession) (frpNet.Conn, error)
+
 // VhostRouteConfig is the params used to match HTTP requests
 type VhostRouteConfig struct {
 	Domain      string
@@ -60,6 +92,53 @@ type VhostRouteConfig struct {
 	Password    string
 	Headers     map[string]string
 
+	// AuthRealm is used in WWW-Authenticate header, default is "Restricted".
+	AuthRealm string
+	// Requests whose path has one of these prefixes skip basic auth.
+	AuthExemptPaths []string
+
+	// Location prefix of request path will be replaced with RewriteLocation
+	// before forwarding, no change if it equals to Location.
+	RewriteLocation string
+
+	// Gzip responses if the user accepts it and they are not compressed yet.
+	ResponseGzip bool
+
+	// Set X-Real-IP header to the address of user. X-Forwarded-For is always
+	// appended by the reverse proxy.
+	SetForwardedHeaders bool
+
+	// Websocket upgrade requests are written to the connection created by
+	// CreateConnFn directly, then data is copied in both directions without
+	// being buffered by the reverse proxy.
+	Websocket bool
+
+	// Requests are sent to the connection created by CreateConnFn by HTTP/2
+	// without tls (h2c) instead of HTTP/1.1.
+	Http2Backend bool
+
+	// Shown when the backend is unavailable, the global page is used if empty.
+	Custom503Page string
+
+	// If it's not nil, tls connections are terminated with it before being
+	// accepted, e.g. to verify client certificates. Only used by https muxer.
+	TlsConfig *tls.Config
+
+	// Called with the time from sending a request to the backend until its
+	// response headers are received, only if ResponseTimeStats of
+	// HttpReverseProxyOptions is enabled.
+	ResponseTimeFn func(time.Duration)
+
+	// Name of the cookie pinning users to one backend. If it's not empty,
+	// connections are created by CreateStickyConnFn and the cookie is set in
+	// responses when the backend changes.
+	StickyCookie       string
+	CreateStickyConnFn CreateStickyConnFunc
+
+	// Only used by http groups, requests from the same ip are sent to the same
+	// backend until StickyIpTTL after the last of them if it's greater than 0.
+	StickyIpTTL time.Duration
+
 	CreateConnFn CreateConnFunc
 }
 
@@ -72,6 +151,7 @@ func (v *VhostMuxer) Listen(cfg *VhostRouteConfig) (l *Listener, err error) {
 		rewriteHost: cfg.RewriteHost,
 		userName:    cfg.Username,
 		passWord:    cfg.Password,
+		tlsConfig:   cfg.TlsConfig,
 		mux:         v,
 		accept:      make(chan frpNet.Conn),
 		Logger:      log.NewPrefixLogger(""),
@@ -140,9 +220,10 @@ func (v *VhostMuxer) handle(c frpNet.Conn) {
 	path := strings.ToLower(reqInfoMap["Path"])
 	l, ok := v.getListener(name, path)
 	if !ok {
-		res := notFoundResponse()
-		res.Write(c)
-		log.Debug("http request for host [%s] path [%s] not found", name, path)
+		if v.failHookFunc != nil {
+			v.failHookFunc(c)
+		}
+		log.Debug("request for host [%s] path [%s] from [%s] not found, close it", name, path, c.RemoteAddr().String())
 		c.Close()
 		return
 	}
@@ -160,6 +241,25 @@ func (v *VhostMuxer) handle(c frpNet.Conn) {
 		}
 	}
 
+	if v.successHookFunc != nil {
+		if err = v.successHookFunc(c); err != nil {
+			l.Warn("success hook error: %v", err)
+			c.Close()
+			return
+		}
+	}
+
+	if l.tlsConfig != nil {
+		// the deadline of c is still used for the handshake
+		tlsConn := tls.Server(sConn, l.tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			l.Debug("tls handshake with [%s] error: %v", c.RemoteAddr().String(), err)
+			c.Close()
+			return
+		}
+		sConn = frpNet.WrapConn(tlsConn)
+	}
+
 	if err = sConn.SetDeadline(time.Time{}); err != nil {
 		c.Close()
 		return
@@ -181,6 +281,7 @@ type Listener struct {
 	rewriteHost string
 	userName    string
 	passWord    string
+	tlsConfig   *tls.Config
 	mux         *VhostMuxer // for closing VhostMuxer
 	accept      chan frpNet.Conn
 	log.Logger